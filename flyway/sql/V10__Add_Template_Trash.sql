@@ -0,0 +1,12 @@
+SET search_path TO template_service, public;
+ALTER TABLE template_service.template
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN deleted_by VARCHAR(100);
+UPDATE template_service.template
+SET deleted_at = COALESCE(updated_at, created_at)
+WHERE is_active = FALSE;
+CREATE INDEX idx_template_deleted_at ON template_service.template (deleted_at) WHERE is_active = FALSE;
+INSERT INTO template_service.configuration (config_key, config_value, description, is_encrypted)
+VALUES ('templates.trash_retention_days', '30', 'Days a deleted template stays in the trash before it is purged, 0 to keep forever', FALSE);
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.9', 'Template trash with purge retention');
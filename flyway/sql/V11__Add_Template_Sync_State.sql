@@ -0,0 +1,10 @@
+SET search_path TO template_service, public;
+CREATE TABLE template_service.template_sync_state
+(
+    template_id  UUID         NOT NULL PRIMARY KEY REFERENCES template_service.template (id) ON DELETE CASCADE,
+    source_path  VARCHAR(500) NOT NULL,
+    content_hash VARCHAR(64)  NOT NULL,
+    synced_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.10', 'Templates-as-code sync state');
@@ -0,0 +1,6 @@
+SET search_path TO template_service, public;
+ALTER TABLE template_service.template_sample
+    ADD COLUMN expected_output     TEXT,
+    ADD COLUMN expected_updated_at TIMESTAMP WITH TIME ZONE;
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.7', 'Added expected outputs to template samples');
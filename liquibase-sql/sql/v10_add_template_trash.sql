@@ -0,0 +1,24 @@
+--liquibase formatted sql
+
+--changeset template_service:10
+--comment Add Template Trash
+ALTER TABLE template_service.template
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN deleted_by VARCHAR(100);
+UPDATE template_service.template
+SET deleted_at = COALESCE(updated_at, created_at)
+WHERE is_active = FALSE;
+CREATE INDEX idx_template_deleted_at ON template_service.template (deleted_at) WHERE is_active = FALSE;
+INSERT INTO template_service.configuration (config_key, config_value, description, is_encrypted)
+VALUES ('templates.trash_retention_days', '30', 'Days a deleted template stays in the trash before it is purged, 0 to keep forever', FALSE);
+
+--rollback DELETE FROM template_service.configuration WHERE config_key = 'templates.trash_retention_days';
+--rollback DROP INDEX IF EXISTS template_service.idx_template_deleted_at;
+--rollback ALTER TABLE template_service.template DROP COLUMN deleted_by, DROP COLUMN deleted_at;
+
+--changeset template_service:10.1
+--comment Update System Info
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.9', 'Template trash with purge retention');
+
+--rollback DELETE FROM template_service.system_info WHERE version = '1.0.9';
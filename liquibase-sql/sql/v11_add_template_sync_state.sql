@@ -0,0 +1,20 @@
+--liquibase formatted sql
+
+--changeset template_service:11
+--comment Add Template Sync State
+CREATE TABLE template_service.template_sync_state
+(
+    template_id  UUID         NOT NULL PRIMARY KEY REFERENCES template_service.template (id) ON DELETE CASCADE,
+    source_path  VARCHAR(500) NOT NULL,
+    content_hash VARCHAR(64)  NOT NULL,
+    synced_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+--rollback DROP TABLE template_service.template_sync_state;
+
+--changeset template_service:11.1
+--comment Update System Info
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.10', 'Templates-as-code sync state');
+
+--rollback DELETE FROM template_service.system_info WHERE version = '1.0.10';
@@ -23,6 +23,8 @@ CREATE INDEX idx_audit_entity ON audit.audit_log (entity_type, entity_id);
 CREATE INDEX idx_audit_timestamp ON audit.audit_log (timestamp);
 
 DROP FUNCTION IF EXISTS audit.log_change();
+--rollback DROP TABLE IF EXISTS audit.audit_log;
+--rollback DROP SCHEMA IF EXISTS audit;
 
 --changeset authornamehere:2.1
 --comment Create Audit Function
@@ -0,0 +1,21 @@
+--liquibase formatted sql
+
+--changeset template_service:6
+--comment Add Email Transport Configuration
+INSERT INTO template_service.configuration (config_key, config_value, description, is_encrypted)
+VALUES ('smtp.transport', 'capture', 'Transport for test emails: smtp or capture', FALSE),
+       ('smtp.host', 'localhost', 'SMTP server host', FALSE),
+       ('smtp.port', '25', 'SMTP server port', FALSE),
+       ('smtp.security', 'starttls', 'SMTP connection security: none, starttls or tls', FALSE),
+       ('smtp.username', '', 'SMTP username, empty to send without authentication', FALSE),
+       ('smtp.password', '', 'SMTP password, encrypted with CONFIG_ENCRYPTION_KEY', TRUE),
+       ('smtp.from', 'Template Service <no-reply@localhost>', 'Default sender of test emails', FALSE);
+
+--rollback DELETE FROM template_service.configuration WHERE config_key LIKE 'smtp.%';
+
+--changeset template_service:6.1
+--comment Update System Info
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.5', 'Email transport configuration');
+
+--rollback DELETE FROM template_service.system_info WHERE version = '1.0.5';
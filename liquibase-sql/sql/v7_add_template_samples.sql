@@ -0,0 +1,37 @@
+--liquibase formatted sql
+
+--changeset template_service:7
+--comment Add Template Samples
+
+SET search_path TO template_service, public;
+
+CREATE TABLE template_service.template_sample
+(
+    id          SERIAL PRIMARY KEY,
+    template_id UUID         NOT NULL REFERENCES template_service.template (id) ON DELETE CASCADE,
+    name        VARCHAR(100) NOT NULL,
+    description TEXT,
+    variables   JSONB        NOT NULL DEFAULT '{}'::jsonb,
+    created_by  VARCHAR(100),
+    created_at  TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE template_service.template_sample
+    ADD CONSTRAINT uk_template_id_sample_name UNIQUE (template_id, name);
+
+CREATE TRIGGER template_sample_audit
+    AFTER INSERT OR UPDATE OR DELETE
+    ON template_service.template_sample
+    FOR EACH ROW
+EXECUTE FUNCTION audit.log_change();
+
+--rollback DROP TRIGGER IF EXISTS template_sample_audit ON template_service.template_sample;
+--rollback DROP TABLE template_service.template_sample;
+
+--changeset template_service:7.1
+--comment Update System Info
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.6', 'Added template sample data sets');
+
+--rollback DELETE FROM template_service.system_info WHERE version = '1.0.6';
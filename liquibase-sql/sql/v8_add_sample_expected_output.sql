@@ -0,0 +1,16 @@
+--liquibase formatted sql
+
+--changeset template_service:8
+--comment Add Expected Output To Template Samples
+ALTER TABLE template_service.template_sample
+    ADD COLUMN expected_output     TEXT,
+    ADD COLUMN expected_updated_at TIMESTAMP WITH TIME ZONE;
+
+--rollback ALTER TABLE template_service.template_sample DROP COLUMN expected_updated_at, DROP COLUMN expected_output;
+
+--changeset template_service:8.1
+--comment Update System Info
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.7', 'Added expected outputs to template samples');
+
+--rollback DELETE FROM template_service.system_info WHERE version = '1.0.7';
@@ -0,0 +1,408 @@
+// Package changeloglint checks Liquibase changelogs against the team rules
+// for this repository's migrations: unique and attributed changesets, a
+// comment on each, rollbacks for destructive changes, contexts on
+// development data, preconditions on changesets that always run and
+// schema-qualified tables.
+package changeloglint
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/liquibase"
+)
+
+// Rules.
+const (
+	RuleUniqueID          = "unique-id"
+	RulePlaceholderAuthor = "placeholder-author"
+	RuleComment           = "comment"
+	RuleRollback          = "rollback"
+	RuleDevContext        = "dev-context"
+	RuleRunAlways         = "run-always"
+	RuleSchemaName        = "schema-name"
+)
+
+// Rules lists every rule, for Options.Disabled.
+var Rules = []string{
+	RuleUniqueID, RulePlaceholderAuthor, RuleComment, RuleRollback,
+	RuleDevContext, RuleRunAlways, RuleSchemaName,
+}
+
+// PlaceholderAuthors are author names left over from changelog templates.
+var PlaceholderAuthors = []string{"authornamehere", "author", "yourname", "your_name", "changeme", "todo", "tbd", "unknown"}
+
+// DevDirectory is the directory holding development-only changelogs.
+const DevDirectory = "dev"
+
+// destructiveChanges are the YAML change types losing data or schema
+// objects that a rollback has to restore.
+var destructiveChanges = map[string]bool{
+	"delete":                       true,
+	"dropAllForeignKeyConstraints": true,
+	"dropColumn":                   true,
+	"dropDefaultValue":             true,
+	"dropForeignKeyConstraint":     true,
+	"dropIndex":                    true,
+	"dropNotNullConstraint":        true,
+	"dropPrimaryKey":               true,
+	"dropProcedure":                true,
+	"dropSequence":                 true,
+	"dropTable":                    true,
+	"dropUniqueConstraint":         true,
+	"dropView":                     true,
+	"mergeColumns":                 true,
+	"modifyDataType":               true,
+	"renameColumn":                 true,
+	"renameTable":                  true,
+	"renameView":                   true,
+}
+
+var (
+	// destructiveSQL matches the statements of SQL changes equivalent to
+	// destructiveChanges.
+	destructiveSQL = regexp.MustCompile(`(?is)\b(DROP\s+(TABLE|COLUMN|INDEX|VIEW|MATERIALIZED\s+VIEW|SEQUENCE|SCHEMA|FUNCTION|PROCEDURE|TRIGGER|CONSTRAINT|TYPE|EXTENSION|DEFAULT|NOT\s+NULL)|TRUNCATE|DELETE\s+FROM|RENAME|ALTER\s+COLUMN\s+\S+\s+(SET\s+DATA\s+)?TYPE)\b`)
+	// tableSQL matches the table names of SQL statements.
+	tableSQL    = regexp.MustCompile(`(?is)\b(?:CREATE\s+(?:UNLOGGED\s+)?TABLE(?:\s+IF\s+NOT\s+EXISTS)?|ALTER\s+TABLE(?:\s+IF\s+EXISTS)?(?:\s+ONLY)?|DROP\s+TABLE(?:\s+IF\s+EXISTS)?|INSERT\s+INTO|UPDATE(?:\s+ONLY)?|DELETE\s+FROM(?:\s+ONLY)?|REFERENCES)\s+([A-Za-z_][\w.]*)`)
+	sqlKeywords = map[string]bool{"OR": true, "ON": true, "OF": true, "SET": true, "CASCADE": true, "RESTRICT": true, "NO": true}
+	devContext  = regexp.MustCompile(`(?i)(^|[^!\w])dev\b`)
+)
+
+// Issue is a broken rule.
+type Issue struct {
+	Rule string `json:"rule"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+	// ChangeSet is the author:id of the changeset.
+	ChangeSet string `json:"changeset"`
+	Message   string `json:"message"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s:%d: %s: [%s] %s", i.File, i.Line, i.ChangeSet, i.Rule, i.Message)
+}
+
+// Report is the result of linting a master changelog. Allowed counts the
+// issues left out because the allow list accepts them.
+type Report struct {
+	Changelog  string  `json:"changelog"`
+	ChangeSets int     `json:"changesets"`
+	Issues     []Issue `json:"issues"`
+	Allowed    int     `json:"allowed"`
+}
+
+// AllowListFile is read from the directory of the master changelog when it
+// exists. Each line holds a changeset and a rule it may break,
+// "author:id rule", for changesets that were applied before the rule: their
+// author or content cannot change without Liquibase running them again.
+// Blank lines and lines starting with # are skipped.
+const AllowListFile = "changeloglint.allow"
+
+// Options configure Lint.
+type Options struct {
+	// Disabled lists the rules not to check.
+	Disabled []string
+}
+
+// Lint loads the master changelog at path with the files it includes and
+// checks their changesets.
+func Lint(path string, opts Options) (*Report, error) {
+	changeSets, err := liquibase.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	disabled := make(map[string]bool)
+	for _, rule := range opts.Disabled {
+		disabled[rule] = true
+	}
+	allowed, err := loadAllowList(filepath.Join(filepath.Dir(path), AllowListFile))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &linter{
+		seen:    make(map[string]liquibase.ChangeSet),
+		schemas: make(map[string]tableSchema),
+		issues:  []Issue{},
+	}
+	for _, cs := range changeSets {
+		l.changeSet(cs)
+	}
+
+	report := &Report{Changelog: path, ChangeSets: len(changeSets), Issues: []Issue{}}
+	for _, issue := range l.issues {
+		switch {
+		case disabled[issue.Rule]:
+		case allowed[issue.ChangeSet+" "+issue.Rule]:
+			report.Allowed++
+		default:
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+	return report, nil
+}
+
+// loadAllowList reads an allow list into a set of "author:id rule" entries.
+// A missing file allows nothing.
+func loadAllowList(path string) (map[string]bool, error) {
+	allowed := make(map[string]bool)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return allowed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 || !strings.Contains(fields[0], ":") {
+			return nil, fmt.Errorf("%s:%d: expected \"author:id rule\"", path, line)
+		}
+		if !knownRule(fields[1]) {
+			return nil, fmt.Errorf("%s:%d: unknown rule %q", path, line, fields[1])
+		}
+		allowed[fields[0]+" "+fields[1]] = true
+	}
+	return allowed, scanner.Err()
+}
+
+func knownRule(rule string) bool {
+	for _, r := range Rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// tableSchema is where a table was first seen with a schema.
+type tableSchema struct {
+	Schema string
+	File   string
+	Line   int
+}
+
+type linter struct {
+	seen    map[string]liquibase.ChangeSet
+	schemas map[string]tableSchema
+	issues  []Issue
+}
+
+func (l *linter) report(cs liquibase.ChangeSet, rule string, line int, format string, args ...interface{}) {
+	l.issues = append(l.issues, Issue{
+		Rule:      rule,
+		File:      cs.File,
+		Line:      line,
+		ChangeSet: cs.Author + ":" + cs.ID,
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) changeSet(cs liquibase.ChangeSet) {
+	key := cs.Author + ":" + cs.ID
+	if first, ok := l.seen[key]; ok {
+		l.report(cs, RuleUniqueID, cs.Line, "id and author already used at %s:%d", first.File, first.Line)
+	} else {
+		l.seen[key] = cs
+	}
+
+	if cs.Author == "" {
+		l.report(cs, RulePlaceholderAuthor, cs.Line, "author is missing")
+	}
+	for _, placeholder := range PlaceholderAuthors {
+		if strings.EqualFold(cs.Author, placeholder) {
+			l.report(cs, RulePlaceholderAuthor, cs.Line, "author %q is a placeholder", cs.Author)
+		}
+	}
+
+	if strings.TrimSpace(cs.Comment) == "" {
+		l.report(cs, RuleComment, cs.Line, "comment is missing")
+	}
+
+	if cs.Rollback == nil {
+		for _, c := range cs.Changes {
+			if line, what := destructive(cs, c); line > 0 {
+				l.report(cs, RuleRollback, line, "%s without a rollback", what)
+				break
+			}
+		}
+	}
+
+	if inDevDirectory(cs.File) && !devContext.MatchString(cs.Context) {
+		l.report(cs, RuleDevContext, cs.Line, "development changeset without context %s", DevDirectory)
+	}
+
+	if cs.RunAlways && (cs.Preconditions == nil || len(cs.Preconditions.Conditions) == 0) {
+		l.report(cs, RuleRunAlways, cs.Line, "runAlways without preconditions")
+	}
+
+	for _, c := range append(append([]liquibase.Change{}, cs.Changes...), cs.Rollback...) {
+		l.schemaNames(cs, c)
+	}
+}
+
+// destructive returns the line and description of the first destructive
+// statement of a change, or 0.
+func destructive(cs liquibase.ChangeSet, c liquibase.Change) (int, string) {
+	if destructiveChanges[c.Type] {
+		return c.Line, c.Type
+	}
+	if c.Type != "sql" {
+		return 0, ""
+	}
+	text := maskSQL(c.SQL)
+	if loc := destructiveSQL.FindStringIndex(text); loc != nil {
+		return sqlLine(cs, c, loc[0]), strings.ToUpper(strings.Join(strings.Fields(text[loc[0]:loc[1]]), " "))
+	}
+	return 0, ""
+}
+
+// schemaNames checks the tables of a change are qualified with a schema,
+// and always the same one.
+func (l *linter) schemaNames(cs liquibase.ChangeSet, c liquibase.Change) {
+	if c.Type == "sql" {
+		text := maskSQL(c.SQL)
+		for _, match := range tableSQL.FindAllStringSubmatchIndex(text, -1) {
+			name := text[match[2]:match[3]]
+			if sqlKeywords[strings.ToUpper(name)] {
+				continue
+			}
+			schema, table, _ := strings.Cut(name, ".")
+			if table == "" {
+				schema, table = "", name
+			}
+			l.tableSchema(cs, sqlLine(cs, c, match[2]), strings.ToLower(schema), strings.ToLower(table))
+		}
+		return
+	}
+
+	if c.Node == nil {
+		return
+	}
+	var attrs struct {
+		SchemaName string `yaml:"schemaName"`
+		TableName  string `yaml:"tableName"`
+	}
+	if err := c.Node.Decode(&attrs); err != nil || attrs.TableName == "" {
+		return
+	}
+	l.tableSchema(cs, c.Line, attrs.SchemaName, attrs.TableName)
+}
+
+func (l *linter) tableSchema(cs liquibase.ChangeSet, line int, schema, table string) {
+	if schema == "" {
+		l.report(cs, RuleSchemaName, line, "table %s without schema", table)
+		return
+	}
+	first, ok := l.schemas[table]
+	if !ok {
+		l.schemas[table] = tableSchema{Schema: schema, File: cs.File, Line: line}
+		return
+	}
+	if first.Schema != schema {
+		l.report(cs, RuleSchemaName, line, "table %s in schema %s, but in %s at %s:%d", table, schema, first.Schema, first.File, first.Line)
+	}
+}
+
+func inDevDirectory(file string) bool {
+	for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(file)), "/") {
+		if dir == DevDirectory {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlLine returns the changelog line of an offset in the SQL of a change.
+// The line of a formatted SQL change is its first statement, but its SQL
+// starts with the comments above it. YAML changes have no finer line than
+// the change itself.
+func sqlLine(cs liquibase.ChangeSet, c liquibase.Change, offset int) int {
+	if cs.Format != liquibase.FormatSQL {
+		return c.Line
+	}
+	line := c.Line + strings.Count(c.SQL[:offset], "\n")
+	for _, text := range strings.Split(c.SQL, "\n") {
+		if t := strings.TrimSpace(text); t != "" && !strings.HasPrefix(t, "--") {
+			break
+		}
+		line--
+	}
+	return line
+}
+
+// maskSQL blanks out comments, string literals and dollar-quoted bodies,
+// keeping offsets and line breaks, so that statements are only matched in
+// SQL code.
+func maskSQL(s string) string {
+	out := []byte(s)
+	blank := func(from, to int) {
+		for i := from; i < to && i < len(out); i++ {
+			if out[i] != '\n' {
+				out[i] = ' '
+			}
+		}
+	}
+
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				end = len(s) - i
+			}
+			blank(i, i+end)
+			i += end
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				end = len(s) - i - 4
+			}
+			blank(i, i+end+4)
+			i += end + 4
+		case s[i] == '\'':
+			j := i + 1
+			for j < len(s) {
+				if s[j] == '\'' {
+					if j+1 < len(s) && s[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			blank(i+1, j)
+			i = j + 1
+		case s[i] == '$':
+			tag := dollarTag.FindString(s[i:])
+			if tag == "" {
+				i++
+				continue
+			}
+			end := strings.Index(s[i+len(tag):], tag)
+			if end < 0 {
+				end = len(s) - i - len(tag)
+			}
+			blank(i+len(tag), i+len(tag)+end)
+			i += 2*len(tag) + end
+		default:
+			i++
+		}
+	}
+	return string(out)
+}
+
+var dollarTag = regexp.MustCompile(`^\$([A-Za-z_][\w]*)?\$`)
@@ -0,0 +1,92 @@
+// Command changeloglint checks Liquibase master changelogs and the files
+// they include against the team rules. It prints one line per issue, or a
+// JSON report with -json, and exits with status 2 on issues and 1 on errors.
+//
+//	go run ./cmd/changeloglint ../liquibase/master-changelog.yaml ../liquibase-sql/master-changelog.xml
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/changeloglint"
+)
+
+var defaultChangelogs = []string{"../liquibase/master-changelog.yaml", "../liquibase-sql/master-changelog.xml"}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+func run(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("changeloglint", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "print a JSON report")
+	disable := flags.String("disable", "", "comma separated rules not to check: "+strings.Join(changeloglint.Rules, ", "))
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	var opts changeloglint.Options
+	for _, rule := range strings.Split(*disable, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		if !known(rule) {
+			log.Printf("Unknown rule %q", rule)
+			return 1
+		}
+		opts.Disabled = append(opts.Disabled, rule)
+	}
+
+	changelogs := flags.Args()
+	if len(changelogs) == 0 {
+		changelogs = defaultChangelogs
+	}
+
+	reports := []*changeloglint.Report{}
+	issues := 0
+	for _, path := range changelogs {
+		report, err := changeloglint.Lint(path, opts)
+		if err != nil {
+			log.Printf("Error reading changelog: %v", err)
+			return 1
+		}
+		reports = append(reports, report)
+		issues += len(report.Issues)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			log.Printf("Error writing report: %v", err)
+			return 1
+		}
+	} else {
+		for _, report := range reports {
+			for _, issue := range report.Issues {
+				_, _ = fmt.Fprintln(out, issue)
+			}
+			_, _ = fmt.Fprintf(out, "%s: %d changesets, %d issues, %d allowed\n", report.Changelog, report.ChangeSets, len(report.Issues), report.Allowed)
+		}
+	}
+
+	if issues > 0 {
+		return 2
+	}
+	return 0
+}
+
+func known(rule string) bool {
+	for _, r := range changeloglint.Rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+// Command migrationconv converts Flyway scripts to Liquibase formatted SQL or
+// YAML changelogs, and Liquibase changelogs to Flyway scripts. A single
+// converted file is printed; several are written to the -out directory.
+//
+//	go run ./cmd/migrationconv -to liquibase-sql ../flyway/sql/V2__Create_Audit_Tables.sql
+//	go run ./cmd/migrationconv -to flyway -out /tmp/flyway ../liquibase/master-changelog.yaml
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/migrationconv"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/migrationgen"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+func run(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("migrationconv", flag.ContinueOnError)
+	to := flags.String("to", "", fmt.Sprintf("output format: %s, %s or %s", migrationgen.ToolLiquibaseSQL, migrationgen.ToolLiquibaseYAML, migrationgen.ToolFlyway))
+	author := flags.String("author", "", "author of the Liquibase changesets (default template_service)")
+	outDir := flags.String("out", "", "directory to write the converted files to")
+	force := flags.Bool("force", false, "overwrite existing files in -out")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() == 0 {
+		log.Println("Usage: migrationconv -to <format> [-out <dir>] <script, directory or changelog>...")
+		return 1
+	}
+
+	var files []migrationconv.File
+	for _, path := range flags.Args() {
+		converted, err := migrationconv.Convert(path, migrationconv.Options{To: *to, Author: *author})
+		if err != nil {
+			log.Printf("Error converting %s: %v", path, err)
+			return 1
+		}
+		files = append(files, converted...)
+	}
+
+	if *outDir == "" {
+		if len(files) != 1 {
+			log.Printf("%d files converted, set -out to write them", len(files))
+			return 1
+		}
+		if _, err := out.Write(files[0].Data); err != nil {
+			log.Printf("Error writing %s: %v", files[0].Name, err)
+			return 1
+		}
+		return 0
+	}
+
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		log.Printf("Error creating %s: %v", *outDir, err)
+		return 1
+	}
+	for _, f := range files {
+		path := filepath.Join(*outDir, f.Name)
+		if _, err := os.Stat(path); err == nil && !*force {
+			log.Printf("%s exists, set -force to overwrite it", path)
+			return 1
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error checking %s: %v", path, err)
+			return 1
+		}
+		if err := os.WriteFile(path, f.Data, 0o644); err != nil {
+			log.Printf("Error writing %s: %v", path, err)
+			return 1
+		}
+		_, _ = fmt.Fprintln(out, path)
+	}
+	return 0
+}
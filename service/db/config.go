@@ -0,0 +1,232 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the database connection and pool configuration.
+type Config struct {
+	// URL is a postgres:// connection URL. When set, it replaces Host,
+	// Port, User, Password and Name.
+	URL      string
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string
+
+	// SSLMode is the libpq sslmode. With a URL, an sslmode in the URL is
+	// kept unless DB_SSLMODE is set.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	// Schema is put first on the search path, before public.
+	Schema string
+	// StatementTimeout cancels statements running longer, 0 for no limit.
+	StatementTimeout time.Duration
+	// QueryTimeout is the deadline the service gives each database call,
+	// 0 for none. Unlike StatementTimeout it is enforced by the client, so
+	// it also covers waiting for a connection.
+	QueryTimeout time.Duration
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// LoadConfig reads the configuration from DATABASE_URL and the DB_*
+// environment variables.
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		URL:         os.Getenv("DATABASE_URL"),
+		Host:        getEnv("DB_HOST", "localhost"),
+		Port:        getEnv("DB_PORT", "5432"),
+		User:        getEnv("DB_USER", "template_user"),
+		Password:    getEnv("DB_PASSWORD", "template_pass"),
+		Name:        getEnv("DB_NAME", "template_db"),
+		SSLMode:     os.Getenv("DB_SSLMODE"),
+		SSLRootCert: os.Getenv("DB_SSLROOTCERT"),
+		SSLCert:     os.Getenv("DB_SSLCERT"),
+		SSLKey:      os.Getenv("DB_SSLKEY"),
+		Schema:      getEnv("DB_SCHEMA", "template_service"),
+	}
+
+	var err error
+	if cfg.StatementTimeout, err = envDuration("DB_STATEMENT_TIMEOUT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.QueryTimeout, err = envDuration("DB_QUERY_TIMEOUT", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxOpenConns, err = envInt("DB_MAX_OPEN_CONNS", 10); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxIdleConns, err = envInt("DB_MAX_IDLE_CONNS", 5); err != nil {
+		return cfg, err
+	}
+	if cfg.ConnMaxLifetime, err = envDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.ConnMaxIdleTime, err = envDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+
+	if cfg.URL != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return cfg, fmt.Errorf("DATABASE_URL: %w", err)
+		}
+		if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			return cfg, fmt.Errorf("DATABASE_URL: unsupported scheme %q", u.Scheme)
+		}
+		if cfg.SSLMode == "" {
+			cfg.SSLMode = u.Query().Get("sslmode")
+		}
+	}
+	if cfg.SSLMode == "" {
+		cfg.SSLMode = "disable"
+	}
+	return cfg, nil
+}
+
+// params returns the connection parameters other than the server and
+// credentials.
+func (c Config) params() map[string]string {
+	params := map[string]string{"sslmode": c.SSLMode}
+	if c.SSLRootCert != "" {
+		params["sslrootcert"] = c.SSLRootCert
+	}
+	if c.SSLCert != "" {
+		params["sslcert"] = c.SSLCert
+	}
+	if c.SSLKey != "" {
+		params["sslkey"] = c.SSLKey
+	}
+	// lib/pq sends unknown parameters as run-time settings of the session.
+	if c.Schema != "" && c.Schema != "public" {
+		params["search_path"] = c.Schema + ",public"
+	}
+	if c.StatementTimeout > 0 {
+		params["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+	return params
+}
+
+// ConnectionString returns the lib/pq connection string: DATABASE_URL with
+// the other settings added as query parameters, or a key=value string.
+func (c Config) ConnectionString() string {
+	params := c.params()
+	if c.URL != "" {
+		// LoadConfig has checked the URL.
+		u, _ := url.Parse(c.URL)
+		q := u.Query()
+		for k, v := range params {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	parts := []string{
+		"host=" + quoteParam(c.Host),
+		"port=" + quoteParam(c.Port),
+		"user=" + quoteParam(c.User),
+		"password=" + quoteParam(c.Password),
+		"dbname=" + quoteParam(c.Name),
+	}
+	var keys []string
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, k+"="+quoteParam(params[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteParam quotes a key=value connection string value when it is empty
+// or holds spaces, quotes or backslashes.
+func quoteParam(s string) string {
+	if s != "" && !strings.ContainsAny(s, " '\\\t\n") {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// Open opens a connection pool with the settings of cfg. Like sql.Open, it
+// does not connect.
+func Open(cfg Config) (*sql.DB, error) {
+	pool, err := sql.Open("postgres", cfg.ConnectionString())
+	if err != nil {
+		return nil, err
+	}
+	pool.SetMaxOpenConns(cfg.MaxOpenConns)
+	pool.SetMaxIdleConns(cfg.MaxIdleConns)
+	pool.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	pool.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	return pool, nil
+}
+
+// PoolStats reports the state of the connection pool.
+type PoolStats struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDurationMs     int64 `json:"wait_duration_ms"`
+	MaxIdleClosed      int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64 `json:"max_lifetime_closed"`
+}
+
+// Stats returns the statistics of the DB pool.
+func Stats() PoolStats {
+	s := DB.Stats()
+	return PoolStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDurationMs:     s.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	}
+}
+
+func envInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s: %q is not a non-negative integer", key, value)
+	}
+	return n, nil
+}
+
+func envDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s: %q is not a duration such as 30s or 5m", key, value)
+	}
+	return d, nil
+}
@@ -1,73 +1,132 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var DB *sql.DB
 
-func WaitForDatabase() {
-	host := getEnv("DB_HOST", "localhost")
-	port := getEnv("DB_PORT", "5432")
-	user := getEnv("DB_USER", "template_user")
-	password := getEnv("DB_PASSWORD", "template_pass")
-	dbname := getEnv("DB_NAME", "template_db")
+// queryTimeout is the deadline WithQueryTimeout gives database calls, set by
+// Connect from DB_QUERY_TIMEOUT.
+var queryTimeout time.Duration
 
-	log.Println("Waiting for database to be ready...")
-
-	maxRetries := 30
-	for i := 0; i < maxRetries; i++ {
-		connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-			host, port, user, password, dbname)
+// Backoff between connection attempts while waiting for the database.
+const (
+	initialBackoff = 250 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+	// pingTimeout bounds a single readiness check.
+	pingTimeout = 5 * time.Second
+)
 
-		db, err := sql.Open("postgres", connStr)
-		if err == nil {
-			err = db.Ping()
-			if err == nil {
-				log.Println("Database is ready!")
-				err := db.Close()
-				if err != nil {
-					return
-				}
-				return
-			}
+// Connect opens the DB pool configured by the environment and waits up to
+// maxWait for the database to accept connections.
+func Connect(ctx context.Context, maxWait time.Duration) error {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("invalid database configuration: %w", err)
+	}
+	pool, err := Open(cfg)
+	if err != nil {
+		return err
+	}
+	if err := WaitForDatabase(ctx, pool, maxWait); err != nil {
+		if err := pool.Close(); err != nil {
+			log.Printf("Error closing database: %v", err)
 		}
-
-		log.Printf("Database not ready yet, retrying in 2 seconds (attempt %d/%d)...", i+1, maxRetries)
-		time.Sleep(2 * time.Second)
+		return err
 	}
 
-	log.Fatal("Database not available after maximum retries")
+	DB = pool
+	queryTimeout = cfg.QueryTimeout
+	log.Println("Connected to database")
+	return nil
 }
 
-func SetupDatabase() {
-	host := getEnv("DB_HOST", "localhost")
-	port := getEnv("DB_PORT", "5432")
-	user := getEnv("DB_USER", "template_user")
-	password := getEnv("DB_PASSWORD", "template_pass")
-	dbname := getEnv("DB_NAME", "template_db")
+// WaitForDatabase checks the database with Ping until it accepts
+// connections, waiting with exponential backoff and jitter between
+// attempts. It gives up when ctx is done, after maxWait unless it is 0, or
+// at once when the database rejects the credentials, as retrying cannot
+// help then.
+func WaitForDatabase(ctx context.Context, pool *sql.DB, maxWait time.Duration) error {
+	if maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxWait)
+		defer cancel()
+	}
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
+	log.Println("Waiting for database to be ready...")
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := Ping(ctx, pool)
+		if err == nil {
+			log.Println("Database is ready!")
+			return nil
+		}
+		if isPermanent(err) {
+			return fmt.Errorf("database rejected the connection: %w", err)
+		}
 
-	var err error
-	DB, err = sql.Open("postgres", connStr)
-	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		// Equal jitter, between half and all of the backoff, keeps replicas
+		// starting together from retrying in step.
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		log.Printf("Database not ready yet, retrying in %s (attempt %d): %v", delay.Round(time.Millisecond), attempt, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("database not available after %d attempts: %w", attempt, err)
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
+}
 
-	err = DB.Ping()
-	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+// WithQueryTimeout returns ctx bounded by the DB_QUERY_TIMEOUT deadline.
+// Every database call of the service runs under it, so a slow query fails
+// instead of holding the request and its connection: with
+// context.DeadlineExceeded, or with query_canceled (57014) when lib/pq had to
+// cancel the running statement. An earlier deadline of ctx is kept.
+func WithQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if queryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, queryTimeout)
+}
 
-	log.Println("Connected to database")
+// Ping checks that the database accepts connections and queries. It is the
+// readiness check of both the startup wait and the health endpoint.
+func Ping(ctx context.Context, pool *sql.DB) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return pool.PingContext(ctx)
+}
+
+// isPermanent reports whether a connection error will not go away by
+// retrying: wrong credentials or a missing database, rather than a server
+// that is down or still starting.
+func isPermanent(err error) bool {
+	if errors.Is(err, pq.ErrSSLNotSupported) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 28 is invalid_authorization_specification, which includes
+		// invalid_password; 3D000 is invalid_catalog_name.
+		return pqErr.Code.Class() == "28" || pqErr.Code == "3D000"
+	}
+	return false
 }
 
 func getEnv(key, defaultValue string) string {
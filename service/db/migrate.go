@@ -0,0 +1,414 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Flyway scripts are copied here by "make sync-migrations", as go:embed
+// cannot reach ../flyway/sql.
+//
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+const (
+	// MigrationHistoryTable records the scripts applied by Migrate. It is
+	// separate from flyway_schema_history so both runners can coexist.
+	MigrationHistoryTable = "template_service.service_schema_history"
+
+	// migrationLockKey is the advisory lock held while migrating, so replicas
+	// starting together apply every script once. It spells "template".
+	migrationLockKey int64 = 0x74656d706c617465
+)
+
+// Migration types in the history table.
+const (
+	MigrationVersioned  = "versioned"
+	MigrationRepeatable = "repeatable"
+	// MigrationBaseline marks scripts found already applied by Flyway or
+	// Liquibase when the history table was first created.
+	MigrationBaseline = "baseline"
+)
+
+// Migration is an embedded Flyway script.
+type Migration struct {
+	// Version is 0 for repeatable scripts.
+	Version     int
+	Description string
+	Script      string
+	// Checksum is the SHA-256 of the script after placeholder replacement,
+	// so repeatable scripts run again when the environment changes.
+	Checksum string
+
+	sql string
+}
+
+var (
+	versionedScript  = regexp.MustCompile(`^V(\d+)__(.+)\.sql$`)
+	repeatableScript = regexp.MustCompile(`^R__(.+)\.sql$`)
+	placeholder      = regexp.MustCompile(`\$\{([A-Za-z0-9_.:]+)\}`)
+	// systemInfoVersion finds the schema version a script records, which
+	// the Flyway and Liquibase migrations share.
+	systemInfoVersion = regexp.MustCompile(`(?i)INSERT INTO template_service\.system_info \(version, description\)\s*VALUES \('([^']+)'`)
+)
+
+// MigrationEnvironment returns the ENVIRONMENT the migrations run for, or
+// "production" when it is unset: R__Dev_Data only seeds its fixtures for
+// "dev", and a deploy that forgets the variable must not get them.
+func MigrationEnvironment() string {
+	if environment := os.Getenv("ENVIRONMENT"); environment != "" {
+		return environment
+	}
+	return "production"
+}
+
+// LoadMigrations returns the embedded scripts, versioned ones first in
+// version order followed by repeatable ones by name, with ${environment}
+// replaced. Unknown placeholders are an error, as with Flyway.
+func LoadMigrations(environment string) ([]Migration, error) {
+	migrations, err := fs.Sub(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return LoadMigrationsFrom(migrations, environment)
+}
+
+// LoadMigrationsFrom is LoadMigrations for the scripts in the root of fsys,
+// such as os.DirFS("../flyway/sql").
+func LoadMigrationsFrom(fsys fs.FS, environment string) ([]Migration, error) {
+	placeholders := map[string]string{"environment": environment}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	versions := make(map[int]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != ".sql" {
+			continue
+		}
+		m := Migration{Script: name}
+		if match := versionedScript.FindStringSubmatch(name); match != nil {
+			m.Version, _ = strconv.Atoi(match[1])
+			m.Description = strings.ReplaceAll(match[2], "_", " ")
+			if other, ok := versions[m.Version]; ok {
+				return nil, fmt.Errorf("%s: version %d is also used by %s", name, m.Version, other)
+			}
+			versions[m.Version] = name
+		} else if match := repeatableScript.FindStringSubmatch(name); match != nil {
+			m.Description = strings.ReplaceAll(match[1], "_", " ")
+		} else {
+			return nil, fmt.Errorf("%s: not a V<version>__<name>.sql or R__<name>.sql script", name)
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var unresolved []string
+		m.sql = placeholder.ReplaceAllStringFunc(string(data), func(s string) string {
+			key := placeholder.FindStringSubmatch(s)[1]
+			value, ok := placeholders[key]
+			if !ok {
+				unresolved = append(unresolved, s)
+				return s
+			}
+			return value
+		})
+		if len(unresolved) > 0 {
+			return nil, fmt.Errorf("%s: unresolved placeholders %s", name, strings.Join(unresolved, ", "))
+		}
+		sum := sha256.Sum256([]byte(m.sql))
+		m.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, m)
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		a, b := migrations[i], migrations[j]
+		if (a.Version == 0) != (b.Version == 0) {
+			return a.Version != 0
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Script < b.Script
+	})
+	return migrations, nil
+}
+
+// SQL returns the script with placeholders replaced.
+func (m Migration) SQL() string {
+	return m.sql
+}
+
+// SchemaVersion returns the system_info version the script records, or ""
+// for scripts that record none.
+func (m Migration) SchemaVersion() string {
+	if match := systemInfoVersion.FindStringSubmatch(m.sql); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// appliedMigration is the last successful history entry of a script.
+type appliedMigration struct {
+	version  int
+	script   string
+	checksum string
+}
+
+// Migrate applies the embedded scripts that are not yet in the history
+// table. Every script runs in its own transaction together with its history
+// entry; a failing script is recorded as unsuccessful and stops the run.
+// Repeatable scripts run again whenever their checksum changes.
+func Migrate(environment string) error {
+	migrations, err := LoadMigrations(environment)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("Error releasing migration connection: %v", err)
+		}
+	}()
+
+	// Neither waiting for the lock nor a long script should hit
+	// DB_STATEMENT_TIMEOUT; RESET ALL below restores it.
+	if _, err := conn.ExecContext(ctx, `SET statement_timeout = 0`); err != nil {
+		return err
+	}
+
+	// Session-level advisory locks belong to the connection, so the lock,
+	// the scripts and the unlock all use conn.
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer func() {
+		// Scripts SET search_path; don't leak it into the pool.
+		if _, err := conn.ExecContext(ctx, `RESET ALL`); err != nil {
+			log.Printf("Error resetting migration connection: %v", err)
+		}
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey); err != nil {
+			log.Printf("Error releasing migration lock: %v", err)
+		}
+	}()
+
+	if err := createHistoryTable(ctx, conn); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		if applied, err = baseline(ctx, conn, migrations); err != nil {
+			return err
+		}
+	}
+
+	latest := 0
+	versions := make(map[int]appliedMigration)
+	scripts := make(map[string]appliedMigration)
+	for _, a := range applied {
+		if a.version > 0 {
+			versions[a.version] = a
+			if a.version > latest {
+				latest = a.version
+			}
+		} else {
+			scripts[a.script] = a
+		}
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if m.Version > 0 {
+			if a, ok := versions[m.Version]; ok {
+				if a.checksum != m.Checksum {
+					return fmt.Errorf("%s: checksum changed since it was applied, add a new version instead of editing it", m.Script)
+				}
+				continue
+			}
+			if m.Version < latest {
+				return fmt.Errorf("%s: version %d is older than the applied version %d", m.Script, m.Version, latest)
+			}
+		} else if a, ok := scripts[m.Script]; ok && a.checksum == m.Checksum {
+			continue
+		}
+
+		if err := applyMigration(ctx, conn, m); err != nil {
+			return err
+		}
+		count++
+	}
+
+	if count == 0 {
+		log.Println("Database schema is up to date")
+	}
+	return nil
+}
+
+func createHistoryTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE SCHEMA IF NOT EXISTS template_service;
+		CREATE TABLE IF NOT EXISTS `+MigrationHistoryTable+`
+		(
+			installed_rank    SERIAL PRIMARY KEY,
+			version           INTEGER,
+			description       VARCHAR(200) NOT NULL,
+			type              VARCHAR(20)  NOT NULL,
+			script            VARCHAR(1000) NOT NULL,
+			checksum          VARCHAR(64)  NOT NULL,
+			installed_by      VARCHAR(100) NOT NULL DEFAULT CURRENT_USER,
+			installed_on      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			execution_time_ms INTEGER      NOT NULL DEFAULT 0,
+			success           BOOLEAN      NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", MigrationHistoryTable, err)
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) ([]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT DISTINCT ON (script) COALESCE(version, 0), script, checksum
+		FROM `+MigrationHistoryTable+`
+		WHERE success
+		ORDER BY script, installed_rank DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}(rows)
+
+	var applied []appliedMigration
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.version, &a.script, &a.checksum); err != nil {
+			return nil, err
+		}
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// baseline records the versioned scripts whose system_info version is
+// already present, for databases migrated by the Flyway or Liquibase
+// containers before the runner was enabled.
+func baseline(ctx context.Context, conn *sql.Conn, migrations []Migration) ([]appliedMigration, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, `SELECT to_regclass('template_service.system_info') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM template_service.system_info`)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}(rows)
+	installed := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		installed[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var applied []appliedMigration
+	for _, m := range migrations {
+		if m.Version == 0 || !installed[m.SchemaVersion()] {
+			continue
+		}
+		_, err := conn.ExecContext(ctx, `
+			INSERT INTO `+MigrationHistoryTable+` (version, description, type, script, checksum, success)
+			VALUES ($1, $2, $3, $4, $5, TRUE)`,
+			m.Version, m.Description, MigrationBaseline, m.Script, m.Checksum)
+		if err != nil {
+			return nil, fmt.Errorf("recording baseline of %s: %w", m.Script, err)
+		}
+		applied = append(applied, appliedMigration{version: m.Version, script: m.Script, checksum: m.Checksum})
+	}
+	if len(applied) > 0 {
+		log.Printf("Baselined %d migrations already applied to the database", len(applied))
+	}
+	return applied, nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m Migration) error {
+	migrationType := MigrationVersioned
+	var version sql.NullInt64
+	if m.Version > 0 {
+		version = sql.NullInt64{Int64: int64(m.Version), Valid: true}
+	} else {
+		migrationType = MigrationRepeatable
+	}
+	record := `INSERT INTO ` + MigrationHistoryTable + ` (version, description, type, script, checksum, execution_time_ms, success)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	start := time.Now()
+	err := func() error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+
+		if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+			return err
+		}
+		elapsed := time.Since(start).Milliseconds()
+		if _, err := tx.ExecContext(ctx, record, version, m.Description, migrationType, m.Script, m.Checksum, elapsed, true); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}()
+	elapsed := time.Since(start).Milliseconds()
+	if err != nil {
+		if _, recordErr := conn.ExecContext(ctx, record, version, m.Description, migrationType, m.Script, m.Checksum, elapsed, false); recordErr != nil {
+			log.Printf("Error recording failed migration %s: %v", m.Script, recordErr)
+		}
+		return fmt.Errorf("%s: %w", m.Script, err)
+	}
+
+	log.Printf("Applied migration %s (%d ms)", m.Script, elapsed)
+	return nil
+}
@@ -0,0 +1,22 @@
+SET search_path TO template_service, public;
+CREATE OR REPLACE FUNCTION audit.log_change()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql AS
+'BEGIN
+    INSERT INTO audit.audit_log (entity_type,
+                                 entity_id,
+                                 action,
+                                 user_id,
+                                 change_data)
+    VALUES (TG_TABLE_NAME,
+            CASE WHEN TG_OP = ''DELETE'' THEN OLD.id::text ELSE NEW.id::text END,
+            TG_OP,
+            COALESCE(current_setting(''app.current_user_id'', true), ''system''),
+            jsonb_build_object(
+                    ''old'', to_jsonb(OLD),
+                    ''new'', to_jsonb(NEW)
+            ));
+    RETURN COALESCE(NEW, OLD);
+END;';
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.4', 'Audit trigger handles deleted rows');
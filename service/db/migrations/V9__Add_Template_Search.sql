@@ -0,0 +1,54 @@
+SET search_path TO template_service, public;
+CREATE TABLE template_service.template_search
+(
+    template_id UUID     NOT NULL PRIMARY KEY REFERENCES template_service.template (id) ON DELETE CASCADE,
+    document    TSVECTOR NOT NULL
+);
+CREATE INDEX idx_template_search_document ON template_service.template_search USING GIN (document);
+CREATE OR REPLACE FUNCTION template_service.refresh_template_search(p_template_id UUID)
+    RETURNS VOID
+    LANGUAGE plpgsql
+AS
+'
+BEGIN
+    INSERT INTO template_service.template_search (template_id, document)
+    SELECT t.id,
+           setweight(to_tsvector(''english'', t.name), ''A'') ||
+           setweight(to_tsvector(''english'', t.content), ''B'') ||
+           setweight(to_tsvector(''english'', COALESCE((SELECT string_agg(v.variable_name || '' '' || COALESCE(v.description, ''''), '' '')
+                                                        FROM template_service.template_variable v
+                                                        WHERE v.template_id = t.id), '''')), ''C'')
+    FROM template_service.template t
+    WHERE t.id = p_template_id
+    ON CONFLICT (template_id) DO UPDATE SET document = EXCLUDED.document;
+END;';
+CREATE OR REPLACE FUNCTION template_service.template_search_trigger()
+    RETURNS TRIGGER
+    LANGUAGE plpgsql
+AS
+'
+BEGIN
+    IF TG_TABLE_NAME = ''template'' THEN
+        PERFORM template_service.refresh_template_search(NEW.id);
+    ELSIF TG_OP = ''DELETE'' THEN
+        PERFORM template_service.refresh_template_search(OLD.template_id);
+    ELSE
+        PERFORM template_service.refresh_template_search(NEW.template_id);
+    END IF;
+    RETURN NULL;
+END;';
+CREATE TRIGGER template_search_update
+    AFTER INSERT OR UPDATE OF name, content
+    ON template_service.template
+    FOR EACH ROW
+EXECUTE FUNCTION template_service.template_search_trigger();
+
+CREATE TRIGGER template_variable_search_update
+    AFTER INSERT OR UPDATE OR DELETE
+    ON template_service.template_variable
+    FOR EACH ROW
+EXECUTE FUNCTION template_service.template_search_trigger();
+SELECT template_service.refresh_template_search(id)
+FROM template_service.template;
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.8', 'Added full-text search over templates');
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequiredSchemaVersion is the oldest system_info version the service works
+// with. It can be set at build time with
+// -ldflags "-X github.com/elvismanchkin/migration_tools_poc_liquibase/db.RequiredSchemaVersion=1.0.10".
+var RequiredSchemaVersion = "1.0.10"
+
+// SchemaVersionError reports a database schema older than required.
+// Current is empty when the schema has not been created.
+type SchemaVersionError struct {
+	Current  string
+	Required string
+}
+
+func (e *SchemaVersionError) Error() string {
+	if e.Current == "" {
+		return fmt.Sprintf("database schema is missing, version %s or later is required; run the migrations first", e.Required)
+	}
+	return fmt.Sprintf("database schema version %s is older than the required %s; run the migrations first", e.Current, e.Required)
+}
+
+// SchemaVersion returns the highest version recorded in system_info, or ""
+// when the table does not exist.
+func SchemaVersion(ctx context.Context) (string, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := DB.QueryRowContext(ctx, `SELECT to_regclass('template_service.system_info') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return "", err
+	}
+
+	rows, err := DB.QueryContext(ctx, `SELECT version FROM template_service.system_info`)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	latest := ""
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return "", err
+		}
+		if latest == "" {
+			latest = version
+			continue
+		}
+		newer, err := CompareSchemaVersions(version, latest)
+		if err != nil {
+			return "", err
+		}
+		if newer > 0 {
+			latest = version
+		}
+	}
+	return latest, rows.Err()
+}
+
+// CheckSchemaVersion returns a *SchemaVersionError when the schema is older
+// than required.
+func CheckSchemaVersion(ctx context.Context, required string) error {
+	current, err := SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return &SchemaVersionError{Required: required}
+	}
+	cmp, err := CompareSchemaVersions(current, required)
+	if err != nil {
+		return err
+	}
+	if cmp < 0 {
+		return &SchemaVersionError{Current: current, Required: required}
+	}
+	return nil
+}
+
+// WaitForSchemaVersion polls every 2 seconds until the schema reaches
+// the required version, for deployments where the migrations run next to
+// the service. It gives up after timeout with the last *SchemaVersionError,
+// or when ctx is done.
+func WaitForSchemaVersion(ctx context.Context, required string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := CheckSchemaVersion(ctx, required)
+		if err == nil {
+			return nil
+		}
+		var versionErr *SchemaVersionError
+		if !errors.As(err, &versionErr) || time.Now().After(deadline) {
+			return err
+		}
+
+		log.Printf("Waiting for schema migrations: %v", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// CompareSchemaVersions compares dotted versions like "1.0.10" part by part,
+// returning -1, 0 or 1. Missing parts count as 0.
+func CompareSchemaVersions(a, b string) (int, error) {
+	pa, err := parseSchemaVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, err := parseSchemaVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func parseSchemaVersion(version string) ([]int, error) {
+	var parts []int
+	for _, s := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid schema version %q", version)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
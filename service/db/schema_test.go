@@ -0,0 +1,47 @@
+package db
+
+import "testing"
+
+func TestCompareSchemaVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.4", "1.0.4", 0},
+		{"1.0.4", "1.0.5", -1},
+		{"1.0.10", "1.0.9", 1},
+		{"2", "1.9.9", 1},
+		{"1.0", "1.0.0", 0},
+		{"1", "1.0.0.0", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.0.0.1", "1.0", 1},
+		{"1.01", "1.1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			got, err := CompareSchemaVersions(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("CompareSchemaVersions(%q, %q): %v", tt.a, tt.b, err)
+			}
+			if got != tt.want {
+				t.Errorf("CompareSchemaVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+			if reverse, _ := CompareSchemaVersions(tt.b, tt.a); reverse != -tt.want {
+				t.Errorf("CompareSchemaVersions(%q, %q) = %d, want %d", tt.b, tt.a, reverse, -tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareSchemaVersionsInvalid(t *testing.T) {
+	for _, version := range []string{"", "1..0", "1.0.", "v1.0", "1.-1", "1.0-beta"} {
+		t.Run(version, func(t *testing.T) {
+			if _, err := CompareSchemaVersions(version, "1.0"); err == nil {
+				t.Errorf("CompareSchemaVersions(%q, \"1.0\") succeeded, want an error", version)
+			}
+			if _, err := CompareSchemaVersions("1.0", version); err == nil {
+				t.Errorf("CompareSchemaVersions(\"1.0\", %q) succeeded, want an error", version)
+			}
+		})
+	}
+}
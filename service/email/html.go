@@ -0,0 +1,100 @@
+package email
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var dataURIImage = regexp.MustCompile(`(?i)(<img\b[^>]*?\bsrc\s*=\s*)(["'])data:([^;,"']+);base64,([^"']+)(["'])`)
+
+// ExtractInlineImages replaces base64 data URI images in the HTML with cid:
+// references and returns the images as inline attachments. Most mail
+// clients block data URIs, while CID attachments display everywhere.
+func ExtractInlineImages(body string) (string, []Attachment, error) {
+	var images []Attachment
+	var firstErr error
+
+	replaced := dataURIImage.ReplaceAllStringFunc(body, func(tag string) string {
+		m := dataURIImage.FindStringSubmatch(tag)
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(m[4]), ""))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("decoding inline image %d: %w", len(images)+1, err)
+			}
+			return tag
+		}
+
+		contentID := fmt.Sprintf("image%d.%s@template-service", len(images)+1, randomHex(4))
+		images = append(images, Attachment{
+			Filename:    fmt.Sprintf("image%d%s", len(images)+1, extension(m[3])),
+			ContentType: m[3],
+			ContentID:   contentID,
+			Data:        data,
+		})
+		return m[1] + m[2] + "cid:" + contentID + m[5]
+	})
+
+	return replaced, images, firstErr
+}
+
+func extension(contentType string) string {
+	exts, err := mime.ExtensionsByType(contentType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
+
+var (
+	textDropped  = regexp.MustCompile(`(?is)<(head|script|style)[^>]*>.*?</(head|script|style)>`)
+	textLink     = regexp.MustCompile(`(?is)<a\b[^>]*?\bhref\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	textHeading  = regexp.MustCompile(`(?is)<h[1-6][^>]*>(.*?)</h[1-6]\s*>`)
+	textListItem = regexp.MustCompile(`(?i)<li[^>]*>`)
+	textBreak    = regexp.MustCompile(`(?i)<br\s*/?>`)
+	textBlock    = regexp.MustCompile(`(?i)</?(p|div|tr|ul|ol|table|section|article|header|footer|blockquote|hr)[^>]*>`)
+	textCell     = regexp.MustCompile(`(?i)</t[dh]\s*>`)
+	textTag      = regexp.MustCompile(`<[^>]*>`)
+	textSpace    = regexp.MustCompile(`[ \t]+`)
+	textBlank    = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText derives the plain text alternative of an HTML email. Links keep
+// their target in brackets, headings are underlined and list items become
+// bullets.
+func HTMLToText(body string) string {
+	body = textDropped.ReplaceAllString(body, "")
+	body = textLink.ReplaceAllStringFunc(body, func(link string) string {
+		m := textLink.FindStringSubmatch(link)
+		text := strings.TrimSpace(textTag.ReplaceAllString(m[2], ""))
+		target := html.UnescapeString(m[1])
+		if target == "" || strings.HasPrefix(target, "#") || strings.HasPrefix(target, "cid:") || text == target {
+			return text
+		}
+		if u, err := url.Parse(target); err == nil && u.Scheme == "mailto" && u.Opaque == text {
+			return text
+		}
+		return text + " [" + target + "]"
+	})
+	body = textHeading.ReplaceAllStringFunc(body, func(heading string) string {
+		text := strings.TrimSpace(html.UnescapeString(textTag.ReplaceAllString(textHeading.FindStringSubmatch(heading)[1], "")))
+		text = textSpace.ReplaceAllString(text, " ")
+		return "\n\n" + text + "\n" + strings.Repeat("=", len([]rune(text))) + "\n\n"
+	})
+	body = textListItem.ReplaceAllString(body, "\n* ")
+	body = textBreak.ReplaceAllString(body, "\n")
+	body = textBlock.ReplaceAllString(body, "\n\n")
+	body = textCell.ReplaceAllString(body, "  ")
+	body = textTag.ReplaceAllString(body, "")
+	body = html.UnescapeString(body)
+
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(textSpace.ReplaceAllString(line, " "))
+	}
+	return strings.TrimSpace(textBlank.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")) + "\n"
+}
@@ -0,0 +1,306 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Attachment is a file carried by a message. Inline attachments are
+// referenced from the HTML body by ContentID ("cid:<ContentID>").
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"`
+	Data        []byte `json:"-"`
+}
+
+// Message is an email built from a rendered template.
+type Message struct {
+	From        string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	ReplyTo     string
+	Subject     string
+	HTML        string
+	Text        string
+	Inline      []Attachment
+	Attachments []Attachment
+	Date        time.Time
+	MessageID   string
+	Headers     map[string]string
+}
+
+// Recipients returns every envelope recipient, including Bcc.
+func (m *Message) Recipients() []string {
+	var all []string
+	all = append(all, m.To...)
+	all = append(all, m.Cc...)
+	all = append(all, m.Bcc...)
+	return all
+}
+
+// Validate checks the addresses of the message.
+func (m *Message) Validate() error {
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		return fmt.Errorf("invalid from address %q: %w", m.From, err)
+	}
+	for _, addr := range m.Recipients() {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("invalid recipient address %q: %w", addr, err)
+		}
+	}
+	if m.ReplyTo != "" {
+		if _, err := mail.ParseAddress(m.ReplyTo); err != nil {
+			return fmt.Errorf("invalid reply-to address %q: %w", m.ReplyTo, err)
+		}
+	}
+	return nil
+}
+
+// Bytes encodes the message as RFC 5322 text. The body is
+// multipart/alternative with a plain text and an HTML part; inline images
+// wrap the HTML part in multipart/related and attachments wrap everything in
+// multipart/mixed. Bcc recipients are not written to the headers.
+func (m *Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID := m.MessageID
+	if messageID == "" {
+		messageID = NewMessageID(m.From)
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("From", m.From)
+	if len(m.To) > 0 {
+		header.Set("To", strings.Join(m.To, ", "))
+	}
+	if len(m.Cc) > 0 {
+		header.Set("Cc", strings.Join(m.Cc, ", "))
+	}
+	if m.ReplyTo != "" {
+		header.Set("Reply-To", m.ReplyTo)
+	}
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header.Set("Date", date.Format(time.RFC1123Z))
+	header.Set("Message-ID", messageID)
+	header.Set("MIME-Version", "1.0")
+	for k, v := range m.Headers {
+		header.Set(k, v)
+	}
+
+	body := m.writeAlternative
+	if len(m.Attachments) > 0 {
+		body = m.writeMixed
+	}
+
+	var content bytes.Buffer
+	contentType, err := body(&content)
+	if err != nil {
+		return nil, err
+	}
+	header.Set("Content-Type", contentType)
+
+	writeHeader(&buf, header)
+	buf.WriteString("\r\n")
+	buf.Write(content.Bytes())
+	return buf.Bytes(), nil
+}
+
+func (m *Message) writeMixed(w io.Writer) (string, error) {
+	mw := multipart.NewWriter(w)
+
+	var alternative bytes.Buffer
+	contentType, err := m.writeAlternative(&alternative)
+	if err != nil {
+		return "", err
+	}
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(alternative.Bytes()); err != nil {
+		return "", err
+	}
+
+	for _, a := range m.Attachments {
+		if err := writeAttachment(mw, a, "attachment"); err != nil {
+			return "", err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+	return "multipart/mixed; boundary=" + mw.Boundary(), nil
+}
+
+func (m *Message) writeAlternative(w io.Writer) (string, error) {
+	mw := multipart.NewWriter(w)
+
+	if err := writeText(mw, "text/plain; charset=utf-8", m.Text); err != nil {
+		return "", err
+	}
+
+	if len(m.Inline) > 0 {
+		var related bytes.Buffer
+		contentType, err := m.writeRelated(&related)
+		if err != nil {
+			return "", err
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+		if err != nil {
+			return "", err
+		}
+		if _, err := part.Write(related.Bytes()); err != nil {
+			return "", err
+		}
+	} else if err := writeText(mw, "text/html; charset=utf-8", m.HTML); err != nil {
+		return "", err
+	}
+
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+	return "multipart/alternative; boundary=" + mw.Boundary(), nil
+}
+
+func (m *Message) writeRelated(w io.Writer) (string, error) {
+	mw := multipart.NewWriter(w)
+
+	if err := writeText(mw, "text/html; charset=utf-8", m.HTML); err != nil {
+		return "", err
+	}
+	for _, a := range m.Inline {
+		if err := writeAttachment(mw, a, "inline"); err != nil {
+			return "", err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+	return "multipart/related; type=\"text/html\"; boundary=" + mw.Boundary(), nil
+}
+
+func writeText(mw *multipart.Writer, contentType, text string) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeAttachment(mw *multipart.Writer, a Attachment, disposition string) error {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	if a.Filename != "" {
+		header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+	} else {
+		header.Set("Content-Disposition", disposition)
+	}
+	if a.ContentID != "" {
+		header.Set("Content-ID", "<"+a.ContentID+">")
+	}
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(part, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = io.WriteString(part, encoded+"\r\n")
+	return err
+}
+
+// writeHeader writes the headers in a stable order, the common ones first.
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	order := []string{"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-Id", "Mime-Version"}
+	seen := map[string]bool{}
+	for _, k := range order {
+		for _, v := range header[k] {
+			fmt.Fprintf(buf, "%s: %s\r\n", headerName(k), v)
+		}
+		seen[k] = true
+	}
+
+	var rest []string
+	for k := range header {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		for _, v := range header[k] {
+			fmt.Fprintf(buf, "%s: %s\r\n", headerName(k), v)
+		}
+	}
+}
+
+func headerName(canonical string) string {
+	switch canonical {
+	case "Message-Id":
+		return "Message-ID"
+	case "Mime-Version":
+		return "MIME-Version"
+	case "Content-Id":
+		return "Content-ID"
+	}
+	return canonical
+}
+
+// NewMessageID returns a unique Message-ID in the domain of the sender.
+func NewMessageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(addr.Address, "@"); at >= 0 {
+			domain = addr.Address[at+1:]
+		}
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), randomHex(8), domain)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "0"
+	}
+	return hex.EncodeToString(b)
+}
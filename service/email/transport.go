@@ -0,0 +1,258 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Transport delivers encoded messages.
+type Transport interface {
+	Send(ctx context.Context, from string, to []string, raw []byte) error
+}
+
+// Configuration keys in template_service.configuration describing how test
+// emails are delivered.
+const (
+	ConfigTransport = "smtp.transport"
+	ConfigHost      = "smtp.host"
+	ConfigPort      = "smtp.port"
+	ConfigUsername  = "smtp.username"
+	ConfigPassword  = "smtp.password"
+	ConfigSecurity  = "smtp.security"
+	ConfigFrom      = "smtp.from"
+)
+
+const (
+	TransportSMTP    = "smtp"
+	TransportCapture = "capture"
+
+	SecurityNone     = "none"
+	SecurityStartTLS = "starttls"
+	SecurityTLS      = "tls"
+)
+
+// SMTPTransport delivers messages to an SMTP server.
+type SMTPTransport struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Security is "none", "starttls" (upgrade when the server offers it,
+	// required when credentials are set) or "tls" (implicit TLS, port 465).
+	Security string
+}
+
+// NewTransport builds the transport described by the smtp.* configuration
+// values. The capture transport is used unless smtp.transport is "smtp".
+func NewTransport(config map[string]string, capture *CaptureTransport) (Transport, error) {
+	switch config[ConfigTransport] {
+	case TransportSMTP:
+		port := 25
+		if config[ConfigPort] != "" {
+			p, err := strconv.Atoi(config[ConfigPort])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", ConfigPort, config[ConfigPort], err)
+			}
+			port = p
+		}
+		if config[ConfigHost] == "" {
+			return nil, fmt.Errorf("%s is not configured", ConfigHost)
+		}
+		return &SMTPTransport{
+			Host:     config[ConfigHost],
+			Port:     port,
+			Username: config[ConfigUsername],
+			Password: config[ConfigPassword],
+			Security: config[ConfigSecurity],
+		}, nil
+	case TransportCapture, "":
+		return capture, nil
+	default:
+		return nil, fmt.Errorf("unknown %s %q", ConfigTransport, config[ConfigTransport])
+	}
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, from string, to []string, raw []byte) error {
+	if len(to) == 0 {
+		return errors.New("no recipients")
+	}
+	sender, err := envelopeAddress(from)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+
+	tlsConfig := &tls.Config{ServerName: t.Host, MinVersion: tls.VersionTLS12}
+	if t.Security == SecurityTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("starting SMTP session: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if t.Security != SecurityNone && t.Security != SecurityTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("starting TLS: %w", err)
+			}
+		} else if t.Username != "" {
+			return errors.New("server does not support STARTTLS, refusing to send credentials")
+		}
+	}
+
+	if t.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", t.Username, t.Password, t.Host)); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	if err := client.Mail(sender); err != nil {
+		return fmt.Errorf("MAIL FROM: %w", err)
+	}
+	for _, rcpt := range to {
+		address, err := envelopeAddress(rcpt)
+		if err != nil {
+			return err
+		}
+		if err := client.Rcpt(address); err != nil {
+			return fmt.Errorf("RCPT TO %s: %w", address, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	if _, err := w.Write(raw); err != nil {
+		return fmt.Errorf("writing message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("finishing message: %w", err)
+	}
+	return client.Quit()
+}
+
+func envelopeAddress(addr string) (string, error) {
+	parsed, err := mail.ParseAddress(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	return parsed.Address, nil
+}
+
+// CapturedMessage is a message kept by the capture transport.
+type CapturedMessage struct {
+	ID         int       `json:"id"`
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	Subject    string    `json:"subject"`
+	CapturedAt time.Time `json:"captured_at"`
+	Size       int       `json:"size"`
+	Raw        []byte    `json:"-"`
+}
+
+// CaptureTransport keeps the most recent messages in memory instead of
+// delivering them, so test sends can be inspected without a mail server.
+type CaptureTransport struct {
+	mu       sync.Mutex
+	limit    int
+	nextID   int
+	messages []CapturedMessage
+}
+
+func NewCaptureTransport(limit int) *CaptureTransport {
+	if limit < 1 {
+		limit = 1
+	}
+	return &CaptureTransport{limit: limit, nextID: 1}
+}
+
+func (t *CaptureTransport) Send(ctx context.Context, from string, to []string, raw []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	subject := ""
+	if msg, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		decoded, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		if err == nil {
+			subject = decoded
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = append(t.messages, CapturedMessage{
+		ID:         t.nextID,
+		From:       from,
+		To:         append([]string(nil), to...),
+		Subject:    subject,
+		CapturedAt: time.Now(),
+		Size:       len(raw),
+		Raw:        append([]byte(nil), raw...),
+	})
+	t.nextID++
+	if len(t.messages) > t.limit {
+		t.messages = t.messages[len(t.messages)-t.limit:]
+	}
+	return nil
+}
+
+// Messages returns the captured messages, newest first.
+func (t *CaptureTransport) Messages() []CapturedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	messages := append([]CapturedMessage(nil), t.messages...)
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID > messages[j].ID })
+	return messages
+}
+
+func (t *CaptureTransport) Message(id int) (CapturedMessage, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, m := range t.messages {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return CapturedMessage{}, false
+}
+
+func (t *CaptureTransport) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
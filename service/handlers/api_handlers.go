@@ -2,9 +2,15 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
 	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -16,6 +22,7 @@ type APIResponse struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Meta    interface{} `json:"meta,omitempty"`
 }
 
 type TemplateRequest struct {
@@ -23,6 +30,10 @@ type TemplateRequest struct {
 	CategoryID string `json:"category_id"`
 	Content    string `json:"content"`
 	Format     string `json:"format"`
+	// VerifySamples refuses the update when it changes the expected output
+	// of a sample; AcceptChanges stores the new outputs instead.
+	VerifySamples bool `json:"verify_samples,omitempty"`
+	AcceptChanges bool `json:"accept_changes,omitempty"`
 }
 
 type TemplateVariableRequest struct {
@@ -35,6 +46,7 @@ type TemplateVariableRequest struct {
 
 type RenderRequest struct {
 	Variables map[string]string `json:"variables"`
+	Sample    string            `json:"sample,omitempty"`
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -59,17 +71,62 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 	})
 }
 
+// dbErrorStatus maps a failed database call to a status code: 504 when the
+// query timed out or the request deadline passed, 500 otherwise.
+func dbErrorStatus(r *http.Request, err error) int {
+	if timedOut(r, err) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// lookupErrorStatus is dbErrorStatus for lookups whose other failures mean
+// the record does not exist.
+func lookupErrorStatus(r *http.Request, err error) int {
+	if timedOut(r, err) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusNotFound
+}
+
+func timedOut(r *http.Request, err error) bool {
+	return models.IsQueryTimeout(err) || errors.Is(r.Context().Err(), context.DeadlineExceeded)
+}
+
 func APIGetTemplates(w http.ResponseWriter, r *http.Request) {
-	_ = r
-	templates, err := models.GetTemplates()
+	filter, err := parseTemplateFilter(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := Repos.Templates.Page(r.Context(), filter)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error fetching templates: "+err.Error())
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching templates: "+err.Error())
 		return
 	}
 
+	var data interface{} = page.Templates
+	if filter.Summary {
+		summaries := make([]models.TemplateSummary, 0, len(page.Templates))
+		for _, t := range page.Templates {
+			summaries = append(summaries, t.Summary())
+		}
+		data = summaries
+	}
+
 	respondWithJSON(w, http.StatusOK, APIResponse{
 		Success: true,
-		Data:    templates,
+		Data:    data,
+		Meta: ListMeta{
+			Count:      len(page.Templates),
+			Limit:      filter.Limit,
+			NextCursor: page.NextCursor,
+		},
 	})
 }
 
@@ -77,10 +134,10 @@ func APIGetTemplate(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	retrievedTemplate, err := models.GetTemplateByID(id)
+	retrievedTemplate, err := Repos.Templates.Get(r.Context(), id)
 	if err != nil {
 		log.Printf("Failed to retrieve template %s: %v", id, err)
-		respondWithError(w, http.StatusNotFound, "Template not found")
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found")
 		return
 	}
 
@@ -108,15 +165,15 @@ func APICreateTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	templateID, err := models.CreateTemplate(req.Name, req.CategoryID, req.Content, req.Format, "api_user")
+	templateID, err := Repos.Templates.Create(r.Context(), req.Name, req.CategoryID, req.Content, req.Format, "api_user")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error creating template: "+err.Error())
+		respondWithError(w, dbErrorStatus(r, err), "Error creating template: "+err.Error())
 		return
 	}
 
-	retrievedTemplate, err := models.GetTemplateByID(templateID)
+	retrievedTemplate, err := Repos.Templates.Get(r.Context(), templateID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Template created but could not be retrieved: "+err.Error())
+		respondWithError(w, dbErrorStatus(r, err), "Template created but could not be retrieved: "+err.Error())
 		return
 	}
 
@@ -130,9 +187,9 @@ func APIUpdateTemplate(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	_, err := models.GetTemplateByID(id)
+	existing, err := Repos.Templates.Get(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Template not found: "+err.Error())
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found: "+err.Error())
 		return
 	}
 
@@ -153,15 +210,53 @@ func APIUpdateTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = models.UpdateTemplate(id, req.Name, req.CategoryID, req.Content, req.Format, "api_user")
+	verify, err := verifyOnUpdate(r.Context(), id, req.VerifySamples || r.URL.Query().Get("verify") == "true")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error updating template: "+err.Error())
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching template config: "+err.Error())
 		return
 	}
 
-	retrievedTemplate, err := models.GetTemplateByID(id)
+	var report VerificationReport
+	if verify || req.AcceptChanges {
+		report, err = verifySamples(r.Context(), id, req.Content, firstNonEmpty(req.Format, existing.Format))
+		if err != nil {
+			respondWithError(w, dbErrorStatus(r, err), "Error verifying samples: "+err.Error())
+			return
+		}
+		if verify && !report.Passed && !req.AcceptChanges {
+			respondWithJSON(w, http.StatusConflict, APIResponse{
+				Success: false,
+				Data:    report,
+				Error: fmt.Sprintf("Update changes the expected output of %d sample(s); "+
+					"set accept_changes to store the new outputs", report.Failed),
+			})
+			return
+		}
+	}
+
+	err = Repos.Templates.Update(r.Context(), id, req.Name, req.CategoryID, req.Content, req.Format, "api_user")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Template updated but could not be retrieved: "+err.Error())
+		respondWithError(w, dbErrorStatus(r, err), "Error updating template: "+err.Error())
+		return
+	}
+
+	if req.AcceptChanges {
+		changed := report
+		changed.Samples = nil
+		for _, result := range report.Samples {
+			if result.Status == VerifyMismatch || result.Status == VerifyNormalizedMatch {
+				changed.Samples = append(changed.Samples, result)
+			}
+		}
+		if err := acceptOutputs(r.Context(), id, &changed); err != nil {
+			respondWithError(w, dbErrorStatus(r, err), "Template updated but expected outputs could not be stored: "+err.Error())
+			return
+		}
+	}
+
+	retrievedTemplate, err := Repos.Templates.Get(r.Context(), id)
+	if err != nil {
+		respondWithError(w, dbErrorStatus(r, err), "Template updated but could not be retrieved: "+err.Error())
 		return
 	}
 
@@ -171,19 +266,30 @@ func APIUpdateTemplate(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// APIDeleteTemplate moves a template to the trash, or deletes it permanently
+// with ?purge=true.
 func APIDeleteTemplate(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	_, err := models.GetTemplateByID(id)
+	if r.URL.Query().Get("purge") == "true" {
+		purgeTemplate(w, r, id)
+		return
+	}
+
+	_, err := Repos.Templates.Get(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Template not found: "+err.Error())
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found: "+err.Error())
 		return
 	}
 
-	err = models.DeleteTemplate(id)
+	err = Repos.Templates.Delete(r.Context(), id, "api_user")
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Template not found or already in the trash")
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error deleting template: "+err.Error())
+		respondWithError(w, dbErrorStatus(r, err), "Error deleting template: "+err.Error())
 		return
 	}
 
@@ -197,15 +303,15 @@ func APIGetTemplateVariables(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	_, err := models.GetTemplateByID(id)
+	_, err := Repos.Templates.Get(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Template not found: "+err.Error())
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found: "+err.Error())
 		return
 	}
 
-	variables, err := models.GetTemplateVariables(id)
+	variables, err := Repos.Variables.List(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error fetching template variables: "+err.Error())
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching template variables: "+err.Error())
 		return
 	}
 
@@ -219,9 +325,9 @@ func APIAddTemplateVariable(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	_, err := models.GetTemplateByID(id)
+	_, err := Repos.Templates.Get(r.Context(), id)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Template not found: "+err.Error())
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found: "+err.Error())
 		return
 	}
 
@@ -242,9 +348,10 @@ func APIAddTemplateVariable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = models.AddTemplateVariable(id, req.VariableName, req.Description, req.DefaultValue, req.IsRequired)
+	err = Repos.Variables.Add(r.Context(), id, req.VariableName, req.Description, req.DefaultValue, req.IsRequired)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error adding template variable: "+err.Error())
+		status, message := variableErrorStatus(r, err, req.VariableName)
+		respondWithError(w, status, message)
 		return
 	}
 
@@ -255,10 +362,9 @@ func APIAddTemplateVariable(w http.ResponseWriter, r *http.Request) {
 }
 
 func APIGetCategories(w http.ResponseWriter, r *http.Request) {
-	_ = r
-	categories, err := models.GetTemplateCategories()
+	categories, err := Repos.Categories.List(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error fetching categories: "+err.Error())
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching categories: "+err.Error())
 		return
 	}
 
@@ -272,16 +378,18 @@ func APIRenderTemplate(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	tmpl, err := models.GetTemplateByID(id)
+	tmpl, err := Repos.Templates.Get(r.Context(), id)
 	if err != nil {
 		log.Printf("Failed to retrieve template %s: %v", id, err)
-		respondWithError(w, http.StatusNotFound, "Template not found")
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found")
 		return
 	}
 
+	sampleName := r.URL.Query().Get("sample")
+
 	var renderReq RenderRequest
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&renderReq); err != nil {
+	if err := decoder.Decode(&renderReq); err != nil && !(errors.Is(err, io.EOF) && sampleName != "") {
 		log.Printf("Invalid request payload: %v", err)
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
@@ -292,10 +400,21 @@ func APIRenderTemplate(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	templateVars, err := models.GetTemplateVariables(id)
+	renderReq.Variables, err = withSample(r.Context(), id, firstNonEmpty(sampleName, renderReq.Sample), renderReq.Variables)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Sample not found: "+firstNonEmpty(sampleName, renderReq.Sample))
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching sample for template %s: %v", id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching template sample")
+		return
+	}
+
+	templateVars, err := Repos.Variables.List(r.Context(), id)
 	if err != nil {
 		log.Printf("Error fetching template variables for %s: %v", id, err)
-		respondWithError(w, http.StatusInternalServerError, "Error fetching template variables")
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching template variables")
 		return
 	}
 
@@ -333,12 +452,29 @@ func APIRenderTemplate(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// APIHealthCheck reports the service down with 503 when the database fails
+// the readiness check used at startup.
 func APIHealthCheck(w http.ResponseWriter, r *http.Request) {
-	_ = r
-
 	status := map[string]interface{}{
 		"status":    "up",
 		"timestamp": time.Now().Format(time.RFC3339),
+		"database":  "up",
+	}
+	if ReadOnly {
+		status["status"] = "degraded"
+		status["read_only"] = true
+	}
+	status["database_pool"] = db.Stats()
+
+	if err := db.Ping(r.Context(), db.DB); err != nil {
+		status["status"] = "down"
+		status["database"] = "down"
+		respondWithJSON(w, http.StatusServiceUnavailable, APIResponse{
+			Success: false,
+			Data:    status,
+			Error:   "Database unavailable: " + err.Error(),
+		})
+		return
 	}
 
 	respondWithJSON(w, http.StatusOK, APIResponse{
@@ -346,3 +482,89 @@ func APIHealthCheck(w http.ResponseWriter, r *http.Request) {
 		Data:    status,
 	})
 }
+
+type TemplateConfigRequest struct {
+	ConfigValue string `json:"config_value"`
+	Description string `json:"description"`
+}
+
+// APIGetTemplateConfig lists a template's config entries, such as the pdf.*
+// and email.* options.
+func APIGetTemplateConfig(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	_, err := Repos.Templates.Get(r.Context(), id)
+	if err != nil {
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found: "+err.Error())
+		return
+	}
+
+	configs, err := Repos.Config.List(r.Context(), id)
+	if err != nil {
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching template config: "+err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    configs,
+	})
+}
+
+// APISetTemplateConfig creates or updates a template config entry. The PDF
+// and email options have no other way in than this and the bundle import.
+func APISetTemplateConfig(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	key := vars["key"]
+
+	_, err := Repos.Templates.Get(r.Context(), id)
+	if err != nil {
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found: "+err.Error())
+		return
+	}
+
+	var req TemplateConfigRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	err = Repos.Config.Set(r.Context(), id, key, req.ConfigValue, req.Description)
+	if err != nil {
+		respondWithError(w, dbErrorStatus(r, err), "Error saving template config: "+err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Template config saved successfully",
+	})
+}
+
+// APIDeleteTemplateConfig removes a template config entry. Deleting the row
+// fires the audit trigger for a DELETE, which needs the trigger fixed by
+// migration 1.0.4 (V5): the original one logged NEW.id, NULL for deletes.
+func APIDeleteTemplateConfig(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	key := vars["key"]
+
+	err := Repos.Config.Delete(r.Context(), id, key)
+	if err != nil {
+		respondWithError(w, dbErrorStatus(r, err), "Error deleting template config: "+err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Template config deleted successfully",
+	})
+}
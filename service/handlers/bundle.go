@@ -0,0 +1,528 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/google/uuid"
+)
+
+// BundleFormatVersion is the version of the bundle layout written by
+// APIExport. APIImport refuses bundles with a different version.
+const BundleFormatVersion = 1
+
+// maxBundleSize bounds the size of an uploaded bundle.
+const maxBundleSize = 32 << 20
+
+// Files of a ZIP bundle. Every template is stored in its own file so
+// bundles can be reviewed and diffed file by file.
+const (
+	bundleManifestFile = "manifest.json"
+	bundleTemplatesDir = "templates"
+)
+
+// Bundle is the portable representation of a set of templates. Categories
+// are referenced by name, since their IDs differ between environments.
+type Bundle struct {
+	FormatVersion int              `json:"format_version"`
+	ExportedAt    time.Time        `json:"exported_at"`
+	SchemaVersion string           `json:"schema_version,omitempty"`
+	Categories    []BundleCategory `json:"categories"`
+	Templates     []BundleTemplate `json:"templates"`
+}
+
+type BundleCategory struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type BundleTemplate struct {
+	ID        string                    `json:"id"`
+	Name      string                    `json:"name"`
+	Category  string                    `json:"category"`
+	Content   string                    `json:"content"`
+	Format    string                    `json:"format"`
+	Version   int                       `json:"version"`
+	Variables []TemplateVariableRequest `json:"variables"`
+	Config    []BundleConfig            `json:"config"`
+	Versions  []BundleVersion           `json:"versions,omitempty"`
+}
+
+type BundleConfig struct {
+	ConfigKey   string `json:"config_key"`
+	ConfigValue string `json:"config_value"`
+	Description string `json:"description,omitempty"`
+}
+
+type BundleVersion struct {
+	Version     int       `json:"version"`
+	Content     string    `json:"content"`
+	Format      string    `json:"format"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	ChangeNotes string    `json:"change_notes,omitempty"`
+}
+
+// bundleManifest is the manifest.json of a ZIP bundle: the bundle without
+// its templates, which are stored in templates/<id>.json.
+type bundleManifest struct {
+	FormatVersion int              `json:"format_version"`
+	ExportedAt    time.Time        `json:"exported_at"`
+	SchemaVersion string           `json:"schema_version,omitempty"`
+	Categories    []BundleCategory `json:"categories"`
+	Templates     []string         `json:"templates"`
+}
+
+type ImportTemplateResult struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Status  string   `json:"status"`
+	Changes []string `json:"changes,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun            bool                   `json:"dry_run"`
+	Strategy          string                 `json:"strategy"`
+	CategoriesCreated []string               `json:"categories_created"`
+	Created           int                    `json:"created"`
+	Updated           int                    `json:"updated"`
+	Skipped           int                    `json:"skipped"`
+	Unchanged         int                    `json:"unchanged"`
+	Templates         []ImportTemplateResult `json:"templates"`
+}
+
+// APIExport downloads a bundle of templates: every active template, the
+// templates of ?category_id=, or the templates listed in ?id= (repeatable or
+// comma separated). ?include_versions=true adds the version history and
+// ?format=zip returns a ZIP archive instead of a JSON document.
+func APIExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := parseExportFilter(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.IncludeVersions = query.Get("include_versions") == "true"
+
+	format := firstNonEmpty(query.Get("format"), "json")
+	if format != "json" && format != "zip" {
+		respondWithError(w, http.StatusBadRequest, "format must be json or zip")
+		return
+	}
+
+	exports, err := Repos.Bundles.Export(r.Context(), filter)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Template not found: "+err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error exporting templates: %v", err)
+		respondWithError(w, dbErrorStatus(r, err), "Error exporting templates")
+		return
+	}
+
+	bundle, err := newBundle(r.Context(), exports)
+	if err != nil {
+		log.Printf("Error exporting templates: %v", err)
+		respondWithError(w, dbErrorStatus(r, err), "Error exporting templates")
+		return
+	}
+
+	var data []byte
+	if format == "zip" {
+		data, err = encodeBundleZip(bundle)
+	} else {
+		data, err = json.MarshalIndent(bundle, "", "  ")
+	}
+	if err != nil {
+		log.Printf("Error encoding bundle: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error encoding bundle")
+		return
+	}
+
+	filename := fmt.Sprintf("templates-%s.%s", bundle.ExportedAt.Format("20060102-150405"), format)
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// parseExportFilter reads the templates to export from ?id= (repeatable or
+// comma separated) and ?category_id=.
+func parseExportFilter(query url.Values) (models.ExportFilter, error) {
+	var filter models.ExportFilter
+	for _, value := range query["id"] {
+		for _, id := range strings.Split(value, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				filter.IDs = append(filter.IDs, id)
+			}
+		}
+	}
+	if value := query.Get("category_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, errors.New("invalid category_id")
+		}
+		filter.CategoryID = id
+	}
+	return filter, nil
+}
+
+func newBundle(ctx context.Context, exports []models.TemplateExport) (Bundle, error) {
+	bundle := Bundle{
+		FormatVersion: BundleFormatVersion,
+		ExportedAt:    time.Now().UTC().Truncate(time.Second),
+		Categories:    []BundleCategory{},
+		Templates:     []BundleTemplate{},
+	}
+
+	schemaVersion, err := Repos.Schema.Version(ctx)
+	if err != nil {
+		return bundle, fmt.Errorf("reading schema version: %w", err)
+	}
+	bundle.SchemaVersion = schemaVersion
+
+	categories, err := Repos.Categories.List(ctx)
+	if err != nil {
+		return bundle, fmt.Errorf("fetching categories: %w", err)
+	}
+	used := make(map[string]bool)
+	for _, e := range exports {
+		used[e.Template.CategoryName] = true
+	}
+	for _, c := range categories {
+		if used[c.Name] {
+			bundle.Categories = append(bundle.Categories, BundleCategory{Name: c.Name, Description: c.Description})
+		}
+	}
+
+	for _, e := range exports {
+		t := BundleTemplate{
+			ID:        e.Template.ID,
+			Name:      e.Template.Name,
+			Category:  e.Template.CategoryName,
+			Content:   e.Template.Content,
+			Format:    e.Template.Format,
+			Version:   e.Template.Version,
+			Variables: []TemplateVariableRequest{},
+			Config:    []BundleConfig{},
+		}
+		for _, v := range e.Variables {
+			t.Variables = append(t.Variables, TemplateVariableRequest{
+				VariableName: v.VariableName,
+				Description:  v.Description,
+				DefaultValue: v.DefaultValue,
+				IsRequired:   v.IsRequired,
+				VariableType: v.VariableType,
+			})
+		}
+		for _, c := range e.Config {
+			t.Config = append(t.Config, BundleConfig{ConfigKey: c.ConfigKey, ConfigValue: c.ConfigValue, Description: c.Description})
+		}
+		for _, v := range e.Versions {
+			t.Versions = append(t.Versions, BundleVersion{
+				Version:     v.Version,
+				Content:     v.Content,
+				Format:      v.Format,
+				CreatedBy:   v.CreatedBy,
+				CreatedAt:   v.CreatedAt,
+				ChangeNotes: v.ChangeNotes,
+			})
+		}
+		bundle.Templates = append(bundle.Templates, t)
+	}
+	return bundle, nil
+}
+
+func encodeBundleZip(bundle Bundle) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	manifest := bundleManifest{
+		FormatVersion: bundle.FormatVersion,
+		ExportedAt:    bundle.ExportedAt,
+		SchemaVersion: bundle.SchemaVersion,
+		Categories:    bundle.Categories,
+		Templates:     []string{},
+	}
+	for _, t := range bundle.Templates {
+		manifest.Templates = append(manifest.Templates, t.ID)
+	}
+
+	write := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: bundle.ExportedAt})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		return err
+	}
+
+	if err := write(bundleManifestFile, manifest); err != nil {
+		return nil, err
+	}
+	for _, t := range bundle.Templates {
+		if err := write(path.Join(bundleTemplatesDir, t.ID+".json"), t); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBundle reads a JSON bundle or, when data starts with the ZIP
+// signature, a ZIP bundle.
+func decodeBundle(data []byte) (Bundle, error) {
+	var bundle Bundle
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return bundle, fmt.Errorf("invalid JSON bundle: %w", err)
+		}
+		return bundle, nil
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return bundle, fmt.Errorf("invalid ZIP bundle: %w", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	read := func(name string, v interface{}) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("%s is missing", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := rc.Close(); err != nil {
+				log.Printf("Error closing %s: %v", name, err)
+			}
+		}()
+		if err := json.NewDecoder(io.LimitReader(rc, maxBundleSize)).Decode(v); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+
+	var manifest bundleManifest
+	if err := read(bundleManifestFile, &manifest); err != nil {
+		return bundle, fmt.Errorf("invalid ZIP bundle: %w", err)
+	}
+	bundle.FormatVersion = manifest.FormatVersion
+	bundle.ExportedAt = manifest.ExportedAt
+	bundle.SchemaVersion = manifest.SchemaVersion
+	bundle.Categories = manifest.Categories
+	for _, id := range manifest.Templates {
+		var t BundleTemplate
+		if err := read(path.Join(bundleTemplatesDir, id+".json"), &t); err != nil {
+			return bundle, fmt.Errorf("invalid ZIP bundle: %w", err)
+		}
+		bundle.Templates = append(bundle.Templates, t)
+	}
+	return bundle, nil
+}
+
+// validate checks the bundle before anything is imported. Templates without
+// an ID get a new one, so hand-written bundles can create templates.
+func (b *Bundle) validate() error {
+	if b.FormatVersion != BundleFormatVersion {
+		return fmt.Errorf("unsupported bundle format_version %d, expected %d", b.FormatVersion, BundleFormatVersion)
+	}
+
+	seen := make(map[string]bool)
+	for i := range b.Templates {
+		t := &b.Templates[i]
+		if t.ID == "" {
+			t.ID = uuid.New().String()
+		} else if _, err := uuid.Parse(t.ID); err != nil {
+			return fmt.Errorf("template %q: invalid id %q", t.Name, t.ID)
+		}
+		if seen[t.ID] {
+			return fmt.Errorf("template %s is listed more than once", t.ID)
+		}
+		seen[t.ID] = true
+
+		if t.Name == "" || t.Content == "" || t.Category == "" {
+			return fmt.Errorf("template %s: name, category and content are required", t.ID)
+		}
+		if t.Format == "" {
+			t.Format = "html"
+		}
+
+		names := make(map[string]bool)
+		for _, v := range t.Variables {
+			if v.VariableName == "" {
+				return fmt.Errorf("template %s: variable name is required", t.ID)
+			}
+			if names[v.VariableName] {
+				return fmt.Errorf("template %s: variable %q is listed more than once", t.ID, v.VariableName)
+			}
+			names[v.VariableName] = true
+		}
+		keys := make(map[string]bool)
+		for _, c := range t.Config {
+			if c.ConfigKey == "" || keys[c.ConfigKey] {
+				return fmt.Errorf("template %s: config keys must be unique and not empty", t.ID)
+			}
+			keys[c.ConfigKey] = true
+		}
+	}
+	return nil
+}
+
+func (b Bundle) exports() ([]models.TemplateCategory, []models.TemplateExport) {
+	var categories []models.TemplateCategory
+	for _, c := range b.Categories {
+		categories = append(categories, models.TemplateCategory{Name: c.Name, Description: c.Description})
+	}
+
+	var exports []models.TemplateExport
+	for _, t := range b.Templates {
+		e := models.TemplateExport{Template: models.Template{
+			ID:           t.ID,
+			Name:         t.Name,
+			CategoryName: t.Category,
+			Content:      t.Content,
+			Format:       t.Format,
+			Version:      t.Version,
+		}}
+		for _, v := range t.Variables {
+			e.Variables = append(e.Variables, v.variable())
+		}
+		for _, c := range t.Config {
+			e.Config = append(e.Config, models.TemplateConfig{ConfigKey: c.ConfigKey, ConfigValue: c.ConfigValue, Description: c.Description})
+		}
+		for _, v := range t.Versions {
+			e.Versions = append(e.Versions, models.TemplateVersion{
+				Version:     v.Version,
+				Content:     v.Content,
+				Format:      firstNonEmpty(v.Format, t.Format),
+				CreatedBy:   firstNonEmpty(v.CreatedBy, "import"),
+				CreatedAt:   v.CreatedAt,
+				ChangeNotes: v.ChangeNotes,
+			})
+		}
+		exports = append(exports, e)
+	}
+	return categories, exports
+}
+
+// APIImport imports a JSON or ZIP bundle produced by APIExport. Templates
+// that already exist are handled according to ?strategy=: skip (default),
+// overwrite, or new-version. ?dry_run=true reports what would change without
+// changing anything.
+func APIImport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	strategy := firstNonEmpty(query.Get("strategy"), models.ImportSkip)
+	switch strategy {
+	case models.ImportSkip, models.ImportOverwrite, models.ImportNewVersion:
+	default:
+		respondWithError(w, http.StatusBadRequest, "strategy must be skip, overwrite or new-version")
+		return
+	}
+	dryRun := query.Get("dry_run") == "true"
+	if !dryRun && refuseReadOnly(w, r) {
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBundleSize))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Error reading bundle: "+err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	bundle, err := decodeBundle(data)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := bundle.validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid bundle: "+err.Error())
+		return
+	}
+
+	categories, exports := bundle.exports()
+	result, err := Repos.Bundles.Import(r.Context(), categories, exports, strategy, dryRun, "api_user")
+	if err != nil {
+		log.Printf("Error importing bundle: %v", err)
+		if models.IsUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "Error importing bundle: "+err.Error())
+			return
+		}
+		respondWithError(w, dbErrorStatus(r, err), "Error importing bundle: "+err.Error())
+		return
+	}
+
+	report := ImportReport{
+		DryRun:            result.DryRun,
+		Strategy:          result.Strategy,
+		CategoriesCreated: result.CategoriesCreated,
+		Templates:         []ImportTemplateResult{},
+	}
+	sort.Strings(report.CategoriesCreated)
+	for _, t := range result.Templates {
+		switch t.Status {
+		case models.ImportCreated:
+			report.Created++
+		case models.ImportUpdated:
+			report.Updated++
+		case models.ImportSkipped:
+			report.Skipped++
+		case models.ImportUnchanged:
+			report.Unchanged++
+		}
+		report.Templates = append(report.Templates, ImportTemplateResult{
+			ID:      t.TemplateID,
+			Name:    t.Name,
+			Status:  t.Status,
+			Changes: t.Changes,
+		})
+	}
+
+	if !dryRun {
+		log.Printf("Imported bundle: %d created, %d updated, %d skipped, %d unchanged",
+			report.Created, report.Updated, report.Skipped, report.Unchanged)
+	}
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
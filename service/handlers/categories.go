@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/gorilla/mux"
+)
+
+type CategoryRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// categoryErrorStatus maps category write errors to a status code and a
+// message safe to show to the client.
+func categoryErrorStatus(r *http.Request, err error, name string) (int, string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, "Category not found"
+	case models.IsUniqueViolation(err):
+		return http.StatusConflict, fmt.Sprintf("A category named %q already exists", name)
+	case errors.Is(err, models.ErrCategoryInUse):
+		return http.StatusConflict, "Category is still used: " + err.Error() +
+			"; pass reassign_to to move its templates to another category"
+	case errors.Is(err, models.ErrInvalidReassignment):
+		return http.StatusBadRequest, err.Error()
+	}
+	log.Printf("Category error: %v", err)
+	return dbErrorStatus(r, err), "Error saving category"
+}
+
+func categoryID(r *http.Request) (int, error) {
+	return strconv.Atoi(mux.Vars(r)["id"])
+}
+
+func APIGetCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := categoryID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	category, err := Repos.Categories.Get(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Category not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching category: "+err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    category,
+	})
+}
+
+func APICreateCategory(w http.ResponseWriter, r *http.Request) {
+	var req CategoryRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Category name is required")
+		return
+	}
+
+	id, err := Repos.Categories.Create(r.Context(), req.Name, req.Description)
+	if err != nil {
+		status, message := categoryErrorStatus(r, err, req.Name)
+		respondWithError(w, status, message)
+		return
+	}
+
+	category, err := Repos.Categories.Get(r.Context(), id)
+	if err != nil {
+		respondWithError(w, dbErrorStatus(r, err), "Category created but could not be retrieved: "+err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Data:    category,
+	})
+}
+
+func APIUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := categoryID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	var req CategoryRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Category name is required")
+		return
+	}
+
+	if err := Repos.Categories.Update(r.Context(), id, req.Name, req.Description); err != nil {
+		status, message := categoryErrorStatus(r, err, req.Name)
+		respondWithError(w, status, message)
+		return
+	}
+
+	category, err := Repos.Categories.Get(r.Context(), id)
+	if err != nil {
+		respondWithError(w, dbErrorStatus(r, err), "Category updated but could not be retrieved: "+err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    category,
+	})
+}
+
+// APIDeleteCategory deletes a category. It is refused with 409 while
+// templates use the category, unless ?reassign_to=<id> moves them first.
+func APIDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := categoryID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	reassignTo := 0
+	if v := r.URL.Query().Get("reassign_to"); v != "" {
+		reassignTo, err = strconv.Atoi(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid reassign_to category ID")
+			return
+		}
+	}
+
+	if err := Repos.Categories.Delete(r.Context(), id, reassignTo); err != nil {
+		status, message := categoryErrorStatus(r, err, "")
+		respondWithError(w, status, message)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Category deleted successfully",
+	})
+}
+
+// HandleListCategories shows the category management page. A failed action
+// is shown through the error query parameter.
+func HandleListCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := Repos.Categories.List(r.Context())
+	if err != nil {
+		http.Error(w, "Error fetching categories: "+err.Error(), dbErrorStatus(r, err))
+		return
+	}
+
+	data := struct {
+		Categories []models.TemplateCategory
+		Error      string
+	}{
+		Categories: categories,
+		Error:      r.URL.Query().Get("error"),
+	}
+
+	htmlTemplate, err := template.ParseFS(FS, "templates/layout.html", "templates/categories.html")
+	if err != nil {
+		http.Error(w, "Error loading template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = htmlTemplate.ExecuteTemplate(w, "layout", data)
+	if err != nil {
+		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// redirectCategories returns to the category page, reporting err if set.
+func redirectCategories(w http.ResponseWriter, r *http.Request, err error, name string) {
+	target := "/categories"
+	if err != nil {
+		_, message := categoryErrorStatus(r, err, name)
+		target += "?error=" + url.QueryEscape(message)
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func HandleCreateCategory(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Category name is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := Repos.Categories.Create(r.Context(), name, r.FormValue("description"))
+	redirectCategories(w, r, err, name)
+}
+
+func HandleUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := categoryID(r)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Category name is required", http.StatusBadRequest)
+		return
+	}
+
+	err = Repos.Categories.Update(r.Context(), id, name, r.FormValue("description"))
+	redirectCategories(w, r, err, name)
+}
+
+func HandleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := categoryID(r)
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reassignTo := 0
+	if v := r.FormValue("reassign_to"); v != "" {
+		if reassignTo, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid category to reassign to", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = Repos.Categories.Delete(r.Context(), id, reassignTo)
+	redirectCategories(w, r, err, "")
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/email"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/gorilla/mux"
+)
+
+// Template config keys used when a template is rendered as an email.
+const (
+	ConfigEmailSubject      = "email.subject"
+	ConfigEmailTextTemplate = "email.text_template"
+	ConfigEmailFrom         = "email.from"
+	ConfigEmailReplyTo      = "email.reply_to"
+)
+
+const defaultEmailFrom = "Template Service <no-reply@localhost>"
+
+// errInvalidEmail marks email build failures caused by the request rather
+// than by the server.
+var errInvalidEmail = errors.New("invalid email request")
+
+type EmailAttachmentRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"`
+	Content     string `json:"content"`
+}
+
+type EmailRenderRequest struct {
+	Variables   map[string]string        `json:"variables"`
+	From        string                   `json:"from,omitempty"`
+	To          []string                 `json:"to,omitempty"`
+	Cc          []string                 `json:"cc,omitempty"`
+	Bcc         []string                 `json:"bcc,omitempty"`
+	ReplyTo     string                   `json:"reply_to,omitempty"`
+	Subject     string                   `json:"subject,omitempty"`
+	AttachPDF   bool                     `json:"attach_pdf"`
+	Attachments []EmailAttachmentRequest `json:"attachments,omitempty"`
+	Format      string                   `json:"format,omitempty"`
+}
+
+type EmailRenderResponse struct {
+	MessageID    string             `json:"message_id"`
+	From         string             `json:"from"`
+	To           []string           `json:"to,omitempty"`
+	Cc           []string           `json:"cc,omitempty"`
+	ReplyTo      string             `json:"reply_to,omitempty"`
+	Subject      string             `json:"subject"`
+	HTML         string             `json:"html"`
+	Text         string             `json:"text"`
+	InlineImages []email.Attachment `json:"inline_images"`
+	Attachments  []email.Attachment `json:"attachments"`
+	Size         int                `json:"size"`
+	Raw          string             `json:"raw"`
+}
+
+// resolveVariables merges the provided values with the template variable
+// defaults, failing when a required variable has no value.
+func resolveVariables(templateVars []models.TemplateVariable, provided map[string]string) (map[string]interface{}, error) {
+	varMap := make(map[string]interface{})
+	for _, v := range templateVars {
+		value, exists := provided[v.VariableName]
+		if v.IsRequired && (!exists || value == "") {
+			return nil, fmt.Errorf("required variable missing: %s", v.VariableName)
+		}
+		if exists && value != "" {
+			varMap[v.VariableName] = value
+		} else {
+			varMap[v.VariableName] = v.DefaultValue
+		}
+	}
+	return varMap, nil
+}
+
+func renderText(content string, varMap map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	tmpl, err := texttemplate.New("text").Parse(content)
+	if err != nil {
+		return "", err
+	}
+	if err := tmpl.Execute(&buf, varMap); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func decodeAttachments(reqs []EmailAttachmentRequest) (inline, attachments []email.Attachment, err error) {
+	for i, a := range reqs {
+		data, err := base64.StdEncoding.DecodeString(a.Content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: attachment %d: content must be base64: %v", errInvalidEmail, i+1, err)
+		}
+		if a.Filename == "" && a.ContentID == "" {
+			return nil, nil, fmt.Errorf("%w: attachment %d: filename or content_id is required", errInvalidEmail, i+1)
+		}
+
+		// content_type and content_id end up in MIME part headers, so they
+		// must not carry line breaks or anything else that would end them.
+		if strings.ContainsAny(a.ContentID, "\r\n<> \t") {
+			return nil, nil, fmt.Errorf("%w: attachment %d: invalid content_id %q", errInvalidEmail, i+1, a.ContentID)
+		}
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: attachment %d: invalid content_type %q: %v", errInvalidEmail, i+1, a.ContentType, err)
+		}
+
+		attachment := email.Attachment{
+			Filename:    a.Filename,
+			ContentType: mime.FormatMediaType(mediaType, params),
+			ContentID:   a.ContentID,
+			Data:        data,
+		}
+		if a.ContentID != "" {
+			inline = append(inline, attachment)
+		} else {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return inline, attachments, nil
+}
+
+// buildEmailMessage renders a template as a complete email: the subject from
+// the email.subject config, the HTML body, a plain text alternative (from the
+// email.text_template partial or derived from the HTML), inline images and
+// attachments, optionally including the template rendered as a PDF.
+func buildEmailMessage(r *http.Request, tmpl models.Template, varMap map[string]interface{},
+	req EmailRenderRequest) (*email.Message, error) {
+	configs, err := Repos.Config.List(r.Context(), tmpl.ID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching template config: %w", err)
+	}
+	config := make(map[string]string)
+	for _, c := range configs {
+		config[c.ConfigKey] = c.ConfigValue
+	}
+
+	rendered, err := renderContent(tmpl.Content, varMap)
+	if err != nil {
+		return nil, fmt.Errorf("rendering template: %w", err)
+	}
+
+	msg := &email.Message{
+		From:    firstNonEmpty(req.From, config[ConfigEmailFrom], defaultEmailSender(r.Context())),
+		To:      req.To,
+		Cc:      req.Cc,
+		Bcc:     req.Bcc,
+		ReplyTo: firstNonEmpty(req.ReplyTo, config[ConfigEmailReplyTo]),
+	}
+
+	subject := firstNonEmpty(req.Subject, config[ConfigEmailSubject], tmpl.Name)
+	msg.Subject, err = renderText(subject, varMap)
+	if err != nil {
+		return nil, fmt.Errorf("rendering subject: %w", err)
+	}
+	msg.Subject = strings.Join(strings.Fields(msg.Subject), " ")
+
+	if tmpl.Format == "html" {
+		msg.HTML = rendered
+	} else {
+		msg.HTML = "<html><body><pre style=\"white-space: pre-wrap; font-family: sans-serif;\">" +
+			template.HTMLEscapeString(rendered) + "</pre></body></html>"
+	}
+
+	var inline []email.Attachment
+	msg.HTML, inline, err = email.ExtractInlineImages(msg.HTML)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidEmail, err)
+	}
+	msg.Inline = append(msg.Inline, inline...)
+
+	switch {
+	case config[ConfigEmailTextTemplate] != "":
+		textTmpl, err := Repos.Templates.Get(r.Context(), config[ConfigEmailTextTemplate])
+		if err != nil {
+			return nil, fmt.Errorf("loading text template %s: %w", config[ConfigEmailTextTemplate], err)
+		}
+		msg.Text, err = renderText(textTmpl.Content, varMap)
+		if err != nil {
+			return nil, fmt.Errorf("rendering text template: %w", err)
+		}
+	case tmpl.Format == "html":
+		msg.Text = email.HTMLToText(msg.HTML)
+	default:
+		msg.Text = rendered
+	}
+
+	requestInline, attachments, err := decodeAttachments(req.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	msg.Inline = append(msg.Inline, requestInline...)
+	msg.Attachments = attachments
+
+	if req.AttachPDF {
+		pdfOptions, err := loadPDFOptions(r.Context(), tmpl.ID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching PDF options: %w", err)
+		}
+		doc, err := buildPDFDocument(r.Context(), tmpl, rendered, pdfOptions, varMap)
+		if err != nil {
+			return nil, fmt.Errorf("preparing PDF: %w", err)
+		}
+		pdfBytes, err := PDFRenderer.Render(r.Context(), doc)
+		if err != nil {
+			return nil, err
+		}
+		msg.Attachments = append(msg.Attachments, email.Attachment{
+			Filename:    tmpl.Name + ".pdf",
+			ContentType: "application/pdf",
+			Data:        pdfBytes,
+		})
+	}
+
+	msg.MessageID = email.NewMessageID(msg.From)
+	if err := msg.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidEmail, err)
+	}
+	return msg, nil
+}
+
+// defaultEmailSender returns the smtp.from configuration value, falling back
+// to EMAIL_FROM and then to a placeholder address.
+func defaultEmailSender(ctx context.Context) string {
+	smtpConfig, err := Repos.Settings.Values(ctx, email.ConfigFrom)
+	if err != nil {
+		log.Printf("Error fetching %s: %v", email.ConfigFrom, err)
+	}
+	return firstNonEmpty(smtpConfig[email.ConfigFrom], os.Getenv("EMAIL_FROM"), defaultEmailFrom)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// APIRenderEmail renders a template as a MIME email. The message is returned
+// as structured JSON by default, or as an .eml file with ?format=eml.
+func APIRenderEmail(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	tmpl, err := Repos.Templates.Get(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to retrieve template %s: %v", id, err)
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found")
+		return
+	}
+
+	var req EmailRenderRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	templateVars, err := Repos.Variables.List(r.Context(), id)
+	if err != nil {
+		log.Printf("Error fetching template variables for %s: %v", id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching template variables")
+		return
+	}
+
+	varMap, err := resolveVariables(templateVars, req.Variables)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid variables: "+err.Error())
+		return
+	}
+
+	msg, err := buildEmailMessage(r, tmpl, varMap, req)
+	if err != nil {
+		respondWithError(w, emailErrorStatus(w, err), "Error building email: "+err.Error())
+		return
+	}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error encoding email: "+err.Error())
+		return
+	}
+
+	format := firstNonEmpty(r.URL.Query().Get("format"), req.Format, "json")
+	if format == "eml" {
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.eml\"", tmpl.Name))
+		if _, err := w.Write(raw); err != nil {
+			log.Printf("Error writing response: %v", err)
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: EmailRenderResponse{
+			MessageID:    msg.MessageID,
+			From:         msg.From,
+			To:           msg.To,
+			Cc:           msg.Cc,
+			ReplyTo:      msg.ReplyTo,
+			Subject:      msg.Subject,
+			HTML:         msg.HTML,
+			Text:         msg.Text,
+			InlineImages: nonNilAttachments(msg.Inline),
+			Attachments:  nonNilAttachments(msg.Attachments),
+			Size:         len(raw),
+			Raw:          string(raw),
+		},
+	})
+}
+
+func nonNilAttachments(a []email.Attachment) []email.Attachment {
+	if a == nil {
+		return []email.Attachment{}
+	}
+	return a
+}
+
+// emailErrorStatus maps errors from buildEmailMessage to a status code. PDF
+// attachment failures keep their PDF specific status.
+func emailErrorStatus(w http.ResponseWriter, err error) int {
+	if errors.Is(err, errInvalidEmail) {
+		return http.StatusBadRequest
+	}
+	return pdfErrorStatus(w, err)
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/email"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/gorilla/mux"
+)
+
+// EmailCapture holds test emails sent while smtp.transport is "capture".
+var EmailCapture = email.NewCaptureTransport(100)
+
+const testSendTimeout = 60 * time.Second
+
+// errDeliveryFailed marks failures reported by the mail transport.
+var errDeliveryFailed = errors.New("delivery failed")
+
+type SendTestResponse struct {
+	MessageID  string   `json:"message_id"`
+	Transport  string   `json:"transport"`
+	Recipients []string `json:"recipients"`
+	Subject    string   `json:"subject"`
+	Size       int      `json:"size"`
+}
+
+type ConfigurationRequest struct {
+	ConfigValue string `json:"config_value"`
+	Description string `json:"description"`
+	IsEncrypted bool   `json:"is_encrypted"`
+}
+
+// sendTestEmail renders the template as an email and delivers it through the
+// transport configured by the smtp.* configuration values.
+func sendTestEmail(r *http.Request, tmpl models.Template, varMap map[string]interface{},
+	req EmailRenderRequest) (*email.Message, string, []byte, error) {
+	if len(req.To) == 0 {
+		return nil, "", nil, fmt.Errorf("%w: at least one recipient is required", errInvalidEmail)
+	}
+
+	smtpConfig, err := Repos.Settings.Values(r.Context(), "smtp.")
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("loading SMTP configuration: %w", err)
+	}
+	transport, err := email.NewTransport(smtpConfig, EmailCapture)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	transportName := smtpConfig[email.ConfigTransport]
+	if transportName == "" {
+		transportName = email.TransportCapture
+	}
+
+	msg, err := buildEmailMessage(r, tmpl, varMap, req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	msg.Subject = "[TEST] " + msg.Subject
+	msg.Headers = map[string]string{"X-Template-ID": tmpl.ID}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("encoding email: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), testSendTimeout)
+	defer cancel()
+	if err := transport.Send(ctx, msg.From, msg.Recipients(), raw); err != nil {
+		return nil, "", nil, fmt.Errorf("%w via %s: %w", errDeliveryFailed, transportName, err)
+	}
+
+	log.Printf("Test email for template %s sent via %s to %s", tmpl.ID, transportName, strings.Join(msg.Recipients(), ", "))
+	return msg, transportName, raw, nil
+}
+
+// APISendTestEmail renders a template as an email and delivers it to the
+// given recipients. Admins only, as it sends mail to any address.
+func APISendTestEmail(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondWithError(w, http.StatusForbidden, "Sending test emails requires a valid X-Admin-Token")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	tmpl, err := Repos.Templates.Get(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to retrieve template %s: %v", id, err)
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found")
+		return
+	}
+
+	var req EmailRenderRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	templateVars, err := Repos.Variables.List(r.Context(), id)
+	if err != nil {
+		log.Printf("Error fetching template variables for %s: %v", id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching template variables")
+		return
+	}
+
+	varMap, err := resolveVariables(templateVars, req.Variables)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid variables: "+err.Error())
+		return
+	}
+
+	msg, transport, raw, err := sendTestEmail(r, tmpl, varMap, req)
+	if err != nil {
+		respondWithError(w, sendErrorStatus(w, err), "Error sending test email: "+err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: SendTestResponse{
+			MessageID:  msg.MessageID,
+			Transport:  transport,
+			Recipients: msg.Recipients(),
+			Subject:    msg.Subject,
+			Size:       len(raw),
+		},
+	})
+}
+
+// sendErrorStatus maps send failures to a status code. Transport failures
+// are reported as a bad gateway.
+func sendErrorStatus(w http.ResponseWriter, err error) int {
+	var netErr interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, errInvalidEmail):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	case errors.Is(err, errDeliveryFailed):
+		return http.StatusBadGateway
+	}
+	return emailErrorStatus(w, err)
+}
+
+// HandleSendTestEmail sends a test email from the template view form and
+// responds with an HTML fragment describing the result. The form carries the
+// admin token in its admin_token field.
+func HandleSendTestEmail(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := Repos.Templates.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Error fetching template: "+err.Error(), dbErrorStatus(r, err))
+		return
+	}
+
+	templateVars, err := Repos.Variables.List(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Error fetching template variables: "+err.Error(), dbErrorStatus(r, err))
+		return
+	}
+
+	provided := make(map[string]string)
+	for _, v := range templateVars {
+		provided[v.VariableName] = r.FormValue(v.VariableName)
+	}
+
+	var recipients []string
+	for _, addr := range strings.Split(r.FormValue("test_recipient"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			recipients = append(recipients, addr)
+		}
+	}
+
+	result := func(ok bool, message string) {
+		class := "text-green-700"
+		if !ok {
+			class = "text-red-600"
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if _, err := fmt.Fprintf(w, `<p class="text-sm %s">%s</p>`, class, template.HTMLEscapeString(message)); err != nil {
+			log.Printf("Error writing response: %v", err)
+		}
+	}
+
+	if !isAdmin(r) && !isAdminToken(r.FormValue("admin_token")) {
+		result(false, "Sending test emails requires the admin token")
+		return
+	}
+
+	varMap, err := resolveVariables(templateVars, provided)
+	if err != nil {
+		result(false, err.Error())
+		return
+	}
+
+	msg, transport, _, err := sendTestEmail(r, tmpl, varMap, EmailRenderRequest{To: recipients})
+	if err != nil {
+		result(false, err.Error())
+		return
+	}
+
+	if transport == email.TransportCapture {
+		result(true, fmt.Sprintf("Captured test email %s for %s", msg.MessageID, strings.Join(recipients, ", ")))
+		return
+	}
+	result(true, "Test email sent to "+strings.Join(recipients, ", "))
+}
+
+// APIGetCapturedEmails lists the emails held by the capture transport,
+// newest first. Admins only.
+func APIGetCapturedEmails(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondWithError(w, http.StatusForbidden, "Captured emails require a valid X-Admin-Token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    nonNilCaptured(EmailCapture.Messages()),
+	})
+}
+
+// APIGetCapturedEmail returns a captured email as an .eml file. Admins only.
+func APIGetCapturedEmail(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondWithError(w, http.StatusForbidden, "Captured emails require a valid X-Admin-Token")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid captured email ID")
+		return
+	}
+
+	msg, ok := EmailCapture.Message(id)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Captured email not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"captured-%d.eml\"", msg.ID))
+	if _, err := w.Write(msg.Raw); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// APIClearCapturedEmails drops the captured emails. Admins only.
+func APIClearCapturedEmails(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondWithError(w, http.StatusForbidden, "Captured emails require a valid X-Admin-Token")
+		return
+	}
+
+	EmailCapture.Clear()
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Captured emails cleared",
+	})
+}
+
+func nonNilCaptured(m []email.CapturedMessage) []email.CapturedMessage {
+	if m == nil {
+		return []email.CapturedMessage{}
+	}
+	return m
+}
+
+// APIGetConfiguration lists the service configuration. Encrypted values are
+// masked.
+func APIGetConfiguration(w http.ResponseWriter, r *http.Request) {
+	configs, err := Repos.Settings.List(r.Context())
+	if err != nil {
+		log.Printf("Error fetching configuration: %v", err)
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching configuration")
+		return
+	}
+
+	for i := range configs {
+		if configs[i].IsEncrypted && configs[i].ConfigValue != "" {
+			configs[i].ConfigValue = "********"
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    configs,
+	})
+}
+
+// settingChecks lists the configuration keys that can be set through the API,
+// each with a check of its value. Other keys are refused.
+var settingChecks = map[string]func(string) error{
+	email.ConfigTransport: oneOf(email.TransportCapture, email.TransportSMTP),
+	email.ConfigHost:      anyValue,
+	email.ConfigPort:      intBetween(1, 65535),
+	email.ConfigSecurity:  oneOf(email.SecurityNone, email.SecurityStartTLS, email.SecurityTLS),
+	email.ConfigUsername:  anyValue,
+	email.ConfigPassword:  anyValue,
+	email.ConfigFrom:      mailAddress,
+
+	models.ConfigTrashRetentionDays: intBetween(0, math.MaxInt32),
+
+	"default_template_format":  anyValue,
+	"max_template_size_kb":     intBetween(1, math.MaxInt32),
+	"enable_template_caching":  boolValue,
+	"default_rendering_engine": anyValue,
+	"test_mode":                boolValue,
+}
+
+// encryptedSettings are stored encrypted whatever is_encrypted says.
+var encryptedSettings = map[string]bool{
+	email.ConfigPassword: true,
+}
+
+func anyValue(string) error { return nil }
+
+func oneOf(allowed ...string) func(string) error {
+	return func(value string) error {
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+func intBetween(min, max int) func(string) error {
+	return func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < min || n > max {
+			return fmt.Errorf("must be a whole number from %d to %d", min, max)
+		}
+		return nil
+	}
+}
+
+func boolValue(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return errors.New("must be true or false")
+	}
+	return nil
+}
+
+func mailAddress(value string) error {
+	if _, err := mail.ParseAddress(value); err != nil {
+		return fmt.Errorf("must be an email address: %v", err)
+	}
+	return nil
+}
+
+// APISetConfiguration creates or updates one of the known configuration
+// values, encrypting it when is_encrypted is set. smtp.password is always
+// encrypted, so it cannot be set without CONFIG_ENCRYPTION_KEY, and a value
+// stored encrypted stays encrypted. Admins only, as the SMTP settings decide
+// where test emails go.
+func APISetConfiguration(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondWithError(w, http.StatusForbidden, "Changing the configuration requires a valid X-Admin-Token")
+		return
+	}
+
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	check, ok := settingChecks[key]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown configuration key "+key)
+		return
+	}
+
+	var req ConfigurationRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	if err := check(req.ConfigValue); err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s: %v", key, err))
+		return
+	}
+	encrypted := req.IsEncrypted || encryptedSettings[key]
+	if !encrypted {
+		current, err := Repos.Settings.List(r.Context())
+		if err != nil {
+			log.Printf("Error fetching configuration: %v", err)
+			respondWithError(w, dbErrorStatus(r, err), "Error setting configuration")
+			return
+		}
+		for _, c := range current {
+			if c.ConfigKey == key && c.IsEncrypted {
+				respondWithError(w, http.StatusBadRequest, key+" is stored encrypted; is_encrypted cannot be turned off")
+				return
+			}
+		}
+	}
+
+	if err := Repos.Settings.Set(r.Context(), key, req.ConfigValue, req.Description, encrypted, "api_user"); err != nil {
+		if errors.Is(err, models.ErrNoEncryptionKey) {
+			respondWithError(w, http.StatusBadRequest, "Cannot store encrypted value: "+err.Error())
+			return
+		}
+		log.Printf("Error setting configuration %s: %v", key, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error setting configuration")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Configuration updated successfully",
+	})
+}
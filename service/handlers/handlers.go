@@ -3,44 +3,118 @@ package handlers
 import (
 	"bytes"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
-	"github.com/SebastiaanKlippert/go-wkhtmltopdf"
 	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/pdf"
 	"github.com/gorilla/mux"
 )
 
 var FS embed.FS
 
+// PDFRenderer produces the PDFs served by HandleGeneratePDF. It is selected
+// in main from the PDF_RENDERER setting and wrapped in a pdf.Pool.
+var PDFRenderer pdf.Renderer = pdf.NewWkhtmltopdfRenderer()
+
+// Repos holds the templates, variables, categories, versions and
+// configuration the handlers work on. It defaults to Postgres; tests can
+// replace it with memory.New to run the handlers without a database.
+var Repos = models.NewPostgresRepositories()
+
 func HandleIndex(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/templates", http.StatusSeeOther)
 }
 
+// templateListPageSize is the number of templates shown per page of the HTML
+// list.
+const templateListPageSize = 24
+
 func HandleListTemplates(w http.ResponseWriter, r *http.Request) {
-	_ = r
-	templates, err := models.GetTemplates()
-	if err != nil {
-		http.Error(w, "Error fetching templates: "+err.Error(), http.StatusInternalServerError)
-		return
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	var page models.TemplatePage
+	var results []models.TemplateSearchResult
+	if search != "" {
+		var err error
+		results, err = Repos.Templates.Search(r.Context(), search, maxSearchLimit, 0)
+		if err != nil {
+			http.Error(w, "Error searching templates: "+err.Error(), dbErrorStatus(r, err))
+			return
+		}
+	} else {
+		filter, err := parseTemplateFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if filter.Limit == 0 {
+			filter.Limit = templateListPageSize
+		}
+		filter.Summary = true
+
+		page, err = Repos.Templates.Page(r.Context(), filter)
+		if errors.Is(err, models.ErrInvalidCursor) {
+			http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error fetching templates: "+err.Error(), dbErrorStatus(r, err))
+			return
+		}
 	}
 
-	categories, err := models.GetTemplateCategories()
+	categories, err := Repos.Categories.List(r.Context())
 	if err != nil {
-		http.Error(w, "Error fetching categories: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error fetching categories: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
+	nextPage := ""
+	if page.NextCursor != "" {
+		q := r.URL.Query()
+		q.Set("cursor", page.NextCursor)
+		nextPage = "/templates?" + q.Encode()
+	}
+
+	// Search highlights are escaped by the template repository apart from
+	// their <mark> tags.
+	type searchResult struct {
+		models.TemplateSearchResult
+		NameHTML    template.HTML
+		SnippetHTML template.HTML
+	}
+	searchResults := make([]searchResult, 0, len(results))
+	for _, result := range results {
+		searchResults = append(searchResults, searchResult{
+			TemplateSearchResult: result,
+			NameHTML:             template.HTML(result.HighlightedName),
+			SnippetHTML:          template.HTML(result.Snippet),
+		})
+	}
+
 	data := struct {
 		Templates  []models.Template
 		Categories []models.TemplateCategory
+		Query      url.Values
+		Filtered   bool
+		NextPage   string
+		Search     string
+		Results    []searchResult
 	}{
-		Templates:  templates,
+		Templates:  page.Templates,
 		Categories: categories,
+		Query:      r.URL.Query(),
+		Filtered:   len(r.URL.Query()) > 0,
+		NextPage:   nextPage,
+		Search:     search,
+		Results:    searchResults,
 	}
 
 	htmlTemplate, err := template.ParseFS(FS, "templates/layout.html", "templates/template-list.html")
@@ -56,10 +130,9 @@ func HandleListTemplates(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleNewTemplateForm(w http.ResponseWriter, r *http.Request) {
-	_ = r
-	categories, err := models.GetTemplateCategories()
+	categories, err := Repos.Categories.List(r.Context())
 	if err != nil {
-		http.Error(w, "Error fetching categories: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error fetching categories: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
@@ -104,9 +177,9 @@ func HandleCreateTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	templateID, err := models.CreateTemplate(name, categoryID, content, format, "web_user")
+	templateID, err := Repos.Templates.Create(r.Context(), name, categoryID, content, format, "web_user")
 	if err != nil {
-		http.Error(w, "Error creating template: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error creating template: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
@@ -116,7 +189,7 @@ func HandleCreateTemplate(w http.ResponseWriter, r *http.Request) {
 	varRequired := r.FormValue("var_required") == "on"
 
 	if varName != "" {
-		err = models.AddTemplateVariable(templateID, varName, varDesc, varDefault, varRequired)
+		err = Repos.Variables.Add(r.Context(), templateID, varName, varDesc, varDefault, varRequired)
 		if err != nil {
 			log.Printf("Warning: Failed to add variable to template: %v", err)
 		}
@@ -129,24 +202,52 @@ func HandleViewTemplate(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	tmpl, err := models.GetTemplateByID(id)
+	tmpl, err := Repos.Templates.Get(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Error fetching template: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error fetching template: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
-	variables, err := models.GetTemplateVariables(id)
+	variables, err := Repos.Variables.List(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Error fetching template variables: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error fetching template variables: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
+	samples, err := Repos.Samples.List(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Error fetching template samples: "+err.Error(), dbErrorStatus(r, err))
+		return
+	}
+
+	values := make(map[string]string)
+	for _, v := range variables {
+		values[v.VariableName] = v.DefaultValue
+	}
+	selected := r.URL.Query().Get("sample")
+	if selected != "" {
+		sample, err := Repos.Samples.Get(r.Context(), id, selected)
+		if err != nil {
+			http.Error(w, "Error fetching sample "+selected+": "+err.Error(), lookupErrorStatus(r, err))
+			return
+		}
+		for k, v := range sample.Variables {
+			values[k] = v
+		}
+	}
+
 	data := struct {
-		Template  models.Template
-		Variables []models.TemplateVariable
+		Template       models.Template
+		Variables      []models.TemplateVariable
+		Samples        []models.TemplateSample
+		SelectedSample string
+		Values         map[string]string
 	}{
-		Template:  tmpl,
-		Variables: variables,
+		Template:       tmpl,
+		Variables:      variables,
+		Samples:        samples,
+		SelectedSample: selected,
+		Values:         values,
 	}
 
 	htmlTemplate, err := template.ParseFS(FS, "templates/layout.html", "templates/template-view.html")
@@ -171,15 +272,15 @@ func HandleRenderTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tmpl, err := models.GetTemplateByID(id)
+	tmpl, err := Repos.Templates.Get(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Error fetching template: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error fetching template: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
-	variables, err := models.GetTemplateVariables(id)
+	variables, err := Repos.Variables.List(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Error fetching template variables: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error fetching template variables: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
@@ -246,15 +347,15 @@ func HandleGeneratePDF(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tmpl, err := models.GetTemplateByID(id)
+	tmpl, err := Repos.Templates.Get(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Error fetching template: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error fetching template: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
-	variables, err := models.GetTemplateVariables(id)
+	variables, err := Repos.Variables.List(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Error fetching template variables: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error fetching template variables: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
@@ -267,46 +368,35 @@ func HandleGeneratePDF(w http.ResponseWriter, r *http.Request) {
 		varMap[v.VariableName] = value
 	}
 
-	var renderedBuffer bytes.Buffer
-	htmlTmpl, err := template.New("render").Parse(tmpl.Content)
+	rendered, err := renderContent(tmpl.Content, varMap)
 	if err != nil {
-		http.Error(w, "Error parsing template: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = htmlTmpl.Execute(&renderedBuffer, varMap)
+	pdfOptions, err := loadPDFOptions(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error fetching PDF options: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
-	pdfGen, err := wkhtmltopdf.NewPDFGenerator()
-
-	pdfGen.Dpi.Set(300)
-	pdfGen.Orientation.Set(wkhtmltopdf.OrientationPortrait)
-	pdfGen.MarginTop.Set(20)
-	pdfGen.MarginBottom.Set(20)
-	pdfGen.MarginLeft.Set(20)
-	pdfGen.MarginRight.Set(20)
-
+	doc, err := buildPDFDocument(r.Context(), tmpl, rendered, pdfOptions, varMap)
 	if err != nil {
-		http.Error(w, "Error creating PDF generator: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error preparing PDF: "+err.Error(), dbErrorStatus(r, err))
 		return
 	}
 
-	page := wkhtmltopdf.NewPageReader(strings.NewReader(renderedBuffer.String()))
-	pdfGen.AddPage(page)
-
-	err = pdfGen.Create()
+	pdfBytes, err := PDFRenderer.Render(r.Context(), doc)
 	if err != nil {
-		http.Error(w, "Error generating PDF: "+err.Error(), http.StatusInternalServerError)
+		status := pdfErrorStatus(w, err)
+		http.Error(w, "Error generating PDF: "+err.Error(), status)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/pdf")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.pdf\"", tmpl.Name))
 
-	_, err = w.Write(pdfGen.Bytes())
+	_, err = w.Write(pdfBytes)
 	if err != nil {
 		http.Error(w, "Error sending PDF: "+err.Error(), http.StatusInternalServerError)
 		return
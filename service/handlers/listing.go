@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+)
+
+const maxListLimit = 500
+
+// ListMeta describes the page returned by a listing endpoint.
+type ListMeta struct {
+	Count      int    `json:"count"`
+	Limit      int    `json:"limit,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// parseTemplateFilter reads the listing query parameters shared by the API
+// and the HTML list page.
+func parseTemplateFilter(q url.Values) (models.TemplateFilter, error) {
+	f := models.TemplateFilter{
+		Format:          q.Get("format"),
+		CreatedBy:       q.Get("created_by"),
+		Cursor:          q.Get("cursor"),
+		Sort:            q.Get("sort"),
+		IncludeInactive: q.Get("include_inactive") == "true" || q.Get("include_inactive") == "on",
+		Summary:         q.Get("view") == "summary",
+	}
+
+	switch f.Sort {
+	case "", models.SortCreatedAt, models.SortUpdatedAt, models.SortName, models.SortVersion:
+	default:
+		return f, fmt.Errorf("sort must be created_at, updated_at, name or version")
+	}
+
+	switch q.Get("order") {
+	case "asc":
+		f.Ascending = true
+	case "desc", "":
+		f.Ascending = q.Get("order") == "" && f.Sort == models.SortName
+	default:
+		return f, fmt.Errorf("order must be asc or desc")
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return f, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		f.Limit = limit
+	}
+
+	if v := q.Get("category_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid category_id %q", v)
+		}
+		f.CategoryID = id
+	}
+
+	dates := []struct {
+		param     string
+		target    *time.Time
+		endOfDate bool
+	}{
+		{"created_from", &f.CreatedFrom, false},
+		{"created_to", &f.CreatedTo, true},
+		{"updated_from", &f.UpdatedFrom, false},
+		{"updated_to", &f.UpdatedTo, true},
+	}
+	for _, d := range dates {
+		v := q.Get(d.param)
+		if v == "" {
+			continue
+		}
+		t, err := parseDateParam(v, d.endOfDate)
+		if err != nil {
+			return f, fmt.Errorf("invalid %s %q: use YYYY-MM-DD or RFC 3339", d.param, v)
+		}
+		*d.target = t
+	}
+
+	return f, nil
+}
+
+// parseDateParam accepts RFC 3339 timestamps and plain dates. A plain date
+// used as an upper bound includes the whole day.
+func parseDateParam(v string, endOfDate bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return t, err
+	}
+	if endOfDate {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// APISearchTemplates returns the templates matching the q full-text query,
+// best matches first, with highlighted snippets.
+func APISearchTemplates(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		respondWithError(w, http.StatusBadRequest, "Query parameter q is required")
+		return
+	}
+
+	limit := defaultSearchLimit
+	if v := q.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxSearchLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+			return
+		}
+		limit = l
+	}
+	offset := 0
+	if v := q.Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			respondWithError(w, http.StatusBadRequest, "offset must be a non-negative number")
+			return
+		}
+		offset = o
+	}
+
+	results, err := Repos.Templates.Search(r.Context(), query, limit, offset)
+	if err != nil {
+		log.Printf("Error searching templates for %q: %v", query, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error searching templates")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    results,
+		Meta: ListMeta{
+			Count: len(results),
+			Limit: limit,
+		},
+	})
+}
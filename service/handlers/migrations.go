@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/migrationgen"
+)
+
+// APIExportMigration writes the selected templates as a migration for
+// ?tool=liquibase-yaml, liquibase-sql or flyway. Templates are selected like
+// in APIExport. With ?since=N only templates changed after version N are
+// written, and rolling back restores version N.
+func APIExportMigration(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := parseExportFilter(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	since := 0
+	if value := query.Get("since"); value != "" {
+		since, err = strconv.Atoi(value)
+		if err != nil || since < 1 {
+			respondWithError(w, http.StatusBadRequest, "since must be a positive version number")
+			return
+		}
+	}
+
+	opts := migrationgen.Options{
+		Tool:        query.Get("tool"),
+		Author:      query.Get("author"),
+		ChangeSetID: query.Get("changeset_id"),
+		Context:     query.Get("context"),
+	}
+	switch opts.Tool {
+	case migrationgen.ToolLiquibaseYAML, migrationgen.ToolLiquibaseSQL, migrationgen.ToolFlyway:
+	default:
+		respondWithError(w, http.StatusBadRequest, "tool must be liquibase-yaml, liquibase-sql or flyway")
+		return
+	}
+	if err := opts.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	exports, err := Repos.Bundles.Export(r.Context(), filter)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Template not found: "+err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error exporting templates: %v", err)
+		respondWithError(w, dbErrorStatus(r, err), "Error exporting templates")
+		return
+	}
+
+	var items []migrationgen.Item
+	for _, e := range exports {
+		item := migrationgen.Item{Template: e}
+		if since > 0 {
+			if e.Template.Version <= since {
+				continue
+			}
+			previous, err := Repos.Versions.At(r.Context(), e.Template.ID, since)
+			if errors.Is(err, sql.ErrNoRows) {
+				respondWithError(w, http.StatusConflict,
+					fmt.Sprintf("No history of version %d of template %s (%s) to roll back to", since, e.Template.Name, e.Template.ID))
+				return
+			}
+			if err != nil {
+				log.Printf("Error fetching version %d of template %s: %v", since, e.Template.ID, err)
+				respondWithError(w, dbErrorStatus(r, err), "Error fetching template history")
+				return
+			}
+			item.Previous = &previous
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		respondWithError(w, http.StatusNotFound, "No template changed since the given version")
+		return
+	}
+
+	data, err := migrationgen.Generate(items, opts)
+	if err != nil {
+		log.Printf("Error generating migration: %v", err)
+		respondWithError(w, http.StatusUnprocessableEntity, "Error generating migration: "+err.Error())
+		return
+	}
+
+	contentType := "application/sql"
+	if opts.Tool == migrationgen.ToolLiquibaseYAML {
+		contentType = "application/yaml"
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", migrationgen.FileName(items, opts)))
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/pdf"
+)
+
+// Template config keys controlling PDF output. They live in
+// template_service.template_config next to any other per-template settings.
+const (
+	ConfigPDFHeaderTemplate   = "pdf.header_template"
+	ConfigPDFFooterTemplate   = "pdf.footer_template"
+	ConfigPDFPageNumberFormat = "pdf.page_number_format"
+	ConfigPDFPageNumberAlign  = "pdf.page_number_align"
+	ConfigPDFWatermarkText    = "pdf.watermark_text"
+	ConfigPDFWatermarkImage   = "pdf.watermark_image"
+	ConfigPDFWatermarkAlways  = "pdf.watermark_always"
+	ConfigPDFTableOfContents  = "pdf.toc"
+	ConfigPDFTOCTitle         = "pdf.toc_title"
+)
+
+type PDFOptions struct {
+	HeaderTemplateID     string
+	FooterTemplateID     string
+	PageNumberFormat     string
+	PageNumberAlign      string
+	WatermarkText        string
+	WatermarkImage       string
+	WatermarkAlways      bool
+	TableOfContents      bool
+	TableOfContentsTitle string
+}
+
+func loadPDFOptions(ctx context.Context, templateID string) (PDFOptions, error) {
+	configs, err := Repos.Config.List(ctx, templateID)
+	if err != nil {
+		return PDFOptions{}, err
+	}
+
+	opts := PDFOptions{PageNumberAlign: "center"}
+	for _, c := range configs {
+		switch c.ConfigKey {
+		case ConfigPDFHeaderTemplate:
+			opts.HeaderTemplateID = c.ConfigValue
+		case ConfigPDFFooterTemplate:
+			opts.FooterTemplateID = c.ConfigValue
+		case ConfigPDFPageNumberFormat:
+			opts.PageNumberFormat = c.ConfigValue
+		case ConfigPDFPageNumberAlign:
+			opts.PageNumberAlign = c.ConfigValue
+		case ConfigPDFWatermarkText:
+			opts.WatermarkText = c.ConfigValue
+		case ConfigPDFWatermarkImage:
+			opts.WatermarkImage = c.ConfigValue
+		case ConfigPDFWatermarkAlways:
+			opts.WatermarkAlways, _ = strconv.ParseBool(c.ConfigValue)
+		case ConfigPDFTableOfContents:
+			opts.TableOfContents, _ = strconv.ParseBool(c.ConfigValue)
+		case ConfigPDFTOCTitle:
+			opts.TableOfContentsTitle = c.ConfigValue
+		}
+	}
+
+	return opts, nil
+}
+
+// watermarkEnabled reports whether the watermark should be drawn. Watermarks
+// mark draft output, so production renders skip them unless forced.
+func (o PDFOptions) watermarkEnabled() bool {
+	if o.WatermarkText == "" && o.WatermarkImage == "" {
+		return false
+	}
+	return o.WatermarkAlways || os.Getenv("ENVIRONMENT") != "production"
+}
+
+func renderContent(content string, varMap map[string]interface{}) (string, error) {
+	var renderedBuffer bytes.Buffer
+	htmlTmpl, err := template.New("render").Parse(content)
+	if err != nil {
+		return "", err
+	}
+
+	if err := htmlTmpl.Execute(&renderedBuffer, varMap); err != nil {
+		return "", err
+	}
+	return renderedBuffer.String(), nil
+}
+
+// renderPartial renders another template with the variables of the main
+// document, e.g. a shared header used by several reports.
+func renderPartial(ctx context.Context, templateID string, varMap map[string]interface{}) (string, error) {
+	partial, err := Repos.Templates.Get(ctx, templateID)
+	if err != nil {
+		return "", fmt.Errorf("loading partial template %s: %w", templateID, err)
+	}
+
+	rendered, err := renderContent(partial.Content, varMap)
+	if err != nil {
+		return "", fmt.Errorf("rendering partial template %s: %w", templateID, err)
+	}
+	return rendered, nil
+}
+
+// buildPDFDocument assembles the renderer input for a rendered template,
+// resolving header and footer partials with the same variables.
+func buildPDFDocument(ctx context.Context, tmpl models.Template, rendered string, o PDFOptions,
+	varMap map[string]interface{}) (pdf.Document, error) {
+	doc := pdf.Document{
+		Title:                tmpl.Name,
+		Content:              rendered,
+		Format:               tmpl.Format,
+		PageNumberFormat:     o.PageNumberFormat,
+		PageNumberAlign:      o.PageNumberAlign,
+		TableOfContents:      o.TableOfContents,
+		TableOfContentsTitle: o.TableOfContentsTitle,
+	}
+
+	if o.watermarkEnabled() {
+		doc.WatermarkText = o.WatermarkText
+		doc.WatermarkImage = o.WatermarkImage
+	}
+
+	if o.HeaderTemplateID != "" {
+		header, err := renderPartial(ctx, o.HeaderTemplateID, varMap)
+		if err != nil {
+			return doc, err
+		}
+		doc.HeaderHTML = header
+	}
+
+	if o.FooterTemplateID != "" {
+		footer, err := renderPartial(ctx, o.FooterTemplateID, varMap)
+		if err != nil {
+			return doc, err
+		}
+		doc.FooterHTML = footer
+	}
+
+	return doc, nil
+}
+
+// pdfErrorStatus maps a render error to an HTTP status. A full render queue
+// also sets Retry-After so clients back off instead of hammering the pool.
+func pdfErrorStatus(w http.ResponseWriter, err error) int {
+	switch {
+	case errors.Is(err, pdf.ErrQueueFull):
+		retryAfter := time.Second
+		if pool, ok := PDFRenderer.(*pdf.Pool); ok {
+			retryAfter = pool.RetryAfter()
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
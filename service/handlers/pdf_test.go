@@ -0,0 +1,210 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/handlers"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models/memory"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/pdf"
+	"github.com/gorilla/mux"
+)
+
+// pdfEndpoint is a handler that renders a template as a PDF: the PDF
+// download of the UI and the email API with attach_pdf.
+type pdfEndpoint struct {
+	name string
+	call func(id string) *httptest.ResponseRecorder
+	// served checks the response of a successful render of want.
+	served func(t *testing.T, rec *httptest.ResponseRecorder, want []byte)
+}
+
+var pdfEndpoints = []pdfEndpoint{
+	{
+		name: "HandleGeneratePDF",
+		call: func(id string) *httptest.ResponseRecorder {
+			form := url.Values{"name": {"Ada"}}
+			req := httptest.NewRequest(http.MethodPost, "/templates/"+id+"/pdf", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			handlers.HandleGeneratePDF(rec, mux.SetURLVars(req, map[string]string{"id": id}))
+			return rec
+		},
+		served: func(t *testing.T, rec *httptest.ResponseRecorder, want []byte) {
+			if got := rec.Header().Get("Content-Type"); got != "application/pdf" {
+				t.Errorf("got Content-Type %q, want application/pdf", got)
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="welcome.pdf"` {
+				t.Errorf("got Content-Disposition %q", got)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), want) {
+				t.Errorf("got body %q, want the rendered PDF %q", rec.Body.Bytes(), want)
+			}
+		},
+	},
+	{
+		name: "APIRenderEmail",
+		call: func(id string) *httptest.ResponseRecorder {
+			body := `{"to": ["ada@example.com"], "attach_pdf": true, "variables": {"name": "Ada"}}`
+			req := httptest.NewRequest(http.MethodPost, "/api/templates/"+id+"/email", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			handlers.APIRenderEmail(rec, mux.SetURLVars(req, map[string]string{"id": id}))
+			return rec
+		},
+		served: func(t *testing.T, rec *httptest.ResponseRecorder, want []byte) {
+			var resp struct {
+				Data handlers.EmailRenderResponse `json:"data"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			attachments := resp.Data.Attachments
+			if len(attachments) != 1 || attachments[0].Filename != "welcome.pdf" || attachments[0].ContentType != "application/pdf" {
+				t.Errorf("got attachments %+v, want welcome.pdf", attachments)
+			}
+		},
+	},
+}
+
+// setupPDF makes the handlers use memory repositories and renderer for the
+// rest of the test, and returns the ID of a template greeting {{.name}}.
+func setupPDF(t *testing.T, renderer pdf.Renderer) string {
+	t.Helper()
+
+	repos := memory.New()
+	oldRepos, oldRenderer := handlers.Repos, handlers.PDFRenderer
+	handlers.Repos, handlers.PDFRenderer = repos, renderer
+	t.Cleanup(func() { handlers.Repos, handlers.PDFRenderer = oldRepos, oldRenderer })
+
+	ctx := context.Background()
+	categoryID, err := repos.Categories.Create(ctx, "letters", "")
+	if err != nil {
+		t.Fatalf("creating category: %v", err)
+	}
+	id, err := repos.Templates.Create(ctx, "welcome", strconv.Itoa(categoryID), "<p>Hello {{.name}}</p>", "html", "test")
+	if err != nil {
+		t.Fatalf("creating template: %v", err)
+	}
+	if err := repos.Variables.Add(ctx, id, "name", "", "World", false); err != nil {
+		t.Fatalf("adding variable: %v", err)
+	}
+	return id
+}
+
+func TestPDFRendered(t *testing.T) {
+	for _, e := range pdfEndpoints {
+		t.Run(e.name, func(t *testing.T) {
+			renderer := pdf.NewFakeRenderer()
+			id := setupPDF(t, renderer)
+
+			rec := e.call(id)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			e.served(t, rec, renderer.Output)
+
+			docs := renderer.Documents()
+			if len(docs) != 1 {
+				t.Fatalf("rendered %d documents, want 1", len(docs))
+			}
+			if docs[0].Title != "welcome" || docs[0].Content != "<p>Hello Ada</p>" || docs[0].Format != "html" {
+				t.Errorf("got document %+v", docs[0])
+			}
+		})
+	}
+}
+
+func TestPDFRenderError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"failure", errors.New("renderer crashed"), http.StatusInternalServerError},
+		{"timeout", context.DeadlineExceeded, http.StatusGatewayTimeout},
+	}
+	for _, e := range pdfEndpoints {
+		for _, tt := range tests {
+			t.Run(e.name+"/"+tt.name, func(t *testing.T) {
+				renderer := pdf.NewFakeRenderer()
+				renderer.Err = tt.err
+				id := setupPDF(t, renderer)
+
+				rec := e.call(id)
+				if rec.Code != tt.status {
+					t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+				}
+				if !strings.Contains(rec.Body.String(), tt.err.Error()) {
+					t.Errorf("got body %q, want it to mention %q", rec.Body, tt.err)
+				}
+				if got := rec.Header().Get("Retry-After"); got != "" {
+					t.Errorf("got Retry-After %q on a failed render", got)
+				}
+			})
+		}
+	}
+}
+
+func TestPDFQueueFull(t *testing.T) {
+	for _, e := range pdfEndpoints {
+		t.Run(e.name, func(t *testing.T) {
+			id := setupPDF(t, fullPool(t))
+
+			rec := e.call(id)
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("got status %d, want 503: %s", rec.Code, rec.Body)
+			}
+			if seconds, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || seconds < 1 {
+				t.Errorf("got Retry-After %q, want a number of seconds", rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+// fullPool returns a pool of one worker and no queue, busy with a render
+// that lasts until the test ends.
+func fullPool(t *testing.T) *pdf.Pool {
+	t.Helper()
+
+	renderer := pdf.NewFakeRenderer()
+	renderer.Delay = time.Minute
+	pool := pdf.NewPool(renderer, 1, 0, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Retry while a probe below holds the slot.
+		for {
+			if _, err := pool.Render(ctx, pdf.Document{}); !errors.Is(err, pdf.ErrQueueFull) {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// A render with a done context returns at once: with ErrQueueFull once
+	// the slot is taken and with the context's error before.
+	probe, stop := context.WithCancel(context.Background())
+	stop()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, err := pool.Render(probe, pdf.Document{}); errors.Is(err, pdf.ErrQueueFull) {
+			return pool
+		}
+	}
+	t.Fatal("the render queue did not fill up")
+	return nil
+}
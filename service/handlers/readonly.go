@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ReadOnly is set when the service started against a schema older than it
+// requires. Requests that change data are refused until it is restarted
+// after the migrations ran.
+var ReadOnly bool
+
+// Writes marks a handler that changes data, refusing it with 503 while
+// ReadOnly is set. Handlers that only read keep working whatever their
+// method, so renders and previews sent with POST are still served.
+func Writes(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if refuseReadOnly(w, r) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// refuseReadOnly answers 503 and returns true while ReadOnly is set. It is
+// for handlers that only write with some of their parameters.
+func refuseReadOnly(w http.ResponseWriter, r *http.Request) bool {
+	if !ReadOnly {
+		return false
+	}
+
+	const message = "The service is read-only until the database migrations have run"
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		respondWithError(w, http.StatusServiceUnavailable, message)
+	} else {
+		http.Error(w, message, http.StatusServiceUnavailable)
+	}
+	return true
+}
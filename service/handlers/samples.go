@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/gorilla/mux"
+)
+
+type TemplateSampleRequest struct {
+	Name           string            `json:"name"`
+	Description    string            `json:"description"`
+	Variables      map[string]string `json:"variables"`
+	ExpectedOutput *string           `json:"expected_output,omitempty"`
+}
+
+// withSample overlays the provided variables on the values stored in the
+// named sample. An empty name returns the provided variables unchanged.
+func withSample(ctx context.Context, templateID, name string, provided map[string]string) (map[string]string, error) {
+	if name == "" {
+		return provided, nil
+	}
+
+	sample, err := Repos.Samples.Get(ctx, templateID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]string, len(sample.Variables)+len(provided))
+	for k, v := range sample.Variables {
+		merged[k] = v
+	}
+	for k, v := range provided {
+		if v != "" {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+func APIGetTemplateSamples(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	samples, err := Repos.Samples.List(r.Context(), id)
+	if err != nil {
+		log.Printf("Error fetching samples for template %s: %v", id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching template samples")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    samples,
+	})
+}
+
+func APIGetTemplateSample(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	name := vars["name"]
+
+	sample, err := Repos.Samples.Get(r.Context(), id, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Sample not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching sample %s for template %s: %v", name, id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching template sample")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    sample,
+	})
+}
+
+func APICreateTemplateSample(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if _, err := Repos.Templates.Get(r.Context(), id); err != nil {
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found")
+		return
+	}
+
+	var req TemplateSampleRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	if req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Sample name is required")
+		return
+	}
+
+	err := Repos.Samples.Create(r.Context(), id, req.Name, req.Description, req.Variables, req.ExpectedOutput, "api_user")
+	if models.IsUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Sample %q already exists", req.Name))
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating sample for template %s: %v", id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error creating template sample")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Data:    "Sample created successfully",
+	})
+}
+
+func APISetTemplateSample(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	name := vars["name"]
+
+	if _, err := Repos.Templates.Get(r.Context(), id); err != nil {
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found")
+		return
+	}
+
+	var req TemplateSampleRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	if err := Repos.Samples.Set(r.Context(), id, name, req.Description, req.Variables, req.ExpectedOutput, "api_user"); err != nil {
+		log.Printf("Error setting sample %s for template %s: %v", name, id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error saving template sample")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Sample saved successfully",
+	})
+}
+
+func APIDeleteTemplateSample(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	name := vars["name"]
+
+	err := Repos.Samples.Delete(r.Context(), id, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Sample not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting sample %s for template %s: %v", name, id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error deleting template sample")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Sample deleted successfully",
+	})
+}
+
+// HandleSaveSample stores the values entered in the render form as a named
+// sample and reopens the template with that sample selected.
+func HandleSaveSample(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := r.FormValue("sample_name")
+	if name == "" {
+		http.Error(w, "Sample name is required", http.StatusBadRequest)
+		return
+	}
+
+	variables, err := Repos.Variables.List(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Error fetching template variables: "+err.Error(), dbErrorStatus(r, err))
+		return
+	}
+
+	values := make(map[string]string)
+	for _, v := range variables {
+		values[v.VariableName] = r.FormValue(v.VariableName)
+	}
+
+	if err := Repos.Samples.Set(r.Context(), id, name, r.FormValue("sample_description"), values, nil, "web_user"); err != nil {
+		http.Error(w, "Error saving sample: "+err.Error(), dbErrorStatus(r, err))
+		return
+	}
+
+	http.Redirect(w, r, "/templates/"+id+"?sample="+url.QueryEscape(name), http.StatusSeeOther)
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+)
+
+// APIGetSchemaStatus reports the schema version, the applied and failed
+// entries of every migration tool's history table and the migrations that
+// are still pending. Admins only.
+func APIGetSchemaStatus(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondWithError(w, http.StatusForbidden, "Schema status requires a valid X-Admin-Token")
+		return
+	}
+
+	status, err := Repos.Schema.Status(r.Context(), db.MigrationEnvironment())
+	if err != nil {
+		log.Printf("Error reading schema status: %v", err)
+		respondWithError(w, dbErrorStatus(r, err), "Error reading schema status")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    status,
+	})
+}
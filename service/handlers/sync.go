@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/templatesync"
+)
+
+// TemplateSyncDir is the templates-as-code directory, empty when sync is
+// not configured.
+var TemplateSyncDir string
+
+// APIGetSyncPlan reports how the database differs from the templates
+// directory, including templates edited through the UI since the last sync.
+func APIGetSyncPlan(w http.ResponseWriter, r *http.Request) {
+	if TemplateSyncDir == "" {
+		respondWithError(w, http.StatusNotFound, "Template sync is not configured, set TEMPLATE_SYNC_DIR")
+		return
+	}
+
+	plan, err := templatesync.MakePlan(r.Context(), Repos, TemplateSyncDir)
+	if err != nil {
+		log.Printf("Error planning template sync: %v", err)
+		respondWithError(w, dbErrorStatus(r, err), "Error planning template sync: "+err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    plan,
+	})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/gorilla/mux"
+)
+
+// retentionUser is recorded in the audit log for templates purged by the
+// retention job.
+const retentionUser = "trash_retention"
+
+// isAdmin reports whether the request carries the ADMIN_TOKEN in the
+// X-Admin-Token header. Without ADMIN_TOKEN nobody is an admin.
+func isAdmin(r *http.Request) bool {
+	return isAdminToken(r.Header.Get("X-Admin-Token"))
+}
+
+// isAdminToken reports whether token is the ADMIN_TOKEN, for forms that
+// cannot set the X-Admin-Token header.
+func isAdminToken(token string) bool {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// APIGetTrash lists the soft-deleted templates, most recently deleted first.
+func APIGetTrash(w http.ResponseWriter, r *http.Request) {
+	templates, err := Repos.Templates.Trashed(r.Context())
+	if err != nil {
+		log.Printf("Error fetching trashed templates: %v", err)
+		respondWithError(w, dbErrorStatus(r, err), "Error fetching trashed templates")
+		return
+	}
+	if templates == nil {
+		templates = []models.TrashedTemplate{}
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    templates,
+	})
+}
+
+func APIRestoreTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	err := Repos.Templates.Restore(r.Context(), id, "api_user")
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Template not found in trash")
+		return
+	}
+	if err != nil {
+		log.Printf("Error restoring template %s: %v", id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error restoring template")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Template restored successfully",
+	})
+}
+
+// purgeTemplate permanently deletes a template on behalf of an admin.
+func purgeTemplate(w http.ResponseWriter, r *http.Request, id string) {
+	if !isAdmin(r) {
+		respondWithError(w, http.StatusForbidden, "Purging templates requires a valid X-Admin-Token")
+		return
+	}
+
+	err := Repos.Templates.Purge(r.Context(), id, "admin")
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Template not found in the trash")
+		return
+	}
+	if err != nil {
+		log.Printf("Error purging template %s: %v", id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error purging template")
+		return
+	}
+
+	log.Printf("Template %s purged by admin", id)
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Template purged successfully",
+	})
+}
+
+// RunTrashRetention purges, every interval, the templates that have been in
+// the trash for longer than templates.trash_retention_days. A retention of
+// 0 keeps them forever. It returns when ctx is done.
+func RunTrashRetention(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purgeExpiredTemplates(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeExpiredTemplates(ctx context.Context) {
+	config, err := Repos.Settings.Values(ctx, models.ConfigTrashRetentionDays)
+	if err != nil {
+		log.Printf("Trash retention: error loading configuration: %v", err)
+		return
+	}
+	value, ok := config[models.ConfigTrashRetentionDays]
+	if !ok {
+		return
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		log.Printf("Trash retention: invalid %s %q", models.ConfigTrashRetentionDays, value)
+		return
+	}
+	if days == 0 {
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	purged, err := Repos.Templates.PurgeDeleted(ctx, cutoff, retentionUser)
+	if err != nil {
+		log.Printf("Trash retention: error purging templates: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Trash retention: purged %d templates deleted before %s", purged, cutoff.Format(time.RFC3339))
+	}
+}
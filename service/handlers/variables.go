@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/gorilla/mux"
+)
+
+// variableErrorStatus maps variable write errors to a status code and
+// message. Duplicate names violate uk_template_id_variable_name.
+func variableErrorStatus(r *http.Request, err error, name string) (int, string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, "Variable not found"
+	case models.IsUniqueViolation(err):
+		return http.StatusConflict, fmt.Sprintf("Variable %q already exists for this template", name)
+	}
+	log.Printf("Template variable error: %v", err)
+	return dbErrorStatus(r, err), "Error saving template variable"
+}
+
+func (req TemplateVariableRequest) variable() models.TemplateVariable {
+	return models.TemplateVariable{
+		VariableName: req.VariableName,
+		Description:  req.Description,
+		DefaultValue: req.DefaultValue,
+		IsRequired:   req.IsRequired,
+		VariableType: req.VariableType,
+	}
+}
+
+func APIUpdateTemplateVariable(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	variableID, err := strconv.Atoi(vars["variableId"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid variable ID")
+		return
+	}
+
+	var req TemplateVariableRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	if req.VariableName == "" {
+		respondWithError(w, http.StatusBadRequest, "Variable name is required")
+		return
+	}
+
+	if err := Repos.Variables.Update(r.Context(), id, variableID, req.variable()); err != nil {
+		status, message := variableErrorStatus(r, err, req.VariableName)
+		respondWithError(w, status, message)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Variable updated successfully",
+	})
+}
+
+func APIDeleteTemplateVariable(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	variableID, err := strconv.Atoi(vars["variableId"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid variable ID")
+		return
+	}
+
+	if err := Repos.Variables.Delete(r.Context(), id, variableID); err != nil {
+		status, message := variableErrorStatus(r, err, "")
+		respondWithError(w, status, message)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Variable deleted successfully",
+	})
+}
+
+// APIReplaceTemplateVariables replaces the complete variable list of a
+// template. Variables are matched by name; the ones not listed are deleted.
+func APIReplaceTemplateVariables(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if _, err := Repos.Templates.Get(r.Context(), id); err != nil {
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found: "+err.Error())
+		return
+	}
+
+	var req []TemplateVariableRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	seen := make(map[string]bool)
+	variables := make([]models.TemplateVariable, 0, len(req))
+	for i, v := range req {
+		if v.VariableName == "" {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Variable %d: name is required", i+1))
+			return
+		}
+		if seen[v.VariableName] {
+			respondWithError(w, http.StatusConflict, fmt.Sprintf("Variable %q is listed more than once", v.VariableName))
+			return
+		}
+		seen[v.VariableName] = true
+		variables = append(variables, v.variable())
+	}
+
+	if err := Repos.Variables.Replace(r.Context(), id, variables); err != nil {
+		status, message := variableErrorStatus(r, err, "")
+		respondWithError(w, status, message)
+		return
+	}
+
+	updated, err := Repos.Variables.List(r.Context(), id)
+	if err != nil {
+		respondWithError(w, dbErrorStatus(r, err), "Variables replaced but could not be retrieved: "+err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    updated,
+	})
+}
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ConfigSamplesVerifyOnUpdate is the template config key that makes
+// APIUpdateTemplate refuse changes breaking a sample's expected output.
+const ConfigSamplesVerifyOnUpdate = "samples.verify_on_update"
+
+// Verification results of a single sample.
+const (
+	VerifyMatch           = "match"
+	VerifyNormalizedMatch = "normalized_match"
+	VerifyMismatch        = "mismatch"
+	VerifyError           = "error"
+	VerifyNoSnapshot      = "no_snapshot"
+)
+
+// maxDiffLines bounds the diff returned for a mismatching sample.
+const maxDiffLines = 50
+
+type SampleVerification struct {
+	Sample string   `json:"sample"`
+	Status string   `json:"status"`
+	Diff   []string `json:"diff,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	Output string   `json:"-"`
+}
+
+type VerificationReport struct {
+	TemplateID string               `json:"template_id"`
+	Passed     bool                 `json:"passed"`
+	Checked    int                  `json:"checked"`
+	Failed     int                  `json:"failed"`
+	Accepted   bool                 `json:"accepted,omitempty"`
+	Samples    []SampleVerification `json:"samples"`
+}
+
+type VerifyRequest struct {
+	// Content verifies a candidate template body instead of the stored one.
+	Content string `json:"content,omitempty"`
+	// Accept stores the current outputs as the expected outputs.
+	Accept bool `json:"accept"`
+}
+
+// verifySamples renders every sample with content and compares the result
+// with the sample's expected output. HTML is also compared after
+// normalizing whitespace, so reformatting markup is not reported as a break.
+func verifySamples(ctx context.Context, templateID, content, format string) (VerificationReport, error) {
+	report := VerificationReport{TemplateID: templateID, Passed: true, Samples: []SampleVerification{}}
+
+	samples, err := Repos.Samples.List(ctx, templateID)
+	if err != nil {
+		return report, fmt.Errorf("fetching samples: %w", err)
+	}
+	templateVars, err := Repos.Variables.List(ctx, templateID)
+	if err != nil {
+		return report, fmt.Errorf("fetching template variables: %w", err)
+	}
+
+	for _, sample := range samples {
+		result := SampleVerification{Sample: sample.Name}
+
+		varMap, err := resolveVariables(templateVars, sample.Variables)
+		if err == nil {
+			result.Output, err = renderContent(content, varMap)
+		}
+
+		switch {
+		case err != nil:
+			result.Status = VerifyError
+			result.Error = err.Error()
+		case !sample.HasExpectedOutput:
+			result.Status = VerifyNoSnapshot
+		case result.Output == sample.ExpectedOutput:
+			result.Status = VerifyMatch
+		case format == "html" && normalizeHTML(result.Output) == normalizeHTML(sample.ExpectedOutput):
+			result.Status = VerifyNormalizedMatch
+		default:
+			result.Status = VerifyMismatch
+			result.Diff = lineDiff(sample.ExpectedOutput, result.Output, maxDiffLines)
+		}
+
+		if result.Status != VerifyNoSnapshot {
+			report.Checked++
+		}
+		if result.Status == VerifyMismatch || (result.Status == VerifyError && sample.HasExpectedOutput) {
+			report.Failed++
+			report.Passed = false
+		}
+		report.Samples = append(report.Samples, result)
+	}
+
+	return report, nil
+}
+
+// acceptOutputs stores the rendered outputs of the report as the expected
+// outputs of their samples.
+func acceptOutputs(ctx context.Context, templateID string, report *VerificationReport) error {
+	for _, result := range report.Samples {
+		if result.Status == VerifyError {
+			continue
+		}
+		output := result.Output
+		if err := Repos.Samples.SetExpectedOutput(ctx, templateID, result.Sample, &output); err != nil {
+			return fmt.Errorf("storing expected output of %s: %w", result.Sample, err)
+		}
+	}
+	report.Accepted = true
+	return nil
+}
+
+var (
+	htmlBetweenTags = regexp.MustCompile(`>\s+<`)
+	htmlWhitespace  = regexp.MustCompile(`\s+`)
+)
+
+func normalizeHTML(s string) string {
+	s = htmlWhitespace.ReplaceAllString(s, " ")
+	s = htmlBetweenTags.ReplaceAllString(s, "><")
+	return strings.TrimSpace(s)
+}
+
+// lineDiff returns the changed lines between expected and actual, prefixed
+// with "-" or "+" and the line number, computed from the longest common
+// subsequence of lines. Very large inputs fall back to comparing line by line.
+func lineDiff(expected, actual string, limit int) []string {
+	a := strings.Split(expected, "\n")
+	b := strings.Split(actual, "\n")
+
+	var diff []string
+	add := func(line string) bool {
+		if len(diff) == limit {
+			diff = append(diff, "...")
+			return false
+		}
+		diff = append(diff, line)
+		return true
+	}
+
+	if len(a)*len(b) > 1_000_000 {
+		for i := 0; i < len(a) || i < len(b); i++ {
+			if i < len(a) && i < len(b) && a[i] == b[i] {
+				continue
+			}
+			if i < len(a) && !add(fmt.Sprintf("-%d: %s", i+1, a[i])) {
+				break
+			}
+			if i < len(b) && !add(fmt.Sprintf("+%d: %s", i+1, b[i])) {
+				break
+			}
+		}
+		return diff
+	}
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			if !add(fmt.Sprintf("-%d: %s", i+1, a[i])) {
+				return diff
+			}
+			i++
+		default:
+			if !add(fmt.Sprintf("+%d: %s", j+1, b[j])) {
+				return diff
+			}
+			j++
+		}
+	}
+	return diff
+}
+
+// APIVerifyTemplate re-renders every sample of a template and reports
+// differences from their expected outputs. With "accept" the current outputs
+// become the new expected outputs.
+func APIVerifyTemplate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	tmpl, err := Repos.Templates.Get(r.Context(), id)
+	if err != nil {
+		respondWithError(w, lookupErrorStatus(r, err), "Template not found")
+		return
+	}
+
+	var req VerifyRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+	req.Accept = req.Accept || r.URL.Query().Get("accept") == "true"
+	if req.Accept && refuseReadOnly(w, r) {
+		return
+	}
+
+	if req.Accept && req.Content != "" {
+		respondWithError(w, http.StatusBadRequest, "Outputs of unsaved content cannot be accepted")
+		return
+	}
+
+	content := firstNonEmpty(req.Content, tmpl.Content)
+	report, err := verifySamples(r.Context(), id, content, tmpl.Format)
+	if err != nil {
+		log.Printf("Error verifying template %s: %v", id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error verifying template")
+		return
+	}
+
+	if req.Accept {
+		if err := acceptOutputs(r.Context(), id, &report); err != nil {
+			log.Printf("Error accepting outputs of template %s: %v", id, err)
+			respondWithError(w, dbErrorStatus(r, err), "Error storing expected outputs")
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
+
+// verifyOnUpdate reports whether an update of the template must keep the
+// expected outputs of its samples.
+func verifyOnUpdate(ctx context.Context, templateID string, requested bool) (bool, error) {
+	if requested {
+		return true, nil
+	}
+	configs, err := Repos.Config.List(ctx, templateID)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range configs {
+		if c.ConfigKey == ConfigSamplesVerifyOnUpdate {
+			return c.ConfigValue == "true", nil
+		}
+	}
+	return false, nil
+}
+
+// APIDeleteSampleExpectedOutput removes the golden output of a sample so it
+// is no longer checked.
+func APIDeleteSampleExpectedOutput(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	name := vars["name"]
+
+	err := Repos.Samples.SetExpectedOutput(r.Context(), id, name, nil)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Sample not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error clearing expected output of sample %s for template %s: %v", name, id, err)
+		respondWithError(w, dbErrorStatus(r, err), "Error clearing expected output")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    "Expected output removed",
+	})
+}
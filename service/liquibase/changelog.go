@@ -0,0 +1,409 @@
+// Package liquibase reads the Liquibase changelogs of this repository: YAML
+// changelogs as in ../liquibase and XML master changelogs including
+// formatted SQL files as in ../liquibase-sql. Changesets keep the file and
+// line they were declared at, for tools reporting on them.
+package liquibase
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Changelog formats.
+const (
+	FormatYAML = "yaml"
+	FormatSQL  = "sql"
+)
+
+type ChangeSet struct {
+	ID          string
+	Author      string
+	Comment     string
+	Context     string
+	RunAlways   bool
+	RunOnChange bool
+	// File is the changelog declaring the changeset and Line the line of
+	// its changeSet key or --changeset comment.
+	File          string
+	Line          int
+	Format        string
+	Preconditions *Preconditions
+	Changes       []Change
+	// Rollback is nil when the changeset declares no rollback.
+	Rollback []Change
+	// Node is the changeSet mapping of YAML changesets.
+	Node *yaml.Node
+}
+
+// Change is one change of a changeset. Formatted SQL changesets have a
+// single "sql" change holding the statements.
+type Change struct {
+	Type string
+	Line int
+	// Node holds the attributes of YAML changes.
+	Node *yaml.Node
+	// SQL is the text of formatted SQL changes and rollbacks.
+	SQL string
+}
+
+type Preconditions struct {
+	OnFail     string
+	OnError    string
+	Line       int
+	Conditions []Condition
+}
+
+// Condition is a precondition such as tableExists or sqlCheck, or one of
+// not, and and or combining the Nested conditions.
+type Condition struct {
+	Type   string
+	Line   int
+	Attrs  map[string]string
+	Nested []Condition
+}
+
+// Load reads the master changelog at path and every file it includes, and
+// returns the changesets in execution order.
+func Load(path string) ([]ChangeSet, error) {
+	return load(path, make(map[string]bool))
+}
+
+func load(path string, seen map[string]bool) ([]ChangeSet, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if seen[abs] {
+		return nil, fmt.Errorf("%s: included more than once", path)
+	}
+	seen[abs] = true
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return loadYAML(path, seen)
+	case ".xml":
+		return loadXML(path, seen)
+	case ".sql":
+		return loadSQL(path)
+	default:
+		return nil, fmt.Errorf("%s: unsupported changelog format", path)
+	}
+}
+
+// includePath resolves an included file. Paths not relative to the
+// changelog are relative to the working directory, as Liquibase runs next
+// to the master changelog.
+func includePath(changelog, file string, relative bool) string {
+	if relative {
+		return filepath.Join(filepath.Dir(changelog), file)
+	}
+	return file
+}
+
+func loadYAML(path string, seen map[string]bool) ([]ChangeSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s: empty changelog", path)
+	}
+	entries := mappingValue(doc.Content[0], "databaseChangeLog")
+	if entries == nil || entries.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s: databaseChangeLog must be a list", path)
+	}
+
+	var changeSets []ChangeSet
+	for _, entry := range entries.Content {
+		if entry.Kind != yaml.MappingNode || len(entry.Content) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a single key", path, entry.Line)
+		}
+		key, value := entry.Content[0], entry.Content[1]
+		switch key.Value {
+		case "changeSet":
+			cs, err := yamlChangeSet(path, key.Line, value)
+			if err != nil {
+				return nil, err
+			}
+			changeSets = append(changeSets, cs)
+		case "include":
+			file := scalar(value, "file")
+			if file == "" {
+				return nil, fmt.Errorf("%s:%d: include without file", path, key.Line)
+			}
+			included, err := load(includePath(path, file, scalar(value, "relativeToChangelogFile") == "true"), seen)
+			if err != nil {
+				return nil, err
+			}
+			changeSets = append(changeSets, included...)
+		case "includeAll":
+			dir := scalar(value, "path")
+			if dir == "" {
+				return nil, fmt.Errorf("%s:%d: includeAll without path", path, key.Line)
+			}
+			included, err := loadDir(includePath(path, dir, scalar(value, "relativeToChangelogFile") == "true"), seen)
+			if err != nil {
+				return nil, err
+			}
+			changeSets = append(changeSets, included...)
+		default:
+			return nil, fmt.Errorf("%s:%d: unsupported changelog entry %q", path, key.Line, key.Value)
+		}
+	}
+	return changeSets, nil
+}
+
+// loadDir includes the changelogs of a directory in file name order, as
+// includeAll does.
+func loadDir(dir string, seen map[string]bool) ([]ChangeSet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".yaml", ".yml", ".xml", ".sql":
+			if !e.IsDir() {
+				names = append(names, e.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var changeSets []ChangeSet
+	for _, name := range names {
+		included, err := load(filepath.Join(dir, name), seen)
+		if err != nil {
+			return nil, err
+		}
+		changeSets = append(changeSets, included...)
+	}
+	return changeSets, nil
+}
+
+func yamlChangeSet(path string, line int, node *yaml.Node) (ChangeSet, error) {
+	cs := ChangeSet{File: path, Line: line, Format: FormatYAML, Node: node}
+	if node.Kind != yaml.MappingNode {
+		return cs, fmt.Errorf("%s:%d: changeSet must be a mapping", path, line)
+	}
+
+	cs.ID = scalar(node, "id")
+	cs.Author = scalar(node, "author")
+	cs.Comment = scalar(node, "comment")
+	cs.Context = scalar(node, "context")
+	if cs.Context == "" {
+		cs.Context = scalar(node, "contextFilter")
+	}
+	cs.RunAlways = scalar(node, "runAlways") == "true"
+	cs.RunOnChange = scalar(node, "runOnChange") == "true"
+
+	if pre := mappingValue(node, "preConditions"); pre != nil {
+		p, err := yamlPreconditions(path, pre)
+		if err != nil {
+			return cs, err
+		}
+		cs.Preconditions = p
+	}
+
+	var err error
+	if changes := mappingValue(node, "changes"); changes != nil {
+		if cs.Changes, err = yamlChanges(path, changes); err != nil {
+			return cs, err
+		}
+	}
+	if rollback := mappingValue(node, "rollback"); rollback != nil {
+		if cs.Rollback, err = yamlChanges(path, rollback); err != nil {
+			return cs, err
+		}
+		if cs.Rollback == nil {
+			cs.Rollback = []Change{}
+		}
+	}
+	return cs, nil
+}
+
+// yamlChanges reads a list of changes. Rollbacks may also be a single
+// change or a plain SQL string.
+func yamlChanges(path string, node *yaml.Node) ([]Change, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []Change{{Type: "sql", Line: node.Line, SQL: node.Value}}, nil
+	case yaml.MappingNode:
+		return yamlChanges(path, &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{node}})
+	case yaml.SequenceNode:
+	default:
+		return nil, fmt.Errorf("%s:%d: expected a list of changes", path, node.Line)
+	}
+
+	var changes []Change
+	for _, item := range node.Content {
+		if item.Kind != yaml.MappingNode || len(item.Content) != 2 {
+			return nil, fmt.Errorf("%s:%d: a change must have a single key", path, item.Line)
+		}
+		change := Change{Type: item.Content[0].Value, Line: item.Content[0].Line, Node: item.Content[1]}
+		if change.Type == "sql" {
+			change.SQL = scalar(change.Node, "sql")
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func yamlPreconditions(path string, node *yaml.Node) (*Preconditions, error) {
+	p := &Preconditions{Line: node.Line}
+	items := node.Content
+	if node.Kind == yaml.MappingNode {
+		items = []*yaml.Node{node}
+	} else if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s:%d: preConditions must be a list", path, node.Line)
+	}
+
+	for _, item := range items {
+		if item.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s:%d: expected a precondition", path, item.Line)
+		}
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			key, value := item.Content[i], item.Content[i+1]
+			switch key.Value {
+			case "onFail":
+				p.OnFail = value.Value
+			case "onError":
+				p.OnError = value.Value
+			case "onFailMessage", "onErrorMessage", "onSqlOutput":
+			default:
+				c, err := yamlCondition(path, key, value)
+				if err != nil {
+					return nil, err
+				}
+				p.Conditions = append(p.Conditions, c)
+			}
+		}
+	}
+	return p, nil
+}
+
+func yamlCondition(path string, key, value *yaml.Node) (Condition, error) {
+	c := Condition{Type: key.Value, Line: key.Line}
+	switch key.Value {
+	case "not", "and", "or":
+		items := value.Content
+		if value.Kind == yaml.MappingNode {
+			items = []*yaml.Node{value}
+		}
+		for _, item := range items {
+			if item.Kind != yaml.MappingNode {
+				return c, fmt.Errorf("%s:%d: expected a precondition", path, item.Line)
+			}
+			for i := 0; i+1 < len(item.Content); i += 2 {
+				nested, err := yamlCondition(path, item.Content[i], item.Content[i+1])
+				if err != nil {
+					return c, err
+				}
+				c.Nested = append(c.Nested, nested)
+			}
+		}
+	default:
+		if value.Kind != yaml.MappingNode {
+			return c, fmt.Errorf("%s:%d: %s must be a mapping", path, key.Line, key.Value)
+		}
+		c.Attrs = make(map[string]string)
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			c.Attrs[value.Content[i].Value] = value.Content[i+1].Value
+		}
+	}
+	return c, nil
+}
+
+// mappingValue returns the value of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// scalar returns the scalar value of key in a mapping node, or "".
+func scalar(node *yaml.Node, key string) string {
+	value := mappingValue(node, key)
+	if value == nil || value.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return value.Value
+}
+
+// loadXML reads an XML master changelog. Only include and includeAll are
+// supported; changesets live in the included files.
+func loadXML(path string, seen map[string]bool) ([]ChangeSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var changeSets []ChangeSet
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			line, _ := decoder.InputPos()
+			if depth == 1 {
+				if t.Name.Local != "databaseChangeLog" {
+					return nil, fmt.Errorf("%s:%d: expected databaseChangeLog", path, line)
+				}
+				continue
+			}
+			if depth > 2 {
+				continue
+			}
+
+			attrs := make(map[string]string)
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			var included []ChangeSet
+			switch t.Name.Local {
+			case "include":
+				included, err = load(includePath(path, attrs["file"], attrs["relativeToChangelogFile"] == "true"), seen)
+			case "includeAll":
+				included, err = loadDir(includePath(path, attrs["path"], attrs["relativeToChangelogFile"] == "true"), seen)
+			default:
+				err = fmt.Errorf("%s:%d: unsupported element <%s>, move changesets to included files", path, line, t.Name.Local)
+			}
+			if err != nil {
+				return nil, err
+			}
+			changeSets = append(changeSets, included...)
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return changeSets, nil
+}
@@ -0,0 +1,319 @@
+package liquibase
+
+import (
+	"fmt"
+	"strings"
+)
+
+// column is the column element of createTable, addColumn, insert, update
+// and createIndex.
+type column struct {
+	Name                 string       `yaml:"name"`
+	Type                 string       `yaml:"type"`
+	Value                *string      `yaml:"value"`
+	ValueNumeric         *string      `yaml:"valueNumeric"`
+	ValueBoolean         *bool        `yaml:"valueBoolean"`
+	ValueComputed        *string      `yaml:"valueComputed"`
+	ValueDate            *string      `yaml:"valueDate"`
+	DefaultValue         *string      `yaml:"defaultValue"`
+	DefaultValueNumeric  *string      `yaml:"defaultValueNumeric"`
+	DefaultValueBoolean  *bool        `yaml:"defaultValueBoolean"`
+	DefaultValueComputed *string      `yaml:"defaultValueComputed"`
+	DefaultValueDate     *string      `yaml:"defaultValueDate"`
+	Constraints          *constraints `yaml:"constraints"`
+}
+
+type constraints struct {
+	PrimaryKey           bool   `yaml:"primaryKey"`
+	PrimaryKeyName       string `yaml:"primaryKeyName"`
+	Nullable             *bool  `yaml:"nullable"`
+	Unique               bool   `yaml:"unique"`
+	UniqueConstraintName string `yaml:"uniqueConstraintName"`
+	ForeignKeyName       string `yaml:"foreignKeyName"`
+	References           string `yaml:"references"`
+	ReferencedTableName  string `yaml:"referencedTableName"`
+	ReferencedColumnName string `yaml:"referencedColumnNames"`
+	DeleteCascade        bool   `yaml:"deleteCascade"`
+}
+
+type columnItem struct {
+	Column column `yaml:"column"`
+}
+
+// changeAttrs holds the attributes of every supported change type.
+type changeAttrs struct {
+	SchemaName     string       `yaml:"schemaName"`
+	TableName      string       `yaml:"tableName"`
+	Columns        []columnItem `yaml:"columns"`
+	ColumnName     string       `yaml:"columnName"`
+	ColumnNames    string       `yaml:"columnNames"`
+	ConstraintName string       `yaml:"constraintName"`
+	IndexName      string       `yaml:"indexName"`
+	Unique         bool         `yaml:"unique"`
+	Where          string       `yaml:"where"`
+	CascadeConstr  bool         `yaml:"cascadeConstraints"`
+	SQL            string       `yaml:"sql"`
+	DBMS           string       `yaml:"dbms"`
+}
+
+// Statements returns the PostgreSQL statements of the change, generated
+// for YAML change types the way Liquibase does. Changes limited to another
+// dbms return none.
+func (c Change) Statements() ([]string, error) {
+	if c.Node == nil {
+		return []string{c.SQL}, nil
+	}
+
+	var a changeAttrs
+	if err := c.Node.Decode(&a); err != nil {
+		return nil, fmt.Errorf("line %d: %s: %w", c.Line, c.Type, err)
+	}
+	table := qualified(a.SchemaName, a.TableName)
+
+	switch c.Type {
+	case "sql":
+		if a.DBMS != "" && !matchesDBMS(a.DBMS) {
+			return nil, nil
+		}
+		return []string{a.SQL}, nil
+
+	case "createTable":
+		var defs, after []string
+		var primaryKey []string
+		for _, item := range a.Columns {
+			col := item.Column
+			defs = append(defs, columnDefinition(col))
+			if k := col.Constraints; k != nil {
+				if k.PrimaryKey {
+					primaryKey = append(primaryKey, col.Name)
+				}
+				if fk := foreignKey(a.SchemaName, col); fk != "" {
+					after = append(after, fmt.Sprintf("ALTER TABLE %s ADD %s", table, fk))
+				}
+			}
+		}
+		if len(primaryKey) > 0 {
+			name := a.TableName + "_pkey"
+			for _, item := range a.Columns {
+				if k := item.Column.Constraints; k != nil && k.PrimaryKeyName != "" {
+					name = k.PrimaryKeyName
+				}
+			}
+			defs = append(defs, fmt.Sprintf("CONSTRAINT %s PRIMARY KEY (%s)", name, strings.Join(primaryKey, ", ")))
+		}
+		create := fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(defs, ", "))
+		return append([]string{create}, after...), nil
+
+	case "addColumn":
+		var statements, after []string
+		for _, item := range a.Columns {
+			col := item.Column
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD %s", table, columnDefinition(col)))
+			if k := col.Constraints; k != nil && k.PrimaryKey {
+				after = append(after, fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s)", table, col.Name))
+			}
+			if fk := foreignKey(a.SchemaName, col); fk != "" {
+				after = append(after, fmt.Sprintf("ALTER TABLE %s ADD %s", table, fk))
+			}
+		}
+		return append(statements, after...), nil
+
+	case "dropColumn":
+		names := []string{a.ColumnName}
+		if a.ColumnName == "" {
+			names = nil
+			for _, item := range a.Columns {
+				names = append(names, item.Column.Name)
+			}
+		}
+		var statements []string
+		for _, name := range names {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, name))
+		}
+		return statements, nil
+
+	case "dropTable":
+		statement := "DROP TABLE " + table
+		if a.CascadeConstr {
+			statement += " CASCADE"
+		}
+		return []string{statement}, nil
+
+	case "insert":
+		var names, values []string
+		for _, item := range a.Columns {
+			value, ok := columnValue(item.Column)
+			if !ok {
+				continue
+			}
+			names = append(names, item.Column.Name)
+			values = append(values, value)
+		}
+		return []string{fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(names, ", "), strings.Join(values, ", "))}, nil
+
+	case "update":
+		var sets []string
+		for _, item := range a.Columns {
+			value, ok := columnValue(item.Column)
+			if !ok {
+				value = "NULL"
+			}
+			sets = append(sets, item.Column.Name+" = "+value)
+		}
+		statement := fmt.Sprintf("UPDATE %s SET %s", table, strings.Join(sets, ", "))
+		if a.Where != "" {
+			statement += " WHERE " + a.Where
+		}
+		return []string{statement}, nil
+
+	case "delete":
+		statement := "DELETE FROM " + table
+		if a.Where != "" {
+			statement += " WHERE " + a.Where
+		}
+		return []string{statement}, nil
+
+	case "addUniqueConstraint":
+		return []string{fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s)", table, a.ConstraintName, a.ColumnNames)}, nil
+
+	case "dropUniqueConstraint":
+		return []string{fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", table, a.ConstraintName)}, nil
+
+	case "createIndex":
+		var names []string
+		for _, item := range a.Columns {
+			names = append(names, item.Column.Name)
+		}
+		unique := ""
+		if a.Unique {
+			unique = "UNIQUE "
+		}
+		return []string{fmt.Sprintf("CREATE %sINDEX %s ON %s(%s)", unique, a.IndexName, table, strings.Join(names, ", "))}, nil
+
+	case "dropIndex":
+		return []string{"DROP INDEX " + qualified(a.SchemaName, a.IndexName)}, nil
+
+	default:
+		return nil, fmt.Errorf("line %d: unsupported change type %s", c.Line, c.Type)
+	}
+}
+
+// matchesDBMS reports whether a dbms attribute such as "postgresql, h2" or
+// "!oracle" includes PostgreSQL.
+func matchesDBMS(dbms string) bool {
+	included := false
+	for _, d := range strings.Split(dbms, ",") {
+		d = strings.TrimSpace(d)
+		switch {
+		case d == "postgresql" || d == "all":
+			included = true
+		case d == "!postgresql":
+			return false
+		case strings.HasPrefix(d, "!"):
+			included = true
+		}
+	}
+	return included
+}
+
+func qualified(schema, name string) string {
+	if schema == "" {
+		return name
+	}
+	return schema + "." + name
+}
+
+func columnDefinition(col column) string {
+	def := col.Name + " " + col.Type
+	if value, ok := defaultValue(col); ok {
+		def += " DEFAULT " + value
+	}
+	if k := col.Constraints; k != nil {
+		if k.Nullable != nil && !*k.Nullable {
+			def += " NOT NULL"
+		}
+		if k.Unique {
+			if k.UniqueConstraintName != "" {
+				def += " CONSTRAINT " + k.UniqueConstraintName
+			}
+			def += " UNIQUE"
+		}
+	}
+	return def
+}
+
+func foreignKey(schema string, col column) string {
+	k := col.Constraints
+	if k == nil || (k.References == "" && k.ReferencedTableName == "") {
+		return ""
+	}
+	references := k.References
+	if references == "" {
+		references = fmt.Sprintf("%s(%s)", qualified(schema, k.ReferencedTableName), k.ReferencedColumnName)
+	}
+	fk := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s", col.Name, references)
+	if k.ForeignKeyName != "" {
+		fk = "CONSTRAINT " + k.ForeignKeyName + " " + fk
+	}
+	if k.DeleteCascade {
+		fk += " ON DELETE CASCADE"
+	}
+	return fk
+}
+
+func columnValue(col column) (string, bool) {
+	switch {
+	case col.Value != nil:
+		return quote(*col.Value), true
+	case col.ValueNumeric != nil:
+		return *col.ValueNumeric, true
+	case col.ValueBoolean != nil:
+		return boolLiteral(*col.ValueBoolean), true
+	case col.ValueComputed != nil:
+		return *col.ValueComputed, true
+	case col.ValueDate != nil:
+		return quote(*col.ValueDate), true
+	}
+	return "", false
+}
+
+func defaultValue(col column) (string, bool) {
+	switch {
+	case col.DefaultValue != nil:
+		if isNumericType(col.Type) {
+			return *col.DefaultValue, true
+		}
+		return quote(*col.DefaultValue), true
+	case col.DefaultValueNumeric != nil:
+		return *col.DefaultValueNumeric, true
+	case col.DefaultValueBoolean != nil:
+		return boolLiteral(*col.DefaultValueBoolean), true
+	case col.DefaultValueComputed != nil:
+		return *col.DefaultValueComputed, true
+	case col.DefaultValueDate != nil:
+		return quote(*col.DefaultValueDate), true
+	}
+	return "", false
+}
+
+func isNumericType(t string) bool {
+	t = strings.ToUpper(t)
+	for _, prefix := range []string{"INT", "SMALLINT", "BIGINT", "NUMERIC", "DECIMAL", "REAL", "DOUBLE", "FLOAT"} {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func boolLiteral(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// quote returns s as an SQL string literal.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,178 @@
+package liquibase
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	changeSetLine = regexp.MustCompile(`^--\s*changeset\s+([^:\s]+):(\S+)(.*)$`)
+	// directiveLine matches the other comments Liquibase reads, written
+	// "--name value" or "--name: value".
+	directiveLine = regexp.MustCompile(`^--\s*(comment|context|contextFilter|labels|runAlways|runOnChange|rollback|preconditions|precondition-[a-z-]+)\b:?\s?(.*)$`)
+	attribute     = regexp.MustCompile(`(\w+):("[^"]*"|\S+)`)
+)
+
+// loadSQL reads a formatted SQL changelog. Besides the attributes Liquibase
+// accepts on the --changeset line, --context and --runAlways lines are read
+// as in the files of ../liquibase-sql.
+func loadSQL(path string) ([]ChangeSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var changeSets []ChangeSet
+	var current *ChangeSet
+	var body, rollback []string
+	bodyLine := 0
+
+	finish := func() {
+		if current == nil {
+			return
+		}
+		sql := strings.TrimSpace(strings.Join(body, "\n"))
+		if sql != "" {
+			current.Changes = []Change{{Type: "sql", Line: bodyLine, SQL: sql}}
+		}
+		if len(rollback) > 0 {
+			current.Rollback = []Change{{Type: "sql", Line: current.Line, SQL: strings.Join(rollback, "\n")}}
+		}
+		changeSets = append(changeSets, *current)
+		current, body, rollback, bodyLine = nil, nil, nil, 0
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	formatted := false
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+
+		if !formatted {
+			if trimmed == "" {
+				continue
+			}
+			if !strings.EqualFold(strings.Join(strings.Fields(trimmed), " "), "--liquibase formatted sql") {
+				return nil, fmt.Errorf("%s:%d: must start with --liquibase formatted sql", path, line)
+			}
+			formatted = true
+			continue
+		}
+
+		if match := changeSetLine.FindStringSubmatch(trimmed); match != nil {
+			finish()
+			current = &ChangeSet{Author: match[1], ID: match[2], File: path, Line: line, Format: FormatSQL}
+			for _, attr := range attribute.FindAllStringSubmatch(match[3], -1) {
+				setSQLAttribute(current, attr[1], strings.Trim(attr[2], `"`))
+			}
+			continue
+		}
+		if current == nil {
+			if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				return nil, fmt.Errorf("%s:%d: statement outside a changeset", path, line)
+			}
+			continue
+		}
+
+		match := directiveLine.FindStringSubmatch(trimmed)
+		if match == nil {
+			if bodyLine == 0 && trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				bodyLine = line
+			}
+			body = append(body, text)
+			continue
+		}
+
+		name, value := match[1], strings.TrimSpace(match[2])
+		switch {
+		case name == "rollback":
+			rollback = append(rollback, value)
+		case name == "preconditions":
+			current.Preconditions = &Preconditions{Line: line}
+			for _, attr := range attribute.FindAllStringSubmatch(value, -1) {
+				switch attr[1] {
+				case "onFail":
+					current.Preconditions.OnFail = attr[2]
+				case "onError":
+					current.Preconditions.OnError = attr[2]
+				}
+			}
+		case strings.HasPrefix(name, "precondition-"):
+			if current.Preconditions == nil {
+				current.Preconditions = &Preconditions{Line: line}
+			}
+			current.Preconditions.Conditions = append(current.Preconditions.Conditions, sqlCondition(name, value, line))
+		default:
+			setSQLAttribute(current, name, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	finish()
+	return changeSets, nil
+}
+
+func setSQLAttribute(cs *ChangeSet, name, value string) {
+	switch name {
+	case "comment":
+		cs.Comment = value
+	case "context", "contextFilter":
+		cs.Context = value
+	case "runAlways":
+		cs.RunAlways = value == "true"
+	case "runOnChange":
+		cs.RunOnChange = value == "true"
+	}
+}
+
+// sqlCondition converts a --precondition-<name> line, such as
+// "--precondition-sql-check expectedResult:0 SELECT COUNT(*) FROM t", to the
+// condition of the YAML format.
+func sqlCondition(name, value string, line int) Condition {
+	c := Condition{Line: line, Attrs: make(map[string]string)}
+	rest := value
+	for {
+		loc := attribute.FindStringSubmatchIndex(rest)
+		if loc == nil || loc[0] != 0 {
+			break
+		}
+		key, val := rest[loc[2]:loc[3]], strings.Trim(rest[loc[4]:loc[5]], `"`)
+		switch key {
+		case "table":
+			key = "tableName"
+		case "schema":
+			key = "schemaName"
+		case "column":
+			key = "columnName"
+		case "view":
+			key = "viewName"
+		}
+		c.Attrs[key] = val
+		rest = strings.TrimSpace(rest[loc[1]:])
+	}
+
+	switch name {
+	case "precondition-sql-check":
+		c.Type = "sqlCheck"
+		c.Attrs["sql"] = rest
+	case "precondition-table-exists":
+		c.Type = "tableExists"
+	case "precondition-view-exists":
+		c.Type = "viewExists"
+	case "precondition-column-exists":
+		c.Type = "columnExists"
+	default:
+		c.Type = strings.TrimPrefix(name, "precondition-")
+	}
+	return c
+}
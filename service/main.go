@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"embed"
+	"expvar"
 	"log"
 	"net/http"
 	"os"
+	"runtime"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 
 	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
 	"github.com/elvismanchkin/migration_tools_poc_liquibase/handlers"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/pdf"
 )
 
 //go:embed templates/*
@@ -26,9 +32,13 @@ func main() {
 		log.Println("Warning: Error loading .env file, using defaults or environment variables")
 	}
 
-	db.WaitForDatabase()
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		os.Exit(runSync(os.Args[2:]))
+	}
 
-	db.SetupDatabase()
+	if err := db.Connect(context.Background(), getEnvDuration("DB_WAIT_TIMEOUT", time.Minute)); err != nil {
+		log.Fatalf("Error connecting to database: %v", err)
+	}
 	defer func(DB *sql.DB) {
 		err := DB.Close()
 		if err != nil {
@@ -36,19 +46,52 @@ func main() {
 		}
 	}(db.DB)
 
+	if skip, _ := strconv.ParseBool(os.Getenv("SKIP_MIGRATIONS")); skip {
+		log.Println("SKIP_MIGRATIONS is set, not migrating the database")
+	} else if err := db.Migrate(db.MigrationEnvironment()); err != nil {
+		log.Fatalf("Error migrating database: %v", err)
+	}
+	checkSchemaVersion(getEnv("SCHEMA_CHECK_MODE", schemaCheckFail), getEnvDuration("SCHEMA_WAIT_TIMEOUT", 5*time.Minute))
+
+	if dir := os.Getenv("TEMPLATE_SYNC_DIR"); dir != "" && !handlers.ReadOnly {
+		syncTemplates(dir, getEnv("TEMPLATE_SYNC_MODE", "apply"))
+		handlers.TemplateSyncDir = dir
+	}
+
+	renderer, err := pdf.SelectRenderer(getEnv("PDF_RENDERER", pdf.RendererAuto))
+	if err != nil {
+		log.Fatalf("Error configuring PDF renderer: %v", err)
+	}
+	log.Printf("Using %s PDF renderer", renderer.Name())
+
 	handlers.FS = templateFS
+	handlers.PDFRenderer = pdf.NewPool(renderer,
+		getEnvInt("PDF_MAX_CONCURRENT", runtime.NumCPU()),
+		getEnvInt("PDF_QUEUE_SIZE", 10),
+		getEnvDuration("PDF_RENDER_TIMEOUT", 30*time.Second))
+	if !handlers.ReadOnly {
+		go handlers.RunTrashRetention(context.Background(), getEnvDuration("TRASH_PURGE_INTERVAL", time.Hour))
+	}
+
 	router := mux.NewRouter()
 
 	router.PathPrefix("/static/").Handler(http.FileServer(http.FS(staticFS)))
+	router.Handle("/debug/vars", expvar.Handler()).Methods("GET")
 	apiRouter := router.PathPrefix("/api").Subrouter()
 
 	router.HandleFunc("/", handlers.HandleIndex)
 	router.HandleFunc("/templates", handlers.HandleListTemplates)
 	router.HandleFunc("/templates/new", handlers.HandleNewTemplateForm).Methods("GET")
-	router.HandleFunc("/templates", handlers.HandleCreateTemplate).Methods("POST")
+	router.HandleFunc("/templates", handlers.Writes(handlers.HandleCreateTemplate)).Methods("POST")
 	router.HandleFunc("/templates/{id}", handlers.HandleViewTemplate).Methods("GET")
 	router.HandleFunc("/templates/{id}/render", handlers.HandleRenderTemplate).Methods("POST")
 	router.HandleFunc("/templates/{id}/pdf", handlers.HandleGeneratePDF).Methods("POST")
+	router.HandleFunc("/templates/{id}/send-test", handlers.HandleSendTestEmail).Methods("POST")
+	router.HandleFunc("/templates/{id}/samples", handlers.Writes(handlers.HandleSaveSample)).Methods("POST")
+	router.HandleFunc("/categories", handlers.HandleListCategories).Methods("GET")
+	router.HandleFunc("/categories", handlers.Writes(handlers.HandleCreateCategory)).Methods("POST")
+	router.HandleFunc("/categories/{id}", handlers.Writes(handlers.HandleUpdateCategory)).Methods("POST")
+	router.HandleFunc("/categories/{id}/delete", handlers.Writes(handlers.HandleDeleteCategory)).Methods("POST")
 
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -60,14 +103,46 @@ func main() {
 	apiRouter.HandleFunc("/health", handlers.APIHealthCheck).Methods("GET")
 
 	apiRouter.HandleFunc("/templates", handlers.APIGetTemplates).Methods("GET")
-	apiRouter.HandleFunc("/templates", handlers.APICreateTemplate).Methods("POST")
+	apiRouter.HandleFunc("/templates", handlers.Writes(handlers.APICreateTemplate)).Methods("POST")
+	apiRouter.HandleFunc("/templates/search", handlers.APISearchTemplates).Methods("GET")
+	apiRouter.HandleFunc("/templates/trash", handlers.APIGetTrash).Methods("GET")
 	apiRouter.HandleFunc("/templates/{id}", handlers.APIGetTemplate).Methods("GET")
-	apiRouter.HandleFunc("/templates/{id}", handlers.APIUpdateTemplate).Methods("PUT")
-	apiRouter.HandleFunc("/templates/{id}", handlers.APIDeleteTemplate).Methods("DELETE")
+	apiRouter.HandleFunc("/templates/{id}", handlers.Writes(handlers.APIUpdateTemplate)).Methods("PUT")
+	apiRouter.HandleFunc("/templates/{id}", handlers.Writes(handlers.APIDeleteTemplate)).Methods("DELETE")
+	apiRouter.HandleFunc("/templates/{id}/restore", handlers.Writes(handlers.APIRestoreTemplate)).Methods("POST")
 	apiRouter.HandleFunc("/templates/{id}/render", handlers.APIRenderTemplate).Methods("POST")
+	apiRouter.HandleFunc("/templates/{id}/email", handlers.APIRenderEmail).Methods("POST")
+	apiRouter.HandleFunc("/templates/{id}/send-test", handlers.APISendTestEmail).Methods("POST")
 	apiRouter.HandleFunc("/templates/{id}/variables", handlers.APIGetTemplateVariables).Methods("GET")
-	apiRouter.HandleFunc("/templates/{id}/variables", handlers.APIAddTemplateVariable).Methods("POST")
+	apiRouter.HandleFunc("/templates/{id}/variables", handlers.Writes(handlers.APIAddTemplateVariable)).Methods("POST")
+	apiRouter.HandleFunc("/templates/{id}/variables", handlers.Writes(handlers.APIReplaceTemplateVariables)).Methods("PUT")
+	apiRouter.HandleFunc("/templates/{id}/variables/{variableId}", handlers.Writes(handlers.APIUpdateTemplateVariable)).Methods("PUT")
+	apiRouter.HandleFunc("/templates/{id}/variables/{variableId}", handlers.Writes(handlers.APIDeleteTemplateVariable)).Methods("DELETE")
+	apiRouter.HandleFunc("/templates/{id}/samples", handlers.APIGetTemplateSamples).Methods("GET")
+	apiRouter.HandleFunc("/templates/{id}/samples", handlers.Writes(handlers.APICreateTemplateSample)).Methods("POST")
+	apiRouter.HandleFunc("/templates/{id}/samples/{name}", handlers.APIGetTemplateSample).Methods("GET")
+	apiRouter.HandleFunc("/templates/{id}/samples/{name}", handlers.Writes(handlers.APISetTemplateSample)).Methods("PUT")
+	apiRouter.HandleFunc("/templates/{id}/samples/{name}", handlers.Writes(handlers.APIDeleteTemplateSample)).Methods("DELETE")
+	apiRouter.HandleFunc("/templates/{id}/samples/{name}/expected", handlers.Writes(handlers.APIDeleteSampleExpectedOutput)).Methods("DELETE")
+	apiRouter.HandleFunc("/templates/{id}/verify", handlers.APIVerifyTemplate).Methods("POST")
+	apiRouter.HandleFunc("/templates/{id}/config", handlers.APIGetTemplateConfig).Methods("GET")
+	apiRouter.HandleFunc("/templates/{id}/config/{key}", handlers.Writes(handlers.APISetTemplateConfig)).Methods("PUT")
+	apiRouter.HandleFunc("/templates/{id}/config/{key}", handlers.Writes(handlers.APIDeleteTemplateConfig)).Methods("DELETE")
 	apiRouter.HandleFunc("/categories", handlers.APIGetCategories).Methods("GET")
+	apiRouter.HandleFunc("/categories", handlers.Writes(handlers.APICreateCategory)).Methods("POST")
+	apiRouter.HandleFunc("/categories/{id}", handlers.APIGetCategory).Methods("GET")
+	apiRouter.HandleFunc("/categories/{id}", handlers.Writes(handlers.APIUpdateCategory)).Methods("PUT")
+	apiRouter.HandleFunc("/categories/{id}", handlers.Writes(handlers.APIDeleteCategory)).Methods("DELETE")
+	apiRouter.HandleFunc("/export", handlers.APIExport).Methods("GET")
+	apiRouter.HandleFunc("/export/migration", handlers.APIExportMigration).Methods("GET")
+	apiRouter.HandleFunc("/import", handlers.APIImport).Methods("POST")
+	apiRouter.HandleFunc("/sync/plan", handlers.APIGetSyncPlan).Methods("GET")
+	apiRouter.HandleFunc("/admin/schema", handlers.APIGetSchemaStatus).Methods("GET")
+	apiRouter.HandleFunc("/config", handlers.APIGetConfiguration).Methods("GET")
+	apiRouter.HandleFunc("/config/{key}", handlers.Writes(handlers.APISetConfiguration)).Methods("PUT")
+	apiRouter.HandleFunc("/email/captured", handlers.APIGetCapturedEmails).Methods("GET")
+	apiRouter.HandleFunc("/email/captured", handlers.APIClearCapturedEmails).Methods("DELETE")
+	apiRouter.HandleFunc("/email/captured/{id}", handlers.APIGetCapturedEmail).Methods("GET")
 
 	port := getEnv("SERVER_PORT", "8080")
 	log.Printf("Starting template service on port %s...", port)
@@ -81,3 +156,31 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvInt returns the integer in the environment variable key, or
+// defaultValue when it is unset. A malformed value stops the service, as the
+// database settings in db.LoadConfig do.
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Fatalf("%s: %q is not a non-negative integer", key, value)
+	}
+	return n
+}
+
+// getEnvDuration is getEnvInt for durations such as 30s or 5m.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Fatalf("%s: %q is not a duration such as 30s or 5m", key, value)
+	}
+	return d
+}
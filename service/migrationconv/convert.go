@@ -0,0 +1,724 @@
+// Package migrationconv converts migrations between Flyway scripts and
+// Liquibase changelogs, so a schema change written for one tool can be
+// carried over to the other two migration sets of this repository instead
+// of being rewritten by hand.
+//
+// Flyway versioned scripts become changesets with the version as id and
+// repeatable scripts changesets running on change. A script guarded by
+// IF '${environment}' = '<name>' becomes a changeset with that context,
+// and Liquibase contexts and preconditions become such guards in Flyway.
+package migrationconv
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/liquibase"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/migrationgen"
+	"gopkg.in/yaml.v3"
+)
+
+// guardTag quotes the DO blocks guarding converted Flyway scripts.
+const guardTag = "$migration_guard$"
+
+// defaultSchemaPath is the search path every Flyway script of this
+// repository sets, and Liquibase sets through defaultSchemaName.
+const defaultSchemaPath = "SET search_path TO template_service, public;"
+
+var (
+	flywayVersioned  = regexp.MustCompile(`^V(\d+(?:[._]\d+)*)__(.+)\.sql$`)
+	flywayRepeatable = regexp.MustCompile(`^R__(.+)\.sql$`)
+	liquibaseFile    = regexp.MustCompile(`^v(\d+(?:[._]\d+)*)_(.+)\.(?:sql|ya?ml|xml)$`)
+	systemInfoInsert = regexp.MustCompile(`(?is)^INSERT\s+INTO\s+template_service\.system_info\s*\(version,\s*description\)\s*VALUES\s*\('([^']+)'`)
+	doBlock          = regexp.MustCompile(`(?is)^DO\s*(\$[A-Za-z_]*\$)(.*)$`)
+	environmentGuard = regexp.MustCompile(`(?is)^\s*BEGIN\s+IF\s+'\$\{environment\}'\s*=\s*'([^']*)'\s+THEN\b(.*)\bEND\s+IF\s*;\s*END\s*;?\s*$`)
+	dollarQuote      = regexp.MustCompile(`(^|[^\w$])\$([A-Za-z_]\w*)?\$`)
+)
+
+// File is a converted migration.
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Options configure Convert.
+type Options struct {
+	// To is the output format: migrationgen.ToolFlyway,
+	// migrationgen.ToolLiquibaseSQL or migrationgen.ToolLiquibaseYAML.
+	To string
+	// Author of the Liquibase changesets.
+	Author string
+}
+
+// changeSet is a Liquibase changeset, or the content of a Flyway script.
+type changeSet struct {
+	ID            string
+	Author        string
+	Comment       string
+	Context       string
+	RunAlways     bool
+	RunOnChange   bool
+	Preconditions *liquibase.Preconditions
+	Statements    []string
+	Rollback      []string
+}
+
+// migration is a Flyway script or a Liquibase changelog file.
+type migration struct {
+	// Version is empty for repeatable migrations.
+	Version string
+	// Description is written as in Flyway script names, words separated
+	// by underscores.
+	Description string
+	ChangeSets  []changeSet
+}
+
+// Convert converts the migrations at path to opts.To. Path is a Flyway
+// script or script directory, or a Liquibase changelog, in which case the
+// changelogs it includes are converted too. The files are returned in
+// execution order.
+func Convert(path string, opts Options) ([]File, error) {
+	if opts.Author == "" {
+		opts.Author = "template_service"
+	}
+
+	fromFlyway, err := isFlyway(path)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	if fromFlyway {
+		migrations, err = readFlyway(path)
+	} else {
+		migrations, err = readLiquibase(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for _, m := range migrations {
+		var f File
+		switch {
+		case opts.To == migrationgen.ToolFlyway && !fromFlyway:
+			f, err = writeFlyway(m)
+		case opts.To == migrationgen.ToolLiquibaseSQL && fromFlyway:
+			f = writeLiquibaseSQL(m, opts)
+		case opts.To == migrationgen.ToolLiquibaseYAML && fromFlyway:
+			f, err = writeLiquibaseYAML(m, opts)
+		case opts.To == migrationgen.ToolFlyway, opts.To == migrationgen.ToolLiquibaseSQL, opts.To == migrationgen.ToolLiquibaseYAML:
+			return nil, fmt.Errorf("%s is already a %s migration", path, strings.SplitN(opts.To, "-", 2)[0])
+		default:
+			return nil, fmt.Errorf("unknown tool %q, expected %s, %s or %s", opts.To, migrationgen.ToolLiquibaseYAML, migrationgen.ToolLiquibaseSQL, migrationgen.ToolFlyway)
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// isFlyway reports whether path is a Flyway script or directory rather
+// than a Liquibase changelog.
+func isFlyway(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return true, nil
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".sql") {
+		return false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	first := strings.TrimSpace(string(data))
+	if i := strings.IndexByte(first, '\n'); i >= 0 {
+		first = first[:i]
+	}
+	return !strings.EqualFold(strings.Join(strings.Fields(first), " "), "--liquibase formatted sql"), nil
+}
+
+// readFlyway reads a Flyway script, or the scripts of a directory in the
+// order Flyway runs them: versioned scripts by version, then repeatable
+// scripts by name.
+func readFlyway(path string) ([]migration, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		m, err := readFlywayScript(path)
+		if err != nil {
+			return nil, err
+		}
+		return []migration{m}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	for _, e := range entries {
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".sql") {
+			continue
+		}
+		m, err := readFlywayScript(filepath.Join(path, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, m)
+	}
+
+	var sortErr error
+	sort.SliceStable(migrations, func(i, j int) bool {
+		a, b := migrations[i], migrations[j]
+		if a.Version == "" || b.Version == "" {
+			if a.Version == b.Version {
+				return a.Description < b.Description
+			}
+			return b.Version == ""
+		}
+		c, err := db.CompareSchemaVersions(a.Version, b.Version)
+		if err != nil {
+			sortErr = err
+		}
+		return c < 0
+	})
+	return migrations, sortErr
+}
+
+func readFlywayScript(path string) (migration, error) {
+	var m migration
+	name := filepath.Base(path)
+	if match := flywayVersioned.FindStringSubmatch(name); match != nil {
+		m.Version = strings.ReplaceAll(match[1], "_", ".")
+		m.Description = match[2]
+	} else if match := flywayRepeatable.FindStringSubmatch(name); match != nil {
+		m.Description = match[1]
+	} else {
+		return m, fmt.Errorf("%s: not a Flyway script name, expected V<version>__<description>.sql or R__<description>.sql", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	statements, context, err := unguard(SplitStatements(string(data)))
+	if err != nil {
+		return m, fmt.Errorf("%s: %w", path, err)
+	}
+	// Liquibase sets the search path through defaultSchemaName. Comments
+	// above the statement are kept.
+	for i, s := range statements {
+		if isComment(s) {
+			continue
+		}
+		comments, code := leadingComments(s)
+		if strings.EqualFold(strings.Join(strings.Fields(code), " "), defaultSchemaPath) {
+			if comments = strings.TrimSpace(comments); comments != "" {
+				statements[i] = comments
+			} else {
+				statements = append(statements[:i:i], statements[i+1:]...)
+			}
+		}
+		break
+	}
+
+	cs := changeSet{
+		ID:          m.Version,
+		Comment:     strings.ReplaceAll(m.Description, "_", " "),
+		Context:     context,
+		RunOnChange: m.Version == "",
+		Statements:  statements,
+	}
+	if cs.ID == "" {
+		cs.ID = strings.ToLower(m.Description)
+	}
+	m.ChangeSets = []changeSet{cs}
+	return m, nil
+}
+
+// unguard returns the statements of a script without the
+// IF '${environment}' = '<name>' guard of its DO blocks, and the guarded
+// environment as context. Only scripts whose statements are all guarded by
+// the same environment can be converted; other uses of ${environment}
+// have no Liquibase counterpart.
+func unguard(statements []string) ([]string, string, error) {
+	var context string
+	guarded, unguarded := 0, 0
+	result := make([]string, len(statements))
+	for i, s := range statements {
+		result[i] = s
+		if isComment(s) {
+			continue
+		}
+
+		comments, code := leadingComments(s)
+		match := doBlock.FindStringSubmatch(code)
+		if match == nil || !strings.HasSuffix(strings.TrimSuffix(match[2], ";"), match[1]) {
+			unguarded++
+			continue
+		}
+		tag := match[1]
+		body := strings.TrimSuffix(strings.TrimSuffix(match[2], ";"), tag)
+		guard := environmentGuard.FindStringSubmatch(body)
+		if guard == nil {
+			unguarded++
+			continue
+		}
+		if guarded > 0 && guard[1] != context {
+			return nil, "", fmt.Errorf("guarded by environments %s and %s, a changeset has one context", context, guard[1])
+		}
+		guarded++
+		context = guard[1]
+		result[i] = comments + "DO\n" + tag + "\n    BEGIN\n        " + strings.TrimSpace(guard[2]) + "\n    END;\n" + tag + ";"
+	}
+
+	if guarded > 0 && unguarded > 0 {
+		return nil, "", fmt.Errorf("only some statements are guarded by ${environment}, split the script")
+	}
+	for _, s := range result {
+		if strings.Contains(s, "${environment}") {
+			return nil, "", fmt.Errorf("${environment} is only converted as the guard IF '${environment}' = '<name>' of a DO block")
+		}
+	}
+	return result, context, nil
+}
+
+// leadingComments splits the comment lines above a statement from its code.
+func leadingComments(statement string) (string, string) {
+	lines := splitLines(statement)
+	for i, line := range lines {
+		if t := strings.TrimSpace(line); t != "" && !strings.HasPrefix(t, "--") {
+			comments := strings.Join(lines[:i], "\n")
+			if comments != "" {
+				comments += "\n"
+			}
+			return comments, strings.Join(lines[i:], "\n")
+		}
+	}
+	return statement, ""
+}
+
+// readLiquibase reads a changelog and the changelogs it includes. Each
+// file holding changesets becomes one migration.
+func readLiquibase(path string) ([]migration, error) {
+	changeSets, err := liquibase.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	file := ""
+	for _, cs := range changeSets {
+		if cs.File != file {
+			file = cs.File
+			next := migration{Description: fileDescription(cs.File)}
+			if match := liquibaseFile.FindStringSubmatch(filepath.Base(cs.File)); match != nil {
+				next.Version = strings.ReplaceAll(match[1], "_", ".")
+			}
+			migrations = append(migrations, next)
+		}
+		m := &migrations[len(migrations)-1]
+
+		c := changeSet{
+			ID:            cs.ID,
+			Author:        cs.Author,
+			Comment:       cs.Comment,
+			Context:       cs.Context,
+			RunAlways:     cs.RunAlways,
+			RunOnChange:   cs.RunOnChange,
+			Preconditions: cs.Preconditions,
+		}
+		if c.Statements, err = changeStatements(cs.Changes); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", cs.File, cs.Line, err)
+		}
+		if c.Rollback, err = changeStatements(cs.Rollback); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", cs.File, cs.Line, err)
+		}
+		m.ChangeSets = append(m.ChangeSets, c)
+	}
+
+	for i, m := range migrations {
+		repeatable := 0
+		for _, cs := range m.ChangeSets {
+			if cs.RunAlways || cs.RunOnChange {
+				repeatable++
+			}
+		}
+		switch {
+		case repeatable == len(m.ChangeSets):
+			migrations[i].Version = ""
+		case repeatable > 0:
+			return nil, fmt.Errorf("%s mixes changesets running once and repeatedly, which cannot share a Flyway script", m.Description)
+		case m.Version == "":
+			return nil, fmt.Errorf("%s: no Flyway version in the file name, expected v<version>_<description>", m.Description)
+		}
+	}
+	return migrations, nil
+}
+
+// fileDescription turns a changelog name such as v10_add_template_trash.yaml
+// into a Flyway description such as Add_Template_Trash.
+func fileDescription(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if match := liquibaseFile.FindStringSubmatch(filepath.Base(path)); match != nil {
+		name = match[2]
+	}
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == ' ' })
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, "_")
+}
+
+// changeStatements returns the SQL statements of changes, each ending with
+// a semicolon.
+func changeStatements(changes []liquibase.Change) ([]string, error) {
+	var statements []string
+	for _, c := range changes {
+		generated, err := c.Statements()
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range generated {
+			for _, s := range SplitStatements(g) {
+				if !isComment(s) && !strings.HasSuffix(s, ";") {
+					s += ";"
+				}
+				statements = append(statements, s)
+			}
+		}
+	}
+	return statements, nil
+}
+
+func liquibaseName(m migration, ext string) string {
+	if m.Version == "" {
+		return strings.ToLower(m.Description) + ext
+	}
+	return "v" + m.Version + "_" + strings.ToLower(m.Description) + ext
+}
+
+func author(cs changeSet, opts Options) string {
+	if cs.Author != "" {
+		return cs.Author
+	}
+	return opts.Author
+}
+
+// writeLiquibaseSQL writes a formatted SQL changelog. As in
+// liquibase-sql/sql, the system_info insert ending a versioned script gets
+// its own changeset, <id>.1, which can be rolled back.
+func writeLiquibaseSQL(m migration, opts Options) File {
+	changeSets := m.ChangeSets
+	if last := len(changeSets) - 1; m.Version != "" && last >= 0 {
+		cs := changeSets[last]
+		if n := len(cs.Statements); n > 1 {
+			if match := systemInfoInsert.FindStringSubmatch(cs.Statements[n-1]); match != nil {
+				info := changeSet{
+					ID:         cs.ID + ".1",
+					Author:     cs.Author,
+					Comment:    "Update System Info",
+					Context:    cs.Context,
+					Statements: cs.Statements[n-1:],
+					Rollback:   []string{fmt.Sprintf("DELETE FROM template_service.system_info WHERE version = '%s';", match[1])},
+				}
+				cs.Statements = cs.Statements[:n-1]
+				changeSets = append(changeSets[:last:last], cs, info)
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("--liquibase formatted sql\n")
+	for _, cs := range changeSets {
+		fmt.Fprintf(&b, "\n--changeset %s:%s", author(cs, opts), cs.ID)
+		// Liquibase splits formatted SQL on semicolons; dollar-quoted
+		// bodies are sent whole, and the driver runs the statements.
+		for _, s := range cs.Statements {
+			if dollarQuote.MatchString(s) {
+				b.WriteString(" splitStatements:false")
+				break
+			}
+		}
+		fmt.Fprintf(&b, "\n--comment %s\n", cs.Comment)
+		if cs.Context != "" {
+			fmt.Fprintf(&b, "--context %s\n", cs.Context)
+		}
+		if cs.RunOnChange {
+			b.WriteString("--runOnChange true\n")
+		}
+		for _, s := range cs.Statements {
+			b.WriteString(s + "\n")
+		}
+		if len(cs.Rollback) > 0 {
+			b.WriteString("\n")
+			for _, s := range cs.Rollback {
+				for _, line := range splitLines(s) {
+					b.WriteString("--rollback " + line + "\n")
+				}
+			}
+		}
+	}
+	return File{Name: liquibaseName(m, ".sql"), Data: []byte(b.String())}
+}
+
+type yamlChangeLog struct {
+	DatabaseChangeLog []yamlEntry `yaml:"databaseChangeLog"`
+}
+
+type yamlEntry struct {
+	ChangeSet yamlChangeSet `yaml:"changeSet"`
+}
+
+type yamlChangeSet struct {
+	ID          string       `yaml:"id"`
+	Author      string       `yaml:"author"`
+	Comment     string       `yaml:"comment"`
+	Context     string       `yaml:"context,omitempty"`
+	RunOnChange bool         `yaml:"runOnChange,omitempty"`
+	Changes     []yamlChange `yaml:"changes"`
+}
+
+type yamlChange struct {
+	SQL yamlSQL `yaml:"sql"`
+}
+
+type yamlSQL struct {
+	DBMS            string `yaml:"dbms"`
+	SplitStatements bool   `yaml:"splitStatements"`
+	SQL             string `yaml:"sql"`
+}
+
+// writeLiquibaseYAML writes a YAML changelog with one sql change per
+// statement, which Liquibase runs without splitting it again.
+func writeLiquibaseYAML(m migration, opts Options) (File, error) {
+	var changeLog yamlChangeLog
+	for _, cs := range m.ChangeSets {
+		entry := yamlChangeSet{
+			ID:          cs.ID,
+			Author:      author(cs, opts),
+			Comment:     cs.Comment,
+			Context:     cs.Context,
+			RunOnChange: cs.RunOnChange,
+		}
+		for _, s := range cs.Statements {
+			if !isComment(s) {
+				entry.Changes = append(entry.Changes, yamlChange{SQL: yamlSQL{DBMS: "postgresql", SQL: s}})
+			}
+		}
+		changeLog.DatabaseChangeLog = append(changeLog.DatabaseChangeLog, yamlEntry{ChangeSet: entry})
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(changeLog); err != nil {
+		return File{}, err
+	}
+	if err := encoder.Close(); err != nil {
+		return File{}, err
+	}
+	return File{Name: liquibaseName(m, ".yaml"), Data: buf.Bytes()}, nil
+}
+
+// writeFlyway writes a Flyway script. Changesets with a context or
+// preconditions run in a DO block checking them; rollbacks, which Flyway
+// does not have, are added as comments.
+func writeFlyway(m migration) (File, error) {
+	var b strings.Builder
+	setsPath := false
+	for _, cs := range m.ChangeSets {
+		for _, s := range cs.Statements {
+			_, code := leadingComments(s)
+			setsPath = setsPath || strings.HasPrefix(strings.ToUpper(code), "SET SEARCH_PATH")
+		}
+	}
+	if m.Version != "" && !setsPath {
+		b.WriteString(defaultSchemaPath + "\n")
+	}
+
+	for _, cs := range m.ChangeSets {
+		fmt.Fprintf(&b, "-- %s:%s: %s\n", cs.Author, cs.ID, cs.Comment)
+		if cs.RunAlways {
+			b.WriteString("-- runAlways: Flyway reruns repeatable scripts only when they change\n")
+		}
+		guards, err := guards(cs)
+		if err != nil {
+			return File{}, fmt.Errorf("%s:%s: %w", cs.Author, cs.ID, err)
+		}
+		if len(guards) == 0 {
+			for _, s := range cs.Statements {
+				b.WriteString(s + "\n")
+			}
+			continue
+		}
+
+		b.WriteString("DO\n" + guardTag + "\n    BEGIN\n")
+		indent := "        "
+		for _, g := range guards {
+			fmt.Fprintf(&b, "%sIF %s THEN\n", indent, g.Condition)
+			indent += "    "
+		}
+		for _, s := range cs.Statements {
+			if strings.Contains(s, guardTag) {
+				return File{}, fmt.Errorf("%s:%s: statement contains %s", cs.Author, cs.ID, guardTag)
+			}
+			// Only the first line is indented; the others may be inside strings.
+			b.WriteString(indent + s + "\n")
+		}
+		for i := len(guards) - 1; i >= 0; i-- {
+			indent = indent[4:]
+			if guards[i].Failure != "" {
+				fmt.Fprintf(&b, "%sELSE\n%s    RAISE EXCEPTION %s;\n", indent, indent, quote(guards[i].Failure))
+			}
+			b.WriteString(indent + "END IF;\n")
+		}
+		b.WriteString("    END;\n" + guardTag + ";\n")
+	}
+
+	var rollback []string
+	for i := len(m.ChangeSets) - 1; i >= 0; i-- {
+		rollback = append(rollback, m.ChangeSets[i].Rollback...)
+	}
+	if len(rollback) > 0 {
+		b.WriteString("\n-- Rollback:\n")
+		for _, s := range rollback {
+			for _, line := range splitLines(s) {
+				b.WriteString("-- " + line + "\n")
+			}
+		}
+	}
+
+	name := fmt.Sprintf("V%s__%s.sql", m.Version, m.Description)
+	if m.Version == "" {
+		name = fmt.Sprintf("R__%s.sql", m.Description)
+	}
+	return File{Name: name, Data: []byte(b.String())}, nil
+}
+
+// guard is a condition a changeset runs under. A failing guard skips the
+// changeset, or raises Failure when set.
+type guard struct {
+	Condition string
+	Failure   string
+}
+
+func guards(cs changeSet) ([]guard, error) {
+	var guards []guard
+	if cs.Context != "" {
+		condition, err := contextCondition(cs.Context)
+		if err != nil {
+			return nil, err
+		}
+		guards = append(guards, guard{Condition: condition})
+	}
+
+	p := cs.Preconditions
+	if p == nil || len(p.Conditions) == 0 || strings.EqualFold(p.OnFail, "WARN") {
+		return guards, nil
+	}
+	var conditions []string
+	for _, c := range p.Conditions {
+		condition, err := preconditionSQL(c)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	g := guard{Condition: strings.Join(conditions, " AND ")}
+	switch strings.ToUpper(p.OnFail) {
+	case "MARK_RAN", "CONTINUE":
+	default:
+		g.Failure = fmt.Sprintf("Preconditions of changeset %s:%s failed", cs.Author, cs.ID)
+	}
+	return append(guards, g), nil
+}
+
+// contextCondition converts a context expression listing contexts, such as
+// "dev" or "dev, test", or excluded contexts, such as "!prod", to a check
+// of the Flyway ${environment} placeholder.
+func contextCondition(expr string) (string, error) {
+	var included, excluded []string
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		if strings.ContainsAny(term, " ()") || term == "" || term == "!" {
+			return "", fmt.Errorf("context %q cannot be converted, only lists of contexts or of excluded contexts", expr)
+		}
+		if strings.HasPrefix(term, "!") {
+			excluded = append(excluded, quote(term[1:]))
+		} else {
+			included = append(included, quote(term))
+		}
+	}
+	switch {
+	case len(included) > 0 && len(excluded) > 0:
+		return "", fmt.Errorf("context %q mixes included and excluded contexts", expr)
+	case len(included) == 1:
+		return "'${environment}' = " + included[0], nil
+	case len(included) > 1:
+		return "'${environment}' IN (" + strings.Join(included, ", ") + ")", nil
+	default:
+		return "'${environment}' NOT IN (" + strings.Join(excluded, ", ") + ")", nil
+	}
+}
+
+// preconditionSQL converts a Liquibase precondition to an SQL condition.
+func preconditionSQL(c liquibase.Condition) (string, error) {
+	schema := c.Attrs["schemaName"]
+	if schema == "" {
+		schema = "template_service"
+	}
+
+	switch c.Type {
+	case "and", "or", "not":
+		var nested []string
+		for _, n := range c.Nested {
+			condition, err := preconditionSQL(n)
+			if err != nil {
+				return "", err
+			}
+			nested = append(nested, condition)
+		}
+		switch c.Type {
+		case "and":
+			return "(" + strings.Join(nested, " AND ") + ")", nil
+		case "or":
+			return "(" + strings.Join(nested, " OR ") + ")", nil
+		default:
+			// Liquibase's not fails when any nested precondition passes.
+			return "NOT (" + strings.Join(nested, " OR ") + ")", nil
+		}
+	case "sqlCheck":
+		query := strings.TrimSuffix(strings.TrimSpace(c.Attrs["sql"]), ";")
+		return fmt.Sprintf("(%s)::text = %s", query, quote(c.Attrs["expectedResult"])), nil
+	case "tableExists":
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = %s AND table_name = %s)",
+			quote(schema), quote(c.Attrs["tableName"])), nil
+	case "viewExists":
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM information_schema.views WHERE table_schema = %s AND table_name = %s)",
+			quote(schema), quote(c.Attrs["viewName"])), nil
+	case "columnExists":
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = %s AND table_name = %s AND column_name = %s)",
+			quote(schema), quote(c.Attrs["tableName"]), quote(c.Attrs["columnName"])), nil
+	default:
+		return "", fmt.Errorf("line %d: precondition %s cannot be converted", c.Line, c.Type)
+	}
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,222 @@
+package migrationconv
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/migrationgen"
+)
+
+// convert writes script to a file called name and converts it.
+func convert(t *testing.T, name, script, to string) File {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := Convert(path, Options{To: to})
+	if err != nil {
+		t.Fatalf("converting %s: %v", name, err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("converting %s: got %d files, want 1", name, len(files))
+	}
+	return files[0]
+}
+
+const systemInfoScript = `SET search_path TO template_service, public;
+CREATE TABLE demo (id INT);
+
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.4', 'Demo');
+`
+
+func TestConvertFlyway(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		script string
+		to     string
+		want   File
+	}{
+		{
+			name:   "system_info insert in its own changeset",
+			file:   "V5__Demo.sql",
+			script: systemInfoScript,
+			to:     migrationgen.ToolLiquibaseSQL,
+			want: File{Name: "v5_demo.sql", Data: []byte(`--liquibase formatted sql
+
+--changeset template_service:5
+--comment Demo
+CREATE TABLE demo (id INT);
+
+--changeset template_service:5.1
+--comment Update System Info
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.4', 'Demo');
+
+--rollback DELETE FROM template_service.system_info WHERE version = '1.0.4';
+`)},
+		},
+		{
+			name: "only a system_info insert",
+			file: "V5_1__Backfill.sql",
+			script: `INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.5', 'Backfill');
+`,
+			to: migrationgen.ToolLiquibaseSQL,
+			want: File{Name: "v5.1_backfill.sql", Data: []byte(`--liquibase formatted sql
+
+--changeset template_service:5.1
+--comment Backfill
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.5', 'Backfill');
+`)},
+		},
+		{
+			name: "repeatable script",
+			file: "R__Refresh.sql",
+			script: `SET search_path TO template_service, public;
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.6', 'Refresh');
+`,
+			to: migrationgen.ToolLiquibaseSQL,
+			want: File{Name: "refresh.sql", Data: []byte(`--liquibase formatted sql
+
+--changeset template_service:refresh
+--comment Refresh
+--runOnChange true
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.6', 'Refresh');
+`)},
+		},
+		{
+			name:   "search path written differently",
+			file:   "V6__Spaced.sql",
+			script: "-- Sets up the demo.\nset  search_path  to template_service,   public;\nCREATE TABLE spaced (id INT);\n",
+			to:     migrationgen.ToolLiquibaseSQL,
+			want: File{Name: "v6_spaced.sql", Data: []byte(`--liquibase formatted sql
+
+--changeset template_service:6
+--comment Spaced
+-- Sets up the demo.
+CREATE TABLE spaced (id INT);
+`)},
+		},
+		{
+			name:   "search path after other statements",
+			file:   "V7__Late_Path.sql",
+			script: "CREATE SCHEMA IF NOT EXISTS demo;\nSET search_path TO template_service, public;\n",
+			to:     migrationgen.ToolLiquibaseSQL,
+			want: File{Name: "v7_late_path.sql", Data: []byte(`--liquibase formatted sql
+
+--changeset template_service:7
+--comment Late Path
+CREATE SCHEMA IF NOT EXISTS demo;
+SET search_path TO template_service, public;
+`)},
+		},
+		{
+			name:   "other search path",
+			file:   "V8__Audit_Path.sql",
+			script: "SET search_path TO audit;\nCREATE TABLE entries (id INT);\n",
+			to:     migrationgen.ToolLiquibaseSQL,
+			want: File{Name: "v8_audit_path.sql", Data: []byte(`--liquibase formatted sql
+
+--changeset template_service:8
+--comment Audit Path
+SET search_path TO audit;
+CREATE TABLE entries (id INT);
+`)},
+		},
+		{
+			name:   "YAML keeps the system_info insert in the changeset",
+			file:   "V5__Demo.sql",
+			script: systemInfoScript,
+			to:     migrationgen.ToolLiquibaseYAML,
+			want: File{Name: "v5_demo.yaml", Data: []byte(`databaseChangeLog:
+  - changeSet:
+      id: "5"
+      author: template_service
+      comment: Demo
+      changes:
+        - sql:
+            dbms: postgresql
+            splitStatements: false
+            sql: CREATE TABLE demo (id INT);
+        - sql:
+            dbms: postgresql
+            splitStatements: false
+            sql: |-
+              INSERT INTO template_service.system_info (version, description)
+              VALUES ('1.0.4', 'Demo');
+`)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convert(t, tt.file, tt.script, tt.to)
+			if got.Name != tt.want.Name || string(got.Data) != string(tt.want.Data) {
+				t.Errorf("got %s:\n%s\nwant %s:\n%s", got.Name, got.Data, tt.want.Name, tt.want.Data)
+			}
+		})
+	}
+}
+
+func TestConvertLiquibaseSQLToFlyway(t *testing.T) {
+	changeLog := `--liquibase formatted sql
+
+--changeset template_service:5
+--comment Demo
+CREATE TABLE demo (id INT);
+
+--changeset template_service:5.1
+--comment Update System Info
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.4', 'Demo');
+
+--rollback DELETE FROM template_service.system_info WHERE version = '1.0.4';
+`
+	got := convert(t, "v5_demo.sql", changeLog, migrationgen.ToolFlyway)
+	want := `SET search_path TO template_service, public;
+-- template_service:5: Demo
+CREATE TABLE demo (id INT);
+-- template_service:5.1: Update System Info
+INSERT INTO template_service.system_info (version, description)
+VALUES ('1.0.4', 'Demo');
+
+-- Rollback:
+-- DELETE FROM template_service.system_info WHERE version = '1.0.4';
+`
+	if got.Name != "V5__Demo.sql" || string(got.Data) != want {
+		t.Errorf("got %s:\n%s\nwant V5__Demo.sql:\n%s", got.Name, got.Data, want)
+	}
+}
+
+// TestConvertRoundTrip converts a Flyway script to formatted SQL and back,
+// which must give the statements of the script again.
+func TestConvertRoundTrip(t *testing.T) {
+	changeLog := convert(t, "V5__Demo.sql", systemInfoScript, migrationgen.ToolLiquibaseSQL)
+	script := convert(t, changeLog.Name, string(changeLog.Data), migrationgen.ToolFlyway)
+
+	if script.Name != "V5__Demo.sql" {
+		t.Errorf("got %s, want V5__Demo.sql", script.Name)
+	}
+	if got, want := statements(string(script.Data)), statements(systemInfoScript); !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements\n%q\nwant\n%q", got, want)
+	}
+}
+
+// statements returns the statements of a script without their comments.
+func statements(script string) []string {
+	var list []string
+	for _, s := range SplitStatements(script) {
+		if _, code := leadingComments(s); code != "" {
+			list = append(list, code)
+		}
+	}
+	return list
+}
@@ -0,0 +1,123 @@
+package migrationconv
+
+import (
+	"regexp"
+	"strings"
+)
+
+var dollarTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// SplitStatements splits a PostgreSQL script into its statements, each
+// ending with its semicolon and keeping the comments above it. Semicolons
+// in comments, quoted strings and identifiers, and dollar-quoted bodies
+// such as those of PL/pgSQL functions and DO blocks do not end a statement.
+// A last statement without a semicolon, or trailing comments, are returned
+// as they are.
+func SplitStatements(script string) []string {
+	var statements []string
+	start := 0
+	for i := 0; i < len(script); {
+		c := script[i]
+		switch {
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 1
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			i = blockCommentEnd(script, i)
+		case c == '\'':
+			escapes := i > 0 && (script[i-1] == 'E' || script[i-1] == 'e') && (i < 2 || !isIdentifier(script[i-2]))
+			i = quotedEnd(script, i, '\'', escapes)
+		case c == '"':
+			i = quotedEnd(script, i, '"', false)
+		case c == '$' && (i == 0 || !isIdentifier(script[i-1])):
+			tag := dollarTag.FindString(script[i:])
+			if tag == "" {
+				i++
+				continue
+			}
+			end := strings.Index(script[i+len(tag):], tag)
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += len(tag) + end + len(tag)
+			}
+		case c == ';':
+			i++
+			if s := strings.TrimSpace(script[start:i]); s != ";" {
+				statements = append(statements, s)
+			}
+			start = i
+		default:
+			i++
+		}
+	}
+	if s := strings.TrimSpace(script[start:]); s != "" {
+		statements = append(statements, s)
+	}
+	return statements
+}
+
+// blockCommentEnd returns the offset after the comment starting at i.
+// PostgreSQL block comments nest.
+func blockCommentEnd(s string, i int) int {
+	depth := 0
+	for i < len(s) {
+		switch {
+		case strings.HasPrefix(s[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(s[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// quotedEnd returns the offset after the string or identifier quoted with
+// quote starting at i. Doubled quotes are part of the value, as are quotes
+// after a backslash in escape strings.
+func quotedEnd(s string, i int, quote byte, escapes bool) int {
+	for i++; i < len(s); i++ {
+		switch {
+		case escapes && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return i
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// isComment reports whether a statement returned by SplitStatements holds
+// only comments.
+func isComment(statement string) bool {
+	for _, s := range splitLines(statement) {
+		if s = strings.TrimSpace(s); s != "" && !strings.HasPrefix(s, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+// splitLines splits s on line breaks.
+func splitLines(s string) []string {
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
@@ -0,0 +1,97 @@
+package migrationconv
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			name:   "statements",
+			script: "CREATE TABLE a (id INT);\n\nDROP TABLE b;\n",
+			want:   []string{"CREATE TABLE a (id INT);", "DROP TABLE b;"},
+		},
+		{
+			name:   "comments stay above their statement",
+			script: "-- first; not a statement\nSELECT 1; -- second; neither\nSELECT 2;",
+			want:   []string{"-- first; not a statement\nSELECT 1;", "-- second; neither\nSELECT 2;"},
+		},
+		{
+			name:   "nested block comments",
+			script: "/* outer /* inner; */ still a comment; */ SELECT 1;\nSELECT 2;",
+			want:   []string{"/* outer /* inner; */ still a comment; */ SELECT 1;", "SELECT 2;"},
+		},
+		{
+			name:   "quoted strings and identifiers",
+			script: `INSERT INTO "odd;name" VALUES ('a;b', 'it''s; fine');SELECT 2;`,
+			want:   []string{`INSERT INTO "odd;name" VALUES ('a;b', 'it''s; fine');`, "SELECT 2;"},
+		},
+		{
+			name:   "escape strings",
+			script: `SELECT E'it\'s; fine', e'\\';SELECT 2;`,
+			want:   []string{`SELECT E'it\'s; fine', e'\\';`, "SELECT 2;"},
+		},
+		{
+			name:   "backslashes in standard strings",
+			script: `SELECT 'C:\';SELECT 2;`,
+			want:   []string{`SELECT 'C:\';`, "SELECT 2;"},
+		},
+		{
+			name:   "identifier ending in E before a string",
+			script: `SELECT * FROM t WHERE type'\';SELECT 2;`,
+			want:   []string{`SELECT * FROM t WHERE type'\';`, "SELECT 2;"},
+		},
+		{
+			name:   "dollar quotes",
+			script: "DO $$ BEGIN PERFORM 1; END $$;\nSELECT 2;",
+			want:   []string{"DO $$ BEGIN PERFORM 1; END $$;", "SELECT 2;"},
+		},
+		{
+			name:   "tagged dollar quotes around other dollar quotes",
+			script: "CREATE FUNCTION f() RETURNS TEXT AS $body$ SELECT $$;$$; $body$ LANGUAGE sql;\nSELECT 2;",
+			want:   []string{"CREATE FUNCTION f() RETURNS TEXT AS $body$ SELECT $$;$$; $body$ LANGUAGE sql;", "SELECT 2;"},
+		},
+		{
+			name:   "parameters and identifiers with dollars",
+			script: "PREPARE p AS SELECT $1;SELECT a$b$c;SELECT 3;",
+			want:   []string{"PREPARE p AS SELECT $1;", "SELECT a$b$c;", "SELECT 3;"},
+		},
+		{
+			name:   "unterminated dollar quote",
+			script: "DO $$ BEGIN PERFORM 1; END;",
+			want:   []string{"DO $$ BEGIN PERFORM 1; END;"},
+		},
+		{
+			name:   "last statement without a semicolon",
+			script: "SELECT 1;\nSELECT 2",
+			want:   []string{"SELECT 1;", "SELECT 2"},
+		},
+		{
+			name:   "trailing comment",
+			script: "SELECT 1;\n-- done\n",
+			want:   []string{"SELECT 1;", "-- done"},
+		},
+		{
+			name:   "empty statements",
+			script: "SELECT 1;;\n;",
+			want:   []string{"SELECT 1;"},
+		},
+		{
+			name:   "empty script",
+			script: "\n\n",
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitStatements(%q)\ngot  %q\nwant %q", tt.script, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+// Package migrationgen turns templates into migrations for the three
+// migration setups of this repository: Liquibase YAML, Liquibase formatted
+// SQL and Flyway, so template content can ship through the same pipeline as
+// the schema instead of hand-written changesets.
+package migrationgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported output formats.
+const (
+	ToolLiquibaseYAML = "liquibase-yaml"
+	ToolLiquibaseSQL  = "liquibase-sql"
+	ToolFlyway        = "flyway"
+)
+
+// flywayDollarTag quotes the DO block of context-restricted Flyway scripts.
+const flywayDollarTag = "$template_export$"
+
+// Item is one template to write. Previous is the state to roll back to; nil
+// means the migration creates the template and rolling back deletes it.
+type Item struct {
+	Template models.TemplateExport
+	Previous *models.Template
+}
+
+type Options struct {
+	Tool string
+	// Author of the Liquibase changesets.
+	Author string
+	// ChangeSetID is the base of the changeset IDs; every template gets
+	// ChangeSetID followed by a three digit sequence number, like the
+	// changesets in liquibase/migrations/dev.
+	ChangeSetID string
+	// Context restricts the migration to an environment, through the
+	// Liquibase context or the Flyway ${environment} placeholder.
+	Context string
+	// UpdatedBy is recorded as the author of the template changes.
+	UpdatedBy string
+}
+
+// headerValue matches the option values written into changeset, context and
+// file names. Anything else, a newline in particular, could add directives or
+// SQL to the migration.
+var headerValue = regexp.MustCompile(`^[A-Za-z0-9_.,!:-]+$`)
+
+// Validate reports the first of Author, ChangeSetID and Context holding
+// characters that cannot be written into a migration header.
+func (o Options) Validate() error {
+	for _, option := range []struct{ name, value string }{
+		{"author", o.Author},
+		{"changeset_id", o.ChangeSetID},
+		{"context", o.Context},
+	} {
+		if option.value != "" && !headerValue.MatchString(option.value) {
+			return fmt.Errorf("%s %q may only contain letters, digits and _ . , ! : -", option.name, option.value)
+		}
+	}
+	return nil
+}
+
+// Generate writes the migration for items.
+func Generate(items []Item, opts Options) ([]byte, error) {
+	if len(items) == 0 {
+		return nil, errors.New("no templates to export")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Author == "" {
+		opts.Author = "template_service"
+	}
+	if opts.ChangeSetID == "" {
+		opts.ChangeSetID = time.Now().Format("20060102")
+	}
+	if opts.UpdatedBy == "" {
+		opts.UpdatedBy = "migration"
+	}
+
+	switch opts.Tool {
+	case ToolLiquibaseYAML:
+		return liquibaseYAML(items, opts)
+	case ToolLiquibaseSQL:
+		return liquibaseSQL(items, opts), nil
+	case ToolFlyway:
+		return flyway(items, opts)
+	}
+	return nil, fmt.Errorf("unknown tool %q, expected %s, %s or %s", opts.Tool, ToolLiquibaseYAML, ToolLiquibaseSQL, ToolFlyway)
+}
+
+// FileName suggests a file name following the naming of each tool's
+// migration directory.
+func FileName(items []Item, opts Options) string {
+	id := opts.ChangeSetID
+	if id == "" {
+		id = time.Now().Format("20060102")
+	}
+	slug := "templates"
+	if len(items) == 1 {
+		slug = slugify(items[0].Template.Template.Name)
+	}
+
+	switch opts.Tool {
+	case ToolLiquibaseYAML:
+		return fmt.Sprintf("v%s_update_%s.yaml", id, strings.ToLower(slug))
+	case ToolLiquibaseSQL:
+		return fmt.Sprintf("v%s_update_%s.sql", id, strings.ToLower(slug))
+	default:
+		return fmt.Sprintf("R__Template_%s.sql", slug)
+	}
+}
+
+var nonWord = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func slugify(s string) string {
+	slug := strings.Trim(nonWord.ReplaceAllString(s, "_"), "_")
+	if slug == "" {
+		return "template"
+	}
+	return slug
+}
+
+func changeSetID(opts Options, i int) string {
+	return fmt.Sprintf("%s%03d", opts.ChangeSetID, i+1)
+}
+
+func comment(item Item) string {
+	t := item.Template.Template
+	if item.Previous == nil {
+		return fmt.Sprintf("Create template %s (version %d)", t.Name, t.Version)
+	}
+	return fmt.Sprintf("Update template %s from version %d to %d", t.Name, item.Previous.Version, t.Version)
+}
+
+// statements returns the SQL writing the template, its variables and
+// configuration. Running it again is harmless, so it also fits repeatable
+// migrations.
+func statements(item Item, opts Options, singleLine bool) []string {
+	e := item.Template
+	t := e.Template
+	q := func(s string) string { return quote(s, singleLine) }
+
+	stmts := []string{
+		fmt.Sprintf(`INSERT INTO template_service.template_category (name)
+VALUES (%s)
+ON CONFLICT (name) DO NOTHING;`, q(t.CategoryName)),
+		fmt.Sprintf(`INSERT INTO template_service.template (id, name, category_id, content, format, version, is_active, created_by)
+VALUES (%s,
+        %s,
+        (SELECT id FROM template_service.template_category WHERE name = %s),
+        %s,
+        %s,
+        %d,
+        TRUE,
+        %s)
+ON CONFLICT (id) DO UPDATE
+    SET name        = EXCLUDED.name,
+        category_id = EXCLUDED.category_id,
+        content     = EXCLUDED.content,
+        format      = EXCLUDED.format,
+        version     = EXCLUDED.version,
+        is_active   = TRUE,
+        updated_by  = %s,
+        updated_at  = CURRENT_TIMESTAMP;`,
+			q(t.ID), q(t.Name), q(t.CategoryName), q(t.Content), q(t.Format), t.Version,
+			q(opts.UpdatedBy), q(opts.UpdatedBy)),
+		fmt.Sprintf(`DELETE FROM template_service.template_variable WHERE template_id = %s;`, q(t.ID)),
+	}
+
+	if len(e.Variables) > 0 {
+		var rows []string
+		for _, v := range e.Variables {
+			variableType := v.VariableType
+			if variableType == "" {
+				variableType = "string"
+			}
+			rows = append(rows, fmt.Sprintf("(%s, %s, %s, %s, %t, %s)",
+				q(t.ID), q(v.VariableName), q(v.Description), q(v.DefaultValue), v.IsRequired, q(variableType)))
+		}
+		stmts = append(stmts, `INSERT INTO template_service.template_variable (template_id, variable_name, description, default_value, is_required, variable_type)
+VALUES `+strings.Join(rows, ",\n       ")+";")
+	}
+
+	stmts = append(stmts, fmt.Sprintf(`DELETE FROM template_service.template_config WHERE template_id = %s;`, q(t.ID)))
+	if len(e.Config) > 0 {
+		var rows []string
+		for _, c := range e.Config {
+			rows = append(rows, fmt.Sprintf("(%s, %s, %s, %s)", q(t.ID), q(c.ConfigKey), q(c.ConfigValue), q(c.Description)))
+		}
+		stmts = append(stmts, `INSERT INTO template_service.template_config (template_id, config_key, config_value, description)
+VALUES `+strings.Join(rows, ",\n       ")+";")
+	}
+	return stmts
+}
+
+// rollback returns single-line statements undoing statements. Variables and
+// configuration are not versioned, so an update only restores the template
+// row itself.
+func rollback(item Item) []string {
+	t := item.Template.Template
+	if item.Previous == nil {
+		return []string{fmt.Sprintf("DELETE FROM template_service.template WHERE id = %s;", quote(t.ID, true))}
+	}
+	p := item.Previous
+	return []string{fmt.Sprintf(
+		"UPDATE template_service.template SET name = %s, content = %s, format = %s, version = %d, updated_at = CURRENT_TIMESTAMP WHERE id = %s;",
+		quote(p.Name, true), quote(p.Content, true), quote(p.Format, true), p.Version, quote(t.ID, true))}
+}
+
+// quote returns s as an SQL string literal. Migration tools replace ${...}
+// placeholders and Liquibase reads lines starting with "--" as directives
+// even inside strings, so such values, and all multi-line values when
+// singleLine is set, are written as escape strings (E'...') with $ and line breaks
+// escaped. Go template braces need no escaping in SQL.
+func quote(s string, singleLine bool) string {
+	needsEscape := strings.Contains(s, "${") || strings.Contains(s, "\\") ||
+		(singleLine && strings.ContainsAny(s, "\r\n"))
+	if !needsEscape {
+		for _, line := range strings.Split(s, "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "--") {
+				needsEscape = true
+				break
+			}
+		}
+	}
+	if !needsEscape {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+
+	var b strings.Builder
+	b.WriteString("E'")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '$':
+			b.WriteString(`\x24`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+type yamlChangeLog struct {
+	DatabaseChangeLog []yamlEntry `yaml:"databaseChangeLog"`
+}
+
+type yamlEntry struct {
+	ChangeSet yamlChangeSet `yaml:"changeSet"`
+}
+
+type yamlChangeSet struct {
+	ID       string       `yaml:"id"`
+	Author   string       `yaml:"author"`
+	Comment  string       `yaml:"comment"`
+	Context  string       `yaml:"context,omitempty"`
+	Changes  []yamlChange `yaml:"changes"`
+	Rollback []yamlChange `yaml:"rollback"`
+}
+
+type yamlChange struct {
+	SQL yamlSQL `yaml:"sql"`
+}
+
+type yamlSQL struct {
+	DBMS string `yaml:"dbms"`
+	SQL  string `yaml:"sql"`
+}
+
+func liquibaseYAML(items []Item, opts Options) ([]byte, error) {
+	var changeLog yamlChangeLog
+	for i, item := range items {
+		cs := yamlChangeSet{
+			ID:      changeSetID(opts, i),
+			Author:  opts.Author,
+			Comment: comment(item),
+			Context: opts.Context,
+		}
+		cs.Changes = append(cs.Changes, yamlChange{SQL: yamlSQL{
+			DBMS: "postgresql",
+			SQL:  strings.Join(statements(item, opts, false), "\n\n") + "\n",
+		}})
+		for _, stmt := range rollback(item) {
+			cs.Rollback = append(cs.Rollback, yamlChange{SQL: yamlSQL{DBMS: "postgresql", SQL: stmt}})
+		}
+		changeLog.DatabaseChangeLog = append(changeLog.DatabaseChangeLog, yamlEntry{ChangeSet: cs})
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(changeLog); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func liquibaseSQL(items []Item, opts Options) []byte {
+	var b strings.Builder
+	b.WriteString("--liquibase formatted sql\n")
+	for i, item := range items {
+		fmt.Fprintf(&b, "\n--changeset %s:%s\n", opts.Author, changeSetID(opts, i))
+		fmt.Fprintf(&b, "--comment %s\n", comment(item))
+		if opts.Context != "" {
+			fmt.Fprintf(&b, "--context %s\n", opts.Context)
+		}
+		for _, stmt := range statements(item, opts, false) {
+			b.WriteString(stmt + "\n")
+		}
+		b.WriteString("\n")
+		for _, stmt := range rollback(item) {
+			b.WriteString("--rollback " + stmt + "\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// flyway writes a repeatable migration: Flyway reruns it whenever the
+// templates change, and every statement is idempotent. Flyway has no
+// rollback blocks, so the rollback statements are added as comments.
+func flyway(items []Item, opts Options) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("-- https://documentation.red-gate.com/fd/repeatable-migrations-273973335.html\n")
+	for _, item := range items {
+		fmt.Fprintf(&b, "-- %s\n", comment(item))
+	}
+	b.WriteString("\n")
+
+	indent := ""
+	if opts.Context != "" {
+		b.WriteString("-- Only execute in the " + opts.Context + " environment\nDO\n" + flywayDollarTag + "\n    BEGIN\n")
+		fmt.Fprintf(&b, "        IF '${environment}' = %s THEN\n", quote(opts.Context, true))
+		indent = "            "
+	}
+
+	for _, item := range items {
+		for _, stmt := range statements(item, opts, false) {
+			if strings.Contains(stmt, flywayDollarTag) {
+				return nil, fmt.Errorf("template %s contains %s", item.Template.Template.Name, flywayDollarTag)
+			}
+			// Only the first line is indented; the others may be inside strings.
+			b.WriteString(indent + stmt + "\n")
+		}
+		b.WriteString("\n")
+	}
+
+	if opts.Context != "" {
+		b.WriteString("        END IF;\n    END;\n" + flywayDollarTag + ";\n\n")
+	}
+
+	b.WriteString("-- Rollback:\n")
+	for _, item := range items {
+		for _, stmt := range rollback(item) {
+			b.WriteString("-- " + stmt + "\n")
+		}
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+)
+
+// Audit log actions, the trigger operation that recorded the entry.
+const (
+	AuditInsert = "INSERT"
+	AuditUpdate = "UPDATE"
+	AuditDelete = "DELETE"
+)
+
+// AuditEntry is a change recorded by the audit.log_change trigger. Old and
+// New hold the row before and after the change as JSON objects keyed by
+// column name; Old is null for inserts and New for deletes.
+type AuditEntry struct {
+	ID         int
+	EntityType string
+	EntityID   string
+	Action     string
+	UserID     string
+	Old        json.RawMessage
+	New        json.RawMessage
+	Timestamp  time.Time
+}
+
+// GetAuditLog returns the changes of an entity, oldest first. The entity
+// type is the table name, such as template or template_config.
+func GetAuditLog(ctx context.Context, entityType, entityID string) ([]AuditEntry, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT id, entity_type, entity_id, action, user_id,
+		       COALESCE(change_data->'old', 'null'), COALESCE(change_data->'new', 'null'), timestamp
+		FROM audit.audit_log
+		WHERE entity_type = $1 AND entity_id = $2
+		ORDER BY timestamp, id
+	`, entityType, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var oldRow, newRow []byte
+		if err := rows.Scan(&e.ID, &e.EntityType, &e.EntityID, &e.Action, &e.UserID,
+			&oldRow, &newRow, &e.Timestamp); err != nil {
+			return nil, err
+		}
+		e.Old = oldRow
+		e.New = newRow
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
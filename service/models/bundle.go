@@ -0,0 +1,572 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+)
+
+// Conflict strategies for templates that already exist when importing.
+const (
+	ImportSkip       = "skip"
+	ImportOverwrite  = "overwrite"
+	ImportNewVersion = "new-version"
+)
+
+// Import results of a single template.
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportSkipped   = "skipped"
+	ImportUnchanged = "unchanged"
+)
+
+// errDryRun rolls back the import transaction of a dry run.
+var errDryRun = errors.New("dry run")
+
+type TemplateVersion struct {
+	ID          int
+	TemplateID  string
+	Version     int
+	Content     string
+	Format      string
+	CreatedBy   string
+	CreatedAt   time.Time
+	ChangeNotes string
+}
+
+// TemplateExport is a template with everything needed to recreate it in
+// another environment. CategoryName identifies the category, since category
+// IDs differ between databases.
+type TemplateExport struct {
+	Template  Template
+	Variables []TemplateVariable
+	Config    []TemplateConfig
+	Versions  []TemplateVersion
+}
+
+// ExportFilter selects the templates to export. IDs takes precedence over
+// CategoryID; with neither every active template is exported.
+type ExportFilter struct {
+	IDs             []string
+	CategoryID      int
+	IncludeVersions bool
+}
+
+type ImportResult struct {
+	TemplateID string
+	Name       string
+	Status     string
+	// Changes lists what differs from the existing template: name, category,
+	// content, format, variables or config.
+	Changes []string
+}
+
+type ImportReport struct {
+	DryRun            bool
+	Strategy          string
+	CategoriesCreated []string
+	Templates         []ImportResult
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func GetTemplateVersions(ctx context.Context, templateID string) ([]TemplateVersion, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT id, template_id, version, content, format, created_by, created_at, change_notes
+		FROM template_service.template_version
+		WHERE template_id = $1
+		ORDER BY version
+	`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	var versions []TemplateVersion
+	for rows.Next() {
+		var v TemplateVersion
+		var createdAt sql.NullTime
+		var notes sql.NullString
+		if err := rows.Scan(&v.ID, &v.TemplateID, &v.Version, &v.Content, &v.Format, &v.CreatedBy,
+			&createdAt, &notes); err != nil {
+			return nil, err
+		}
+		v.CreatedAt = createdAt.Time
+		v.ChangeNotes = notes.String
+		versions = append(versions, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// CreateTemplateVersion stores a snapshot of a template at a version. A
+// second snapshot of the same version violates uk_template_id_version.
+func CreateTemplateVersion(ctx context.Context, v TemplateVersion) (int, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	createdAt := v.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	var id int
+	err := db.DB.QueryRowContext(ctx, `
+		INSERT INTO template_service.template_version
+		(template_id, version, content, format, created_by, created_at, change_notes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		v.TemplateID, v.Version, v.Content, v.Format, v.CreatedBy, createdAt, v.ChangeNotes).Scan(&id)
+	return id, err
+}
+
+// ExportTemplates loads the selected templates with their variables,
+// configuration and, optionally, version history.
+func ExportTemplates(ctx context.Context, f ExportFilter) ([]TemplateExport, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var templates []Template
+	if len(f.IDs) > 0 {
+		for _, id := range f.IDs {
+			t, err := GetTemplateByID(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("template %s: %w", id, err)
+			}
+			templates = append(templates, t)
+		}
+	} else {
+		page, err := ListTemplates(ctx, TemplateFilter{CategoryID: f.CategoryID, Sort: SortName, Ascending: true})
+		if err != nil {
+			return nil, err
+		}
+		templates = page.Templates
+	}
+
+	exports := make([]TemplateExport, 0, len(templates))
+	for _, t := range templates {
+		e := TemplateExport{Template: t}
+		var err error
+		if e.Variables, err = templateVariables(ctx, db.DB, t.ID); err != nil {
+			return nil, fmt.Errorf("variables of %s: %w", t.ID, err)
+		}
+		if e.Config, err = GetTemplateConfig(ctx, t.ID); err != nil {
+			return nil, fmt.Errorf("config of %s: %w", t.ID, err)
+		}
+		if f.IncludeVersions {
+			if e.Versions, err = GetTemplateVersions(ctx, t.ID); err != nil {
+				return nil, fmt.Errorf("versions of %s: %w", t.ID, err)
+			}
+		}
+		exports = append(exports, e)
+	}
+	return exports, nil
+}
+
+// ImportTemplates creates or updates the templates of a bundle in one
+// transaction. Categories are matched by name and created when missing.
+// Templates are matched by ID; an existing template that differs from the
+// bundle is skipped, overwritten in place, or updated to a new version with
+// its current content kept in template_version, depending on strategy. A dry
+// run reports the same results and rolls everything back.
+func ImportTemplates(ctx context.Context, categories []TemplateCategory, templates []TemplateExport, strategy string,
+	dryRun bool, importedBy string) (ImportReport, error) {
+	report := ImportReport{DryRun: dryRun, Strategy: strategy, CategoriesCreated: []string{}, Templates: []ImportResult{}}
+
+	switch strategy {
+	case ImportSkip, ImportOverwrite, ImportNewVersion:
+	default:
+		return report, fmt.Errorf("unknown conflict strategy %q", strategy)
+	}
+
+	err := asUser(ctx, importedBy, func(ctx context.Context, tx *sql.Tx) error {
+		categoryIDs, err := importCategories(ctx, tx, categories, templates, &report)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range templates {
+			result, err := importTemplate(ctx, tx, e, categoryIDs[e.Template.CategoryName], strategy, importedBy)
+			if err != nil {
+				return fmt.Errorf("template %s (%s): %w", e.Template.Name, e.Template.ID, err)
+			}
+			report.Templates = append(report.Templates, result)
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		err = nil
+	}
+	return report, err
+}
+
+// importCategories returns the ID of every category the bundle refers to,
+// creating the missing ones.
+func importCategories(ctx context.Context, tx *sql.Tx, categories []TemplateCategory, templates []TemplateExport,
+	report *ImportReport) (map[string]int, error) {
+	ids := make(map[string]int)
+	rows, err := tx.QueryContext(ctx, `SELECT id, name FROM template_service.template_category`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		ids[name] = id
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	descriptions := make(map[string]string)
+	for _, c := range categories {
+		descriptions[c.Name] = c.Description
+	}
+
+	for _, e := range templates {
+		name := e.Template.CategoryName
+		if _, ok := ids[name]; ok {
+			continue
+		}
+		var id int
+		if err := tx.QueryRowContext(ctx, `
+			INSERT INTO template_service.template_category (name, description)
+			VALUES ($1, $2)
+			RETURNING id`,
+			name, descriptions[name]).Scan(&id); err != nil {
+			return nil, fmt.Errorf("creating category %s: %w", name, err)
+		}
+		ids[name] = id
+		report.CategoriesCreated = append(report.CategoriesCreated, name)
+	}
+	return ids, nil
+}
+
+func importTemplate(ctx context.Context, tx *sql.Tx, e TemplateExport, categoryID int, strategy, importedBy string) (ImportResult, error) {
+	t := e.Template
+	result := ImportResult{TemplateID: t.ID, Name: t.Name, Changes: []string{}}
+
+	var existing Template
+	err := tx.QueryRowContext(ctx, `
+		SELECT name, category_id, content, format, version
+		FROM template_service.template
+		WHERE id = $1
+		FOR UPDATE`, t.ID).Scan(&existing.Name, &existing.CategoryID, &existing.Content, &existing.Format, &existing.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		result.Status = ImportCreated
+		version := t.Version
+		if version < 1 {
+			version = 1
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO template_service.template
+			(id, name, category_id, content, format, version, is_active, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, true, $7)`,
+			t.ID, t.Name, categoryID, t.Content, t.Format, version, importedBy); err != nil {
+			return result, err
+		}
+		if err := replaceTemplateDetails(ctx, tx, t.ID, e); err != nil {
+			return result, err
+		}
+		return result, importVersions(ctx, tx, t.ID, e.Versions)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	variables, err := templateVariables(ctx, tx, t.ID)
+	if err != nil {
+		return result, err
+	}
+	config, err := templateConfig(ctx, tx, t.ID)
+	if err != nil {
+		return result, err
+	}
+	result.Changes = ImportChanges(existing, variables, config, e, categoryID)
+
+	switch {
+	case len(result.Changes) == 0:
+		result.Status = ImportUnchanged
+		return result, importVersions(ctx, tx, t.ID, e.Versions)
+	case strategy == ImportSkip:
+		result.Status = ImportSkipped
+		return result, nil
+	}
+
+	result.Status = ImportUpdated
+	if strategy == ImportNewVersion {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO template_service.template_version
+			(template_id, version, content, format, created_by, change_notes)
+			VALUES ($1, $2, $3, $4, $5, 'Replaced by import')
+			ON CONFLICT (template_id, version) DO NOTHING`,
+			t.ID, existing.Version, existing.Content, existing.Format, importedBy); err != nil {
+			return result, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE template_service.template
+			SET name = $2, category_id = $3, content = $4, format = $5, version = version + 1,
+			    updated_by = $6, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1`,
+			t.ID, t.Name, categoryID, t.Content, t.Format, importedBy)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE template_service.template
+			SET name = $2, category_id = $3, content = $4, format = $5, version = GREATEST($6, 1),
+			    updated_by = $7, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1`,
+			t.ID, t.Name, categoryID, t.Content, t.Format, t.Version, importedBy)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if err := replaceTemplateDetails(ctx, tx, t.ID, e); err != nil {
+		return result, err
+	}
+	return result, importVersions(ctx, tx, t.ID, e.Versions)
+}
+
+// replaceTemplateDetails replaces the variables and configuration of a
+// template with the ones of the bundle.
+func replaceTemplateDetails(ctx context.Context, tx *sql.Tx, templateID string, e TemplateExport) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM template_service.template_variable WHERE template_id = $1`, templateID); err != nil {
+		return err
+	}
+	for _, v := range e.Variables {
+		variableType := v.VariableType
+		if variableType == "" {
+			variableType = defaultVariableType
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO template_service.template_variable
+			(template_id, variable_name, description, default_value, is_required, variable_type)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			templateID, v.VariableName, v.Description, v.DefaultValue, v.IsRequired, variableType); err != nil {
+			return fmt.Errorf("variable %s: %w", v.VariableName, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM template_service.template_config WHERE template_id = $1`, templateID); err != nil {
+		return err
+	}
+	for _, c := range e.Config {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO template_service.template_config
+			(template_id, config_key, config_value, description)
+			VALUES ($1, $2, $3, $4)`,
+			templateID, c.ConfigKey, c.ConfigValue, c.Description); err != nil {
+			return fmt.Errorf("config %s: %w", c.ConfigKey, err)
+		}
+	}
+	return nil
+}
+
+// importVersions adds the version history of the bundle, keeping versions
+// the template already has.
+func importVersions(ctx context.Context, tx *sql.Tx, templateID string, versions []TemplateVersion) error {
+	for _, v := range versions {
+		createdAt := v.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO template_service.template_version
+			(template_id, version, content, format, created_by, created_at, change_notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (template_id, version) DO NOTHING`,
+			templateID, v.Version, v.Content, v.Format, v.CreatedBy, createdAt, v.ChangeNotes); err != nil {
+			return fmt.Errorf("version %d: %w", v.Version, err)
+		}
+	}
+	return nil
+}
+
+// templateVariables is GetTemplateVariables for a transaction, tolerating
+// NULL descriptions and default values.
+func templateVariables(ctx context.Context, q queryer, templateID string) ([]TemplateVariable, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, template_id, variable_name, description, default_value, is_required, variable_type
+		FROM template_service.template_variable
+		WHERE template_id = $1
+		ORDER BY id
+	`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	var variables []TemplateVariable
+	for rows.Next() {
+		var v TemplateVariable
+		var description, defaultValue sql.NullString
+		if err := rows.Scan(&v.ID, &v.TemplateID, &v.VariableName, &description, &defaultValue,
+			&v.IsRequired, &v.VariableType); err != nil {
+			return nil, err
+		}
+		v.Description = description.String
+		v.DefaultValue = defaultValue.String
+		variables = append(variables, v)
+	}
+	return variables, rows.Err()
+}
+
+func templateConfig(ctx context.Context, q queryer, templateID string) ([]TemplateConfig, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, template_id, config_key, config_value, description
+		FROM template_service.template_config
+		WHERE template_id = $1
+		ORDER BY config_key
+	`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	var configs []TemplateConfig
+	for rows.Next() {
+		var c TemplateConfig
+		var value, description sql.NullString
+		if err := rows.Scan(&c.ID, &c.TemplateID, &c.ConfigKey, &value, &description); err != nil {
+			return nil, err
+		}
+		c.ConfigValue = value.String
+		c.Description = description.String
+		configs = append(configs, c)
+	}
+	return configs, rows.Err()
+}
+
+// ImportChanges lists what importing e into categoryID would change in a
+// template that is existing with the given variables and configuration:
+// name, category, content, format, variables or config.
+func ImportChanges(existing Template, variables []TemplateVariable, config []TemplateConfig, e TemplateExport,
+	categoryID int) []string {
+	t := e.Template
+	changes := []string{}
+	if existing.Name != t.Name {
+		changes = append(changes, "name")
+	}
+	if existing.CategoryID != categoryID {
+		changes = append(changes, "category")
+	}
+	if existing.Content != t.Content {
+		changes = append(changes, "content")
+	}
+	if existing.Format != t.Format {
+		changes = append(changes, "format")
+	}
+	if !sameVariables(variables, e.Variables) {
+		changes = append(changes, "variables")
+	}
+	if !sameConfig(config, e.Config) {
+		changes = append(changes, "config")
+	}
+	return changes
+}
+
+func sameVariables(a, b []TemplateVariable) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	key := func(v TemplateVariable) string {
+		variableType := v.VariableType
+		if variableType == "" {
+			variableType = defaultVariableType
+		}
+		return fmt.Sprintf("%q %q %q %t %q", v.VariableName, v.Description, v.DefaultValue, v.IsRequired, variableType)
+	}
+	return sameKeys(len(a), func(i int) string { return key(a[i]) }, func(i int) string { return key(b[i]) })
+}
+
+func sameConfig(a, b []TemplateConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	key := func(c TemplateConfig) string {
+		return fmt.Sprintf("%q %q %q", c.ConfigKey, c.ConfigValue, c.Description)
+	}
+	return sameKeys(len(a), func(i int) string { return key(a[i]) }, func(i int) string { return key(b[i]) })
+}
+
+// sameKeys compares two lists of n elements regardless of their order.
+func sameKeys(n int, a, b func(i int) string) bool {
+	ka := make([]string, n)
+	kb := make([]string, n)
+	for i := 0; i < n; i++ {
+		ka[i], kb[i] = a(i), b(i)
+	}
+	sort.Strings(ka)
+	sort.Strings(kb)
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetTemplateAtVersion returns the name, content and format a template had
+// at the given version, taken from the audit log or, when the audit log has
+// no entry, from template_version. It returns sql.ErrNoRows when neither
+// knows the version.
+func GetTemplateAtVersion(ctx context.Context, templateID string, version int) (Template, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	t := Template{ID: templateID, Version: version}
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT change_data->'new'->>'name', change_data->'new'->>'content', change_data->'new'->>'format'
+		FROM audit.audit_log
+		WHERE entity_type = 'template' AND entity_id = $1
+		  AND change_data->'new'->>'version' = $2::text
+		ORDER BY timestamp DESC, id DESC
+		LIMIT 1
+	`, templateID, version).Scan(&t.Name, &t.Content, &t.Format)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return t, err
+	}
+
+	err = db.DB.QueryRowContext(ctx, `
+		SELECT t.name, v.content, v.format
+		FROM template_service.template_version v
+		JOIN template_service.template t ON t.id = v.template_id
+		WHERE v.template_id = $1 AND v.version = $2
+	`, templateID, version).Scan(&t.Name, &t.Content, &t.Format)
+	return t, err
+}
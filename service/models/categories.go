@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+)
+
+// ErrCategoryInUse is returned when deleting a category that templates still
+// reference.
+var ErrCategoryInUse = errors.New("category is used by templates")
+
+// ErrInvalidReassignment is returned when templates would be moved to the
+// deleted category itself or to a category that does not exist.
+var ErrInvalidReassignment = errors.New("invalid category to reassign templates to")
+
+func GetTemplateCategory(ctx context.Context, id int) (TemplateCategory, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var c TemplateCategory
+	var description sql.NullString
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT c.id, c.name, c.description,
+		       (SELECT COUNT(*) FROM template_service.template t WHERE t.category_id = c.id)
+		FROM template_service.template_category c
+		WHERE c.id = $1
+	`, id).Scan(&c.ID, &c.Name, &description, &c.TemplateCount)
+	c.Description = description.String
+	return c, err
+}
+
+func CreateTemplateCategory(ctx context.Context, name, description string) (int, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := db.DB.QueryRowContext(ctx, `
+		INSERT INTO template_service.template_category (name, description)
+		VALUES ($1, $2)
+		RETURNING id`,
+		name, description).Scan(&id)
+
+	return id, err
+}
+
+// UpdateTemplateCategory returns sql.ErrNoRows when the category does not
+// exist.
+func UpdateTemplateCategory(ctx context.Context, id int, name, description string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE template_service.template_category
+		SET name = $2, description = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		id, name, description)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteTemplateCategory deletes a category. When reassignTo is not zero the
+// templates of the category are moved to that category first; otherwise the
+// delete fails with ErrCategoryInUse while templates reference it.
+func DeleteTemplateCategory(ctx context.Context, id, reassignTo int) (err error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	if reassignTo == id {
+		return fmt.Errorf("%w: it is the category being deleted", ErrInvalidReassignment)
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Printf("Error rolling back transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	// Lock the category so no template is added to it while it is deleted.
+	var exists int
+	if err = tx.QueryRowContext(ctx, `
+		SELECT id FROM template_service.template_category WHERE id = $1 FOR UPDATE`,
+		id).Scan(&exists); err != nil {
+		return err
+	}
+
+	if reassignTo != 0 {
+		if err = tx.QueryRowContext(ctx, `
+			SELECT id FROM template_service.template_category WHERE id = $1`,
+			reassignTo).Scan(&exists); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = fmt.Errorf("%w: category %d does not exist", ErrInvalidReassignment, reassignTo)
+			}
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, `
+			UPDATE template_service.template
+			SET category_id = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE category_id = $1`,
+			id, reassignTo); err != nil {
+			return err
+		}
+	}
+
+	var count int
+	if err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM template_service.template WHERE category_id = $1`,
+		id).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		err = fmt.Errorf("%w: %d template(s)", ErrCategoryInUse, count)
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		DELETE FROM template_service.template_category WHERE id = $1`,
+		id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
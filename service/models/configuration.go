@@ -0,0 +1,215 @@
+package models
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+)
+
+// ErrNoEncryptionKey is returned when an encrypted configuration value is read
+// or written without CONFIG_ENCRYPTION_KEY being set.
+var ErrNoEncryptionKey = errors.New("CONFIG_ENCRYPTION_KEY is not set")
+
+type Configuration struct {
+	ID            int
+	ConfigKey     string
+	ConfigValue   string
+	Description   string
+	IsEncrypted   bool
+	LastUpdatedBy string
+	LastUpdatedAt time.Time
+}
+
+func GetConfigurations(ctx context.Context) ([]Configuration, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT id, config_key, config_value, description, is_encrypted,
+		       last_updated_by, last_updated_at
+		FROM template_service.configuration
+		ORDER BY config_key
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	var configs []Configuration
+	for rows.Next() {
+		var c Configuration
+		var value, description, updatedBy sql.NullString
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&c.ID, &c.ConfigKey, &value, &description, &c.IsEncrypted,
+			&updatedBy, &updatedAt); err != nil {
+			return nil, err
+		}
+		c.ConfigValue = value.String
+		c.Description = description.String
+		c.LastUpdatedBy = updatedBy.String
+		c.LastUpdatedAt = updatedAt.Time
+		configs = append(configs, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// GetConfigValues returns the configuration values whose keys start with
+// prefix, with encrypted values decrypted. The prefix is compared as plain
+// text, so _ and % in it match only themselves.
+func GetConfigValues(ctx context.Context, prefix string) (map[string]string, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT config_key, config_value, is_encrypted
+		FROM template_service.configuration
+		WHERE left(config_key, length($1::text)) = $1
+	`, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+		var encrypted bool
+		if err := rows.Scan(&key, &value, &encrypted); err != nil {
+			return nil, err
+		}
+		if encrypted && value.String != "" {
+			decrypted, err := DecryptConfigValue(value.String)
+			if err != nil {
+				return nil, fmt.Errorf("decrypting %s: %w", key, err)
+			}
+			values[key] = decrypted
+			continue
+		}
+		values[key] = value.String
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// SetConfigValue creates or updates a configuration entry. Values flagged as
+// encrypted are stored encrypted with CONFIG_ENCRYPTION_KEY.
+func SetConfigValue(ctx context.Context, key, value, description string, encrypted bool, updatedBy string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	stored := value
+	if encrypted && value != "" {
+		var err error
+		stored, err = EncryptConfigValue(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := db.DB.ExecContext(ctx, `
+		INSERT INTO template_service.configuration
+		(config_key, config_value, description, is_encrypted, last_updated_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (config_key) DO UPDATE
+		SET config_value = EXCLUDED.config_value,
+		    description = COALESCE(NULLIF(EXCLUDED.description, ''), template_service.configuration.description),
+		    is_encrypted = EXCLUDED.is_encrypted, last_updated_by = EXCLUDED.last_updated_by,
+		    last_updated_at = CURRENT_TIMESTAMP`,
+		key, stored, description, encrypted, updatedBy)
+
+	return err
+}
+
+// DeleteConfigValue removes a configuration entry. Unknown keys are not
+// reported.
+func DeleteConfigValue(ctx context.Context, key string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.DB.ExecContext(ctx, `
+		DELETE FROM template_service.configuration
+		WHERE config_key = $1`,
+		key)
+
+	return err
+}
+
+// EncryptConfigValue encrypts a value with AES-256-GCM. The result is the
+// base64 encoded nonce followed by the ciphertext.
+func EncryptConfigValue(plain string) (string, error) {
+	gcm, err := configCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func DecryptConfigValue(encoded string) (string, error) {
+	gcm, err := configCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decoding encrypted value: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypting value: %w", err)
+	}
+	return string(plain), nil
+}
+
+// configCipher derives the AES key from CONFIG_ENCRYPTION_KEY with SHA-256,
+// so any passphrase can be used as the key.
+func configCipher() (cipher.AEAD, error) {
+	secret := os.Getenv("CONFIG_ENCRYPTION_KEY")
+	if secret == "" {
+		return nil, ErrNoEncryptionKey
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// IsUniqueViolation reports whether err was caused by a unique constraint,
+// such as creating a second sample with the same name.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// IsQueryTimeout reports whether err means a query ran out of time. That is
+// context.DeadlineExceeded when the deadline passed before or between round
+// trips, and query_canceled (57014) when Postgres cancelled a running
+// statement, either on behalf of the expired context or because of
+// DB_STATEMENT_TIMEOUT.
+func IsQueryTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
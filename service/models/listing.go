@@ -0,0 +1,266 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ErrInvalidCursor is returned when a listing cursor cannot be decoded or
+// belongs to a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Sort orders supported by ListTemplates.
+const (
+	SortCreatedAt = "created_at"
+	SortUpdatedAt = "updated_at"
+	SortName      = "name"
+	SortVersion   = "version"
+)
+
+// sortColumns maps a sort order to its SQL expression and the type its cursor
+// value is cast to.
+var sortColumns = map[string]struct{ expr, cast string }{
+	SortCreatedAt: {"t.created_at", "timestamptz"},
+	SortUpdatedAt: {"COALESCE(t.updated_at, t.created_at)", "timestamptz"},
+	SortName:      {"t.name", "text"},
+	SortVersion:   {"t.version", "integer"},
+}
+
+// TemplateFilter selects and orders the templates returned by ListTemplates.
+// Zero values do not filter.
+type TemplateFilter struct {
+	CategoryID      int
+	Format          string
+	CreatedBy       string
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+	UpdatedFrom     time.Time
+	UpdatedTo       time.Time
+	IncludeInactive bool
+	Sort            string
+	Ascending       bool
+	// Limit is the page size; 0 returns every matching template.
+	Limit  int
+	Cursor string
+	// Summary skips loading the template content.
+	Summary bool
+}
+
+// TemplatePage is one page of templates. NextCursor is empty on the last
+// page.
+type TemplatePage struct {
+	Templates  []Template
+	NextCursor string
+}
+
+// TemplateSummary is the listing projection of a template without its
+// content.
+type TemplateSummary struct {
+	ID           string
+	Name         string
+	CategoryID   int
+	CategoryName string
+	Format       string
+	Version      int
+	IsActive     bool
+	CreatedBy    string
+	CreatedAt    time.Time
+	UpdatedBy    string
+	UpdatedAt    time.Time
+}
+
+func (t Template) Summary() TemplateSummary {
+	return TemplateSummary{
+		ID:           t.ID,
+		Name:         t.Name,
+		CategoryID:   t.CategoryID,
+		CategoryName: t.CategoryName,
+		Format:       t.Format,
+		Version:      t.Version,
+		IsActive:     t.IsActive,
+		CreatedBy:    t.CreatedBy,
+		CreatedAt:    t.CreatedAt,
+		UpdatedBy:    t.UpdatedBy,
+		UpdatedAt:    t.UpdatedAt,
+	}
+}
+
+type listCursor struct {
+	Sort  string `json:"s"`
+	Asc   bool   `json:"a,omitempty"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// EncodeCursor returns the cursor of the page following the template with
+// the given sort value and ID.
+func EncodeCursor(f TemplateFilter, value, id string) string {
+	data, _ := json.Marshal(listCursor{Sort: f.Sort, Asc: f.Ascending, Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor returns the sort value and ID held by the cursor of f. It
+// fails with ErrInvalidCursor when the cursor was issued for another sort
+// order or does not hold a template ID.
+func DecodeCursor(f TemplateFilter) (value, id string, err error) {
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return "", "", ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return "", "", ErrInvalidCursor
+	}
+	if c.Sort != f.Sort || c.Asc != f.Ascending {
+		return "", "", fmt.Errorf("%w: cursor was issued for a different sort order", ErrInvalidCursor)
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return "", "", fmt.Errorf("%w: cursor does not hold a template ID", ErrInvalidCursor)
+	}
+	return c.Value, c.ID, nil
+}
+
+// ListTemplates returns the templates matching the filter using keyset
+// pagination: the cursor holds the sort value and ID of the last template of
+// the previous page, so pages stay stable while templates are added.
+func ListTemplates(ctx context.Context, f TemplateFilter) (TemplatePage, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var page TemplatePage
+
+	if f.Sort == "" {
+		f.Sort = SortCreatedAt
+	}
+	sortColumn, ok := sortColumns[f.Sort]
+	if !ok {
+		return page, fmt.Errorf("unknown sort %q", f.Sort)
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if !f.IncludeInactive {
+		where = append(where, "t.is_active = true")
+	}
+	if f.CategoryID != 0 {
+		where = append(where, "t.category_id = "+arg(f.CategoryID))
+	}
+	if f.Format != "" {
+		where = append(where, "t.format = "+arg(f.Format))
+	}
+	if f.CreatedBy != "" {
+		where = append(where, "t.created_by = "+arg(f.CreatedBy))
+	}
+	if !f.CreatedFrom.IsZero() {
+		where = append(where, "t.created_at >= "+arg(f.CreatedFrom))
+	}
+	if !f.CreatedTo.IsZero() {
+		where = append(where, "t.created_at < "+arg(f.CreatedTo))
+	}
+	if !f.UpdatedFrom.IsZero() {
+		where = append(where, "t.updated_at >= "+arg(f.UpdatedFrom))
+	}
+	if !f.UpdatedTo.IsZero() {
+		where = append(where, "t.updated_at < "+arg(f.UpdatedTo))
+	}
+
+	direction, comparison := "DESC", "<"
+	if f.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+
+	if f.Cursor != "" {
+		value, id, err := DecodeCursor(f)
+		if err != nil {
+			return page, err
+		}
+		where = append(where, fmt.Sprintf("(%s, t.id) %s (%s::%s, %s::uuid)",
+			sortColumn.expr, comparison, arg(value), sortColumn.cast, arg(id)))
+	}
+
+	content := "t.content"
+	if f.Summary {
+		content = "''"
+	}
+
+	query := `
+		SELECT
+			t.id, t.name, t.category_id, ` + content + `, t.format,
+			t.version, t.is_active, t.created_by, t.created_at,
+			t.updated_by, t.updated_at, c.name as category_name,
+			(` + sortColumn.expr + `)::text
+		FROM template_service.template t
+		JOIN template_service.template_category c ON t.category_id = c.id`
+	if len(where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf("\n\t\tORDER BY %s %s, t.id %s", sortColumn.expr, direction, direction)
+	if f.Limit > 0 {
+		// One extra row tells whether there is a next page.
+		query += "\n\t\tLIMIT " + arg(f.Limit+1)
+	}
+
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	var pqErr *pq.Error
+	if f.Cursor != "" && errors.As(err, &pqErr) && pqErr.Code.Class() == "22" {
+		// The sort value of a tampered cursor does not cast.
+		return page, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	if err != nil {
+		return page, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	var lastValue string
+	for rows.Next() {
+		var t Template
+		var updatedBy sql.NullString
+		var updatedAt sql.NullTime
+		var sortValue string
+
+		if f.Limit > 0 && len(page.Templates) == f.Limit {
+			last := page.Templates[len(page.Templates)-1]
+			page.NextCursor = EncodeCursor(f, lastValue, last.ID)
+			break
+		}
+
+		err := rows.Scan(
+			&t.ID, &t.Name, &t.CategoryID, &t.Content, &t.Format,
+			&t.Version, &t.IsActive, &t.CreatedBy, &t.CreatedAt,
+			&updatedBy, &updatedAt, &t.CategoryName, &sortValue,
+		)
+		if err != nil {
+			return page, err
+		}
+		t.UpdatedBy = updatedBy.String
+		t.UpdatedAt = updatedAt.Time
+		lastValue = sortValue
+
+		page.Templates = append(page.Templates, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+	return page, nil
+}
@@ -0,0 +1,58 @@
+package models_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+)
+
+const cursorID = "5f0c6d8e-3b1a-4c2e-9f7d-2a6b8c4e1d03"
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, sort := range []string{models.SortCreatedAt, models.SortUpdatedAt, models.SortName, models.SortVersion} {
+		for _, ascending := range []bool{false, true} {
+			f := models.TemplateFilter{Sort: sort, Ascending: ascending}
+			f.Cursor = models.EncodeCursor(f, "value with spaces, quotes ' and ünïcode", cursorID)
+
+			value, id, err := models.DecodeCursor(f)
+			if err != nil {
+				t.Errorf("%s ascending=%t: %v", sort, ascending, err)
+				continue
+			}
+			if value != "value with spaces, quotes ' and ünïcode" || id != cursorID {
+				t.Errorf("%s ascending=%t: got value %q and ID %q", sort, ascending, value, id)
+			}
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	byName := models.TemplateFilter{Sort: models.SortName, Ascending: true}
+	raw := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"name","a":true,"v":"x","id":"` + cursorID + `"}`))},
+		{"truncated", models.EncodeCursor(byName, "x", cursorID)[:20]},
+		{"not JSON", raw("name|x|" + cursorID)},
+		{"wrong types", raw(`{"s":1,"a":"yes","v":2,"id":3}`)},
+		{"other sort", models.EncodeCursor(models.TemplateFilter{Sort: models.SortVersion, Ascending: true}, "x", cursorID)},
+		{"other direction", models.EncodeCursor(models.TemplateFilter{Sort: models.SortName}, "x", cursorID)},
+		{"no ID", raw(`{"s":"name","a":true,"v":"x"}`)},
+		{"ID is not a UUID", raw(`{"s":"name","a":true,"v":"x","id":"1' OR '1'='1"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := byName
+			f.Cursor = tt.cursor
+			if _, _, err := models.DecodeCursor(f); !errors.Is(err, models.ErrInvalidCursor) {
+				t.Errorf("DecodeCursor(%q): got error %v, want ErrInvalidCursor", tt.cursor, err)
+			}
+		})
+	}
+}
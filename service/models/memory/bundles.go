@@ -0,0 +1,282 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+)
+
+type bundles struct{ *store }
+
+func (r bundles) Export(ctx context.Context, f models.ExportFilter) ([]models.TemplateExport, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var list []*template
+	if len(f.IDs) > 0 {
+		for _, id := range f.IDs {
+			t, err := r.template(id)
+			if err != nil {
+				return nil, fmt.Errorf("template %s: %w", id, err)
+			}
+			list = append(list, t)
+		}
+	} else {
+		for _, t := range r.templates {
+			if t.IsActive && (f.CategoryID == 0 || t.CategoryID == f.CategoryID) {
+				list = append(list, t)
+			}
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Name != list[j].Name {
+				return list[i].Name < list[j].Name
+			}
+			return list[i].ID < list[j].ID
+		})
+	}
+
+	exports := make([]models.TemplateExport, 0, len(list))
+	for _, t := range list {
+		e := models.TemplateExport{
+			Template:  templates{r.store}.withCategory(t),
+			Variables: r.templateVariables(t.ID),
+			Config:    r.templateConfig(t.ID),
+		}
+		if f.IncludeVersions {
+			e.Versions = r.templateVersions(t.ID)
+		}
+		exports = append(exports, e)
+	}
+	return exports, nil
+}
+
+// Import changes the store in place and, like the rolled back transaction of
+// the Postgres implementation, restores it on an error or a dry run. IDs
+// taken in the meantime stay taken, as sequences do.
+func (r bundles) Import(ctx context.Context, categoryList []models.TemplateCategory, list []models.TemplateExport,
+	strategy string, dryRun bool, importedBy string) (models.ImportReport, error) {
+	report := models.ImportReport{DryRun: dryRun, Strategy: strategy, CategoriesCreated: []string{}, Templates: []models.ImportResult{}}
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+
+	switch strategy {
+	case models.ImportSkip, models.ImportOverwrite, models.ImportNewVersion:
+	default:
+		return report, fmt.Errorf("unknown conflict strategy %q", strategy)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := r.snapshot()
+	err := r.importBundle(categoryList, list, strategy, importedBy, &report)
+	if err != nil || dryRun {
+		r.restore(saved)
+	}
+	return report, err
+}
+
+func (r bundles) importBundle(categoryList []models.TemplateCategory, list []models.TemplateExport, strategy,
+	importedBy string, report *models.ImportReport) error {
+	descriptions := make(map[string]string)
+	for _, c := range categoryList {
+		descriptions[c.Name] = c.Description
+	}
+
+	ids := make(map[string]int)
+	for _, e := range list {
+		name := e.Template.CategoryName
+		if _, ok := ids[name]; ok {
+			continue
+		}
+		if c := (categories{r.store}).byName(name); c != nil {
+			ids[name] = c.ID
+			continue
+		}
+		c := &models.TemplateCategory{ID: r.nextID("template_category"), Name: name, Description: descriptions[name]}
+		r.categories[c.ID] = c
+		ids[name] = c.ID
+		report.CategoriesCreated = append(report.CategoriesCreated, name)
+	}
+
+	for _, e := range list {
+		result, err := r.importTemplate(e, ids[e.Template.CategoryName], strategy, importedBy)
+		if err != nil {
+			return fmt.Errorf("template %s (%s): %w", e.Template.Name, e.Template.ID, err)
+		}
+		report.Templates = append(report.Templates, result)
+	}
+	return nil
+}
+
+func (r bundles) importTemplate(e models.TemplateExport, categoryID int, strategy, importedBy string) (models.ImportResult, error) {
+	t := e.Template
+	result := models.ImportResult{TemplateID: t.ID, Name: t.Name, Changes: []string{}}
+
+	version := t.Version
+	if version < 1 {
+		version = 1
+	}
+
+	existing, err := r.template(t.ID)
+	if err == sql.ErrNoRows {
+		result.Status = models.ImportCreated
+		id, _ := parseTemplateID(t.ID)
+		now := time.Now()
+		created := &template{Template: models.Template{
+			ID:         id,
+			Name:       t.Name,
+			CategoryID: categoryID,
+			Content:    t.Content,
+			Format:     t.Format,
+			Version:    version,
+			IsActive:   true,
+			CreatedBy:  importedBy,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}}
+		r.templates[id] = created
+		r.log("template", id, models.AuditInsert, importedBy, nil, templateRow(created))
+		if err := r.replaceDetails(id, e, importedBy); err != nil {
+			return result, err
+		}
+		return result, r.importVersions(id, e.Versions, importedBy)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.Changes = models.ImportChanges(existing.Template, r.templateVariables(existing.ID),
+		r.templateConfig(existing.ID), e, categoryID)
+	switch {
+	case len(result.Changes) == 0:
+		result.Status = models.ImportUnchanged
+		return result, r.importVersions(existing.ID, e.Versions, importedBy)
+	case strategy == models.ImportSkip:
+		result.Status = models.ImportSkipped
+		return result, nil
+	}
+
+	result.Status = models.ImportUpdated
+	old := templateRow(existing)
+	if strategy == models.ImportNewVersion {
+		if err := r.importVersions(existing.ID, []models.TemplateVersion{{
+			Version:     existing.Version,
+			Content:     existing.Content,
+			Format:      existing.Format,
+			CreatedBy:   importedBy,
+			ChangeNotes: "Replaced by import",
+		}}, importedBy); err != nil {
+			return result, err
+		}
+		version = existing.Version + 1
+	}
+	existing.Name, existing.CategoryID, existing.Content, existing.Format = t.Name, categoryID, t.Content, t.Format
+	existing.Version, existing.UpdatedBy, existing.UpdatedAt = version, importedBy, time.Now()
+	r.log("template", existing.ID, models.AuditUpdate, importedBy, old, templateRow(existing))
+
+	if err := r.replaceDetails(existing.ID, e, importedBy); err != nil {
+		return result, err
+	}
+	return result, r.importVersions(existing.ID, e.Versions, importedBy)
+}
+
+// replaceDetails replaces the variables and configuration of a template
+// with the ones of the bundle.
+func (r bundles) replaceDetails(templateID string, e models.TemplateExport, importedBy string) error {
+	for id, v := range r.variables {
+		if v.TemplateID == templateID {
+			delete(r.variables, id)
+		}
+	}
+	for _, v := range e.Variables {
+		if (variables{r.store}).byName(templateID, v.VariableName) != nil {
+			return fmt.Errorf("variable %s: %w", v.VariableName, uniqueViolation("uk_template_id_variable_name"))
+		}
+		v.TemplateID = templateID
+		variables{r.store}.insert(v)
+	}
+
+	for id, c := range r.config {
+		if c.TemplateID == templateID {
+			delete(r.config, id)
+			r.log("template_config", strconv.Itoa(id), models.AuditDelete, importedBy, configRow(c), nil)
+		}
+	}
+	for _, c := range e.Config {
+		if (config{r.store}).byKey(templateID, c.ConfigKey) != nil {
+			return fmt.Errorf("config %s: %w", c.ConfigKey, uniqueViolation("uk_template_id_config_key"))
+		}
+		c.ID, c.TemplateID = r.nextID("template_config"), templateID
+		r.config[c.ID] = &c
+		r.log("template_config", strconv.Itoa(c.ID), models.AuditInsert, importedBy, nil, configRow(&c))
+	}
+	return nil
+}
+
+// importVersions adds the versions the template does not have yet.
+func (r bundles) importVersions(templateID string, list []models.TemplateVersion, importedBy string) error {
+	have := make(map[int]bool)
+	for _, v := range r.templateVersions(templateID) {
+		have[v.Version] = true
+	}
+	for _, v := range list {
+		if have[v.Version] {
+			continue
+		}
+		v.TemplateID = templateID
+		r.insertVersion(v, importedBy)
+		have[v.Version] = true
+	}
+	return nil
+}
+
+// snapshot is a copy of the tables an import changes.
+type snapshot struct {
+	templates  map[string]*template
+	categories map[int]*models.TemplateCategory
+	variables  map[int]*models.TemplateVariable
+	config     map[int]*models.TemplateConfig
+	versions   map[int]*models.TemplateVersion
+	audit      int
+}
+
+func (s *store) snapshot() snapshot {
+	return snapshot{
+		templates:  copyRows(s.templates),
+		categories: copyRows(s.categories),
+		variables:  copyRows(s.variables),
+		config:     copyRows(s.config),
+		versions:   copyRows(s.versions),
+		audit:      len(s.audit),
+	}
+}
+
+func (s *store) restore(saved snapshot) {
+	s.templates = saved.templates
+	s.categories = saved.categories
+	s.variables = saved.variables
+	s.config = saved.config
+	s.versions = saved.versions
+	s.audit = s.audit[:saved.audit]
+}
+
+// copyRows copies a table, so changes to its rows leave the copy alone.
+func copyRows[K comparable, V any](rows map[K]*V) map[K]*V {
+	copied := make(map[K]*V, len(rows))
+	for k, v := range rows {
+		row := *v
+		copied[k] = &row
+	}
+	return copied
+}
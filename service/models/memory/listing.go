@@ -0,0 +1,319 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+)
+
+// sortValues return the value templates are ordered by for each sort order,
+// formatted so that comparing the strings compares the values.
+var sortValues = map[string]func(t *template) string{
+	models.SortCreatedAt: func(t *template) string { return sortableTime(t.CreatedAt) },
+	models.SortUpdatedAt: func(t *template) string {
+		if t.UpdatedAt.IsZero() {
+			return sortableTime(t.CreatedAt)
+		}
+		return sortableTime(t.UpdatedAt)
+	},
+	models.SortName:    func(t *template) string { return t.Name },
+	models.SortVersion: func(t *template) string { return fmt.Sprintf("%010d", t.Version) },
+}
+
+func sortableTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000")
+}
+
+// Page orders templates by byte value rather than by the collation of the
+// database, which only matters for names.
+func (r templates) Page(ctx context.Context, f models.TemplateFilter) (models.TemplatePage, error) {
+	if err := ctx.Err(); err != nil {
+		return models.TemplatePage{}, err
+	}
+
+	var page models.TemplatePage
+	if f.Sort == "" {
+		f.Sort = models.SortCreatedAt
+	}
+	sortValue, ok := sortValues[f.Sort]
+	if !ok {
+		return page, fmt.Errorf("unknown sort %q", f.Sort)
+	}
+	var after, afterID string
+	if f.Cursor != "" {
+		var err error
+		if after, afterID, err = models.DecodeCursor(f); err != nil {
+			return page, err
+		}
+	}
+
+	// before reports whether a template sorted by (value, id) comes before
+	// another in the requested direction.
+	before := func(value, id, otherValue, otherID string) bool {
+		if value != otherValue {
+			return (value < otherValue) == f.Ascending
+		}
+		return (id < otherID) == f.Ascending
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type entry struct {
+		t     *template
+		value string
+	}
+	var matching []entry
+	for _, t := range r.templates {
+		if !matchesFilter(t, f) {
+			continue
+		}
+		value := sortValue(t)
+		if f.Cursor != "" && !before(after, afterID, value, t.ID) {
+			continue
+		}
+		matching = append(matching, entry{t, value})
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return before(matching[i].value, matching[i].t.ID, matching[j].value, matching[j].t.ID)
+	})
+
+	if f.Limit > 0 && len(matching) > f.Limit {
+		matching = matching[:f.Limit]
+		last := matching[len(matching)-1]
+		page.NextCursor = models.EncodeCursor(f, last.value, last.t.ID)
+	}
+	for _, e := range matching {
+		t := r.withCategory(e.t)
+		if f.Summary {
+			t.Content = ""
+		}
+		page.Templates = append(page.Templates, t)
+	}
+	return page, nil
+}
+
+func matchesFilter(t *template, f models.TemplateFilter) bool {
+	switch {
+	case !f.IncludeInactive && !t.IsActive,
+		f.CategoryID != 0 && t.CategoryID != f.CategoryID,
+		f.Format != "" && t.Format != f.Format,
+		f.CreatedBy != "" && t.CreatedBy != f.CreatedBy,
+		!f.CreatedFrom.IsZero() && t.CreatedAt.Before(f.CreatedFrom),
+		!f.CreatedTo.IsZero() && !t.CreatedAt.Before(f.CreatedTo),
+		!f.UpdatedFrom.IsZero() && t.UpdatedAt.Before(f.UpdatedFrom),
+		!f.UpdatedTo.IsZero() && !t.UpdatedAt.Before(f.UpdatedTo):
+		return false
+	}
+	return true
+}
+
+// searchTerm is a word or quoted phrase of a search query.
+type searchTerm struct {
+	words   []string
+	exclude bool
+}
+
+// parseQuery splits a web search style query into alternatives separated by
+// "or", each a list of terms that must all match.
+func parseQuery(query string) [][]searchTerm {
+	var alternatives [][]searchTerm
+	var terms []searchTerm
+	parts := strings.Split(query, `"`)
+	for i, part := range parts {
+		if i%2 == 1 {
+			if words := splitWords(part); len(words) > 0 {
+				terms = append(terms, searchTerm{words: words, exclude: strings.HasSuffix(parts[i-1], "-")})
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			if strings.EqualFold(field, "or") {
+				if len(terms) > 0 {
+					alternatives = append(alternatives, terms)
+					terms = nil
+				}
+				continue
+			}
+			if words := splitWords(field); len(words) > 0 {
+				terms = append(terms, searchTerm{words: words, exclude: strings.HasPrefix(field, "-")})
+			}
+		}
+	}
+	if len(terms) > 0 {
+		alternatives = append(alternatives, terms)
+	}
+	return alternatives
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// occurrences counts the places where the words of a term follow each other
+// in words.
+func occurrences(words []string, term []string) int {
+	count := 0
+	for i := 0; i+len(term) <= len(words); i++ {
+		match := true
+		for j, w := range term {
+			if words[i+j] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			count++
+		}
+	}
+	return count
+}
+
+var markup = regexp.MustCompile(`<[^>]*>`)
+
+// searchDocument holds the words of a template by weight, as the
+// template_search document does.
+type searchDocument struct {
+	name, content, variables []string
+}
+
+// rank returns the weighted number of matches of the alternative that fits
+// best, and 0 when none does. The weights are the ts_rank defaults of the
+// name, content and variables weight classes.
+func (d searchDocument) rank(alternatives [][]searchTerm) float64 {
+	best := 0.0
+	for _, terms := range alternatives {
+		rank := 0.0
+		for _, term := range terms {
+			n := occurrences(d.name, term.words)
+			c := occurrences(d.content, term.words)
+			v := occurrences(d.variables, term.words)
+			if term.exclude != (n+c+v == 0) {
+				rank = 0
+				break
+			}
+			rank += 1.0*float64(n) + 0.4*float64(c) + 0.2*float64(v)
+		}
+		if rank > best {
+			best = rank
+		}
+	}
+	return best
+}
+
+// highlightWords escapes fields and joins them with spaces, wrapping the
+// fields containing a searched word in <mark>.
+func highlightWords(fields []string, searched map[string]bool) string {
+	out := make([]string, len(fields))
+	for i, field := range fields {
+		out[i] = html.EscapeString(field)
+		for _, w := range splitWords(field) {
+			if searched[w] {
+				out[i] = "<mark>" + out[i] + "</mark>"
+				break
+			}
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// snippet returns up to 25 words of content around the first searched word.
+func snippet(content string, searched map[string]bool) string {
+	fields := strings.Fields(markup.ReplaceAllString(content, " "))
+	start := 0
+	for i, field := range fields {
+		found := false
+		for _, w := range splitWords(field) {
+			found = found || searched[w]
+		}
+		if found {
+			start = i - 8
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + 25
+	if end > len(fields) {
+		end = len(fields)
+	}
+	return highlightWords(fields[start:end], searched)
+}
+
+// Search matches whole words where Postgres matches English stems and skips
+// stop words, so "invoices" does not find "invoice" and "the" must occur.
+func (r templates) Search(ctx context.Context, query string, limit, offset int) ([]models.TemplateSearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	alternatives := parseQuery(query)
+	searched := make(map[string]bool)
+	for _, terms := range alternatives {
+		for _, term := range terms {
+			for _, w := range term.words {
+				searched[w] = searched[w] || !term.exclude
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := []models.TemplateSearchResult{}
+	for _, t := range r.templates {
+		if !t.IsActive {
+			continue
+		}
+		doc := searchDocument{
+			name:    splitWords(t.Name),
+			content: splitWords(markup.ReplaceAllString(t.Content, " ")),
+		}
+		for _, v := range r.variables {
+			if v.TemplateID == t.ID {
+				doc.variables = append(doc.variables, splitWords(v.VariableName+" "+v.Description)...)
+			}
+		}
+		rank := doc.rank(alternatives)
+		if rank == 0 {
+			continue
+		}
+		results = append(results, models.TemplateSearchResult{
+			ID:              t.ID,
+			Name:            t.Name,
+			HighlightedName: highlightWords(strings.Fields(t.Name), searched),
+			CategoryID:      t.CategoryID,
+			CategoryName:    r.categories[t.CategoryID].Name,
+			Format:          t.Format,
+			Version:         t.Version,
+			CreatedAt:       t.CreatedAt,
+			Rank:            rank,
+			Snippet:         snippet(t.Content, searched),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	if offset > len(results) {
+		offset = len(results)
+	}
+	results = results[offset:]
+	if limit < len(results) {
+		results = results[:limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,1023 @@
+// Package memory implements the model repositories in memory, with the
+// semantics of the Postgres implementation: soft delete, version bumps on
+// update, unique and foreign key constraints, cascading purges and the audit
+// log kept by the triggers. Search matches whole words instead of English
+// stems, and the schema is the one the embedded migrations describe. It lets
+// handlers run without a database. Calls
+// with a done context fail with its error, as queries would.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// systemUser is recorded in the audit log for changes made without a user,
+// like the audit trigger does when app.current_user_id is not set.
+const systemUser = "system"
+
+type template struct {
+	models.Template
+	DeletedAt time.Time
+	DeletedBy string
+}
+
+// store holds the rows of every table behind one lock, so that changes
+// spanning tables, like purges and category reassignment, are atomic.
+type store struct {
+	mu sync.Mutex
+
+	templates  map[string]*template
+	categories map[int]*models.TemplateCategory
+	variables  map[int]*models.TemplateVariable
+	samples    map[int]*models.TemplateSample
+	config     map[int]*models.TemplateConfig
+	versions   map[int]*models.TemplateVersion
+	settings   map[string]*models.Configuration
+	syncStates map[string]*models.SyncState
+	audit      []models.AuditEntry
+
+	// lastID holds the last value of each table's serial ID.
+	lastID map[string]int
+}
+
+// New returns empty repositories sharing one store.
+func New() models.Repositories {
+	s := &store{
+		templates:  make(map[string]*template),
+		categories: make(map[int]*models.TemplateCategory),
+		variables:  make(map[int]*models.TemplateVariable),
+		samples:    make(map[int]*models.TemplateSample),
+		config:     make(map[int]*models.TemplateConfig),
+		versions:   make(map[int]*models.TemplateVersion),
+		settings:   make(map[string]*models.Configuration),
+		syncStates: make(map[string]*models.SyncState),
+		lastID:     make(map[string]int),
+	}
+	return models.Repositories{
+		Templates:  templates{s},
+		Variables:  variables{s},
+		Samples:    samples{s},
+		Categories: categories{s},
+		Versions:   versions{s},
+		Config:     config{s},
+		Settings:   settings{s},
+		Audit:      audit{s},
+		Bundles:    bundles{s},
+		SyncStates: syncStates{s},
+		Schema:     schema{},
+	}
+}
+
+func (s *store) nextID(table string) int {
+	s.lastID[table]++
+	return s.lastID[table]
+}
+
+// log records a change in the audit log. Either row is nil for inserts and
+// deletes.
+func (s *store) log(entityType, entityID, action, userID string, oldRow, newRow interface{}) {
+	if userID == "" {
+		userID = systemUser
+	}
+	s.audit = append(s.audit, models.AuditEntry{
+		ID:         s.nextID("audit_log"),
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		UserID:     userID,
+		Old:        rowJSON(oldRow),
+		New:        rowJSON(newRow),
+		Timestamp:  time.Now(),
+	})
+}
+
+func rowJSON(row interface{}) json.RawMessage {
+	data, err := json.Marshal(row)
+	if err != nil {
+		// The rows are maps of strings, numbers, booleans and times.
+		panic(err)
+	}
+	return data
+}
+
+// templateRow returns a template as the audit trigger records it.
+func templateRow(t *template) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          t.ID,
+		"name":        t.Name,
+		"category_id": t.CategoryID,
+		"content":     t.Content,
+		"format":      t.Format,
+		"version":     t.Version,
+		"is_active":   t.IsActive,
+		"created_by":  t.CreatedBy,
+		"created_at":  t.CreatedAt,
+		"updated_by":  nullString(t.UpdatedBy),
+		"updated_at":  t.UpdatedAt,
+		"deleted_by":  nullString(t.DeletedBy),
+		"deleted_at":  nullTime(t.DeletedAt),
+	}
+}
+
+func configRow(c *models.TemplateConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           c.ID,
+		"template_id":  c.TemplateID,
+		"config_key":   c.ConfigKey,
+		"config_value": c.ConfigValue,
+		"description":  c.Description,
+	}
+}
+
+func versionRow(v *models.TemplateVersion) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           v.ID,
+		"template_id":  v.TemplateID,
+		"version":      v.Version,
+		"content":      v.Content,
+		"format":       v.Format,
+		"created_by":   v.CreatedBy,
+		"created_at":   v.CreatedAt,
+		"change_notes": v.ChangeNotes,
+	}
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// uniqueViolation, foreignKeyViolation and invalidInput return the errors
+// Postgres reports, so callers can check them with models.IsUniqueViolation
+// or pq.Error codes whichever implementation they use.
+func uniqueViolation(constraint string) error {
+	return &pq.Error{
+		Code:       "23505",
+		Message:    fmt.Sprintf("duplicate key value violates unique constraint %q", constraint),
+		Constraint: constraint,
+	}
+}
+
+func foreignKeyViolation(table, constraint string) error {
+	return &pq.Error{
+		Code:       "23503",
+		Message:    fmt.Sprintf("insert or update on table %q violates foreign key constraint %q", table, constraint),
+		Table:      table,
+		Constraint: constraint,
+	}
+}
+
+func invalidInput(typ, value string) error {
+	return &pq.Error{
+		Code:    "22P02",
+		Message: fmt.Sprintf("invalid input syntax for type %s: %q", typ, value),
+	}
+}
+
+// parseTemplateID returns the canonical form of a template ID, which
+// Postgres accepts in any case and with or without braces.
+func parseTemplateID(id string) (string, error) {
+	u, err := uuid.Parse(id)
+	if err != nil {
+		return "", invalidInput("uuid", id)
+	}
+	return u.String(), nil
+}
+
+func parseCategoryID(id string) (int, error) {
+	n, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return 0, invalidInput("integer", id)
+	}
+	return int(n), nil
+}
+
+// template returns the stored template with the given ID.
+func (s *store) template(id string) (*template, error) {
+	id, err := parseTemplateID(id)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := s.templates[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+// templateRef returns the template a row references, or the foreign key
+// violation of the referencing table.
+func (s *store) templateRef(id, table string) (*template, error) {
+	t, err := s.template(id)
+	if err == sql.ErrNoRows {
+		return nil, foreignKeyViolation(table, table+"_template_id_fkey")
+	}
+	return t, err
+}
+
+func (s *store) categoryRef(id string) (int, error) {
+	categoryID, err := parseCategoryID(id)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := s.categories[categoryID]; !ok {
+		return 0, foreignKeyViolation("template", "template_category_id_fkey")
+	}
+	return categoryID, nil
+}
+
+type templates struct{ *store }
+
+func (r templates) Get(ctx context.Context, id string) (models.Template, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Template{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.template(id)
+	if err != nil {
+		return models.Template{}, err
+	}
+	return r.withCategory(t), nil
+}
+
+func (r templates) withCategory(t *template) models.Template {
+	result := t.Template
+	result.CategoryName = r.categories[t.CategoryID].Name
+	return result
+}
+
+func (r templates) List(ctx context.Context) ([]models.Template, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var list []models.Template
+	for _, t := range r.templates {
+		if t.IsActive {
+			list = append(list, r.withCategory(t))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list, nil
+}
+
+func (r templates) Create(ctx context.Context, name, categoryID, content, format, createdBy string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	category, err := r.categoryRef(categoryID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	t := &template{Template: models.Template{
+		ID:         uuid.New().String(),
+		Name:       name,
+		CategoryID: category,
+		Content:    content,
+		Format:     format,
+		Version:    1,
+		IsActive:   true,
+		CreatedBy:  createdBy,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}}
+	r.templates[t.ID] = t
+	r.log("template", t.ID, models.AuditInsert, "", nil, templateRow(t))
+	return t.ID, nil
+}
+
+// Update changes a template whether it is active or not. Like the Postgres
+// implementation it does not report unknown IDs.
+func (r templates) Update(ctx context.Context, id, name, categoryID, content, format, updatedBy string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	category, err := r.categoryRef(categoryID)
+	if err != nil {
+		return err
+	}
+	t, err := r.template(id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	old := templateRow(t)
+	t.Name, t.CategoryID, t.Content, t.Format = name, category, content, format
+	t.UpdatedBy, t.UpdatedAt = updatedBy, time.Now()
+	t.Version++
+	r.log("template", t.ID, models.AuditUpdate, "", old, templateRow(t))
+	return nil
+}
+
+func (r templates) Delete(ctx context.Context, id, deletedBy string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.template(id)
+	if err != nil {
+		return err
+	}
+	if !t.IsActive {
+		return sql.ErrNoRows
+	}
+
+	old := templateRow(t)
+	now := time.Now()
+	t.IsActive = false
+	t.UpdatedAt, t.DeletedAt, t.DeletedBy = now, now, deletedBy
+	r.log("template", t.ID, models.AuditUpdate, deletedBy, old, templateRow(t))
+	return nil
+}
+
+func (r templates) Trashed(ctx context.Context) ([]models.TrashedTemplate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var list []models.TrashedTemplate
+	for _, t := range r.templates {
+		if t.IsActive {
+			continue
+		}
+		list = append(list, models.TrashedTemplate{
+			ID:           t.ID,
+			Name:         t.Name,
+			CategoryID:   t.CategoryID,
+			CategoryName: r.categories[t.CategoryID].Name,
+			Format:       t.Format,
+			Version:      t.Version,
+			DeletedBy:    t.DeletedBy,
+			DeletedAt:    trashedAt(t),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].DeletedAt.Equal(list[j].DeletedAt) {
+			return list[i].DeletedAt.After(list[j].DeletedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// trashedAt is when a template was moved to the trash, falling back to its
+// last change for templates deleted before deleted_at was recorded.
+func trashedAt(t *template) time.Time {
+	switch {
+	case !t.DeletedAt.IsZero():
+		return t.DeletedAt
+	case !t.UpdatedAt.IsZero():
+		return t.UpdatedAt
+	}
+	return t.CreatedAt
+}
+
+func (r templates) Restore(ctx context.Context, id, restoredBy string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.template(id)
+	if err != nil {
+		return err
+	}
+	if t.IsActive {
+		return sql.ErrNoRows
+	}
+
+	old := templateRow(t)
+	t.IsActive = true
+	t.DeletedAt, t.DeletedBy = time.Time{}, ""
+	t.UpdatedBy, t.UpdatedAt = restoredBy, time.Now()
+	r.log("template", t.ID, models.AuditUpdate, restoredBy, old, templateRow(t))
+	return nil
+}
+
+func (r templates) Purge(ctx context.Context, id, purgedBy string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.template(id)
+	if err != nil {
+		return err
+	}
+	if t.IsActive {
+		return sql.ErrNoRows
+	}
+	r.purge(t, purgedBy)
+	return nil
+}
+
+func (r templates) PurgeDeleted(ctx context.Context, cutoff time.Time, purgedBy string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged int64
+	for _, t := range r.templates {
+		if !t.IsActive && trashedAt(t).Before(cutoff) {
+			r.purge(t, purgedBy)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// purge deletes a template and, like ON DELETE CASCADE, the rows that
+// reference it.
+func (s *store) purge(t *template, purgedBy string) {
+	delete(s.templates, t.ID)
+	s.log("template", t.ID, models.AuditDelete, purgedBy, templateRow(t), nil)
+
+	for id, v := range s.variables {
+		if v.TemplateID == t.ID {
+			delete(s.variables, id)
+		}
+	}
+	for id, sample := range s.samples {
+		if sample.TemplateID == t.ID {
+			delete(s.samples, id)
+			s.log("template_sample", strconv.Itoa(id), models.AuditDelete, purgedBy, sampleRow(sample), nil)
+		}
+	}
+	for id, c := range s.config {
+		if c.TemplateID == t.ID {
+			delete(s.config, id)
+			s.log("template_config", strconv.Itoa(id), models.AuditDelete, purgedBy, configRow(c), nil)
+		}
+	}
+	for id, v := range s.versions {
+		if v.TemplateID == t.ID {
+			delete(s.versions, id)
+			s.log("template_version", strconv.Itoa(id), models.AuditDelete, purgedBy, versionRow(v), nil)
+		}
+	}
+	delete(s.syncStates, t.ID)
+}
+
+type variables struct{ *store }
+
+func (r variables) List(ctx context.Context, templateID string) ([]models.TemplateVariable, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return nil, err
+	}
+	return r.templateVariables(id), nil
+}
+
+// templateVariables returns the variables of a template in the order they
+// were added.
+func (s *store) templateVariables(templateID string) []models.TemplateVariable {
+	var list []models.TemplateVariable
+	for _, v := range s.variables {
+		if v.TemplateID == templateID {
+			list = append(list, *v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// byName returns the variable of a template with the given name.
+func (r variables) byName(templateID, name string) *models.TemplateVariable {
+	for _, v := range r.variables {
+		if v.TemplateID == templateID && v.VariableName == name {
+			return v
+		}
+	}
+	return nil
+}
+
+func (r variables) Add(ctx context.Context, templateID, variableName, description, defaultValue string, isRequired bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.templateRef(templateID, "template_variable")
+	if err != nil {
+		return err
+	}
+	if r.byName(t.ID, variableName) != nil {
+		return uniqueViolation("uk_template_id_variable_name")
+	}
+	r.insert(models.TemplateVariable{
+		TemplateID:   t.ID,
+		VariableName: variableName,
+		Description:  description,
+		DefaultValue: defaultValue,
+		IsRequired:   isRequired,
+	})
+	return nil
+}
+
+func (r variables) insert(v models.TemplateVariable) {
+	if v.VariableType == "" {
+		v.VariableType = "string"
+	}
+	v.ID = r.nextID("template_variable")
+	r.variables[v.ID] = &v
+}
+
+func (r variables) Update(ctx context.Context, templateID string, variableID int, v models.TemplateVariable) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return err
+	}
+	existing, ok := r.variables[variableID]
+	if !ok || existing.TemplateID != id {
+		return sql.ErrNoRows
+	}
+	if other := r.byName(id, v.VariableName); other != nil && other.ID != variableID {
+		return uniqueViolation("uk_template_id_variable_name")
+	}
+
+	if v.VariableType == "" {
+		v.VariableType = "string"
+	}
+	v.ID, v.TemplateID = variableID, id
+	*existing = v
+	return nil
+}
+
+func (r variables) Delete(ctx context.Context, templateID string, variableID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return err
+	}
+	existing, ok := r.variables[variableID]
+	if !ok || existing.TemplateID != id {
+		return sql.ErrNoRows
+	}
+	delete(r.variables, variableID)
+	return nil
+}
+
+// Replace matches variables by name, so existing ones keep their ID. A later
+// variable with a name already in the list updates the earlier one.
+func (r variables) Replace(ctx context.Context, templateID string, list []models.TemplateVariable) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return err
+	}
+	if _, ok := r.templates[id]; !ok && len(list) > 0 {
+		return foreignKeyViolation("template_variable", "template_variable_template_id_fkey")
+	}
+
+	keep := make(map[string]bool, len(list))
+	for _, v := range list {
+		keep[v.VariableName] = true
+	}
+	for variableID, v := range r.variables {
+		if v.TemplateID == id && !keep[v.VariableName] {
+			delete(r.variables, variableID)
+		}
+	}
+
+	for _, v := range list {
+		if v.VariableType == "" {
+			v.VariableType = "string"
+		}
+		existing := r.byName(id, v.VariableName)
+		if existing == nil {
+			v.TemplateID = id
+			r.insert(v)
+			continue
+		}
+		existing.Description, existing.DefaultValue = v.Description, v.DefaultValue
+		existing.IsRequired, existing.VariableType = v.IsRequired, v.VariableType
+	}
+	return nil
+}
+
+type categories struct{ *store }
+
+func (r categories) withCount(c *models.TemplateCategory) models.TemplateCategory {
+	result := *c
+	result.TemplateCount = 0
+	for _, t := range r.templates {
+		if t.CategoryID == c.ID {
+			result.TemplateCount++
+		}
+	}
+	return result
+}
+
+func (r categories) List(ctx context.Context) ([]models.TemplateCategory, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var list []models.TemplateCategory
+	for _, c := range r.categories {
+		list = append(list, r.withCount(c))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (r categories) Get(ctx context.Context, id int) (models.TemplateCategory, error) {
+	if err := ctx.Err(); err != nil {
+		return models.TemplateCategory{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.categories[id]
+	if !ok {
+		return models.TemplateCategory{}, sql.ErrNoRows
+	}
+	return r.withCount(c), nil
+}
+
+func (r categories) byName(name string) *models.TemplateCategory {
+	for _, c := range r.categories {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (r categories) Create(ctx context.Context, name, description string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.byName(name) != nil {
+		return 0, uniqueViolation("template_category_name_key")
+	}
+	c := &models.TemplateCategory{ID: r.nextID("template_category"), Name: name, Description: description}
+	r.categories[c.ID] = c
+	return c.ID, nil
+}
+
+func (r categories) Update(ctx context.Context, id int, name, description string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.categories[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if other := r.byName(name); other != nil && other.ID != id {
+		return uniqueViolation("template_category_name_key")
+	}
+	c.Name, c.Description = name, description
+	return nil
+}
+
+func (r categories) Delete(ctx context.Context, id, reassignTo int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if reassignTo == id {
+		return fmt.Errorf("%w: it is the category being deleted", models.ErrInvalidReassignment)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.categories[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	var used []*template
+	for _, t := range r.templates {
+		if t.CategoryID == id {
+			used = append(used, t)
+		}
+	}
+
+	if reassignTo != 0 {
+		if _, ok := r.categories[reassignTo]; !ok {
+			return fmt.Errorf("%w: category %d does not exist", models.ErrInvalidReassignment, reassignTo)
+		}
+		for _, t := range used {
+			old := templateRow(t)
+			t.CategoryID, t.UpdatedAt = reassignTo, time.Now()
+			r.log("template", t.ID, models.AuditUpdate, "", old, templateRow(t))
+		}
+		used = nil
+	}
+
+	if len(used) > 0 {
+		return fmt.Errorf("%w: %d template(s)", models.ErrCategoryInUse, len(used))
+	}
+	delete(r.categories, id)
+	return nil
+}
+
+type versions struct{ *store }
+
+func (r versions) List(ctx context.Context, templateID string) ([]models.TemplateVersion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return nil, err
+	}
+	return r.templateVersions(id), nil
+}
+
+func (s *store) templateVersions(templateID string) []models.TemplateVersion {
+	var list []models.TemplateVersion
+	for _, v := range s.versions {
+		if v.TemplateID == templateID {
+			list = append(list, *v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+func (r versions) Create(ctx context.Context, v models.TemplateVersion) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.templateRef(v.TemplateID, "template_version")
+	if err != nil {
+		return 0, err
+	}
+	for _, existing := range r.versions {
+		if existing.TemplateID == t.ID && existing.Version == v.Version {
+			return 0, uniqueViolation("uk_template_id_version")
+		}
+	}
+
+	v.TemplateID = t.ID
+	return r.insertVersion(v, ""), nil
+}
+
+// insertVersion stores a version of a template, recording userID in the
+// audit log, and returns its ID.
+func (s *store) insertVersion(v models.TemplateVersion, userID string) int {
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	v.ID = s.nextID("template_version")
+	s.versions[v.ID] = &v
+	s.log("template_version", strconv.Itoa(v.ID), models.AuditInsert, userID, nil, versionRow(&v))
+	return v.ID
+}
+
+// At looks for the version in the audit log first, then in the stored
+// versions, like models.GetTemplateAtVersion.
+func (r versions) At(ctx context.Context, templateID string, version int) (models.Template, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Template{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := models.Template{ID: templateID, Version: version}
+	for i := len(r.audit) - 1; i >= 0; i-- {
+		e := r.audit[i]
+		if e.EntityType != "template" || e.EntityID != templateID {
+			continue
+		}
+		var row struct {
+			Name    string `json:"name"`
+			Content string `json:"content"`
+			Format  string `json:"format"`
+			Version *int   `json:"version"`
+		}
+		if err := json.Unmarshal(e.New, &row); err != nil {
+			return result, err
+		}
+		if row.Version != nil && *row.Version == version {
+			result.Name, result.Content, result.Format = row.Name, row.Content, row.Format
+			return result, nil
+		}
+	}
+
+	t, err := r.template(templateID)
+	if err != nil {
+		return result, err
+	}
+	for _, v := range r.versions {
+		if v.TemplateID == t.ID && v.Version == version {
+			result.Name, result.Content, result.Format = t.Name, v.Content, v.Format
+			return result, nil
+		}
+	}
+	return result, sql.ErrNoRows
+}
+
+type config struct{ *store }
+
+func (r config) List(ctx context.Context, templateID string) ([]models.TemplateConfig, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return nil, err
+	}
+	return r.templateConfig(id), nil
+}
+
+func (s *store) templateConfig(templateID string) []models.TemplateConfig {
+	var list []models.TemplateConfig
+	for _, c := range s.config {
+		if c.TemplateID == templateID {
+			list = append(list, *c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ConfigKey < list[j].ConfigKey })
+	return list
+}
+
+func (r config) byKey(templateID, key string) *models.TemplateConfig {
+	for _, c := range r.config {
+		if c.TemplateID == templateID && c.ConfigKey == key {
+			return c
+		}
+	}
+	return nil
+}
+
+func (r config) Set(ctx context.Context, templateID, key, value, description string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.templateRef(templateID, "template_config")
+	if err != nil {
+		return err
+	}
+
+	if c := r.byKey(t.ID, key); c != nil {
+		old := configRow(c)
+		c.ConfigValue, c.Description = value, description
+		r.log("template_config", strconv.Itoa(c.ID), models.AuditUpdate, "", old, configRow(c))
+		return nil
+	}
+
+	c := &models.TemplateConfig{
+		ID:          r.nextID("template_config"),
+		TemplateID:  t.ID,
+		ConfigKey:   key,
+		ConfigValue: value,
+		Description: description,
+	}
+	r.config[c.ID] = c
+	r.log("template_config", strconv.Itoa(c.ID), models.AuditInsert, "", nil, configRow(c))
+	return nil
+}
+
+func (r config) Delete(ctx context.Context, templateID, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return err
+	}
+	if c := r.byKey(id, key); c != nil {
+		delete(r.config, c.ID)
+		r.log("template_config", strconv.Itoa(c.ID), models.AuditDelete, "", configRow(c), nil)
+	}
+	return nil
+}
+
+type audit struct{ *store }
+
+func (r audit) Entries(ctx context.Context, entityType, entityID string) ([]models.AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var entries []models.AuditEntry
+	for _, e := range r.audit {
+		if e.EntityType == entityType && e.EntityID == entityID {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
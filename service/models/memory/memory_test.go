@@ -0,0 +1,12 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models/memory"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, memory.New)
+}
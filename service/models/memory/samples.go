@@ -0,0 +1,217 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+)
+
+func sampleRow(s *models.TemplateSample) map[string]interface{} {
+	row := map[string]interface{}{
+		"id":                  s.ID,
+		"template_id":         s.TemplateID,
+		"name":                s.Name,
+		"description":         s.Description,
+		"variables":           s.Variables,
+		"expected_output":     nil,
+		"expected_updated_at": nullTime(s.ExpectedUpdatedAt),
+		"created_by":          nullString(s.CreatedBy),
+		"created_at":          s.CreatedAt,
+		"updated_at":          s.UpdatedAt,
+	}
+	if s.HasExpectedOutput {
+		row["expected_output"] = s.ExpectedOutput
+	}
+	return row
+}
+
+type samples struct{ *store }
+
+func (r samples) List(ctx context.Context, templateID string) ([]models.TemplateSample, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return nil, err
+	}
+	var list []models.TemplateSample
+	for _, s := range r.samples {
+		if s.TemplateID == id {
+			list = append(list, copySample(s))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// copySample returns a sample that does not share its variables with the
+// stored one.
+func copySample(s *models.TemplateSample) models.TemplateSample {
+	result := *s
+	result.Variables = copyVariables(s.Variables)
+	return result
+}
+
+func copyVariables(variables map[string]string) map[string]string {
+	result := make(map[string]string, len(variables))
+	for k, v := range variables {
+		result[k] = v
+	}
+	return result
+}
+
+func (r samples) byName(templateID, name string) *models.TemplateSample {
+	for _, s := range r.samples {
+		if s.TemplateID == templateID && s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (r samples) Get(ctx context.Context, templateID, name string) (models.TemplateSample, error) {
+	if err := ctx.Err(); err != nil {
+		return models.TemplateSample{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return models.TemplateSample{}, err
+	}
+	s := r.byName(id, name)
+	if s == nil {
+		return models.TemplateSample{}, sql.ErrNoRows
+	}
+	return copySample(s), nil
+}
+
+func (r samples) Create(ctx context.Context, templateID, name, description string, variables map[string]string,
+	expectedOutput *string, createdBy string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.templateRef(templateID, "template_sample")
+	if err != nil {
+		return err
+	}
+	if r.byName(t.ID, name) != nil {
+		return uniqueViolation("uk_template_id_sample_name")
+	}
+	r.insert(t.ID, name, description, variables, expectedOutput, createdBy)
+	return nil
+}
+
+func (r samples) insert(templateID, name, description string, variables map[string]string,
+	expectedOutput *string, createdBy string) {
+	now := time.Now()
+	s := &models.TemplateSample{
+		ID:          r.nextID("template_sample"),
+		TemplateID:  templateID,
+		Name:        name,
+		Description: description,
+		Variables:   copyVariables(variables),
+		CreatedBy:   createdBy,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	setExpectedOutput(s, expectedOutput, now)
+	r.samples[s.ID] = s
+	r.log("template_sample", strconv.Itoa(s.ID), models.AuditInsert, "", nil, sampleRow(s))
+}
+
+func setExpectedOutput(s *models.TemplateSample, expectedOutput *string, now time.Time) {
+	if expectedOutput == nil {
+		s.HasExpectedOutput, s.ExpectedOutput, s.ExpectedUpdatedAt = false, "", time.Time{}
+		return
+	}
+	s.HasExpectedOutput, s.ExpectedOutput, s.ExpectedUpdatedAt = true, *expectedOutput, now
+}
+
+func (r samples) Set(ctx context.Context, templateID, name, description string, variables map[string]string,
+	expectedOutput *string, updatedBy string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.templateRef(templateID, "template_sample")
+	if err != nil {
+		return err
+	}
+	s := r.byName(t.ID, name)
+	if s == nil {
+		r.insert(t.ID, name, description, variables, expectedOutput, updatedBy)
+		return nil
+	}
+
+	old := sampleRow(s)
+	now := time.Now()
+	s.Description, s.Variables, s.UpdatedAt = description, copyVariables(variables), now
+	if expectedOutput != nil {
+		setExpectedOutput(s, expectedOutput, now)
+	}
+	r.log("template_sample", strconv.Itoa(s.ID), models.AuditUpdate, "", old, sampleRow(s))
+	return nil
+}
+
+func (r samples) SetExpectedOutput(ctx context.Context, templateID, name string, expectedOutput *string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return err
+	}
+	s := r.byName(id, name)
+	if s == nil {
+		return sql.ErrNoRows
+	}
+
+	old := sampleRow(s)
+	setExpectedOutput(s, expectedOutput, time.Now())
+	r.log("template_sample", strconv.Itoa(s.ID), models.AuditUpdate, "", old, sampleRow(s))
+	return nil
+}
+
+func (r samples) Delete(ctx context.Context, templateID, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return err
+	}
+	s := r.byName(id, name)
+	if s == nil {
+		return sql.ErrNoRows
+	}
+	delete(r.samples, s.ID)
+	r.log("template_sample", strconv.Itoa(s.ID), models.AuditDelete, "", sampleRow(s), nil)
+	return nil
+}
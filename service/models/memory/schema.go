@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+)
+
+// schema reports the schema the embedded migrations describe, as if all of
+// them had been applied without a history table to show for it.
+type schema struct{}
+
+func (schema) Version(ctx context.Context) (string, error) {
+	status, err := schema{}.Status(ctx, "production")
+	return status.Version, err
+}
+
+func (schema) Status(ctx context.Context, environment string) (models.SchemaStatus, error) {
+	status := models.SchemaStatus{
+		SystemInfo:    []models.SystemInfo{},
+		HistoryTables: []models.MigrationHistory{},
+		Pending:       []models.PendingMigration{},
+	}
+	if err := ctx.Err(); err != nil {
+		return status, err
+	}
+
+	migrations, err := db.LoadMigrations(environment)
+	if err != nil {
+		return status, err
+	}
+	for _, m := range migrations {
+		version := m.SchemaVersion()
+		if version == "" {
+			continue
+		}
+		status.SystemInfo = append(status.SystemInfo, models.SystemInfo{Version: version, Description: m.Description})
+		if status.Version == "" {
+			status.Version = version
+			continue
+		}
+		newer, err := db.CompareSchemaVersions(version, status.Version)
+		if err != nil {
+			return status, err
+		}
+		if newer > 0 {
+			status.Version = version
+		}
+	}
+	return status, nil
+}
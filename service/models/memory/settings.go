@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+)
+
+func settingRow(c *models.Configuration) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              c.ID,
+		"config_key":      c.ConfigKey,
+		"config_value":    c.ConfigValue,
+		"description":     c.Description,
+		"is_encrypted":    c.IsEncrypted,
+		"last_updated_by": nullString(c.LastUpdatedBy),
+		"last_updated_at": c.LastUpdatedAt,
+	}
+}
+
+type settings struct{ *store }
+
+func (r settings) List(ctx context.Context) ([]models.Configuration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var list []models.Configuration
+	for _, c := range r.settings {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ConfigKey < list[j].ConfigKey })
+	return list, nil
+}
+
+func (r settings) Values(ctx context.Context, prefix string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	values := make(map[string]string)
+	for key, c := range r.settings {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if c.IsEncrypted && c.ConfigValue != "" {
+			decrypted, err := models.DecryptConfigValue(c.ConfigValue)
+			if err != nil {
+				return nil, fmt.Errorf("decrypting %s: %w", key, err)
+			}
+			values[key] = decrypted
+			continue
+		}
+		values[key] = c.ConfigValue
+	}
+	return values, nil
+}
+
+// Set keeps the description of an existing key when description is empty,
+// like models.SetConfigValue.
+func (r settings) Set(ctx context.Context, key, value, description string, encrypted bool, updatedBy string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stored := value
+	if encrypted && value != "" {
+		var err error
+		stored, err = models.EncryptConfigValue(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if c, ok := r.settings[key]; ok {
+		old := settingRow(c)
+		c.ConfigValue, c.IsEncrypted = stored, encrypted
+		if description != "" {
+			c.Description = description
+		}
+		c.LastUpdatedBy, c.LastUpdatedAt = updatedBy, now
+		r.log("configuration", strconv.Itoa(c.ID), models.AuditUpdate, "", old, settingRow(c))
+		return nil
+	}
+
+	c := &models.Configuration{
+		ID:            r.nextID("configuration"),
+		ConfigKey:     key,
+		ConfigValue:   stored,
+		Description:   description,
+		IsEncrypted:   encrypted,
+		LastUpdatedBy: updatedBy,
+		LastUpdatedAt: now,
+	}
+	r.settings[key] = c
+	r.log("configuration", strconv.Itoa(c.ID), models.AuditInsert, "", nil, settingRow(c))
+	return nil
+}
+
+func (r settings) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.settings[key]; ok {
+		delete(r.settings, key)
+		r.log("configuration", strconv.Itoa(c.ID), models.AuditDelete, "", settingRow(c), nil)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+)
+
+type syncStates struct{ *store }
+
+func (r syncStates) List(ctx context.Context) (map[string]models.SyncState, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	states := make(map[string]models.SyncState, len(r.syncStates))
+	for id, s := range r.syncStates {
+		states[id] = *s
+	}
+	return states, nil
+}
+
+func (r syncStates) Set(ctx context.Context, templateID, sourcePath, contentHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.templateRef(templateID, "template_sync_state")
+	if err != nil {
+		return err
+	}
+	r.syncStates[t.ID] = &models.SyncState{
+		TemplateID:  t.ID,
+		SourcePath:  sourcePath,
+		ContentHash: contentHash,
+		SyncedAt:    time.Now(),
+	}
+	return nil
+}
+
+func (r syncStates) Delete(ctx context.Context, templateID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, err := parseTemplateID(templateID)
+	if err != nil {
+		return err
+	}
+	delete(r.syncStates, id)
+	return nil
+}
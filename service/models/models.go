@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"time"
@@ -35,65 +36,27 @@ type TemplateVariable struct {
 }
 
 type TemplateCategory struct {
-	ID          int
-	Name        string
-	Description string
+	ID            int
+	Name          string
+	Description   string
+	TemplateCount int
 }
 
-func GetTemplates() ([]Template, error) {
-	rows, err := db.DB.Query(`
-		SELECT 
-			t.id, t.name, t.category_id, t.content, t.format, 
-			t.version, t.is_active, t.created_by, t.created_at, 
-			t.updated_by, t.updated_at, c.name as category_name
-		FROM template_service.template t
-		JOIN template_service.template_category c ON t.category_id = c.id
-		WHERE t.is_active = true
-		ORDER BY t.created_at DESC
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		if closeErr := rows.Close(); closeErr != nil {
-			log.Printf("Error closing rows: %v", closeErr)
-		}
-	}()
-
-	var templates []Template
-	for rows.Next() {
-		var t Template
-		var updatedBy sql.NullString
-		var updatedAt sql.NullTime
-
-		err := rows.Scan(
-			&t.ID, &t.Name, &t.CategoryID, &t.Content, &t.Format,
-			&t.Version, &t.IsActive, &t.CreatedBy, &t.CreatedAt,
-			&updatedBy, &updatedAt, &t.CategoryName,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		if updatedBy.Valid {
-			t.UpdatedBy = updatedBy.String
-		}
-		if updatedAt.Valid {
-			t.UpdatedAt = updatedAt.Time
-		}
-
-		templates = append(templates, t)
-	}
-
-	return templates, nil
+// GetTemplates returns every active template, newest first.
+func GetTemplates(ctx context.Context) ([]Template, error) {
+	page, err := ListTemplates(ctx, TemplateFilter{})
+	return page.Templates, err
 }
 
-func GetTemplateByID(id string) (Template, error) {
+func GetTemplateByID(ctx context.Context, id string) (Template, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
 	var t Template
 	var updatedBy sql.NullString
 	var updatedAt sql.NullTime
 
-	err := db.DB.QueryRow(`
+	err := db.DB.QueryRowContext(ctx, `
 		SELECT 
 			t.id, t.name, t.category_id, t.content, t.format, 
 			t.version, t.is_active, t.created_by, t.created_at, 
@@ -121,8 +84,11 @@ func GetTemplateByID(id string) (Template, error) {
 	return t, nil
 }
 
-func GetTemplateVariables(templateID string) ([]TemplateVariable, error) {
-	rows, err := db.DB.Query(`
+func GetTemplateVariables(ctx context.Context, templateID string) ([]TemplateVariable, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
 		SELECT 
 			id, template_id, variable_name, description, 
 			default_value, is_required, variable_type
@@ -155,11 +121,18 @@ func GetTemplateVariables(templateID string) ([]TemplateVariable, error) {
 	return variables, nil
 }
 
-func GetTemplateCategories() ([]TemplateCategory, error) {
-	rows, err := db.DB.Query(`
-		SELECT id, name, description
-		FROM template_service.template_category
-		ORDER BY name
+// GetTemplateCategories returns every category with the number of templates,
+// active or not, that reference it.
+func GetTemplateCategories(ctx context.Context) ([]TemplateCategory, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT c.id, c.name, c.description, COUNT(t.id)
+		FROM template_service.template_category c
+		LEFT JOIN template_service.template t ON t.category_id = c.id
+		GROUP BY c.id, c.name, c.description
+		ORDER BY c.name
 	`)
 	if err != nil {
 		return nil, err
@@ -173,10 +146,12 @@ func GetTemplateCategories() ([]TemplateCategory, error) {
 	var categories []TemplateCategory
 	for rows.Next() {
 		var c TemplateCategory
-		err := rows.Scan(&c.ID, &c.Name, &c.Description)
+		var description sql.NullString
+		err := rows.Scan(&c.ID, &c.Name, &description, &c.TemplateCount)
 		if err != nil {
 			return nil, err
 		}
+		c.Description = description.String
 
 		categories = append(categories, c)
 	}
@@ -184,9 +159,12 @@ func GetTemplateCategories() ([]TemplateCategory, error) {
 	return categories, nil
 }
 
-func CreateTemplate(name, categoryID, content, format, createdBy string) (string, error) {
+func CreateTemplate(ctx context.Context, name, categoryID, content, format, createdBy string) (string, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
 	templateID := uuid.New().String()
-	_, err := db.DB.Exec(`
+	_, err := db.DB.ExecContext(ctx, `
 		INSERT INTO template_service.template 
 		(id, name, category_id, content, format, version, is_active, created_by) 
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
@@ -199,8 +177,11 @@ func CreateTemplate(name, categoryID, content, format, createdBy string) (string
 	return templateID, nil
 }
 
-func AddTemplateVariable(templateID, variableName, description, defaultValue string, isRequired bool) error {
-	_, err := db.DB.Exec(`
+func AddTemplateVariable(ctx context.Context, templateID, variableName, description, defaultValue string, isRequired bool) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.DB.ExecContext(ctx, `
 		INSERT INTO template_service.template_variable 
 		(template_id, variable_name, description, default_value, is_required) 
 		VALUES ($1, $2, $3, $4, $5)`,
@@ -209,8 +190,11 @@ func AddTemplateVariable(templateID, variableName, description, defaultValue str
 	return err
 }
 
-func UpdateTemplate(id, name, categoryID, content, format, updatedBy string) error {
-	_, err := db.DB.Exec(`
+func UpdateTemplate(ctx context.Context, id, name, categoryID, content, format, updatedBy string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.DB.ExecContext(ctx, `
 		UPDATE template_service.template 
 		SET name = $1, category_id = $2, content = $3, format = $4, 
 		    updated_by = $5, updated_at = CURRENT_TIMESTAMP, version = version + 1
@@ -224,12 +208,93 @@ func UpdateTemplate(id, name, categoryID, content, format, updatedBy string) err
 	return nil
 }
 
-func DeleteTemplate(id string) error {
-	_, err := db.DB.Exec(`
-		UPDATE template_service.template 
-		SET is_active = false, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $1`,
-		id)
+// DeleteTemplate moves a template to the trash. It can be restored with
+// RestoreTemplate until it is purged. It returns sql.ErrNoRows when the
+// template does not exist or is already in the trash.
+func DeleteTemplate(ctx context.Context, id, deletedBy string) error {
+	return asUser(ctx, deletedBy, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE template_service.template 
+			SET is_active = false, updated_at = CURRENT_TIMESTAMP,
+			    deleted_at = CURRENT_TIMESTAMP, deleted_by = $2
+			WHERE id = $1 AND is_active = true`,
+			id, deletedBy)
+		if err != nil {
+			return err
+		}
+		return requireAffected(result)
+	})
+}
+
+type TemplateConfig struct {
+	ID          int
+	TemplateID  string
+	ConfigKey   string
+	ConfigValue string
+	Description string
+}
+
+func GetTemplateConfig(ctx context.Context, templateID string) ([]TemplateConfig, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT id, template_id, config_key, config_value, description
+		FROM template_service.template_config
+		WHERE template_id = $1
+		ORDER BY config_key
+	`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	var configs []TemplateConfig
+	for rows.Next() {
+		var c TemplateConfig
+		var value, description sql.NullString
+		if err := rows.Scan(&c.ID, &c.TemplateID, &c.ConfigKey, &value, &description); err != nil {
+			return nil, err
+		}
+		c.ConfigValue = value.String
+		c.Description = description.String
+		configs = append(configs, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+func SetTemplateConfig(ctx context.Context, templateID, key, value, description string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.DB.ExecContext(ctx, `
+		INSERT INTO template_service.template_config 
+		(template_id, config_key, config_value, description) 
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (template_id, config_key) DO UPDATE
+		SET config_value = EXCLUDED.config_value, description = EXCLUDED.description,
+		    updated_at = CURRENT_TIMESTAMP`,
+		templateID, key, value, description)
+
+	return err
+}
+
+func DeleteTemplateConfig(ctx context.Context, templateID, key string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.DB.ExecContext(ctx, `
+		DELETE FROM template_service.template_config
+		WHERE template_id = $1 AND config_key = $2`,
+		templateID, key)
 
 	return err
 }
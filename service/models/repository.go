@@ -0,0 +1,351 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+)
+
+// TemplateRepository stores templates. Get returns inactive templates too
+// and sql.ErrNoRows for unknown IDs. Update bumps the version; Delete moves
+// an active template to the trash, from where Restore takes it back and
+// Purge removes it with its variables, configuration and versions.
+type TemplateRepository interface {
+	Get(ctx context.Context, id string) (Template, error)
+	// List returns the active templates, newest first.
+	List(ctx context.Context) ([]Template, error)
+	Create(ctx context.Context, name, categoryID, content, format, createdBy string) (string, error)
+	Update(ctx context.Context, id, name, categoryID, content, format, updatedBy string) error
+	Delete(ctx context.Context, id, deletedBy string) error
+	Trashed(ctx context.Context) ([]TrashedTemplate, error)
+	Restore(ctx context.Context, id, restoredBy string) error
+	Purge(ctx context.Context, id, purgedBy string) error
+	PurgeDeleted(ctx context.Context, cutoff time.Time, purgedBy string) (int64, error)
+	// Page returns one page of the templates matching the filter, and
+	// ErrInvalidCursor for cursors it did not issue.
+	Page(ctx context.Context, f TemplateFilter) (TemplatePage, error)
+	// Search returns the active templates matching a web search style query,
+	// best matches first.
+	Search(ctx context.Context, query string, limit, offset int) ([]TemplateSearchResult, error)
+}
+
+// VariableRepository stores the variables of templates. Variable names are
+// unique per template.
+type VariableRepository interface {
+	List(ctx context.Context, templateID string) ([]TemplateVariable, error)
+	Add(ctx context.Context, templateID, variableName, description, defaultValue string, isRequired bool) error
+	Update(ctx context.Context, templateID string, variableID int, v TemplateVariable) error
+	Delete(ctx context.Context, templateID string, variableID int) error
+	Replace(ctx context.Context, templateID string, variables []TemplateVariable) error
+}
+
+// SampleRepository stores the samples of templates. Sample names are unique
+// per template. Set creates or replaces a sample but keeps its expected
+// output unless a new one is given; Get, SetExpectedOutput and Delete return
+// sql.ErrNoRows for unknown samples.
+type SampleRepository interface {
+	List(ctx context.Context, templateID string) ([]TemplateSample, error)
+	Get(ctx context.Context, templateID, name string) (TemplateSample, error)
+	Create(ctx context.Context, templateID, name, description string, variables map[string]string,
+		expectedOutput *string, createdBy string) error
+	Set(ctx context.Context, templateID, name, description string, variables map[string]string,
+		expectedOutput *string, updatedBy string) error
+	SetExpectedOutput(ctx context.Context, templateID, name string, expectedOutput *string) error
+	Delete(ctx context.Context, templateID, name string) error
+}
+
+// CategoryRepository stores template categories. Category names are unique.
+type CategoryRepository interface {
+	List(ctx context.Context) ([]TemplateCategory, error)
+	Get(ctx context.Context, id int) (TemplateCategory, error)
+	Create(ctx context.Context, name, description string) (int, error)
+	Update(ctx context.Context, id int, name, description string) error
+	Delete(ctx context.Context, id, reassignTo int) error
+}
+
+// VersionRepository stores template snapshots. At also finds versions that
+// were only recorded in the audit log.
+type VersionRepository interface {
+	List(ctx context.Context, templateID string) ([]TemplateVersion, error)
+	Create(ctx context.Context, v TemplateVersion) (int, error)
+	At(ctx context.Context, templateID string, version int) (Template, error)
+}
+
+// ConfigRepository stores the configuration of templates. Keys are unique
+// per template; Set replaces the value of an existing key.
+type ConfigRepository interface {
+	List(ctx context.Context, templateID string) ([]TemplateConfig, error)
+	Set(ctx context.Context, templateID, key, value, description string) error
+	Delete(ctx context.Context, templateID, key string) error
+}
+
+// SettingRepository stores the service configuration, such as the SMTP
+// settings. Keys are unique. Encrypted values are listed as stored and
+// returned decrypted by Values.
+type SettingRepository interface {
+	List(ctx context.Context) ([]Configuration, error)
+	// Values returns the values whose keys start with prefix.
+	Values(ctx context.Context, prefix string) (map[string]string, error)
+	Set(ctx context.Context, key, value, description string, encrypted bool, updatedBy string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// AuditRepository reads the changes recorded for templates, versions and
+// template configuration.
+type AuditRepository interface {
+	Entries(ctx context.Context, entityType, entityID string) ([]AuditEntry, error)
+}
+
+// BundleRepository exports templates with everything needed to recreate
+// them elsewhere and imports them back. Export returns an error wrapping
+// sql.ErrNoRows for unknown IDs. Import applies the whole bundle or, on an
+// error or a dry run, nothing.
+type BundleRepository interface {
+	Export(ctx context.Context, f ExportFilter) ([]TemplateExport, error)
+	Import(ctx context.Context, categories []TemplateCategory, templates []TemplateExport, strategy string,
+		dryRun bool, importedBy string) (ImportReport, error)
+}
+
+// SyncStateRepository stores the state of the templates managed by the
+// templates-as-code sync. Set creates or replaces the state of a template;
+// purging the template removes it.
+type SyncStateRepository interface {
+	// List returns the states keyed by template ID.
+	List(ctx context.Context) (map[string]SyncState, error)
+	Set(ctx context.Context, templateID, sourcePath, contentHash string) error
+	Delete(ctx context.Context, templateID string) error
+}
+
+// SchemaRepository reports the database schema. Version is the highest
+// system_info version.
+type SchemaRepository interface {
+	Version(ctx context.Context) (string, error)
+	Status(ctx context.Context, environment string) (SchemaStatus, error)
+}
+
+// Repositories groups the repositories the handlers use. Errors follow the
+// Postgres implementation: sql.ErrNoRows for missing rows, errors for which
+// IsUniqueViolation reports true for duplicates, and ErrCategoryInUse and
+// ErrInvalidReassignment when deleting categories.
+type Repositories struct {
+	Templates  TemplateRepository
+	Variables  VariableRepository
+	Samples    SampleRepository
+	Categories CategoryRepository
+	Versions   VersionRepository
+	Config     ConfigRepository
+	Settings   SettingRepository
+	Audit      AuditRepository
+	Bundles    BundleRepository
+	SyncStates SyncStateRepository
+	Schema     SchemaRepository
+}
+
+// NewPostgresRepositories returns the repositories backed by db.DB.
+func NewPostgresRepositories() Repositories {
+	return Repositories{
+		Templates:  pgTemplates{},
+		Variables:  pgVariables{},
+		Samples:    pgSamples{},
+		Categories: pgCategories{},
+		Versions:   pgVersions{},
+		Config:     pgConfig{},
+		Settings:   pgSettings{},
+		Audit:      pgAudit{},
+		Bundles:    pgBundles{},
+		SyncStates: pgSyncStates{},
+		Schema:     pgSchema{},
+	}
+}
+
+type pgTemplates struct{}
+
+func (pgTemplates) Get(ctx context.Context, id string) (Template, error) {
+	return GetTemplateByID(ctx, id)
+}
+func (pgTemplates) List(ctx context.Context) ([]Template, error) { return GetTemplates(ctx) }
+
+func (pgTemplates) Create(ctx context.Context, name, categoryID, content, format, createdBy string) (string, error) {
+	return CreateTemplate(ctx, name, categoryID, content, format, createdBy)
+}
+
+func (pgTemplates) Update(ctx context.Context, id, name, categoryID, content, format, updatedBy string) error {
+	return UpdateTemplate(ctx, id, name, categoryID, content, format, updatedBy)
+}
+
+func (pgTemplates) Delete(ctx context.Context, id, deletedBy string) error {
+	return DeleteTemplate(ctx, id, deletedBy)
+}
+func (pgTemplates) Trashed(ctx context.Context) ([]TrashedTemplate, error) {
+	return GetTrashedTemplates(ctx)
+}
+func (pgTemplates) Restore(ctx context.Context, id, restoredBy string) error {
+	return RestoreTemplate(ctx, id, restoredBy)
+}
+func (pgTemplates) Purge(ctx context.Context, id, purgedBy string) error {
+	return PurgeTemplate(ctx, id, purgedBy)
+}
+
+func (pgTemplates) PurgeDeleted(ctx context.Context, cutoff time.Time, purgedBy string) (int64, error) {
+	return PurgeDeletedTemplates(ctx, cutoff, purgedBy)
+}
+
+func (pgTemplates) Page(ctx context.Context, f TemplateFilter) (TemplatePage, error) {
+	return ListTemplates(ctx, f)
+}
+
+func (pgTemplates) Search(ctx context.Context, query string, limit, offset int) ([]TemplateSearchResult, error) {
+	return SearchTemplates(ctx, query, limit, offset)
+}
+
+type pgVariables struct{}
+
+func (pgVariables) List(ctx context.Context, templateID string) ([]TemplateVariable, error) {
+	return GetTemplateVariables(ctx, templateID)
+}
+
+func (pgVariables) Add(ctx context.Context, templateID, variableName, description, defaultValue string, isRequired bool) error {
+	return AddTemplateVariable(ctx, templateID, variableName, description, defaultValue, isRequired)
+}
+
+func (pgVariables) Update(ctx context.Context, templateID string, variableID int, v TemplateVariable) error {
+	return UpdateTemplateVariable(ctx, templateID, variableID, v)
+}
+
+func (pgVariables) Delete(ctx context.Context, templateID string, variableID int) error {
+	return DeleteTemplateVariable(ctx, templateID, variableID)
+}
+
+func (pgVariables) Replace(ctx context.Context, templateID string, variables []TemplateVariable) error {
+	return ReplaceTemplateVariables(ctx, templateID, variables)
+}
+
+type pgSamples struct{}
+
+func (pgSamples) List(ctx context.Context, templateID string) ([]TemplateSample, error) {
+	return GetTemplateSamples(ctx, templateID)
+}
+
+func (pgSamples) Get(ctx context.Context, templateID, name string) (TemplateSample, error) {
+	return GetTemplateSample(ctx, templateID, name)
+}
+
+func (pgSamples) Create(ctx context.Context, templateID, name, description string, variables map[string]string,
+	expectedOutput *string, createdBy string) error {
+	return CreateTemplateSample(ctx, templateID, name, description, variables, expectedOutput, createdBy)
+}
+
+func (pgSamples) Set(ctx context.Context, templateID, name, description string, variables map[string]string,
+	expectedOutput *string, updatedBy string) error {
+	return SetTemplateSample(ctx, templateID, name, description, variables, expectedOutput, updatedBy)
+}
+
+func (pgSamples) SetExpectedOutput(ctx context.Context, templateID, name string, expectedOutput *string) error {
+	return SetSampleExpectedOutput(ctx, templateID, name, expectedOutput)
+}
+
+func (pgSamples) Delete(ctx context.Context, templateID, name string) error {
+	return DeleteTemplateSample(ctx, templateID, name)
+}
+
+type pgCategories struct{}
+
+func (pgCategories) List(ctx context.Context) ([]TemplateCategory, error) {
+	return GetTemplateCategories(ctx)
+}
+func (pgCategories) Get(ctx context.Context, id int) (TemplateCategory, error) {
+	return GetTemplateCategory(ctx, id)
+}
+func (pgCategories) Delete(ctx context.Context, id, reassignTo int) error {
+	return DeleteTemplateCategory(ctx, id, reassignTo)
+}
+
+func (pgCategories) Create(ctx context.Context, name, description string) (int, error) {
+	return CreateTemplateCategory(ctx, name, description)
+}
+
+func (pgCategories) Update(ctx context.Context, id int, name, description string) error {
+	return UpdateTemplateCategory(ctx, id, name, description)
+}
+
+type pgVersions struct{}
+
+func (pgVersions) List(ctx context.Context, templateID string) ([]TemplateVersion, error) {
+	return GetTemplateVersions(ctx, templateID)
+}
+
+func (pgVersions) Create(ctx context.Context, v TemplateVersion) (int, error) {
+	return CreateTemplateVersion(ctx, v)
+}
+
+func (pgVersions) At(ctx context.Context, templateID string, version int) (Template, error) {
+	return GetTemplateAtVersion(ctx, templateID, version)
+}
+
+type pgConfig struct{}
+
+func (pgConfig) List(ctx context.Context, templateID string) ([]TemplateConfig, error) {
+	return GetTemplateConfig(ctx, templateID)
+}
+
+func (pgConfig) Set(ctx context.Context, templateID, key, value, description string) error {
+	return SetTemplateConfig(ctx, templateID, key, value, description)
+}
+
+func (pgConfig) Delete(ctx context.Context, templateID, key string) error {
+	return DeleteTemplateConfig(ctx, templateID, key)
+}
+
+type pgSettings struct{}
+
+func (pgSettings) List(ctx context.Context) ([]Configuration, error) { return GetConfigurations(ctx) }
+
+func (pgSettings) Values(ctx context.Context, prefix string) (map[string]string, error) {
+	return GetConfigValues(ctx, prefix)
+}
+
+func (pgSettings) Set(ctx context.Context, key, value, description string, encrypted bool, updatedBy string) error {
+	return SetConfigValue(ctx, key, value, description, encrypted, updatedBy)
+}
+
+func (pgSettings) Delete(ctx context.Context, key string) error { return DeleteConfigValue(ctx, key) }
+
+type pgAudit struct{}
+
+func (pgAudit) Entries(ctx context.Context, entityType, entityID string) ([]AuditEntry, error) {
+	return GetAuditLog(ctx, entityType, entityID)
+}
+
+type pgBundles struct{}
+
+func (pgBundles) Export(ctx context.Context, f ExportFilter) ([]TemplateExport, error) {
+	return ExportTemplates(ctx, f)
+}
+
+func (pgBundles) Import(ctx context.Context, categories []TemplateCategory, templates []TemplateExport, strategy string,
+	dryRun bool, importedBy string) (ImportReport, error) {
+	return ImportTemplates(ctx, categories, templates, strategy, dryRun, importedBy)
+}
+
+type pgSyncStates struct{}
+
+func (pgSyncStates) List(ctx context.Context) (map[string]SyncState, error) {
+	return GetSyncStates(ctx)
+}
+
+func (pgSyncStates) Set(ctx context.Context, templateID, sourcePath, contentHash string) error {
+	return SetSyncState(ctx, templateID, sourcePath, contentHash)
+}
+
+func (pgSyncStates) Delete(ctx context.Context, templateID string) error {
+	return DeleteSyncState(ctx, templateID)
+}
+
+type pgSchema struct{}
+
+func (pgSchema) Version(ctx context.Context) (string, error) { return db.SchemaVersion(ctx) }
+
+func (pgSchema) Status(ctx context.Context, environment string) (SchemaStatus, error) {
+	return GetSchemaStatus(ctx, environment)
+}
@@ -0,0 +1,32 @@
+package models_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models/repotest"
+)
+
+// TestPostgresConformance runs the conformance suite against the database
+// configured by DATABASE_URL and the DB_* variables. That database must be
+// migrated and disposable: the suite empties its trash.
+func TestPostgresConformance(t *testing.T) {
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	if err := db.Connect(context.Background(), 30*time.Second); err != nil {
+		t.Fatalf("connecting to database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.DB.Close(); err != nil {
+			t.Errorf("closing database: %v", err)
+		}
+	})
+
+	repotest.Run(t, models.NewPostgresRepositories)
+}
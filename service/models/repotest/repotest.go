@@ -0,0 +1,1470 @@
+// Package repotest is the conformance suite of the model repositories.
+// Every implementation, the Postgres one and the in-memory one, must pass
+// it, so handlers behave the same whichever they are given. Run it from a
+// test of the implementation.
+//
+// The cases only rely on the rows they create and remove them afterwards,
+// but templates/purge-deleted empties the trash, so run the suite against
+// a scratch database.
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/google/uuid"
+)
+
+// testCase is a single conformance check. run returns the first difference
+// from the expected behaviour.
+type testCase struct {
+	name string
+	run  func(ctx context.Context, r models.Repositories) error
+}
+
+var cases = []testCase{
+	{"templates/create", testCreateTemplate},
+	{"templates/unknown", testUnknownTemplate},
+	{"templates/update-bumps-version", testUpdateTemplate},
+	{"templates/soft-delete", testSoftDelete},
+	{"templates/purge", testPurge},
+	{"templates/purge-deleted", testPurgeDeleted},
+	{"templates/page", testPage},
+	{"templates/search", testSearch},
+	{"categories/crud", testCategories},
+	{"categories/unique-name", testCategoryUniqueName},
+	{"categories/delete-in-use", testCategoryInUse},
+	{"categories/reassign", testCategoryReassign},
+	{"variables/crud", testVariables},
+	{"variables/unique-name", testVariableUniqueName},
+	{"variables/replace", testReplaceVariables},
+	{"samples/crud", testSamples},
+	{"config/upsert", testConfig},
+	{"settings/values", testSettings},
+	{"versions/snapshots", testVersions},
+	{"versions/at", testVersionAt},
+	{"audit/templates", testAudit},
+	{"bundles/export", testExport},
+	{"bundles/import", testImport},
+	{"bundles/import-rollback", testImportRollback},
+	{"sync-states/crud", testSyncStates},
+	{"schema/version", testSchemaVersion},
+	{"context/done", testContextDone},
+}
+
+// Run runs every case as a subtest of t, on repositories returned by
+// newRepos.
+func Run(t *testing.T, newRepos func() models.Repositories) {
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.run(context.Background(), newRepos()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// fixture creates a category with a unique name and removes it, with the
+// templates created through it, when cleanup is called.
+type fixture struct {
+	ctx        context.Context
+	r          models.Repositories
+	suffix     string
+	categoryID int
+	templates  []string
+}
+
+func newFixture(ctx context.Context, r models.Repositories) (*fixture, error) {
+	f := &fixture{ctx: ctx, r: r, suffix: uuid.New().String()[:8]}
+	id, err := r.Categories.Create(ctx, f.name("category"), "conformance test category")
+	if err != nil {
+		return nil, fmt.Errorf("creating category: %w", err)
+	}
+	f.categoryID = id
+	return f, nil
+}
+
+// name returns a name unique to this fixture.
+func (f *fixture) name(s string) string {
+	return s + "-" + f.suffix
+}
+
+func (f *fixture) category() string {
+	return fmt.Sprint(f.categoryID)
+}
+
+func (f *fixture) createTemplate(name, content string) (string, error) {
+	id, err := f.r.Templates.Create(f.ctx, f.name(name), f.category(), content, "html", "conformance")
+	if err != nil {
+		return "", fmt.Errorf("creating template: %w", err)
+	}
+	f.templates = append(f.templates, id)
+	return id, nil
+}
+
+// cleanup purges the templates and deletes the category, ignoring what
+// the case removed itself.
+func (f *fixture) cleanup() {
+	f.purgeTemplates()
+	_ = f.r.Categories.Delete(f.ctx, f.categoryID, 0)
+}
+
+// purgeTemplates moves the templates to the trash, as only trashed ones can
+// be purged, and purges them.
+func (f *fixture) purgeTemplates() {
+	for _, id := range f.templates {
+		_ = f.r.Templates.Delete(f.ctx, id, "conformance")
+		_ = f.r.Templates.Purge(f.ctx, id, "conformance")
+	}
+}
+
+// run creates a fixture for fn and cleans up after it.
+func run(ctx context.Context, r models.Repositories, fn func(f *fixture) error) error {
+	f, err := newFixture(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer f.cleanup()
+	return fn(f)
+}
+
+func expectNoRows(what string, err error) error {
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: got error %v, want sql.ErrNoRows", what, err)
+	}
+	return nil
+}
+
+func expectUniqueViolation(what string, err error) error {
+	if !models.IsUniqueViolation(err) {
+		return fmt.Errorf("%s: got error %v, want a unique violation", what, err)
+	}
+	return nil
+}
+
+func containsTemplate(list []models.Template, id string) bool {
+	for _, t := range list {
+		if t.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTrashed(list []models.TrashedTemplate, id string) (models.TrashedTemplate, bool) {
+	for _, t := range list {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return models.TrashedTemplate{}, false
+}
+
+func testCreateTemplate(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("welcome", "<p>Hello {{.Name}}</p>")
+		if err != nil {
+			return err
+		}
+
+		t, err := r.Templates.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("getting template: %w", err)
+		}
+		switch {
+		case t.Name != f.name("welcome"), t.Content != "<p>Hello {{.Name}}</p>", t.Format != "html":
+			return fmt.Errorf("got template %q %q %q", t.Name, t.Content, t.Format)
+		case t.CategoryID != f.categoryID, t.CategoryName != f.name("category"):
+			return fmt.Errorf("got category %d %q, want %d %q", t.CategoryID, t.CategoryName, f.categoryID, f.name("category"))
+		case t.Version != 1, !t.IsActive, t.CreatedBy != "conformance", t.CreatedAt.IsZero():
+			return fmt.Errorf("got version %d, active %t, created by %q at %v", t.Version, t.IsActive, t.CreatedBy, t.CreatedAt)
+		}
+
+		list, err := r.Templates.List(ctx)
+		if err != nil {
+			return fmt.Errorf("listing templates: %w", err)
+		}
+		if !containsTemplate(list, id) {
+			return errors.New("created template is not listed")
+		}
+
+		if _, err := r.Templates.Create(ctx, f.name("orphan"), "-1", "x", "html", "conformance"); err == nil {
+			return errors.New("created a template in a category that does not exist")
+		}
+		return nil
+	})
+}
+
+func testUnknownTemplate(ctx context.Context, r models.Repositories) error {
+	unknown := uuid.New().String()
+	_, err := r.Templates.Get(ctx, unknown)
+	if err := expectNoRows("getting unknown template", err); err != nil {
+		return err
+	}
+	if err := expectNoRows("restoring unknown template", r.Templates.Restore(ctx, unknown, "conformance")); err != nil {
+		return err
+	}
+	if err := expectNoRows("purging unknown template", r.Templates.Purge(ctx, unknown, "conformance")); err != nil {
+		return err
+	}
+	return expectNoRows("deleting unknown template", r.Templates.Delete(ctx, unknown, "conformance"))
+}
+
+func testUpdateTemplate(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("invoice", "v1")
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			if err := r.Templates.Update(ctx, id, f.name("invoice"), f.category(), fmt.Sprintf("v%d", i+2), "markdown", "editor"); err != nil {
+				return fmt.Errorf("updating template: %w", err)
+			}
+		}
+
+		t, err := r.Templates.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("getting template: %w", err)
+		}
+		if t.Version != 3 || t.Content != "v3" || t.Format != "markdown" || t.UpdatedBy != "editor" {
+			return fmt.Errorf("got version %d, content %q, format %q, updated by %q; want 3, v3, markdown, editor",
+				t.Version, t.Content, t.Format, t.UpdatedBy)
+		}
+		if t.UpdatedAt.Before(t.CreatedAt) {
+			return fmt.Errorf("updated at %v before created at %v", t.UpdatedAt, t.CreatedAt)
+		}
+		return nil
+	})
+}
+
+func testSoftDelete(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("receipt", "x")
+		if err != nil {
+			return err
+		}
+		if err := r.Templates.Delete(ctx, id, "alice"); err != nil {
+			return fmt.Errorf("deleting template: %w", err)
+		}
+		// A trashed template cannot be deleted again.
+		if err := expectNoRows("deleting trashed template", r.Templates.Delete(ctx, id, "bob")); err != nil {
+			return err
+		}
+
+		t, err := r.Templates.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("getting deleted template: %w", err)
+		}
+		if t.IsActive || t.Version != 1 {
+			return fmt.Errorf("deleted template is active %t with version %d", t.IsActive, t.Version)
+		}
+		list, err := r.Templates.List(ctx)
+		if err != nil {
+			return fmt.Errorf("listing templates: %w", err)
+		}
+		if containsTemplate(list, id) {
+			return errors.New("deleted template is listed")
+		}
+		trash, err := r.Templates.Trashed(ctx)
+		if err != nil {
+			return fmt.Errorf("listing trash: %w", err)
+		}
+		trashed, ok := containsTrashed(trash, id)
+		if !ok {
+			return errors.New("deleted template is not in the trash")
+		}
+		if trashed.DeletedBy != "alice" || trashed.DeletedAt.IsZero() || trashed.CategoryName != f.name("category") {
+			return fmt.Errorf("trashed template deleted by %q at %v in %q", trashed.DeletedBy, trashed.DeletedAt, trashed.CategoryName)
+		}
+
+		if err := r.Templates.Restore(ctx, id, "carol"); err != nil {
+			return fmt.Errorf("restoring template: %w", err)
+		}
+		if err := expectNoRows("restoring active template", r.Templates.Restore(ctx, id, "carol")); err != nil {
+			return err
+		}
+		t, err = r.Templates.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("getting restored template: %w", err)
+		}
+		if !t.IsActive || t.UpdatedBy != "carol" {
+			return fmt.Errorf("restored template is active %t, updated by %q", t.IsActive, t.UpdatedBy)
+		}
+		trash, err = r.Templates.Trashed(ctx)
+		if err != nil {
+			return fmt.Errorf("listing trash: %w", err)
+		}
+		if _, ok := containsTrashed(trash, id); ok {
+			return errors.New("restored template is still in the trash")
+		}
+		return nil
+	})
+}
+
+func testPurge(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("statement", "x")
+		if err != nil {
+			return err
+		}
+		if err := r.Variables.Add(ctx, id, "name", "", "", true); err != nil {
+			return fmt.Errorf("adding variable: %w", err)
+		}
+		if err := r.Config.Set(ctx, id, "subject", "Statement", ""); err != nil {
+			return fmt.Errorf("setting config: %w", err)
+		}
+		if _, err := r.Versions.Create(ctx, models.TemplateVersion{TemplateID: id, Version: 1, Content: "x", Format: "html", CreatedBy: "conformance"}); err != nil {
+			return fmt.Errorf("creating version: %w", err)
+		}
+		if err := r.Samples.Create(ctx, id, "typical", "", map[string]string{"name": "Ada"}, nil, "conformance"); err != nil {
+			return fmt.Errorf("creating sample: %w", err)
+		}
+
+		// Only templates in the trash can be purged.
+		if err := expectNoRows("purging active template", r.Templates.Purge(ctx, id, "admin")); err != nil {
+			return err
+		}
+		if _, err := r.Templates.Get(ctx, id); err != nil {
+			return fmt.Errorf("getting template after refused purge: %w", err)
+		}
+
+		if err := r.Templates.Delete(ctx, id, "conformance"); err != nil {
+			return fmt.Errorf("deleting template: %w", err)
+		}
+		if err := r.Templates.Purge(ctx, id, "admin"); err != nil {
+			return fmt.Errorf("purging template: %w", err)
+		}
+		_, err = r.Templates.Get(ctx, id)
+		if err := expectNoRows("getting purged template", err); err != nil {
+			return err
+		}
+
+		variables, err := r.Variables.List(ctx, id)
+		if err != nil || len(variables) != 0 {
+			return fmt.Errorf("purged template has %d variables, error %v", len(variables), err)
+		}
+		config, err := r.Config.List(ctx, id)
+		if err != nil || len(config) != 0 {
+			return fmt.Errorf("purged template has %d config entries, error %v", len(config), err)
+		}
+		versions, err := r.Versions.List(ctx, id)
+		if err != nil || len(versions) != 0 {
+			return fmt.Errorf("purged template has %d versions, error %v", len(versions), err)
+		}
+		samples, err := r.Samples.List(ctx, id)
+		if err != nil || len(samples) != 0 {
+			return fmt.Errorf("purged template has %d samples, error %v", len(samples), err)
+		}
+		return nil
+	})
+}
+
+func testPurgeDeleted(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		deleted, err := f.createTemplate("old", "x")
+		if err != nil {
+			return err
+		}
+		active, err := f.createTemplate("current", "x")
+		if err != nil {
+			return err
+		}
+		if err := r.Templates.Delete(ctx, deleted, "conformance"); err != nil {
+			return fmt.Errorf("deleting template: %w", err)
+		}
+
+		if _, err := r.Templates.PurgeDeleted(ctx, time.Now().Add(-time.Hour), "retention"); err != nil {
+			return fmt.Errorf("purging deleted templates: %w", err)
+		}
+		if _, err := r.Templates.Get(ctx, deleted); err != nil {
+			return fmt.Errorf("template deleted after the cutoff was purged: %w", err)
+		}
+
+		if _, err := r.Templates.PurgeDeleted(ctx, time.Now().Add(time.Minute), "retention"); err != nil {
+			return fmt.Errorf("purging deleted templates: %w", err)
+		}
+		_, err = r.Templates.Get(ctx, deleted)
+		if err := expectNoRows("getting purged template", err); err != nil {
+			return err
+		}
+		if _, err := r.Templates.Get(ctx, active); err != nil {
+			return fmt.Errorf("active template was purged: %w", err)
+		}
+		return nil
+	})
+}
+
+// names returns the names of templates without the fixture suffix.
+func (f *fixture) names(list []models.Template) string {
+	names := make([]string, len(list))
+	for i, t := range list {
+		names[i] = strings.TrimSuffix(t.Name, "-"+f.suffix)
+	}
+	return strings.Join(names, ",")
+}
+
+func testPage(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		var ids []string
+		for _, name := range []string{"charlie", "alpha", "bravo"} {
+			id, err := f.createTemplate(name, "content of "+name)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+
+		filter := models.TemplateFilter{CategoryID: f.categoryID, Sort: models.SortName, Ascending: true, Limit: 2}
+		page, err := r.Templates.Page(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("listing first page: %w", err)
+		}
+		if got := f.names(page.Templates); got != "alpha,bravo" || page.NextCursor == "" {
+			return fmt.Errorf("first page is %q with cursor %q, want alpha,bravo and a cursor", got, page.NextCursor)
+		}
+		if t := page.Templates[0]; t.Content != "content of alpha" || t.CategoryName != f.name("category") {
+			return fmt.Errorf("listed template has content %q in category %q", t.Content, t.CategoryName)
+		}
+
+		filter.Cursor = page.NextCursor
+		page, err = r.Templates.Page(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("listing second page: %w", err)
+		}
+		if got := f.names(page.Templates); got != "charlie" || page.NextCursor != "" {
+			return fmt.Errorf("second page is %q with cursor %q, want charlie and no cursor", got, page.NextCursor)
+		}
+
+		filter.Ascending = false
+		if _, err := r.Templates.Page(ctx, filter); !errors.Is(err, models.ErrInvalidCursor) {
+			return fmt.Errorf("listing with the cursor of another order: got error %v, want ErrInvalidCursor", err)
+		}
+		filter.Cursor = "not a cursor"
+		if _, err := r.Templates.Page(ctx, filter); !errors.Is(err, models.ErrInvalidCursor) {
+			return fmt.Errorf("listing with a malformed cursor: got error %v, want ErrInvalidCursor", err)
+		}
+
+		if err := r.Templates.Delete(ctx, ids[0], "conformance"); err != nil {
+			return fmt.Errorf("deleting template: %w", err)
+		}
+		filter = models.TemplateFilter{CategoryID: f.categoryID, Sort: models.SortName, Summary: true}
+		page, err = r.Templates.Page(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("listing summaries: %w", err)
+		}
+		if got := f.names(page.Templates); got != "bravo,alpha" || page.Templates[0].Content != "" {
+			return fmt.Errorf("summaries are %q with content %q, want bravo,alpha without content", got, page.Templates[0].Content)
+		}
+		filter.IncludeInactive = true
+		page, err = r.Templates.Page(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("listing with inactive templates: %w", err)
+		}
+		if got := f.names(page.Templates); got != "charlie,bravo,alpha" {
+			return fmt.Errorf("listing with inactive templates got %q, want charlie,bravo,alpha", got)
+		}
+		return nil
+	})
+}
+
+func testSearch(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		// A word no other template contains, left alone by stemming.
+		word := "zeppelin" + f.suffix
+		found, err := f.createTemplate("found", "<p>The "+word+" report</p>")
+		if err != nil {
+			return err
+		}
+		draft, err := f.createTemplate("draft", "<p>"+word+" draft</p>")
+		if err != nil {
+			return err
+		}
+		if err := r.Variables.Add(ctx, draft, "owner", "quokka"+f.suffix+" owner", "", false); err != nil {
+			return fmt.Errorf("adding variable: %w", err)
+		}
+		deleted, err := f.createTemplate("deleted", word)
+		if err != nil {
+			return err
+		}
+		if err := r.Templates.Delete(ctx, deleted, "conformance"); err != nil {
+			return fmt.Errorf("deleting template: %w", err)
+		}
+
+		search := func(query string) ([]string, error) {
+			results, err := r.Templates.Search(ctx, query, 10, 0)
+			if err != nil {
+				return nil, fmt.Errorf("searching %q: %w", query, err)
+			}
+			var ids []string
+			for _, result := range results {
+				ids = append(ids, result.ID)
+			}
+			sort.Strings(ids)
+			return ids, nil
+		}
+		want := func(ids ...string) string {
+			sort.Strings(ids)
+			return fmt.Sprint(ids)
+		}
+
+		for query, expected := range map[string]string{
+			word:                    want(found, draft),
+			word + " -draft":        want(found),
+			`"` + word + ` report"`: want(found),
+			"quokka" + f.suffix:     want(draft),
+			"nothing" + f.suffix:    want(),
+			"nothing" + f.suffix + " or " + "quokka" + f.suffix: want(draft),
+		} {
+			ids, err := search(query)
+			if err != nil {
+				return err
+			}
+			if fmt.Sprint(ids) != expected {
+				return fmt.Errorf("searching %q found %v, want %v", query, ids, expected)
+			}
+		}
+
+		results, err := r.Templates.Search(ctx, word+" report", 10, 0)
+		if err != nil {
+			return fmt.Errorf("searching: %w", err)
+		}
+		if len(results) != 1 || !strings.Contains(results[0].Snippet, "<mark>"+word+"</mark>") ||
+			results[0].CategoryName != f.name("category") || results[0].Rank <= 0 {
+			return fmt.Errorf("got results %+v, want one with %s highlighted", results, word)
+		}
+		return nil
+	})
+}
+
+func testCategories(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		c, err := r.Categories.Get(ctx, f.categoryID)
+		if err != nil {
+			return fmt.Errorf("getting category: %w", err)
+		}
+		if c.Name != f.name("category") || c.Description != "conformance test category" || c.TemplateCount != 0 {
+			return fmt.Errorf("got category %q %q with %d templates", c.Name, c.Description, c.TemplateCount)
+		}
+
+		if err := r.Categories.Update(ctx, f.categoryID, f.name("renamed"), "changed"); err != nil {
+			return fmt.Errorf("updating category: %w", err)
+		}
+		if _, err := f.createTemplate("member", "x"); err != nil {
+			return err
+		}
+		list, err := r.Categories.List(ctx)
+		if err != nil {
+			return fmt.Errorf("listing categories: %w", err)
+		}
+		var found bool
+		for _, c := range list {
+			if c.ID == f.categoryID {
+				found = true
+				if c.Name != f.name("renamed") || c.Description != "changed" || c.TemplateCount != 1 {
+					return fmt.Errorf("listed category %q %q with %d templates", c.Name, c.Description, c.TemplateCount)
+				}
+			}
+		}
+		if !found {
+			return errors.New("category is not listed")
+		}
+
+		_, err = r.Categories.Get(ctx, -1)
+		if err := expectNoRows("getting unknown category", err); err != nil {
+			return err
+		}
+		if err := expectNoRows("updating unknown category", r.Categories.Update(ctx, -1, f.name("none"), "")); err != nil {
+			return err
+		}
+		return expectNoRows("deleting unknown category", r.Categories.Delete(ctx, -1, 0))
+	})
+}
+
+func testCategoryUniqueName(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		_, err := r.Categories.Create(ctx, f.name("category"), "")
+		if err := expectUniqueViolation("creating duplicate category", err); err != nil {
+			return err
+		}
+
+		other, err := r.Categories.Create(ctx, f.name("other"), "")
+		if err != nil {
+			return fmt.Errorf("creating category: %w", err)
+		}
+		defer func() { _ = r.Categories.Delete(ctx, other, 0) }()
+		return expectUniqueViolation("renaming to a used name", r.Categories.Update(ctx, other, f.name("category"), ""))
+	})
+}
+
+func testCategoryInUse(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("member", "x")
+		if err != nil {
+			return err
+		}
+		// Deleted templates still hold on to their category.
+		if err := r.Templates.Delete(ctx, id, "conformance"); err != nil {
+			return fmt.Errorf("deleting template: %w", err)
+		}
+
+		if err := r.Categories.Delete(ctx, f.categoryID, 0); !errors.Is(err, models.ErrCategoryInUse) {
+			return fmt.Errorf("deleting used category: got error %v, want ErrCategoryInUse", err)
+		}
+		if err := r.Categories.Delete(ctx, f.categoryID, f.categoryID); !errors.Is(err, models.ErrInvalidReassignment) {
+			return fmt.Errorf("reassigning to the deleted category: got error %v, want ErrInvalidReassignment", err)
+		}
+		if err := r.Categories.Delete(ctx, f.categoryID, -1); !errors.Is(err, models.ErrInvalidReassignment) {
+			return fmt.Errorf("reassigning to an unknown category: got error %v, want ErrInvalidReassignment", err)
+		}
+		if _, err := r.Categories.Get(ctx, f.categoryID); err != nil {
+			return fmt.Errorf("category is gone after failed deletes: %w", err)
+		}
+		return nil
+	})
+}
+
+func testCategoryReassign(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		target, err := r.Categories.Create(ctx, f.name("target"), "")
+		if err != nil {
+			return fmt.Errorf("creating category: %w", err)
+		}
+		defer func() { _ = r.Categories.Delete(ctx, target, 0) }()
+		// The templates move along, so the fixture cleans them up first.
+		defer f.purgeTemplates()
+
+		id, err := f.createTemplate("member", "x")
+		if err != nil {
+			return err
+		}
+		if err := r.Categories.Delete(ctx, f.categoryID, target); err != nil {
+			return fmt.Errorf("deleting category: %w", err)
+		}
+		_, err = r.Categories.Get(ctx, f.categoryID)
+		if err := expectNoRows("getting deleted category", err); err != nil {
+			return err
+		}
+
+		t, err := r.Templates.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("getting template: %w", err)
+		}
+		if t.CategoryID != target || t.CategoryName != f.name("target") {
+			return fmt.Errorf("template is in category %d %q, want %d", t.CategoryID, t.CategoryName, target)
+		}
+		return nil
+	})
+}
+
+func testVariables(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("letter", "x")
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"name", "date"} {
+			if err := r.Variables.Add(ctx, id, name, "the "+name, "", name == "name"); err != nil {
+				return fmt.Errorf("adding variable: %w", err)
+			}
+		}
+
+		variables, err := r.Variables.List(ctx, id)
+		if err != nil {
+			return fmt.Errorf("listing variables: %w", err)
+		}
+		if len(variables) != 2 || variables[0].VariableName != "name" || variables[1].VariableName != "date" {
+			return fmt.Errorf("got variables %+v, want name and date in insertion order", variables)
+		}
+		if v := variables[0]; v.TemplateID != id || v.Description != "the name" || !v.IsRequired || v.VariableType != "string" {
+			return fmt.Errorf("got variable %+v", v)
+		}
+
+		date := variables[1]
+		if err := r.Variables.Update(ctx, id, date.ID, models.TemplateVariable{VariableName: "due_date", DefaultValue: "today"}); err != nil {
+			return fmt.Errorf("updating variable: %w", err)
+		}
+		variables, err = r.Variables.List(ctx, id)
+		if err != nil {
+			return fmt.Errorf("listing variables: %w", err)
+		}
+		if v := variables[1]; v.ID != date.ID || v.VariableName != "due_date" || v.DefaultValue != "today" || v.VariableType != "string" {
+			return fmt.Errorf("updated variable is %+v", v)
+		}
+
+		other, err := f.createTemplate("other", "x")
+		if err != nil {
+			return err
+		}
+		if err := expectNoRows("updating another template's variable",
+			r.Variables.Update(ctx, other, date.ID, models.TemplateVariable{VariableName: "x"})); err != nil {
+			return err
+		}
+		if err := expectNoRows("deleting another template's variable", r.Variables.Delete(ctx, other, date.ID)); err != nil {
+			return err
+		}
+
+		if err := r.Variables.Delete(ctx, id, date.ID); err != nil {
+			return fmt.Errorf("deleting variable: %w", err)
+		}
+		if err := expectNoRows("deleting deleted variable", r.Variables.Delete(ctx, id, date.ID)); err != nil {
+			return err
+		}
+		if err := r.Variables.Add(ctx, uuid.New().String(), "name", "", "", false); err == nil {
+			return errors.New("added a variable to a template that does not exist")
+		}
+		return nil
+	})
+}
+
+func testVariableUniqueName(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("letter", "x")
+		if err != nil {
+			return err
+		}
+		if err := r.Variables.Add(ctx, id, "name", "", "", false); err != nil {
+			return fmt.Errorf("adding variable: %w", err)
+		}
+		if err := expectUniqueViolation("adding duplicate variable", r.Variables.Add(ctx, id, "name", "", "", false)); err != nil {
+			return err
+		}
+		if err := r.Variables.Add(ctx, id, "other", "", "", false); err != nil {
+			return fmt.Errorf("adding variable: %w", err)
+		}
+		variables, err := r.Variables.List(ctx, id)
+		if err != nil {
+			return fmt.Errorf("listing variables: %w", err)
+		}
+		err = r.Variables.Update(ctx, id, variables[1].ID, models.TemplateVariable{VariableName: "name"})
+		if err := expectUniqueViolation("renaming to a used name", err); err != nil {
+			return err
+		}
+
+		// The same name in another template is fine.
+		other, err := f.createTemplate("other", "x")
+		if err != nil {
+			return err
+		}
+		if err := r.Variables.Add(ctx, other, "name", "", "", false); err != nil {
+			return fmt.Errorf("adding variable to another template: %w", err)
+		}
+		return nil
+	})
+}
+
+func testReplaceVariables(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("letter", "x")
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"keep", "drop"} {
+			if err := r.Variables.Add(ctx, id, name, "", "", false); err != nil {
+				return fmt.Errorf("adding variable: %w", err)
+			}
+		}
+		before, err := r.Variables.List(ctx, id)
+		if err != nil {
+			return fmt.Errorf("listing variables: %w", err)
+		}
+
+		if err := r.Variables.Replace(ctx, id, []models.TemplateVariable{
+			{VariableName: "keep", Description: "kept", IsRequired: true},
+			{VariableName: "new", VariableType: "number"},
+		}); err != nil {
+			return fmt.Errorf("replacing variables: %w", err)
+		}
+		after, err := r.Variables.List(ctx, id)
+		if err != nil {
+			return fmt.Errorf("listing variables: %w", err)
+		}
+		if len(after) != 2 {
+			return fmt.Errorf("got %d variables after replace, want 2", len(after))
+		}
+		if keep := after[0]; keep.ID != before[0].ID || keep.VariableName != "keep" || keep.Description != "kept" ||
+			!keep.IsRequired || keep.VariableType != "string" {
+			return fmt.Errorf("kept variable is %+v, want ID %d", keep, before[0].ID)
+		}
+		if added := after[1]; added.VariableName != "new" || added.VariableType != "number" {
+			return fmt.Errorf("added variable is %+v", added)
+		}
+
+		if err := r.Variables.Replace(ctx, id, nil); err != nil {
+			return fmt.Errorf("removing variables: %w", err)
+		}
+		after, err = r.Variables.List(ctx, id)
+		if err != nil || len(after) != 0 {
+			return fmt.Errorf("got %d variables after removing them all, error %v", len(after), err)
+		}
+		return nil
+	})
+}
+
+func testSamples(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("letter", "Dear {{.name}}")
+		if err != nil {
+			return err
+		}
+		expected := "Dear Ada"
+		if err := r.Samples.Create(ctx, id, "typical", "a typical letter", map[string]string{"name": "Ada"}, &expected, "alice"); err != nil {
+			return fmt.Errorf("creating sample: %w", err)
+		}
+		if err := r.Samples.Create(ctx, id, "empty", "", nil, nil, "alice"); err != nil {
+			return fmt.Errorf("creating sample: %w", err)
+		}
+		err = r.Samples.Create(ctx, id, "typical", "", nil, nil, "alice")
+		if err := expectUniqueViolation("creating duplicate sample", err); err != nil {
+			return err
+		}
+
+		s, err := r.Samples.Get(ctx, id, "typical")
+		if err != nil {
+			return fmt.Errorf("getting sample: %w", err)
+		}
+		if s.TemplateID != id || s.Description != "a typical letter" || s.Variables["name"] != "Ada" || s.CreatedBy != "alice" ||
+			!s.HasExpectedOutput || s.ExpectedOutput != expected || s.ExpectedUpdatedAt.IsZero() {
+			return fmt.Errorf("got sample %+v", s)
+		}
+
+		// Set keeps the expected output when none is given.
+		if err := r.Samples.Set(ctx, id, "typical", "changed", map[string]string{"name": "Grace"}, nil, "bob"); err != nil {
+			return fmt.Errorf("setting sample: %w", err)
+		}
+		s, err = r.Samples.Get(ctx, id, "typical")
+		if err != nil {
+			return fmt.Errorf("getting sample: %w", err)
+		}
+		if s.Description != "changed" || s.Variables["name"] != "Grace" || s.CreatedBy != "alice" || s.ExpectedOutput != expected {
+			return fmt.Errorf("set sample is %+v", s)
+		}
+		if err := r.Samples.SetExpectedOutput(ctx, id, "typical", nil); err != nil {
+			return fmt.Errorf("clearing expected output: %w", err)
+		}
+
+		list, err := r.Samples.List(ctx, id)
+		if err != nil {
+			return fmt.Errorf("listing samples: %w", err)
+		}
+		if len(list) != 2 || list[0].Name != "empty" || list[1].Name != "typical" {
+			return fmt.Errorf("got samples %+v, want empty and typical sorted by name", list)
+		}
+		if list[0].Variables == nil || list[1].HasExpectedOutput {
+			return fmt.Errorf("got samples %+v, want empty variables and a cleared expected output", list)
+		}
+
+		if err := r.Samples.Delete(ctx, id, "empty"); err != nil {
+			return fmt.Errorf("deleting sample: %w", err)
+		}
+		if err := expectNoRows("deleting deleted sample", r.Samples.Delete(ctx, id, "empty")); err != nil {
+			return err
+		}
+		_, err = r.Samples.Get(ctx, id, "empty")
+		if err := expectNoRows("getting deleted sample", err); err != nil {
+			return err
+		}
+		if err := expectNoRows("setting expected output of unknown sample",
+			r.Samples.SetExpectedOutput(ctx, id, "empty", &expected)); err != nil {
+			return err
+		}
+		if err := r.Samples.Create(ctx, uuid.New().String(), "typical", "", nil, nil, "alice"); err == nil {
+			return errors.New("created a sample of a template that does not exist")
+		}
+		return nil
+	})
+}
+
+func testConfig(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("mail", "x")
+		if err != nil {
+			return err
+		}
+		for _, kv := range [][2]string{{"subject", "Hello"}, {"from", "noreply@example.com"}, {"subject", "Welcome"}} {
+			if err := r.Config.Set(ctx, id, kv[0], kv[1], kv[0]+" header"); err != nil {
+				return fmt.Errorf("setting config: %w", err)
+			}
+		}
+
+		config, err := r.Config.List(ctx, id)
+		if err != nil {
+			return fmt.Errorf("listing config: %w", err)
+		}
+		if len(config) != 2 || config[0].ConfigKey != "from" || config[1].ConfigKey != "subject" {
+			return fmt.Errorf("got config %+v, want from and subject sorted by key", config)
+		}
+		if c := config[1]; c.ConfigValue != "Welcome" || c.Description != "subject header" || c.TemplateID != id {
+			return fmt.Errorf("got subject %+v, want the last value", c)
+		}
+
+		if err := r.Config.Delete(ctx, id, "from"); err != nil {
+			return fmt.Errorf("deleting config: %w", err)
+		}
+		if err := r.Config.Delete(ctx, id, "from"); err != nil {
+			return fmt.Errorf("deleting deleted config: %w", err)
+		}
+		config, err = r.Config.List(ctx, id)
+		if err != nil || len(config) != 1 {
+			return fmt.Errorf("got %d config entries after delete, error %v", len(config), err)
+		}
+		if err := r.Config.Set(ctx, uuid.New().String(), "subject", "x", ""); err == nil {
+			return errors.New("set config of a template that does not exist")
+		}
+		return nil
+	})
+}
+
+func testSettings(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		// The prefix ends in _ so a LIKE pattern would also match the last key.
+		prefix := "repotest." + f.suffix + "_"
+		keys := []string{prefix + "host", prefix + "port", prefix + "password", "repotest." + f.suffix + "x.other"}
+		defer func() {
+			for _, key := range keys {
+				_ = r.Settings.Delete(ctx, key)
+			}
+		}()
+
+		for _, c := range []struct{ key, value, description string }{
+			{keys[0], "smtp.example.com", "SMTP host"},
+			{keys[1], "25", "SMTP port"},
+			{keys[1], "587", ""},
+			{keys[3], "x", ""},
+		} {
+			if err := r.Settings.Set(ctx, c.key, c.value, c.description, false, "conformance"); err != nil {
+				return fmt.Errorf("setting %s: %w", c.key, err)
+			}
+		}
+
+		values, err := r.Settings.Values(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("reading values: %w", err)
+		}
+		if len(values) != 2 || values[keys[0]] != "smtp.example.com" || values[keys[1]] != "587" {
+			return fmt.Errorf("got values %v, want the host and the last port", values)
+		}
+
+		list, err := r.Settings.List(ctx)
+		if err != nil {
+			return fmt.Errorf("listing settings: %w", err)
+		}
+		var port *models.Configuration
+		for i := range list {
+			if list[i].ConfigKey == keys[1] {
+				port = &list[i]
+			}
+		}
+		if port == nil || port.ConfigValue != "587" || port.Description != "SMTP port" || port.IsEncrypted ||
+			port.LastUpdatedBy != "conformance" {
+			return fmt.Errorf("listed port is %+v, want 587 keeping its description", port)
+		}
+
+		// Encrypted values need CONFIG_ENCRYPTION_KEY.
+		err = r.Settings.Set(ctx, keys[2], "secret", "", true, "conformance")
+		if err != nil && !errors.Is(err, models.ErrNoEncryptionKey) {
+			return fmt.Errorf("setting encrypted value: %w", err)
+		}
+		if err == nil {
+			values, err := r.Settings.Values(ctx, prefix)
+			if err != nil || values[keys[2]] != "secret" {
+				return fmt.Errorf("got encrypted value %q, error %v, want it decrypted", values[keys[2]], err)
+			}
+			list, err := r.Settings.List(ctx)
+			if err != nil {
+				return fmt.Errorf("listing settings: %w", err)
+			}
+			for _, c := range list {
+				if c.ConfigKey == keys[2] && (!c.IsEncrypted || c.ConfigValue == "secret") {
+					return fmt.Errorf("encrypted value is listed as %+v", c)
+				}
+			}
+		}
+
+		if err := r.Settings.Delete(ctx, keys[0]); err != nil {
+			return fmt.Errorf("deleting setting: %w", err)
+		}
+		values, err = r.Settings.Values(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("reading values: %w", err)
+		}
+		if _, ok := values[keys[0]]; ok {
+			return errors.New("deleted setting is still returned")
+		}
+		return nil
+	})
+}
+
+func testVersions(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("report", "x")
+		if err != nil {
+			return err
+		}
+		for _, version := range []int{2, 1} {
+			if _, err := r.Versions.Create(ctx, models.TemplateVersion{
+				TemplateID: id, Version: version, Content: fmt.Sprintf("v%d", version), Format: "html",
+				CreatedBy: "conformance", ChangeNotes: "imported",
+			}); err != nil {
+				return fmt.Errorf("creating version: %w", err)
+			}
+		}
+		_, err = r.Versions.Create(ctx, models.TemplateVersion{TemplateID: id, Version: 1, Content: "again", Format: "html", CreatedBy: "conformance"})
+		if err := expectUniqueViolation("creating duplicate version", err); err != nil {
+			return err
+		}
+
+		versions, err := r.Versions.List(ctx, id)
+		if err != nil {
+			return fmt.Errorf("listing versions: %w", err)
+		}
+		if len(versions) != 2 || versions[0].Version != 1 || versions[1].Version != 2 {
+			return fmt.Errorf("got versions %+v, want 1 and 2 in order", versions)
+		}
+		if v := versions[0]; v.Content != "v1" || v.ChangeNotes != "imported" || v.CreatedAt.IsZero() || v.TemplateID != id {
+			return fmt.Errorf("got version %+v", v)
+		}
+		return nil
+	})
+}
+
+func testVersionAt(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("notice", "first")
+		if err != nil {
+			return err
+		}
+		if err := r.Templates.Update(ctx, id, f.name("notice"), f.category(), "second", "text", "editor"); err != nil {
+			return fmt.Errorf("updating template: %w", err)
+		}
+
+		for version, content := range map[int]string{1: "first", 2: "second"} {
+			t, err := r.Versions.At(ctx, id, version)
+			if err != nil {
+				return fmt.Errorf("getting version %d: %w", version, err)
+			}
+			if t.Content != content || t.Name != f.name("notice") || t.Version != version {
+				return fmt.Errorf("version %d is %q %q, want %q", version, t.Name, t.Content, content)
+			}
+		}
+
+		// Versions missing from the audit log come from the snapshots.
+		if _, err := r.Versions.Create(ctx, models.TemplateVersion{TemplateID: id, Version: 7, Content: "seventh", Format: "html", CreatedBy: "conformance"}); err != nil {
+			return fmt.Errorf("creating version: %w", err)
+		}
+		t, err := r.Versions.At(ctx, id, 7)
+		if err != nil {
+			return fmt.Errorf("getting version 7: %w", err)
+		}
+		if t.Content != "seventh" {
+			return fmt.Errorf("version 7 has content %q", t.Content)
+		}
+
+		_, err = r.Versions.At(ctx, id, 3)
+		return expectNoRows("getting unknown version", err)
+	})
+}
+
+func testAudit(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("audited", "x")
+		if err != nil {
+			return err
+		}
+		if err := r.Templates.Update(ctx, id, f.name("audited"), f.category(), "y", "html", "editor"); err != nil {
+			return fmt.Errorf("updating template: %w", err)
+		}
+		if err := r.Templates.Delete(ctx, id, "alice"); err != nil {
+			return fmt.Errorf("deleting template: %w", err)
+		}
+		if err := r.Templates.Purge(ctx, id, "admin"); err != nil {
+			return fmt.Errorf("purging template: %w", err)
+		}
+
+		entries, err := r.Audit.Entries(ctx, "template", id)
+		if err != nil {
+			return fmt.Errorf("reading audit log: %w", err)
+		}
+		var actions []string
+		for _, e := range entries {
+			actions = append(actions, e.Action)
+		}
+		want := []string{models.AuditInsert, models.AuditUpdate, models.AuditUpdate, models.AuditDelete}
+		if fmt.Sprint(actions) != fmt.Sprint(want) {
+			return fmt.Errorf("got actions %v, want %v", actions, want)
+		}
+
+		deleted, purged := entries[2], entries[3]
+		if deleted.UserID != "alice" || purged.UserID != "admin" {
+			return fmt.Errorf("delete attributed to %q and purge to %q, want alice and admin", deleted.UserID, purged.UserID)
+		}
+		var row struct {
+			Version  int  `json:"version"`
+			IsActive bool `json:"is_active"`
+		}
+		if err := json.Unmarshal(deleted.New, &row); err != nil {
+			return fmt.Errorf("decoding audit entry: %w", err)
+		}
+		if row.Version != 2 || row.IsActive {
+			return fmt.Errorf("delete recorded version %d, active %t", row.Version, row.IsActive)
+		}
+		if string(purged.New) != "null" || string(purged.Old) == "null" {
+			return fmt.Errorf("purge recorded old %s and new %s", purged.Old, purged.New)
+		}
+		return nil
+	})
+}
+
+func testExport(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		beta, err := f.createTemplate("beta", "b")
+		if err != nil {
+			return err
+		}
+		alpha, err := f.createTemplate("alpha", "a")
+		if err != nil {
+			return err
+		}
+		if err := r.Variables.Add(ctx, alpha, "name", "recipient", "Ada", true); err != nil {
+			return fmt.Errorf("adding variable: %w", err)
+		}
+		if err := r.Config.Set(ctx, alpha, "subject", "Hello", ""); err != nil {
+			return fmt.Errorf("setting config: %w", err)
+		}
+		if _, err := r.Versions.Create(ctx, models.TemplateVersion{TemplateID: alpha, Version: 1, Content: "a", Format: "html", CreatedBy: "conformance"}); err != nil {
+			return fmt.Errorf("creating version: %w", err)
+		}
+		trashed, err := f.createTemplate("trashed", "x")
+		if err != nil {
+			return err
+		}
+		if err := r.Templates.Delete(ctx, trashed, "conformance"); err != nil {
+			return fmt.Errorf("deleting template: %w", err)
+		}
+
+		exports, err := r.Bundles.Export(ctx, models.ExportFilter{CategoryID: f.categoryID, IncludeVersions: true})
+		if err != nil {
+			return fmt.Errorf("exporting category: %w", err)
+		}
+		if len(exports) != 2 || exports[0].Template.ID != alpha || exports[1].Template.ID != beta {
+			return fmt.Errorf("got %d exports, want alpha and beta in order", len(exports))
+		}
+		e := exports[0]
+		if e.Template.CategoryName != f.name("category") {
+			return fmt.Errorf("got category name %q, want %q", e.Template.CategoryName, f.name("category"))
+		}
+		if len(e.Variables) != 1 || e.Variables[0].VariableName != "name" || e.Variables[0].DefaultValue != "Ada" {
+			return fmt.Errorf("got variables %+v", e.Variables)
+		}
+		if len(e.Config) != 1 || e.Config[0].ConfigKey != "subject" || e.Config[0].ConfigValue != "Hello" {
+			return fmt.Errorf("got config %+v", e.Config)
+		}
+		if len(e.Versions) != 1 || e.Versions[0].Version != 1 {
+			return fmt.Errorf("got versions %+v", e.Versions)
+		}
+
+		exports, err = r.Bundles.Export(ctx, models.ExportFilter{IDs: []string{trashed}})
+		if err != nil {
+			return fmt.Errorf("exporting a trashed template by ID: %w", err)
+		}
+		if len(exports) != 1 || exports[0].Template.IsActive || exports[0].Versions != nil {
+			return fmt.Errorf("got exports %+v, want the inactive template without versions", exports)
+		}
+
+		_, err = r.Bundles.Export(ctx, models.ExportFilter{IDs: []string{uuid.New().String()}})
+		return expectNoRows("exporting an unknown template", err)
+	})
+}
+
+func testImport(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("invoice", "old")
+		if err != nil {
+			return err
+		}
+		exports, err := r.Bundles.Export(ctx, models.ExportFilter{IDs: []string{id}})
+		if err != nil {
+			return fmt.Errorf("exporting template: %w", err)
+		}
+		e := exports[0]
+		e.Template.Content = "new"
+		e.Variables = []models.TemplateVariable{{VariableName: "total", IsRequired: true}}
+		bundle := []models.TemplateExport{e}
+
+		// importOnce imports the bundle and returns the result of the
+		// template and the template afterwards.
+		importOnce := func(strategy string, dryRun bool) (models.ImportResult, models.Template, error) {
+			report, err := r.Bundles.Import(ctx, nil, bundle, strategy, dryRun, "importer")
+			if err != nil {
+				return models.ImportResult{}, models.Template{}, fmt.Errorf("importing with %s: %w", strategy, err)
+			}
+			if len(report.Templates) != 1 {
+				return models.ImportResult{}, models.Template{}, fmt.Errorf("importing with %s: got %d results, want 1", strategy, len(report.Templates))
+			}
+			t, err := r.Templates.Get(ctx, id)
+			if err != nil {
+				return models.ImportResult{}, models.Template{}, fmt.Errorf("getting template: %w", err)
+			}
+			return report.Templates[0], t, nil
+		}
+
+		result, t, err := importOnce(models.ImportNewVersion, true)
+		if err != nil {
+			return err
+		}
+		if result.Status != models.ImportUpdated || strings.Join(result.Changes, ",") != "content,variables" {
+			return fmt.Errorf("dry run: got %s with changes %v, want updated with content and variables", result.Status, result.Changes)
+		}
+		if t.Content != "old" || t.Version != 1 {
+			return fmt.Errorf("dry run changed the template to %q version %d", t.Content, t.Version)
+		}
+
+		result, t, err = importOnce(models.ImportSkip, false)
+		if err != nil {
+			return err
+		}
+		if result.Status != models.ImportSkipped || t.Content != "old" {
+			return fmt.Errorf("skip: got %s and content %q, want skipped and old", result.Status, t.Content)
+		}
+
+		result, t, err = importOnce(models.ImportNewVersion, false)
+		if err != nil {
+			return err
+		}
+		if result.Status != models.ImportUpdated || t.Content != "new" || t.Version != 2 || t.UpdatedBy != "importer" {
+			return fmt.Errorf("new version: got %s and %+v, want updated to version 2", result.Status, t)
+		}
+		versions, err := r.Versions.List(ctx, id)
+		if err != nil {
+			return fmt.Errorf("listing versions: %w", err)
+		}
+		if len(versions) != 1 || versions[0].Version != 1 || versions[0].Content != "old" || versions[0].ChangeNotes != "Replaced by import" {
+			return fmt.Errorf("got versions %+v, want version 1 with the replaced content", versions)
+		}
+		variables, err := r.Variables.List(ctx, id)
+		if err != nil {
+			return fmt.Errorf("listing variables: %w", err)
+		}
+		if len(variables) != 1 || variables[0].VariableName != "total" || variables[0].VariableType != "string" {
+			return fmt.Errorf("got variables %+v, want total as a string", variables)
+		}
+
+		result, _, err = importOnce(models.ImportOverwrite, false)
+		if err != nil {
+			return err
+		}
+		if result.Status != models.ImportUnchanged || len(result.Changes) != 0 {
+			return fmt.Errorf("reimport: got %s with changes %v, want unchanged", result.Status, result.Changes)
+		}
+
+		bundle[0].Template.Content, bundle[0].Template.Version = "newer", 7
+		result, t, err = importOnce(models.ImportOverwrite, false)
+		if err != nil {
+			return err
+		}
+		if result.Status != models.ImportUpdated || t.Content != "newer" || t.Version != 7 {
+			return fmt.Errorf("overwrite: got %s and %+v, want updated to the bundle's version 7", result.Status, t)
+		}
+
+		created := models.TemplateExport{
+			Template: models.Template{ID: uuid.New().String(), Name: f.name("imported"), CategoryName: f.name("imported-category"),
+				Content: "c3", Format: "html", Version: 3},
+			Config:   []models.TemplateConfig{{ConfigKey: "subject", ConfigValue: "Hi"}},
+			Versions: []models.TemplateVersion{{Version: 1, Content: "c1", Format: "html", CreatedBy: "someone"}},
+		}
+		f.templates = append(f.templates, created.Template.ID)
+		categoryList := []models.TemplateCategory{{Name: f.name("imported-category"), Description: "from the bundle"}}
+		report, err := r.Bundles.Import(ctx, categoryList, []models.TemplateExport{created}, models.ImportSkip, false, "importer")
+		defer func() {
+			f.purgeTemplates()
+			categories, _ := r.Categories.List(ctx)
+			for _, c := range categories {
+				if c.Name == f.name("imported-category") {
+					_ = r.Categories.Delete(ctx, c.ID, 0)
+				}
+			}
+		}()
+		if err != nil {
+			return fmt.Errorf("importing a new template: %w", err)
+		}
+		if len(report.CategoriesCreated) != 1 || report.CategoriesCreated[0] != f.name("imported-category") ||
+			len(report.Templates) != 1 || report.Templates[0].Status != models.ImportCreated {
+			return fmt.Errorf("got report %+v, want the template and its category created", report)
+		}
+		t, err = r.Templates.Get(ctx, created.Template.ID)
+		if err != nil {
+			return fmt.Errorf("getting imported template: %w", err)
+		}
+		if t.Version != 3 || t.CreatedBy != "importer" || !t.IsActive || t.CategoryName != f.name("imported-category") {
+			return fmt.Errorf("got imported template %+v", t)
+		}
+		config, err := r.Config.List(ctx, t.ID)
+		if err != nil {
+			return fmt.Errorf("listing config: %w", err)
+		}
+		if len(config) != 1 || config[0].ConfigValue != "Hi" {
+			return fmt.Errorf("got config %+v", config)
+		}
+		versions, err = r.Versions.List(ctx, t.ID)
+		if err != nil {
+			return fmt.Errorf("listing versions: %w", err)
+		}
+		if len(versions) != 1 || versions[0].Content != "c1" || versions[0].CreatedBy != "someone" {
+			return fmt.Errorf("got versions %+v", versions)
+		}
+		return nil
+	})
+}
+
+func testImportRollback(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("kept", "kept")
+		if err != nil {
+			return err
+		}
+		newID := uuid.New().String()
+		f.templates = append(f.templates, newID)
+		bundle := []models.TemplateExport{
+			{Template: models.Template{ID: newID, Name: f.name("rolled-back"), CategoryName: f.name("category"), Content: "x", Format: "html"}},
+			{
+				Template:  models.Template{ID: id, Name: f.name("kept"), CategoryName: f.name("category"), Content: "changed", Format: "html"},
+				Variables: []models.TemplateVariable{{VariableName: "twice"}, {VariableName: "twice"}},
+			},
+		}
+
+		_, err = r.Bundles.Import(ctx, nil, bundle, models.ImportOverwrite, false, "importer")
+		if err := expectUniqueViolation("importing a duplicate variable", err); err != nil {
+			return err
+		}
+		_, err = r.Templates.Get(ctx, newID)
+		if err := expectNoRows("getting the template created before the failure", err); err != nil {
+			return err
+		}
+		t, err := r.Templates.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("getting template: %w", err)
+		}
+		if t.Content != "kept" {
+			return fmt.Errorf("failed import changed the content to %q", t.Content)
+		}
+
+		if _, err := r.Bundles.Import(ctx, nil, bundle, "merge", false, "importer"); err == nil {
+			return errors.New("importing with an unknown strategy succeeded")
+		}
+		return nil
+	})
+}
+
+func testSyncStates(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("synced", "x")
+		if err != nil {
+			return err
+		}
+		if err := r.SyncStates.Set(ctx, id, "synced/template.yaml", "first"); err != nil {
+			return fmt.Errorf("setting sync state: %w", err)
+		}
+		if err := r.SyncStates.Set(ctx, id, "moved/template.yaml", "second"); err != nil {
+			return fmt.Errorf("replacing sync state: %w", err)
+		}
+		states, err := r.SyncStates.List(ctx)
+		if err != nil {
+			return fmt.Errorf("listing sync states: %w", err)
+		}
+		if s, ok := states[id]; !ok || s.SourcePath != "moved/template.yaml" || s.ContentHash != "second" || s.SyncedAt.IsZero() {
+			return fmt.Errorf("got sync state %+v, want the replaced one", s)
+		}
+		if err := r.SyncStates.Set(ctx, uuid.New().String(), "unknown/template.yaml", "x"); err == nil {
+			return errors.New("setting the sync state of an unknown template succeeded")
+		}
+
+		if err := r.SyncStates.Delete(ctx, id); err != nil {
+			return fmt.Errorf("deleting sync state: %w", err)
+		}
+		if states, err = r.SyncStates.List(ctx); err != nil {
+			return fmt.Errorf("listing sync states: %w", err)
+		}
+		if _, ok := states[id]; ok {
+			return errors.New("deleted sync state is still listed")
+		}
+
+		if err := r.SyncStates.Set(ctx, id, "synced/template.yaml", "third"); err != nil {
+			return fmt.Errorf("setting sync state: %w", err)
+		}
+		f.purgeTemplates()
+		if states, err = r.SyncStates.List(ctx); err != nil {
+			return fmt.Errorf("listing sync states: %w", err)
+		}
+		if _, ok := states[id]; ok {
+			return errors.New("purging the template kept its sync state")
+		}
+		return nil
+	})
+}
+
+func testSchemaVersion(ctx context.Context, r models.Repositories) error {
+	version, err := r.Schema.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	if version == "" {
+		return errors.New("got an empty schema version")
+	}
+	status, err := r.Schema.Status(ctx, "production")
+	if err != nil {
+		return fmt.Errorf("reading schema status: %w", err)
+	}
+	if status.Version != version {
+		return fmt.Errorf("got status version %q, want %q", status.Version, version)
+	}
+	for _, info := range status.SystemInfo {
+		if info.Version == version {
+			return nil
+		}
+	}
+	return fmt.Errorf("version %q is missing from system_info %+v", version, status.SystemInfo)
+}
+
+func testContextDone(ctx context.Context, r models.Repositories) error {
+	return run(ctx, r, func(f *fixture) error {
+		id, err := f.createTemplate("late", "x")
+		if err != nil {
+			return err
+		}
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := r.Templates.Get(cancelled, id); !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("getting template with a cancelled context: got error %v, want context.Canceled", err)
+		}
+		if err := r.Templates.Delete(cancelled, id, "conformance"); !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("deleting template with a cancelled context: got error %v, want context.Canceled", err)
+		}
+
+		expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+		defer cancel()
+		if _, err := r.Variables.List(expired, id); !errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("listing variables after the deadline: got error %v, want context.DeadlineExceeded", err)
+		}
+		if err := r.Config.Set(expired, id, "subject", "x", ""); !errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("setting config after the deadline: got error %v, want context.DeadlineExceeded", err)
+		}
+
+		t, err := r.Templates.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("getting template: %w", err)
+		}
+		if !t.IsActive {
+			return errors.New("delete with a cancelled context deleted the template")
+		}
+		return nil
+	})
+}
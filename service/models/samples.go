@@ -0,0 +1,216 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+)
+
+// TemplateSample is a named set of variable values used to preview a template
+// with realistic data. A sample with an expected output doubles as a golden
+// test of the template.
+type TemplateSample struct {
+	ID                int
+	TemplateID        string
+	Name              string
+	Description       string
+	Variables         map[string]string
+	HasExpectedOutput bool
+	ExpectedOutput    string
+	ExpectedUpdatedAt time.Time
+	CreatedBy         string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+const sampleColumns = `id, template_id, name, description, variables, expected_output, expected_updated_at,
+		created_by, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTemplateSample(row rowScanner) (TemplateSample, error) {
+	var s TemplateSample
+	var description, expected, createdBy sql.NullString
+	var variables []byte
+	var expectedAt, createdAt, updatedAt sql.NullTime
+	if err := row.Scan(&s.ID, &s.TemplateID, &s.Name, &description, &variables, &expected, &expectedAt,
+		&createdBy, &createdAt, &updatedAt); err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(variables, &s.Variables); err != nil {
+		return s, err
+	}
+	s.Description = description.String
+	s.HasExpectedOutput = expected.Valid
+	s.ExpectedOutput = expected.String
+	s.ExpectedUpdatedAt = expectedAt.Time
+	s.CreatedBy = createdBy.String
+	s.CreatedAt = createdAt.Time
+	s.UpdatedAt = updatedAt.Time
+	return s, nil
+}
+
+func GetTemplateSamples(ctx context.Context, templateID string) ([]TemplateSample, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT `+sampleColumns+`
+		FROM template_service.template_sample
+		WHERE template_id = $1
+		ORDER BY name
+	`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	var samples []TemplateSample
+	for rows.Next() {
+		s, err := scanTemplateSample(rows)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// GetTemplateSample returns sql.ErrNoRows when the template has no sample
+// with the given name.
+func GetTemplateSample(ctx context.Context, templateID, name string) (TemplateSample, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	return scanTemplateSample(db.DB.QueryRowContext(ctx, `
+		SELECT `+sampleColumns+`
+		FROM template_service.template_sample
+		WHERE template_id = $1 AND name = $2
+	`, templateID, name))
+}
+
+// CreateTemplateSample stores a new sample. A nil expectedOutput creates the
+// sample without a golden output.
+func CreateTemplateSample(ctx context.Context, templateID, name, description string, variables map[string]string,
+	expectedOutput *string, createdBy string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	data, err := json.Marshal(nonNilVariables(variables))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.ExecContext(ctx, `
+		INSERT INTO template_service.template_sample
+		(template_id, name, description, variables, expected_output, expected_updated_at, created_by)
+		VALUES ($1, $2, $3, $4, $5::text, CASE WHEN $5::text IS NULL THEN NULL ELSE CURRENT_TIMESTAMP END, $6)`,
+		templateID, name, description, data, nullString(expectedOutput), createdBy)
+
+	return err
+}
+
+// SetTemplateSample creates the sample or replaces its description and
+// variables. The expected output is only replaced when expectedOutput is not
+// nil.
+func SetTemplateSample(ctx context.Context, templateID, name, description string, variables map[string]string,
+	expectedOutput *string, updatedBy string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	data, err := json.Marshal(nonNilVariables(variables))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.ExecContext(ctx, `
+		INSERT INTO template_service.template_sample
+		(template_id, name, description, variables, expected_output, expected_updated_at, created_by)
+		VALUES ($1, $2, $3, $4, $5::text, CASE WHEN $5::text IS NULL THEN NULL ELSE CURRENT_TIMESTAMP END, $6)
+		ON CONFLICT (template_id, name) DO UPDATE
+		SET description = EXCLUDED.description, variables = EXCLUDED.variables,
+		    expected_output = COALESCE(EXCLUDED.expected_output, template_sample.expected_output),
+		    expected_updated_at = COALESCE(EXCLUDED.expected_updated_at, template_sample.expected_updated_at),
+		    updated_at = CURRENT_TIMESTAMP`,
+		templateID, name, description, data, nullString(expectedOutput), updatedBy)
+
+	return err
+}
+
+// SetSampleExpectedOutput replaces the golden output of a sample; nil clears
+// it. It returns sql.ErrNoRows when the sample does not exist.
+func SetSampleExpectedOutput(ctx context.Context, templateID, name string, expectedOutput *string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE template_service.template_sample
+		SET expected_output = $3::text,
+		    expected_updated_at = CASE WHEN $3::text IS NULL THEN NULL ELSE CURRENT_TIMESTAMP END
+		WHERE template_id = $1 AND name = $2`,
+		templateID, name, nullString(expectedOutput))
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteTemplateSample returns sql.ErrNoRows when there was nothing to
+// delete.
+func DeleteTemplateSample(ctx context.Context, templateID, name string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx, `
+		DELETE FROM template_service.template_sample
+		WHERE template_id = $1 AND name = $2`,
+		templateID, name)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func nullString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
+
+func nonNilVariables(variables map[string]string) map[string]string {
+	if variables == nil {
+		return map[string]string{}
+	}
+	return variables
+}
@@ -0,0 +1,302 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+	"github.com/lib/pq"
+)
+
+// Migration tools whose history tables are reported.
+const (
+	ToolLiquibase = "liquibase"
+	ToolFlyway    = "flyway"
+	// ToolService is the migration runner embedded in the service.
+	ToolService = "service"
+)
+
+// historyTables maps the history table of every tool to the tool.
+var historyTables = map[string]string{
+	"databasechangelog":      ToolLiquibase,
+	"flyway_schema_history":  ToolFlyway,
+	"service_schema_history": ToolService,
+}
+
+type SystemInfo struct {
+	Version     string    `json:"version"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AppliedChangeSet is an entry of a migration tool's history table. ID is
+// the Liquibase changeset id or the Flyway version.
+type AppliedChangeSet struct {
+	ID              string    `json:"id"`
+	Author          string    `json:"author,omitempty"`
+	Description     string    `json:"description"`
+	Script          string    `json:"script"`
+	Checksum        string    `json:"checksum"`
+	Type            string    `json:"type"`
+	AppliedAt       time.Time `json:"applied_at"`
+	ExecutionTimeMs int       `json:"execution_time_ms,omitempty"`
+	Success         bool      `json:"success"`
+}
+
+// MigrationHistory is one history table. Liquibase does not record failed
+// changesets, so Failed is only filled for Flyway and the service; a
+// Liquibase run that died instead shows as a Lock that is still held.
+type MigrationHistory struct {
+	Tool    string             `json:"tool"`
+	Table   string             `json:"table"`
+	Applied []AppliedChangeSet `json:"applied"`
+	Failed  []AppliedChangeSet `json:"failed"`
+	Lock    *ChangeLogLock     `json:"lock,omitempty"`
+}
+
+// ChangeLogLock is a held Liquibase lock. Liquibase refuses to migrate until
+// it is released, with "liquibase releaseLocks" when its owner is gone.
+type ChangeLogLock struct {
+	LockedBy    string    `json:"locked_by"`
+	LockGranted time.Time `json:"lock_granted"`
+}
+
+// PendingMigration is an embedded migration whose system_info version is
+// missing from the database.
+type PendingMigration struct {
+	Version       int    `json:"version"`
+	Script        string `json:"script"`
+	SchemaVersion string `json:"schema_version"`
+}
+
+type SchemaStatus struct {
+	Version       string             `json:"version"`
+	SystemInfo    []SystemInfo       `json:"system_info"`
+	HistoryTables []MigrationHistory `json:"history_tables"`
+	Pending       []PendingMigration `json:"pending"`
+}
+
+// GetSchemaStatus reports the schema version, the history of every
+// migration tool that ran against the database and the migrations the
+// service embeds but the database lacks.
+func GetSchemaStatus(ctx context.Context, environment string) (SchemaStatus, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	status := SchemaStatus{
+		SystemInfo:    []SystemInfo{},
+		HistoryTables: []MigrationHistory{},
+		Pending:       []PendingMigration{},
+	}
+
+	var err error
+	status.SystemInfo, err = getSystemInfo(ctx)
+	if err != nil {
+		return status, fmt.Errorf("reading system_info: %w", err)
+	}
+	installed := make(map[string]bool)
+	for _, info := range status.SystemInfo {
+		installed[info.Version] = true
+	}
+	// The highest version, as the startup check sees it, rather than the
+	// last row: a backfilled row can come after a newer one.
+	status.Version, err = db.SchemaVersion(ctx)
+	if err != nil {
+		return status, fmt.Errorf("reading the schema version: %w", err)
+	}
+
+	status.HistoryTables, err = getMigrationHistories(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	migrations, err := db.LoadMigrations(environment)
+	if err != nil {
+		return status, err
+	}
+	for _, m := range migrations {
+		if version := m.SchemaVersion(); m.Version > 0 && version != "" && !installed[version] {
+			status.Pending = append(status.Pending, PendingMigration{Version: m.Version, Script: m.Script, SchemaVersion: version})
+		}
+	}
+	return status, nil
+}
+
+func getSystemInfo(ctx context.Context) ([]SystemInfo, error) {
+	infos := []SystemInfo{}
+
+	var exists bool
+	err := db.DB.QueryRowContext(ctx, `SELECT to_regclass('template_service.system_info') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return infos, err
+	}
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT version, COALESCE(description, ''), created_at
+		FROM template_service.system_info
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var info SystemInfo
+		if err := rows.Scan(&info.Version, &info.Description, &info.CreatedAt); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+// getMigrationHistories reads every history table found in any schema, as
+// Liquibase creates databasechangelog in public when the default schema
+// does not exist yet.
+func getMigrationHistories(ctx context.Context) ([]MigrationHistory, error) {
+	var names []string
+	for name := range historyTables {
+		names = append(names, name)
+	}
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT table_schema, table_name
+		FROM information_schema.tables
+		WHERE table_name = ANY($1)
+		ORDER BY table_name, table_schema
+	`, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var tables [][2]string
+	for rows.Next() {
+		var schema, table string
+		if err := rows.Scan(&schema, &table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, [2]string{schema, table})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	histories := []MigrationHistory{}
+	for _, t := range tables {
+		history := MigrationHistory{
+			Tool:    historyTables[t[1]],
+			Table:   t[0] + "." + t[1],
+			Applied: []AppliedChangeSet{},
+			Failed:  []AppliedChangeSet{},
+		}
+		entries, err := getHistoryEntries(ctx, history.Tool, pq.QuoteIdentifier(t[0])+"."+pq.QuoteIdentifier(t[1]))
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", history.Table, err)
+		}
+		if history.Tool == ToolLiquibase {
+			history.Lock, err = getChangeLogLock(ctx, t[0])
+			if err != nil {
+				return nil, fmt.Errorf("reading %s.databasechangeloglock: %w", t[0], err)
+			}
+		}
+		for _, e := range entries {
+			if e.Success {
+				history.Applied = append(history.Applied, e)
+			} else {
+				history.Failed = append(history.Failed, e)
+			}
+		}
+		histories = append(histories, history)
+	}
+	return histories, nil
+}
+
+func getHistoryEntries(ctx context.Context, tool, table string) ([]AppliedChangeSet, error) {
+	var query string
+	switch tool {
+	case ToolLiquibase:
+		query = `
+			SELECT id, author, COALESCE(description, ''), filename, COALESCE(md5sum, ''),
+			       exectype, dateexecuted, 0, TRUE
+			FROM ` + table + `
+			ORDER BY orderexecuted`
+	default:
+		// The embedded runner's table has the same columns as Flyway's.
+		query = `
+			SELECT COALESCE(version::text, ''), '', description, script, COALESCE(checksum::text, ''),
+			       type, installed_on, ` + executionTime(tool) + `, success
+			FROM ` + table + `
+			ORDER BY installed_rank`
+	}
+
+	rows, err := db.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var entries []AppliedChangeSet
+	for rows.Next() {
+		var e AppliedChangeSet
+		var executionTime sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.Author, &e.Description, &e.Script, &e.Checksum,
+			&e.Type, &e.AppliedAt, &executionTime, &e.Success); err != nil {
+			return nil, err
+		}
+		e.ExecutionTimeMs = int(executionTime.Int64)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// getChangeLogLock returns the Liquibase lock of schema when it is held.
+func getChangeLogLock(ctx context.Context, schema string) (*ChangeLogLock, error) {
+	table := pq.QuoteIdentifier(schema) + ".databasechangeloglock"
+
+	var exists bool
+	err := db.DB.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	var lock ChangeLogLock
+	var granted sql.NullTime
+	err = db.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(lockedby, ''), lockgranted
+		FROM `+table+`
+		WHERE locked
+		LIMIT 1
+	`).Scan(&lock.LockedBy, &granted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lock.LockGranted = granted.Time
+	return &lock, nil
+}
+
+func executionTime(tool string) string {
+	if tool == ToolFlyway {
+		return "execution_time"
+	}
+	return "execution_time_ms"
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+)
+
+// Highlight markers passed to ts_headline. They are replaced with <mark>
+// tags after the snippet has been HTML escaped.
+const (
+	highlightStart = "[[mark]]"
+	highlightStop  = "[[/mark]]"
+)
+
+// TemplateSearchResult is a template matching a full-text search. Name and
+// Snippet are HTML escaped with the matching words wrapped in <mark>.
+type TemplateSearchResult struct {
+	ID              string
+	Name            string
+	HighlightedName string
+	CategoryID      int
+	CategoryName    string
+	Format          string
+	Version         int
+	CreatedAt       time.Time
+	Rank            float64
+	Snippet         string
+}
+
+// SearchTemplates runs a full-text search over template names, content and
+// variable descriptions. Snippets are taken from the content with markup
+// removed. The query uses web search syntax: quoted phrases,
+// "or" and a leading "-" to exclude words.
+func SearchTemplates(ctx context.Context, query string, limit, offset int) ([]TemplateSearchResult, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	options := "StartSel=" + highlightStart + ", StopSel=" + highlightStop
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT
+			t.id, t.name, c.id, c.name, t.format, t.version, t.created_at,
+			ts_rank(s.document, q) AS rank,
+			ts_headline('english', t.name, q, $2 || ', HighlightAll=true'),
+			ts_headline('english', regexp_replace(t.content, '<[^>]*>', ' ', 'g'), q, $2 || ', MaxFragments=2, MaxWords=25, MinWords=8')
+		FROM template_service.template_search s
+		JOIN template_service.template t ON t.id = s.template_id
+		JOIN template_service.template_category c ON t.category_id = c.id,
+			websearch_to_tsquery('english', $1) q
+		WHERE s.document @@ q AND t.is_active = true
+		ORDER BY rank DESC, t.name
+		LIMIT $3 OFFSET $4
+	`, query, options, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	results := []TemplateSearchResult{}
+	for rows.Next() {
+		var r TemplateSearchResult
+		var name, snippet string
+		if err := rows.Scan(&r.ID, &r.Name, &r.CategoryID, &r.CategoryName, &r.Format, &r.Version,
+			&r.CreatedAt, &r.Rank, &name, &snippet); err != nil {
+			return nil, err
+		}
+		r.HighlightedName = highlight(name)
+		r.Snippet = highlight(snippet)
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func highlight(s string) string {
+	s = html.EscapeString(strings.Join(strings.Fields(s), " "))
+	s = strings.ReplaceAll(s, html.EscapeString(highlightStart), "<mark>")
+	return strings.ReplaceAll(s, html.EscapeString(highlightStop), "</mark>")
+}
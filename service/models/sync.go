@@ -0,0 +1,82 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+)
+
+// SyncState records the state a template had when it was last written by
+// the templates-as-code sync, so edits made afterwards can be detected.
+type SyncState struct {
+	TemplateID  string
+	SourcePath  string
+	ContentHash string
+	SyncedAt    time.Time
+}
+
+// GetSyncStates returns the state of every managed template keyed by
+// template ID.
+func GetSyncStates(ctx context.Context) (map[string]SyncState, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT template_id, source_path, content_hash, synced_at
+		FROM template_service.template_sync_state
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	states := make(map[string]SyncState)
+	for rows.Next() {
+		var s SyncState
+		var syncedAt sql.NullTime
+		if err := rows.Scan(&s.TemplateID, &s.SourcePath, &s.ContentHash, &syncedAt); err != nil {
+			return nil, err
+		}
+		s.SyncedAt = syncedAt.Time
+		states[s.TemplateID] = s
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+func SetSyncState(ctx context.Context, templateID, sourcePath, contentHash string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.DB.ExecContext(ctx, `
+		INSERT INTO template_service.template_sync_state (template_id, source_path, content_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (template_id) DO UPDATE
+		SET source_path = EXCLUDED.source_path, content_hash = EXCLUDED.content_hash,
+		    synced_at = CURRENT_TIMESTAMP`,
+		templateID, sourcePath, contentHash)
+
+	return err
+}
+
+func DeleteSyncState(ctx context.Context, templateID string) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := db.DB.ExecContext(ctx, `
+		DELETE FROM template_service.template_sync_state
+		WHERE template_id = $1`,
+		templateID)
+
+	return err
+}
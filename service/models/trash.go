@@ -0,0 +1,160 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+)
+
+// ConfigTrashRetentionDays is the configuration key holding how many days a
+// deleted template is kept before the retention job purges it.
+const ConfigTrashRetentionDays = "templates.trash_retention_days"
+
+// TrashedTemplate is a soft-deleted template.
+type TrashedTemplate struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	CategoryID   int       `json:"category_id"`
+	CategoryName string    `json:"category_name"`
+	Format       string    `json:"format"`
+	Version      int       `json:"version"`
+	DeletedBy    string    `json:"deleted_by"`
+	DeletedAt    time.Time `json:"deleted_at"`
+}
+
+// asUser runs fn in a transaction whose audit log entries are attributed to
+// userID. fn must run its statements with the ctx it is given, which carries
+// the query deadline of the transaction.
+func asUser(ctx context.Context, userID string, fn func(ctx context.Context, tx *sql.Tx) error) (err error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Printf("Error rolling back transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `SELECT set_config('app.current_user_id', $1, true)`, userID); err != nil {
+		return err
+	}
+	if err = fn(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetTrashedTemplates returns the soft-deleted templates, most recently
+// deleted first.
+func GetTrashedTemplates(ctx context.Context) ([]TrashedTemplate, error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT
+			t.id, t.name, t.category_id, c.name, t.format, t.version,
+			t.deleted_by, COALESCE(t.deleted_at, t.updated_at, t.created_at)
+		FROM template_service.template t
+		JOIN template_service.template_category c ON t.category_id = c.id
+		WHERE t.is_active = false
+		ORDER BY 8 DESC, t.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			log.Printf("Error closing rows: %v", closeErr)
+		}
+	}()
+
+	var templates []TrashedTemplate
+	for rows.Next() {
+		var t TrashedTemplate
+		var deletedBy sql.NullString
+		if err := rows.Scan(&t.ID, &t.Name, &t.CategoryID, &t.CategoryName, &t.Format, &t.Version,
+			&deletedBy, &t.DeletedAt); err != nil {
+			return nil, err
+		}
+		t.DeletedBy = deletedBy.String
+		templates = append(templates, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// RestoreTemplate takes a template out of the trash. It returns
+// sql.ErrNoRows when the template is not in the trash.
+func RestoreTemplate(ctx context.Context, id, restoredBy string) error {
+	return asUser(ctx, restoredBy, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE template_service.template
+			SET is_active = true, deleted_at = NULL, deleted_by = NULL,
+			    updated_by = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND is_active = false`,
+			id, restoredBy)
+		if err != nil {
+			return err
+		}
+		return requireAffected(result)
+	})
+}
+
+// PurgeTemplate permanently deletes a template in the trash with its
+// versions, variables, samples and configuration. It returns sql.ErrNoRows
+// when the template is not in the trash, so an active template has to be
+// deleted first.
+func PurgeTemplate(ctx context.Context, id, purgedBy string) error {
+	return asUser(ctx, purgedBy, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM template_service.template
+			WHERE id = $1 AND is_active = false`,
+			id)
+		if err != nil {
+			return err
+		}
+		return requireAffected(result)
+	})
+}
+
+// PurgeDeletedTemplates permanently deletes the templates moved to the trash
+// before cutoff and returns how many were deleted.
+func PurgeDeletedTemplates(ctx context.Context, cutoff time.Time, purgedBy string) (int64, error) {
+	var purged int64
+	err := asUser(ctx, purgedBy, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM template_service.template
+			WHERE is_active = false
+			  AND COALESCE(deleted_at, updated_at, created_at) < $1`,
+			cutoff)
+		if err != nil {
+			return err
+		}
+		purged, err = result.RowsAffected()
+		return err
+	})
+	return purged, err
+}
+
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
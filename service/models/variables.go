@@ -0,0 +1,117 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+	"github.com/lib/pq"
+)
+
+const defaultVariableType = "string"
+
+// UpdateTemplateVariable replaces every field of a variable. It returns
+// sql.ErrNoRows when the template has no variable with that ID.
+func UpdateTemplateVariable(ctx context.Context, templateID string, variableID int, v TemplateVariable) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	if v.VariableType == "" {
+		v.VariableType = defaultVariableType
+	}
+
+	result, err := db.DB.ExecContext(ctx, `
+		UPDATE template_service.template_variable
+		SET variable_name = $3, description = $4, default_value = $5,
+		    is_required = $6, variable_type = $7, updated_at = CURRENT_TIMESTAMP
+		WHERE template_id = $1 AND id = $2`,
+		templateID, variableID, v.VariableName, v.Description, v.DefaultValue, v.IsRequired, v.VariableType)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteTemplateVariable returns sql.ErrNoRows when the template has no
+// variable with that ID.
+func DeleteTemplateVariable(ctx context.Context, templateID string, variableID int) error {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx, `
+		DELETE FROM template_service.template_variable
+		WHERE template_id = $1 AND id = $2`,
+		templateID, variableID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ReplaceTemplateVariables makes variables the complete variable list of a
+// template in one transaction. Variables are matched by name, so existing
+// ones keep their ID; variables missing from the list are deleted.
+func ReplaceTemplateVariables(ctx context.Context, templateID string, variables []TemplateVariable) (err error) {
+	ctx, cancel := db.WithQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Printf("Error rolling back transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	names := make([]string, 0, len(variables))
+	for _, v := range variables {
+		names = append(names, v.VariableName)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		DELETE FROM template_service.template_variable
+		WHERE template_id = $1 AND variable_name <> ALL($2)`,
+		templateID, pq.Array(names)); err != nil {
+		return err
+	}
+
+	for _, v := range variables {
+		if v.VariableType == "" {
+			v.VariableType = defaultVariableType
+		}
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO template_service.template_variable
+			(template_id, variable_name, description, default_value, is_required, variable_type)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (template_id, variable_name) DO UPDATE
+			SET description = EXCLUDED.description, default_value = EXCLUDED.default_value,
+			    is_required = EXCLUDED.is_required, variable_type = EXCLUDED.variable_type,
+			    updated_at = CURRENT_TIMESTAMP`,
+			templateID, v.VariableName, v.Description, v.DefaultValue, v.IsRequired, v.VariableType); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,59 @@
+package pdf
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FakeRenderer records the documents it is asked to render and returns a
+// fixed payload. It is meant for tests and for running without any PDF
+// tooling.
+type FakeRenderer struct {
+	mu        sync.Mutex
+	documents []Document
+
+	Output []byte
+	Err    error
+	// Delay simulates a slow render; it is cut short when the context ends.
+	Delay time.Duration
+}
+
+func NewFakeRenderer() *FakeRenderer {
+	return &FakeRenderer{Output: []byte("%PDF-1.4\n%fake\n%%EOF\n")}
+}
+
+func (r *FakeRenderer) Name() string {
+	return RendererFake
+}
+
+func (r *FakeRenderer) Check() error {
+	return nil
+}
+
+func (r *FakeRenderer) Render(ctx context.Context, doc Document) ([]byte, error) {
+	if r.Delay > 0 {
+		select {
+		case <-time.After(r.Delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.documents = append(r.documents, doc)
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return append([]byte(nil), r.Output...), nil
+}
+
+// Documents returns a copy of every document rendered so far.
+func (r *FakeRenderer) Documents() []Document {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Document(nil), r.documents...)
+}
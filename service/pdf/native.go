@@ -0,0 +1,578 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NativeRenderer is a pure-Go renderer producing simple text PDFs with the
+// standard Helvetica fonts. It understands plain text and the common
+// Markdown constructs; HTML is reduced to its text. It is the fallback when
+// wkhtmltopdf is not installed and is good enough for text and markdown
+// templates, but it does not apply any CSS.
+type NativeRenderer struct {
+	PageWidth  float64
+	PageHeight float64
+	Margin     float64
+	FontSize   float64
+}
+
+// NewNativeRenderer returns a renderer for A4 pages with 20mm margins.
+func NewNativeRenderer() *NativeRenderer {
+	return &NativeRenderer{
+		PageWidth:  595.28,
+		PageHeight: 841.89,
+		Margin:     56.69,
+		FontSize:   11,
+	}
+}
+
+func (r *NativeRenderer) Name() string {
+	return RendererNative
+}
+
+func (r *NativeRenderer) Check() error {
+	return nil
+}
+
+type blockKind int
+
+const (
+	blockParagraph blockKind = iota
+	blockHeading
+	blockBullet
+	blockBlank
+)
+
+type block struct {
+	kind  blockKind
+	level int
+	text  string
+}
+
+type layoutLine struct {
+	text   string
+	size   float64
+	bold   bool
+	indent float64
+	gap    float64
+	// marker is drawn in the left gutter on the same baseline, e.g. the
+	// bullet of a list item.
+	marker string
+	// heading is set on the first line of a heading so the table of
+	// contents can point at the page it lands on.
+	heading      string
+	headingLevel int
+}
+
+type placedText struct {
+	x, y float64
+	size float64
+	bold bool
+	text string
+}
+
+type nativePage struct {
+	texts []placedText
+}
+
+type tocEntry struct {
+	title string
+	level int
+	page  int
+}
+
+func (r *NativeRenderer) Render(ctx context.Context, doc Document) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var blocks []block
+	switch doc.Format {
+	case "html":
+		blocks = markdownBlocks(htmlToMarkdown(doc.Content))
+	case "markdown":
+		blocks = markdownBlocks(doc.Content)
+	default:
+		blocks = textBlocks(doc.Content)
+	}
+
+	header := collapseSpace(htmlToText(doc.HeaderHTML))
+	footer := collapseSpace(htmlToText(doc.FooterHTML))
+
+	top := r.PageHeight - r.Margin
+	if header != "" {
+		top -= 2 * r.FontSize
+	}
+	bottom := r.Margin
+	if footer != "" {
+		bottom += 2 * r.FontSize
+	}
+	if doc.PageNumberFormat != "" {
+		bottom += 2 * r.FontSize
+	}
+
+	bodyPages, toc := r.paginate(r.layout(blocks), top, bottom)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var tocPages []nativePage
+	if doc.TableOfContents && len(toc) > 0 {
+		title := doc.TableOfContentsTitle
+		if title == "" {
+			title = "Table of Contents"
+		}
+		// The table of contents is laid out twice: once to learn how many
+		// pages it takes, then again with page numbers shifted by that count.
+		tocPages = r.tocPages(title, toc, 0, top, bottom)
+		tocPages = r.tocPages(title, toc, len(tocPages), top, bottom)
+	}
+
+	pages := append(tocPages, bodyPages...)
+	total := strconv.Itoa(len(pages))
+	streams := make([][]byte, 0, len(pages))
+	for i, p := range pages {
+		var content bytes.Buffer
+		if doc.WatermarkText != "" {
+			r.writeWatermark(&content, doc.WatermarkText)
+		}
+		if header != "" {
+			r.writeAligned(&content, header, r.PageHeight-r.Margin+r.FontSize/2, 9, "center")
+		}
+		if footer != "" {
+			r.writeAligned(&content, footer, r.Margin, 9, "center")
+		}
+		if doc.PageNumberFormat != "" {
+			y := r.Margin
+			if footer != "" {
+				y += 1.5 * r.FontSize
+			}
+			text := pageNumberText(doc.PageNumberFormat, strconv.Itoa(i+1), total)
+			r.writeAligned(&content, text, y, 9, doc.PageNumberAlign)
+		}
+		for _, t := range p.texts {
+			writeText(&content, t)
+		}
+		streams = append(streams, content.Bytes())
+	}
+
+	return writePDF(doc.Title, r.PageWidth, r.PageHeight, streams), nil
+}
+
+// layout wraps the blocks into lines fitting the text width.
+func (r *NativeRenderer) layout(blocks []block) []layoutLine {
+	width := r.PageWidth - 2*r.Margin
+	var lines []layoutLine
+
+	for _, b := range blocks {
+		switch b.kind {
+		case blockBlank:
+			lines = append(lines, layoutLine{size: r.FontSize})
+		case blockHeading:
+			size := headingSize(b.level, r.FontSize)
+			for i, text := range wrapText(b.text, width, size, true) {
+				l := layoutLine{text: text, size: size, bold: true}
+				if i == 0 {
+					l.gap = size / 2
+					l.heading = b.text
+					l.headingLevel = b.level
+				}
+				lines = append(lines, l)
+			}
+		case blockBullet:
+			const indent = 14
+			for i, text := range wrapText(b.text, width-indent, r.FontSize, false) {
+				l := layoutLine{text: text, size: r.FontSize, indent: indent}
+				if i == 0 {
+					l.marker = "•"
+				}
+				lines = append(lines, l)
+			}
+		default:
+			wrapped := wrapText(b.text, width, r.FontSize, false)
+			if len(wrapped) == 0 {
+				wrapped = []string{""}
+			}
+			for _, text := range wrapped {
+				lines = append(lines, layoutLine{text: text, size: r.FontSize})
+			}
+		}
+	}
+	return lines
+}
+
+// paginate places lines on pages from top to bottom.
+func (r *NativeRenderer) paginate(lines []layoutLine, top, bottom float64) ([]nativePage, []tocEntry) {
+	var pages []nativePage
+	var toc []tocEntry
+	current := nativePage{}
+	y := top
+
+	for _, l := range lines {
+		height := l.size * 1.35
+		if y-l.gap-height < bottom && len(current.texts) > 0 {
+			pages = append(pages, current)
+			current = nativePage{}
+			y = top
+		}
+		if len(current.texts) > 0 {
+			y -= l.gap
+		}
+		y -= height
+
+		if l.heading != "" {
+			toc = append(toc, tocEntry{title: l.heading, level: l.headingLevel, page: len(pages)})
+		}
+		if l.marker != "" {
+			current.texts = append(current.texts, placedText{
+				x: r.Margin + 4, y: y, size: l.size, text: l.marker,
+			})
+		}
+		if l.text != "" {
+			current.texts = append(current.texts, placedText{
+				x: r.Margin + l.indent, y: y, size: l.size, bold: l.bold, text: l.text,
+			})
+		}
+	}
+
+	if len(current.texts) > 0 || len(pages) == 0 {
+		pages = append(pages, current)
+	}
+	return pages, toc
+}
+
+// tocPages lists the level 1-3 headings with their page numbers, shifted by
+// offset pages that precede the body.
+func (r *NativeRenderer) tocPages(title string, toc []tocEntry, offset int, top, bottom float64) []nativePage {
+	titleSize := headingSize(1, r.FontSize)
+	y := top - titleSize*1.35
+	current := nativePage{texts: []placedText{{x: r.Margin, y: y, size: titleSize, bold: true, text: title}}}
+	var pages []nativePage
+
+	for _, entry := range toc {
+		if entry.level > 3 {
+			continue
+		}
+		height := r.FontSize*1.35 + 2
+		if y-height < bottom {
+			pages = append(pages, current)
+			current = nativePage{}
+			y = top
+		}
+		y -= height
+
+		number := strconv.Itoa(entry.page + offset + 1)
+		indent := float64(entry.level-1) * 12
+		numberWidth := textWidth(number, r.FontSize, false)
+		available := r.PageWidth - 2*r.Margin - indent - numberWidth - 12
+		label := entry.title
+		if wrapped := wrapText(label, available, r.FontSize, false); len(wrapped) > 0 {
+			label = wrapped[0]
+		}
+
+		current.texts = append(current.texts,
+			placedText{x: r.Margin + indent, y: y, size: r.FontSize, text: label},
+			placedText{x: r.PageWidth - r.Margin - numberWidth, y: y, size: r.FontSize, text: number},
+		)
+	}
+	return append(pages, current)
+}
+
+func (r *NativeRenderer) writeAligned(buf *bytes.Buffer, text string, y, size float64, align string) {
+	width := textWidth(text, size, false)
+	x := (r.PageWidth - width) / 2
+	switch align {
+	case "left":
+		x = r.Margin
+	case "right":
+		x = r.PageWidth - r.Margin - width
+	}
+	writeText(buf, placedText{x: x, y: y, size: size, text: text})
+}
+
+func (r *NativeRenderer) writeWatermark(buf *bytes.Buffer, text string) {
+	const size = 96
+	angle := math.Pi / 4
+	c, s := math.Cos(angle), math.Sin(angle)
+	width := textWidth(text, size, true)
+	cx, cy := r.PageWidth/2, r.PageHeight/2
+	x := cx - width/2*c + size/3*s
+	y := cy - width/2*s - size/3*c
+
+	fmt.Fprintf(buf, "q 0.85 g BT /F2 %d Tf %.4f %.4f %.4f %.4f %.2f %.2f Tm (%s) Tj ET Q\n",
+		size, c, s, -s, c, x, y, encodeText(text))
+}
+
+func writeText(buf *bytes.Buffer, t placedText) {
+	font := "F1"
+	if t.bold {
+		font = "F2"
+	}
+	fmt.Fprintf(buf, "BT /%s %.1f Tf 0 g 1 0 0 1 %.2f %.2f Tm (%s) Tj ET\n",
+		font, t.size, t.x, t.y, encodeText(t.text))
+}
+
+func headingSize(level int, base float64) float64 {
+	switch level {
+	case 1:
+		return base + 9
+	case 2:
+		return base + 5
+	case 3:
+		return base + 3
+	default:
+		return base + 1
+	}
+}
+
+// wrapText breaks text into lines no wider than width. Words longer than a
+// line are split by character.
+func wrapText(text string, width, size float64, bold bool) []string {
+	var lines []string
+	var current string
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if textWidth(candidate, size, bold) <= width {
+			current = candidate
+			continue
+		}
+		if current != "" {
+			lines = append(lines, current)
+			current = ""
+		}
+		for textWidth(word, size, bold) > width {
+			runes := []rune(word)
+			cut := len(runes)
+			for cut > 1 && textWidth(string(runes[:cut]), size, bold) > width {
+				cut--
+			}
+			lines = append(lines, string(runes[:cut]))
+			word = string(runes[cut:])
+		}
+		current = word
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// helveticaWidths holds the advance widths of the printable ASCII characters
+// in Helvetica, in thousandths of the font size.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+func textWidth(text string, size float64, bold bool) float64 {
+	total := 0
+	for _, r := range text {
+		if r >= 32 && r < 127 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	width := float64(total) * size / 1000
+	if bold {
+		// Helvetica-Bold is slightly wider; this is close enough for wrapping.
+		width *= 1.06
+	}
+	return width
+}
+
+// winAnsi maps the non Latin-1 characters of the WinAnsi encoding.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// encodeText converts text to a WinAnsi PDF string body, escaping the
+// characters that are special inside parentheses.
+func encodeText(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r >= 32 && r < 127:
+			b.WriteByte(byte(r))
+		case r >= 0xA0 && r <= 0xFF:
+			b.WriteByte(byte(r))
+		default:
+			if c, ok := winAnsi[r]; ok {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('?')
+			}
+		}
+	}
+	return b.String()
+}
+
+func writePDF(title string, width, height float64, streams [][]byte) []byte {
+	var buf bytes.Buffer
+	offsets := []int{0}
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets)-1, body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	kids := make([]string, len(streams))
+	for i := range streams {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(streams)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (template-service) >>", encodeText(title)))
+
+	for i, stream := range streams {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", width, height, 7+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(stream), stream))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets))
+	for _, off := range offsets[1:] {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets), xref)
+	return buf.Bytes()
+}
+
+func textBlocks(content string) []block {
+	var blocks []block
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			blocks = append(blocks, block{kind: blockBlank})
+			continue
+		}
+		blocks = append(blocks, block{kind: blockParagraph, text: line})
+	}
+	return blocks
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	mdBullet  = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdLink    = regexp.MustCompile(`\[([^\]]*)\]\(([^)]*)\)`)
+	mdImage   = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdEmph    = regexp.MustCompile("(\\*\\*|__|\\*|_|`)")
+)
+
+// markdownBlocks parses headings, bullets and paragraphs. Consecutive text
+// lines form one paragraph as in Markdown; inline markup is dropped.
+func markdownBlocks(content string) []block {
+	var blocks []block
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			blocks = append(blocks, block{kind: blockParagraph, text: strings.Join(paragraph, " ")})
+			paragraph = nil
+		}
+	}
+
+	inCode := false
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			flush()
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			blocks = append(blocks, block{kind: blockParagraph, text: line})
+			continue
+		}
+
+		switch {
+		case trimmed == "":
+			flush()
+			blocks = append(blocks, block{kind: blockBlank})
+		case mdHeading.MatchString(trimmed):
+			flush()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			blocks = append(blocks, block{kind: blockHeading, level: len(m[1]), text: inlineText(m[2])})
+		case mdBullet.MatchString(line):
+			flush()
+			blocks = append(blocks, block{kind: blockBullet, text: inlineText(mdBullet.FindStringSubmatch(line)[1])})
+		default:
+			paragraph = append(paragraph, inlineText(trimmed))
+		}
+	}
+	flush()
+	return blocks
+}
+
+func inlineText(text string) string {
+	text = mdImage.ReplaceAllString(text, "$1")
+	text = mdLink.ReplaceAllString(text, "$1 ($2)")
+	return mdEmph.ReplaceAllString(text, "")
+}
+
+var (
+	htmlDropped   = regexp.MustCompile(`(?is)<(head|script|style)[^>]*>.*?</(head|script|style)>`)
+	htmlHeading   = regexp.MustCompile(`(?i)<h([1-6])[^>]*>`)
+	htmlHeadEnd   = regexp.MustCompile(`(?i)</h[1-6]\s*>`)
+	htmlListItem  = regexp.MustCompile(`(?i)<li[^>]*>`)
+	htmlBreak     = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlBlock     = regexp.MustCompile(`(?i)</?(p|div|tr|ul|ol|table|section|article|header|footer|blockquote)[^>]*>`)
+	htmlCell      = regexp.MustCompile(`(?i)</t[dh]\s*>`)
+	htmlTag       = regexp.MustCompile(`<[^>]*>`)
+	multipleSpace = regexp.MustCompile(`[ \t]+`)
+)
+
+// htmlToMarkdown reduces HTML to Markdown-like text keeping headings, list
+// items and block boundaries.
+func htmlToMarkdown(content string) string {
+	content = htmlDropped.ReplaceAllString(content, "")
+	content = htmlHeading.ReplaceAllStringFunc(content, func(tag string) string {
+		level, _ := strconv.Atoi(htmlHeading.FindStringSubmatch(tag)[1])
+		return "\n\n" + strings.Repeat("#", level) + " "
+	})
+	content = htmlHeadEnd.ReplaceAllString(content, "\n\n")
+	content = htmlListItem.ReplaceAllString(content, "\n- ")
+	content = htmlBreak.ReplaceAllString(content, "\n")
+	content = htmlBlock.ReplaceAllString(content, "\n\n")
+	content = htmlCell.ReplaceAllString(content, "  ")
+
+	// Drop the markup and squeeze the indentation HTML sources carry.
+	content = htmlTag.ReplaceAllString(content, "")
+	content = html.UnescapeString(content)
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(multipleSpace.ReplaceAllString(line, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func htmlToText(content string) string {
+	content = htmlDropped.ReplaceAllString(content, "")
+	content = htmlTag.ReplaceAllString(content, " ")
+	return html.UnescapeString(content)
+}
+
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,86 @@
+package pdf
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Document is a rendered template ready to be turned into a PDF. Content holds
+// the template output in its own format; header and footer are HTML fragments
+// rendered from the partial templates configured for the template.
+type Document struct {
+	Title                string
+	Content              string
+	Format               string
+	HeaderHTML           string
+	FooterHTML           string
+	PageNumberFormat     string
+	PageNumberAlign      string
+	WatermarkText        string
+	WatermarkImage       string
+	TableOfContents      bool
+	TableOfContentsTitle string
+}
+
+// Renderer converts documents to PDF bytes.
+type Renderer interface {
+	// Name identifies the renderer in logs and configuration.
+	Name() string
+	// Check verifies the renderer can run in this environment.
+	Check() error
+	// Render must stop work and release any resources once ctx is done.
+	Render(ctx context.Context, doc Document) ([]byte, error)
+}
+
+const (
+	RendererAuto        = "auto"
+	RendererWkhtmltopdf = "wkhtmltopdf"
+	RendererNative      = "native"
+	RendererFake        = "fake"
+)
+
+// NewRenderer returns the renderer registered under name without checking
+// whether it can run.
+func NewRenderer(name string) (Renderer, error) {
+	switch strings.ToLower(name) {
+	case RendererWkhtmltopdf:
+		return NewWkhtmltopdfRenderer(), nil
+	case RendererNative:
+		return NewNativeRenderer(), nil
+	case RendererFake:
+		return NewFakeRenderer(), nil
+	default:
+		return nil, fmt.Errorf("unknown PDF renderer %q", name)
+	}
+}
+
+// SelectRenderer returns the configured renderer after running its capability
+// check. "auto" (or an empty name) prefers wkhtmltopdf and falls back to the
+// native renderer when the binary is not available.
+func SelectRenderer(name string) (Renderer, error) {
+	if name == "" || strings.EqualFold(name, RendererAuto) {
+		wk := NewWkhtmltopdfRenderer()
+		if err := wk.Check(); err != nil {
+			log.Printf("wkhtmltopdf not available (%v), falling back to native PDF renderer", err)
+			return NewNativeRenderer(), nil
+		}
+		return wk, nil
+	}
+
+	renderer, err := NewRenderer(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := renderer.Check(); err != nil {
+		return nil, fmt.Errorf("PDF renderer %s is not usable: %w", renderer.Name(), err)
+	}
+	return renderer, nil
+}
+
+// pageNumberText expands the {page} and {total} placeholders of a page number
+// format using the given replacements.
+func pageNumberText(format, page, total string) string {
+	return strings.NewReplacer("{page}", page, "{total}", total).Replace(format)
+}
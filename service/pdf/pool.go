@@ -0,0 +1,155 @@
+package pdf
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when the pool already holds as many renders as
+// it is allowed to queue.
+var ErrQueueFull = errors.New("pdf: render queue is full")
+
+// metrics are published on /debug/vars under "pdf".
+var metrics = expvar.NewMap("pdf")
+
+var (
+	metricQueueDepth   = new(expvar.Int)
+	metricActive       = new(expvar.Int)
+	metricCompleted    = new(expvar.Int)
+	metricFailed       = new(expvar.Int)
+	metricRejected     = new(expvar.Int)
+	metricTimedOut     = new(expvar.Int)
+	metricDurationMs   = new(expvar.Int)
+	metricLastDuration = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("queue_depth", metricQueueDepth)
+	metrics.Set("active_renders", metricActive)
+	metrics.Set("renders_completed", metricCompleted)
+	metrics.Set("renders_failed", metricFailed)
+	metrics.Set("renders_rejected", metricRejected)
+	metrics.Set("renders_timed_out", metricTimedOut)
+	metrics.Set("render_duration_ms_total", metricDurationMs)
+	metrics.Set("render_duration_ms_last", metricLastDuration)
+}
+
+// Pool bounds the number of concurrent renders of the wrapped renderer.
+// Requests beyond the worker count wait in a queue of limited size; once that
+// is full, Render fails fast with ErrQueueFull. Every render gets a deadline
+// derived from the caller's context and the pool timeout.
+type Pool struct {
+	renderer Renderer
+	workers  chan struct{}
+	queue    chan struct{}
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	average time.Duration
+}
+
+// NewPool wraps renderer with at most workers concurrent renders and
+// queueSize renders waiting for a worker.
+func NewPool(renderer Renderer, workers, queueSize int, timeout time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &Pool{
+		renderer: renderer,
+		workers:  make(chan struct{}, workers),
+		queue:    make(chan struct{}, workers+queueSize),
+		timeout:  timeout,
+	}
+}
+
+func (p *Pool) Name() string {
+	return p.renderer.Name()
+}
+
+func (p *Pool) Check() error {
+	return p.renderer.Check()
+}
+
+func (p *Pool) Render(ctx context.Context, doc Document) ([]byte, error) {
+	select {
+	case p.queue <- struct{}{}:
+	default:
+		metricRejected.Add(1)
+		return nil, ErrQueueFull
+	}
+	defer func() { <-p.queue }()
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	metricQueueDepth.Add(1)
+	select {
+	case p.workers <- struct{}{}:
+		metricQueueDepth.Add(-1)
+	case <-ctx.Done():
+		metricQueueDepth.Add(-1)
+		p.countFailure(ctx.Err())
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.workers }()
+
+	metricActive.Add(1)
+	defer metricActive.Add(-1)
+
+	start := time.Now()
+	out, err := p.renderer.Render(ctx, doc)
+	elapsed := time.Since(start)
+
+	metricDurationMs.Add(elapsed.Milliseconds())
+	metricLastDuration.Set(elapsed.Milliseconds())
+	if err != nil {
+		p.countFailure(err)
+		return nil, err
+	}
+	metricCompleted.Add(1)
+	p.observe(elapsed)
+	return out, nil
+}
+
+// RetryAfter estimates how long a rejected client should wait before the
+// queue has room again, based on recent render durations.
+func (p *Pool) RetryAfter() time.Duration {
+	p.mu.Lock()
+	average := p.average
+	p.mu.Unlock()
+
+	waiting := len(p.queue)
+	estimate := average * time.Duration(waiting) / time.Duration(cap(p.workers))
+	if estimate < time.Second {
+		return time.Second
+	}
+	return estimate
+}
+
+// observe keeps an exponentially weighted average of render durations.
+func (p *Pool) observe(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.average == 0 {
+		p.average = d
+		return
+	}
+	p.average = (p.average*4 + d) / 5
+}
+
+func (p *Pool) countFailure(err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		metricTimedOut.Add(1)
+	}
+	metricFailed.Add(1)
+}
@@ -0,0 +1,193 @@
+package pdf
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SebastiaanKlippert/go-wkhtmltopdf"
+)
+
+// pageScript copies the page variables wkhtmltopdf passes in the query string
+// of header and footer documents into elements with the matching class name.
+const pageScript = `<script>
+function subst() {
+    var vars = {};
+    var query = window.location.search.substring(1).split('&');
+    for (var i = 0; i < query.length; i++) {
+        var kv = query[i].split('=', 2);
+        vars[kv[0]] = decodeURIComponent(kv[1] || '');
+    }
+    var names = ['page', 'topage', 'section', 'subsection', 'title', 'date'];
+    for (var n = 0; n < names.length; n++) {
+        var elements = document.getElementsByClassName(names[n]);
+        for (var j = 0; j < elements.length; j++) {
+            elements[j].textContent = vars[names[n]] || '';
+        }
+    }
+}
+</script>`
+
+// WkhtmltopdfRenderer renders HTML through the wkhtmltopdf binary.
+type WkhtmltopdfRenderer struct {
+	Dpi    uint
+	Margin uint
+}
+
+func NewWkhtmltopdfRenderer() *WkhtmltopdfRenderer {
+	return &WkhtmltopdfRenderer{Dpi: 300, Margin: 20}
+}
+
+func (r *WkhtmltopdfRenderer) Name() string {
+	return RendererWkhtmltopdf
+}
+
+func (r *WkhtmltopdfRenderer) Check() error {
+	_, err := wkhtmltopdf.NewPDFGenerator()
+	return err
+}
+
+// Render runs wkhtmltopdf bound to ctx, so the process is killed when the
+// request is cancelled or times out.
+func (r *WkhtmltopdfRenderer) Render(ctx context.Context, doc Document) ([]byte, error) {
+	pdfGen, err := wkhtmltopdf.NewPDFGenerator()
+	if err != nil {
+		return nil, fmt.Errorf("creating PDF generator: %w", err)
+	}
+
+	pdfGen.Dpi.Set(r.Dpi)
+	pdfGen.Orientation.Set(wkhtmltopdf.OrientationPortrait)
+	pdfGen.MarginTop.Set(r.Margin)
+	pdfGen.MarginBottom.Set(r.Margin)
+	pdfGen.MarginLeft.Set(r.Margin)
+	pdfGen.MarginRight.Set(r.Margin)
+	if doc.Title != "" {
+		pdfGen.Title.Set(doc.Title)
+	}
+
+	workDir, err := os.MkdirTemp("", "template-pdf-")
+	if err != nil {
+		return nil, fmt.Errorf("creating PDF work directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil {
+			log.Printf("Error removing PDF work directory: %v", err)
+		}
+	}()
+
+	page, err := r.preparePage(pdfGen, doc, workDir)
+	if err != nil {
+		return nil, err
+	}
+	pdfGen.AddPage(page)
+
+	if err := pdfGen.CreateContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("generating PDF: %w", err)
+	}
+	return pdfGen.Bytes(), nil
+}
+
+// preparePage applies the document options to the generator and the page.
+// Header and footer documents are written to workDir, which wkhtmltopdf is
+// allowed to read from.
+func (r *WkhtmltopdfRenderer) preparePage(pdfGen *wkhtmltopdf.PDFGenerator, doc Document,
+	workDir string) (*wkhtmltopdf.PageReader, error) {
+	content := doc.Content
+	if doc.Format != "" && doc.Format != "html" {
+		content = "<pre style=\"white-space: pre-wrap; font-family: sans-serif;\">" +
+			template.HTMLEscapeString(content) + "</pre>"
+	}
+	if doc.WatermarkText != "" || doc.WatermarkImage != "" {
+		content = addWatermark(content, doc)
+	}
+
+	page := wkhtmltopdf.NewPageReader(strings.NewReader(content))
+
+	footer := doc.FooterHTML
+	if doc.PageNumberFormat != "" {
+		footer += pageNumberHTML(doc.PageNumberFormat, doc.PageNumberAlign)
+	}
+
+	if doc.HeaderHTML != "" || footer != "" {
+		page.Allow.Set(workDir)
+	}
+
+	if doc.HeaderHTML != "" {
+		path := filepath.Join(workDir, "header.html")
+		if err := os.WriteFile(path, []byte(headerFooterDocument(doc.HeaderHTML)), 0o600); err != nil {
+			return nil, fmt.Errorf("writing header: %w", err)
+		}
+		page.HeaderHTML.Set(path)
+		page.HeaderSpacing.Set(5)
+	}
+
+	if footer != "" {
+		path := filepath.Join(workDir, "footer.html")
+		if err := os.WriteFile(path, []byte(headerFooterDocument(footer)), 0o600); err != nil {
+			return nil, fmt.Errorf("writing footer: %w", err)
+		}
+		page.FooterHTML.Set(path)
+		page.FooterSpacing.Set(5)
+	}
+
+	if doc.TableOfContents {
+		pdfGen.TOC.Include = true
+		if doc.TableOfContentsTitle != "" {
+			pdfGen.TOC.TocHeaderText.Set(doc.TableOfContentsTitle)
+		}
+	}
+
+	return page, nil
+}
+
+// pageNumberHTML turns a format such as "Page {page} of {total}" into markup
+// filled in by pageScript.
+func pageNumberHTML(format, align string) string {
+	escaped := pageNumberText(template.HTMLEscapeString(format),
+		`<span class="page"></span>`, `<span class="topage"></span>`)
+
+	switch align {
+	case "left", "right", "center":
+	default:
+		align = "center"
+	}
+	return fmt.Sprintf(`<div style="text-align: %s; font-size: 9pt;">%s</div>`, align, escaped)
+}
+
+func headerFooterDocument(body string) string {
+	return "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"UTF-8\">\n" + pageScript +
+		"\n</head>\n<body onload=\"subst()\" style=\"margin: 0;\">\n" + body + "\n</body>\n</html>\n"
+}
+
+func watermarkHTML(doc Document) string {
+	const style = "position: fixed; top: 0; left: 0; width: 100%; height: 100%; " +
+		"z-index: 1000; pointer-events: none; display: flex; align-items: center; justify-content: center;"
+
+	var mark string
+	if doc.WatermarkImage != "" {
+		mark = fmt.Sprintf(`<img src="%s" style="max-width: 60%%; opacity: 0.15;" alt="">`,
+			template.HTMLEscapeString(doc.WatermarkImage))
+	} else {
+		mark = fmt.Sprintf(`<span style="font-size: 120pt; font-weight: bold; color: rgba(200, 0, 0, 0.15); `+
+			`-webkit-transform: rotate(-45deg); transform: rotate(-45deg);">%s</span>`,
+			template.HTMLEscapeString(doc.WatermarkText))
+	}
+	return fmt.Sprintf(`<div class="pdf-watermark" style="%s">%s</div>`, style, mark)
+}
+
+// addWatermark inserts the watermark overlay right before </body>, or at the
+// end when the template is an HTML fragment.
+func addWatermark(document string, doc Document) string {
+	overlay := watermarkHTML(doc)
+	if idx := strings.LastIndex(strings.ToLower(document), "</body>"); idx >= 0 {
+		return document[:idx] + overlay + document[idx:]
+	}
+	return document + overlay
+}
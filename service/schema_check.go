@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/handlers"
+)
+
+// Values of SCHEMA_CHECK_MODE.
+const (
+	schemaCheckFail     = "fail"
+	schemaCheckWait     = "wait"
+	schemaCheckReadOnly = "read-only"
+	schemaCheckOff      = "off"
+)
+
+// checkSchemaVersion makes sure the database schema is at least
+// db.RequiredSchemaVersion before handlers touch it. Depending on mode it
+// exits, waits up to timeout for the migrations, or starts read-only.
+func checkSchemaVersion(mode string, timeout time.Duration) {
+	var err error
+	switch mode {
+	case schemaCheckOff:
+		return
+	case schemaCheckWait:
+		err = db.WaitForSchemaVersion(context.Background(), db.RequiredSchemaVersion, timeout)
+	case schemaCheckFail, schemaCheckReadOnly:
+		err = db.CheckSchemaVersion(context.Background(), db.RequiredSchemaVersion)
+	default:
+		log.Fatalf("Unknown SCHEMA_CHECK_MODE %q, use fail, wait, read-only or off", mode)
+	}
+	if err == nil {
+		log.Printf("Database schema satisfies the required version %s", db.RequiredSchemaVersion)
+		return
+	}
+
+	var versionErr *db.SchemaVersionError
+	if mode == schemaCheckReadOnly && errors.As(err, &versionErr) {
+		log.Printf("Warning: %v. Starting in read-only mode", err)
+		handlers.ReadOnly = true
+		return
+	}
+	log.Fatalf("Error checking database schema: %v", err)
+}
@@ -0,0 +1,237 @@
+// Package schemadrift checks that the Liquibase YAML, Liquibase formatted
+// SQL and Flyway migrations build the same schema. Every set is applied to
+// its own scratch database, the resulting schemas are snapshotted from
+// pg_catalog and the snapshots are compared.
+package schemadrift
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/liquibase"
+)
+
+// Kinds of migration sets.
+const (
+	KindLiquibase = "liquibase"
+	KindFlyway    = "flyway"
+)
+
+// Source is a set of migrations: a Liquibase master changelog or a Flyway
+// script directory.
+type Source struct {
+	Name string
+	Kind string
+	Path string
+}
+
+// Options configure how the migrations are applied.
+type Options struct {
+	// Contexts selects the Liquibase changesets to run, all when empty.
+	Contexts []string
+	// Environment replaces the Flyway ${environment} placeholder.
+	Environment string
+}
+
+// Apply runs the migrations of src on conn. Liquibase changesets run in
+// order with their preconditions and contexts, each in a transaction, with
+// the search path Liquibase sets for defaultSchemaName=template_service.
+// Flyway scripts run as Flyway does with defaultSchema=template_service.
+func Apply(ctx context.Context, conn *sql.Conn, src Source, opts Options) error {
+	switch src.Kind {
+	case KindLiquibase:
+		return applyLiquibase(ctx, conn, src.Path, opts.Contexts)
+	case KindFlyway:
+		return applyFlyway(ctx, conn, src.Path, opts.Environment)
+	default:
+		return fmt.Errorf("unknown migration kind %q", src.Kind)
+	}
+}
+
+func applyLiquibase(ctx context.Context, conn *sql.Conn, master string, contexts []string) error {
+	changeSets, err := liquibase.Load(master)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `SET search_path TO template_service, public`); err != nil {
+		return err
+	}
+
+	for _, cs := range changeSets {
+		where := fmt.Sprintf("%s:%d (%s:%s)", cs.File, cs.Line, cs.Author, cs.ID)
+		if !matchesContexts(cs.Context, contexts) {
+			continue
+		}
+		if cs.Preconditions != nil {
+			run, err := checkPreconditions(ctx, conn, cs.Preconditions)
+			if err != nil {
+				return fmt.Errorf("%s: %w", where, err)
+			}
+			if !run {
+				continue
+			}
+		}
+
+		var statements []string
+		for _, change := range cs.Changes {
+			s, err := change.Statements()
+			if err != nil {
+				return fmt.Errorf("%s: %w", where, err)
+			}
+			statements = append(statements, s...)
+		}
+		if err := execInTx(ctx, conn, statements); err != nil {
+			return fmt.Errorf("%s: %w", where, err)
+		}
+	}
+	return nil
+}
+
+func applyFlyway(ctx context.Context, conn *sql.Conn, dir, environment string) error {
+	migrations, err := db.LoadMigrationsFrom(os.DirFS(dir), environment)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS template_service; SET search_path TO template_service`); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if err := execInTx(ctx, conn, []string{m.SQL()}); err != nil {
+			return fmt.Errorf("%s: %w", m.Script, err)
+		}
+	}
+	return nil
+}
+
+func execInTx(ctx context.Context, conn *sql.Conn, statements []string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, statement := range statements {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// matchesContexts evaluates a changeset context expression such as "dev",
+// "dev, test", "!prod" or "dev and !ci" against the selected contexts.
+func matchesContexts(expr string, contexts []string) bool {
+	if strings.TrimSpace(expr) == "" || len(contexts) == 0 {
+		return true
+	}
+	selected := make(map[string]bool)
+	for _, c := range contexts {
+		selected[strings.ToLower(strings.TrimSpace(c))] = true
+	}
+
+	expr = strings.NewReplacer(" or ", ",", " OR ", ",").Replace(expr)
+	for _, alternative := range strings.Split(expr, ",") {
+		all := true
+		for _, term := range strings.Split(strings.NewReplacer(" AND ", " and ").Replace(alternative), " and ") {
+			term = strings.ToLower(strings.TrimSpace(term))
+			if strings.HasPrefix(term, "!") {
+				all = all && !selected[strings.TrimPrefix(term, "!")]
+			} else {
+				all = all && selected[term]
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions reports whether the changeset should run. Failed
+// preconditions skip it with onFail MARK_RAN or CONTINUE, run it with WARN
+// and stop the run otherwise, as HALT is Liquibase's default.
+func checkPreconditions(ctx context.Context, conn *sql.Conn, p *liquibase.Preconditions) (bool, error) {
+	passed := true
+	for _, c := range p.Conditions {
+		ok, err := checkCondition(ctx, conn, c)
+		if err != nil {
+			return false, err
+		}
+		passed = passed && ok
+	}
+	if passed {
+		return true, nil
+	}
+
+	switch strings.ToUpper(p.OnFail) {
+	case "MARK_RAN", "CONTINUE":
+		return false, nil
+	case "WARN":
+		return true, nil
+	default:
+		return false, fmt.Errorf("preconditions on line %d failed", p.Line)
+	}
+}
+
+func checkCondition(ctx context.Context, conn *sql.Conn, c liquibase.Condition) (bool, error) {
+	schema := c.Attrs["schemaName"]
+	if schema == "" {
+		schema = "template_service"
+	}
+
+	var exists bool
+	var err error
+	switch c.Type {
+	case "and", "not", "or":
+		anyPassed, allPassed := false, true
+		for _, nested := range c.Nested {
+			ok, err := checkCondition(ctx, conn, nested)
+			if err != nil {
+				return false, err
+			}
+			anyPassed = anyPassed || ok
+			allPassed = allPassed && ok
+		}
+		switch c.Type {
+		case "and":
+			return allPassed, nil
+		case "or":
+			return anyPassed, nil
+		default:
+			return !anyPassed, nil
+		}
+	case "tableExists", "viewExists":
+		name := c.Attrs["tableName"]
+		if c.Type == "viewExists" {
+			name = c.Attrs["viewName"]
+		}
+		err = conn.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = $1 AND table_name = $2)`,
+			schema, name).Scan(&exists)
+	case "columnExists":
+		err = conn.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM information_schema.columns
+			               WHERE table_schema = $1 AND table_name = $2 AND column_name = $3)`,
+			schema, c.Attrs["tableName"], c.Attrs["columnName"]).Scan(&exists)
+	case "sqlCheck":
+		var result sql.NullString
+		err = conn.QueryRowContext(ctx, c.Attrs["sql"]).Scan(&result)
+		exists = strings.TrimSpace(result.String) == strings.TrimSpace(c.Attrs["expectedResult"])
+	default:
+		return false, fmt.Errorf("line %d: unsupported precondition %s", c.Line, c.Type)
+	}
+	if err != nil {
+		return false, fmt.Errorf("line %d: %s: %w", c.Line, c.Type, err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,149 @@
+package schemadrift
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Difference is an object that is missing from or defined differently in
+// at least one migration set.
+type Difference struct {
+	Kind   string `json:"kind"`
+	Object string `json:"object"`
+	// Definitions maps every set to the object's definition, "" when the
+	// set lacks it.
+	Definitions map[string]string `json:"definitions"`
+}
+
+// Compare returns the objects whose definitions differ between the
+// snapshots of the named sets, ordered by kind and name.
+func Compare(names []string, snapshots map[string]Snapshot) []Difference {
+	diffs := []Difference{}
+	for _, kind := range objectKinds {
+		objects := make(map[string]bool)
+		for _, name := range names {
+			for object := range snapshots[name][kind] {
+				objects[object] = true
+			}
+		}
+		var sorted []string
+		for object := range objects {
+			sorted = append(sorted, object)
+		}
+		sort.Strings(sorted)
+
+		for _, object := range sorted {
+			d := Difference{Kind: kind, Object: object, Definitions: make(map[string]string)}
+			same := true
+			for _, name := range names {
+				d.Definitions[name] = snapshots[name][kind][object]
+				same = same && d.Definitions[name] == d.Definitions[names[0]]
+			}
+			if !same {
+				diffs = append(diffs, d)
+			}
+		}
+	}
+	return diffs
+}
+
+// Snapshots applies every source to a scratch database created through
+// the connection string dsn and returns their snapshots. The scratch
+// databases are dropped afterwards unless keep is set.
+func Snapshots(ctx context.Context, dsn string, sources []Source, opts Options, keep bool) (map[string]Snapshot, error) {
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := admin.Close(); err != nil {
+			log.Printf("Error closing database: %v", err)
+		}
+	}()
+
+	snapshots := make(map[string]Snapshot)
+	suffix := time.Now().Format("20060102150405")
+	for _, src := range sources {
+		name := "schemadrift_" + identifier(src.Name) + "_" + suffix
+		snapshot, err := snapshotSource(ctx, admin, dsn, name, src, opts, keep)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", src.Name, err)
+		}
+		snapshots[src.Name] = snapshot
+	}
+	return snapshots, nil
+}
+
+func snapshotSource(ctx context.Context, admin *sql.DB, dsn, name string, src Source, opts Options, keep bool) (Snapshot, error) {
+	if _, err := admin.ExecContext(ctx, "CREATE DATABASE "+pq.QuoteIdentifier(name)); err != nil {
+		return nil, fmt.Errorf("creating scratch database: %w", err)
+	}
+	if keep {
+		log.Printf("Applying %s to database %s", src.Name, name)
+	} else {
+		defer func() {
+			if _, err := admin.ExecContext(ctx, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(name)); err != nil {
+				log.Printf("Error dropping scratch database %s: %v", name, err)
+			}
+		}()
+	}
+
+	scratchDSN, err := withDatabase(dsn, name)
+	if err != nil {
+		return nil, err
+	}
+	scratch, err := sql.Open("postgres", scratchDSN)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := scratch.Close(); err != nil {
+			log.Printf("Error closing scratch database: %v", err)
+		}
+	}()
+
+	// A single connection keeps the session state, such as the search path
+	// set by the migrations, as the migration tools do.
+	conn, err := scratch.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := Apply(ctx, conn, src, opts); err != nil {
+		return nil, err
+	}
+	return Take(ctx, conn)
+}
+
+var nonIdentifier = regexp.MustCompile(`[^a-z0-9_]+`)
+
+func identifier(s string) string {
+	return nonIdentifier.ReplaceAllString(strings.ToLower(s), "_")
+}
+
+// withDatabase returns dsn, a URL or key=value connection string, connecting
+// to database name instead.
+func withDatabase(dsn, name string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		u.Path = "/" + name
+		return u.String(), nil
+	}
+	// Later keys win in key=value connection strings.
+	return dsn + " dbname=" + name, nil
+}
@@ -0,0 +1,196 @@
+package schemadrift
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Object kinds of a snapshot, in report order.
+const (
+	ObjectExtension  = "extension"
+	ObjectTable      = "table"
+	ObjectColumn     = "column"
+	ObjectConstraint = "constraint"
+	ObjectIndex      = "index"
+	ObjectTrigger    = "trigger"
+	ObjectFunction   = "function"
+	ObjectRow        = "row"
+)
+
+var objectKinds = []string{
+	ObjectExtension, ObjectTable, ObjectColumn, ObjectConstraint,
+	ObjectIndex, ObjectTrigger, ObjectFunction, ObjectRow,
+}
+
+// Snapshot maps every object kind to the objects found, keyed by qualified
+// name, and their definitions.
+type Snapshot map[string]map[string]string
+
+// historyTables are created by the migration tools, not the migrations.
+var historyTables = []string{"databasechangelog", "databasechangeloglock", "flyway_schema_history", "service_schema_history"}
+
+// seedSchema is the schema whose rows are compared. Audit rows are left
+// out as they only echo the seed rows with generated ids and timestamps.
+const seedSchema = "template_service"
+
+// userSchemas restricts a query on the namespace alias n to the schemas
+// created by the migrations.
+const userSchemas = `n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_toast%' AND n.nspname NOT LIKE 'pg\_temp%'`
+
+// historyTableList is historyTables as an SQL list.
+var historyTableList = "'" + strings.Join(historyTables, "', '") + "'"
+
+// catalogQueries return the key and definition of the objects of a kind.
+var catalogQueries = map[string]string{
+	ObjectExtension: `
+		SELECT e.extname, 'schema ' || n.nspname
+		FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace
+		WHERE e.extname <> 'plpgsql'`,
+	ObjectTable: `
+		SELECT n.nspname || '.' || c.relname,
+		       CASE c.relkind WHEN 'v' THEN 'view' WHEN 'm' THEN 'materialized view' ELSE 'table' END
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'v', 'm') AND ` + userSchemas + ` AND c.relname NOT IN (` + historyTableList + `)`,
+	ObjectColumn: `
+		SELECT n.nspname || '.' || c.relname || '.' || a.attname,
+		       format_type(a.atttypid, a.atttypmod)
+		           || CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END
+		           || COALESCE(' DEFAULT ' || pg_get_expr(d.adbin, d.adrelid), '')
+		FROM pg_attribute a
+		         JOIN pg_class c ON c.oid = a.attrelid
+		         JOIN pg_namespace n ON n.oid = c.relnamespace
+		         LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attnum > 0 AND NOT a.attisdropped
+		  AND c.relkind IN ('r', 'p', 'v', 'm') AND ` + userSchemas + ` AND c.relname NOT IN (` + historyTableList + `)`,
+	ObjectConstraint: `
+		SELECT n.nspname || '.' || c.relname || '.' || con.conname, pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		         JOIN pg_class c ON c.oid = con.conrelid
+		         JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE ` + userSchemas + ` AND c.relname NOT IN (` + historyTableList + `)`,
+	ObjectIndex: `
+		SELECT n.nspname || '.' || i.relname, pg_get_indexdef(i.oid)
+		FROM pg_index x
+		         JOIN pg_class i ON i.oid = x.indexrelid
+		         JOIN pg_class c ON c.oid = x.indrelid
+		         JOIN pg_namespace n ON n.oid = i.relnamespace
+		WHERE ` + userSchemas + ` AND c.relname NOT IN (` + historyTableList + `)`,
+	ObjectTrigger: `
+		SELECT n.nspname || '.' || c.relname || '.' || t.tgname, pg_get_triggerdef(t.oid)
+		FROM pg_trigger t
+		         JOIN pg_class c ON c.oid = t.tgrelid
+		         JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE NOT t.tgisinternal AND ` + userSchemas + ` AND c.relname NOT IN (` + historyTableList + `)`,
+	ObjectFunction: `
+		SELECT n.nspname || '.' || p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ')',
+		       'returns ' || pg_get_function_result(p.oid) || ' language ' || l.lanname || E'\n' || p.prosrc
+		FROM pg_proc p
+		         JOIN pg_namespace n ON n.oid = p.pronamespace
+		         JOIN pg_language l ON l.oid = p.prolang
+		WHERE ` + userSchemas + `
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = p.oid AND d.deptype = 'e')`,
+}
+
+// Take snapshots the schema reachable through q.
+func Take(ctx context.Context, q *sql.Conn) (Snapshot, error) {
+	snapshot := make(Snapshot)
+	for _, kind := range objectKinds {
+		if kind == ObjectRow {
+			continue
+		}
+		objects, err := queryObjects(ctx, q, catalogQueries[kind])
+		if err != nil {
+			return nil, fmt.Errorf("reading %ss: %w", kind, err)
+		}
+		if kind == ObjectFunction {
+			for name, def := range objects {
+				objects[name] = functionDefinition(def)
+			}
+		}
+		snapshot[kind] = objects
+	}
+
+	rows, err := seedRows(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("reading rows: %w", err)
+	}
+	snapshot[ObjectRow] = rows
+	return snapshot, nil
+}
+
+func queryObjects(ctx context.Context, q *sql.Conn, query string, args ...interface{}) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	objects := make(map[string]string)
+	for rows.Next() {
+		var name, def string
+		if err := rows.Scan(&name, &def); err != nil {
+			return nil, err
+		}
+		objects[name] = def
+	}
+	return objects, rows.Err()
+}
+
+// functionDefinition keeps the signature of a function and replaces its
+// body by a hash, ignoring whitespace, as the three migration sets quote
+// and indent function bodies differently.
+func functionDefinition(def string) string {
+	signature, body, _ := strings.Cut(def, "\n")
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(body), " ")))
+	return signature + " body " + hex.EncodeToString(sum[:])[:12]
+}
+
+// seedRows returns the rows of every table in seedSchema as JSON, keyed by
+// table and row, with the number of identical rows as definition. Columns
+// holding generated UUIDs and timestamps are left out.
+func seedRows(ctx context.Context, q *sql.Conn) (map[string]string, error) {
+	tables, err := queryObjects(ctx, q, `
+		SELECT c.relname,
+		       COALESCE(string_agg(a.attname, ',') FILTER (
+		           WHERE format_type(a.atttypid, a.atttypmod) IN
+		                 ('uuid', 'timestamp with time zone', 'timestamp without time zone', 'date')), '')
+		FROM pg_class c
+		         JOIN pg_namespace n ON n.oid = c.relnamespace
+		         JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+		WHERE n.nspname = $1 AND c.relkind IN ('r', 'p') AND c.relname NOT IN (`+historyTableList+`)
+		GROUP BY c.relname`, seedSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make(map[string]string)
+	for table, volatile := range tables {
+		skip := []string{}
+		if volatile != "" {
+			skip = strings.Split(volatile, ",")
+		}
+		query := fmt.Sprintf(`
+			SELECT (to_jsonb(t) - $1::text[])::text, COUNT(*)::text
+			FROM %s.%s t
+			GROUP BY 1`, pq.QuoteIdentifier(seedSchema), pq.QuoteIdentifier(table))
+		rows, err := queryObjects(ctx, q, query, pq.Array(skip))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", table, err)
+		}
+		for row, count := range rows {
+			objects[seedSchema+"."+table+" "+row] = count + " row(s)"
+		}
+	}
+	return objects, nil
+}
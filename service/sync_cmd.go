@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/db"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/handlers"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/templatesync"
+)
+
+// runSync implements the "sync" subcommand. It prints the plan for the
+// templates directory and applies it with -apply. The exit status is 2 when
+// a managed template drifted, so CI can flag edits made through the UI.
+func runSync(args []string) int {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	dir := flags.String("dir", getEnv("TEMPLATE_SYNC_DIR", "templates-src"), "directory of template folders")
+	apply := flags.Bool("apply", false, "apply the plan instead of only printing it")
+	force := flags.Bool("force", false, "overwrite templates edited since the last sync")
+	prune := flags.Bool("prune", false, "move templates whose folder was removed to the trash")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if err := db.Connect(context.Background(), getEnvDuration("DB_WAIT_TIMEOUT", time.Minute)); err != nil {
+		log.Printf("Error connecting to database: %v", err)
+		return 1
+	}
+	defer func() {
+		if err := db.DB.Close(); err != nil {
+			log.Printf("Error closing database: %v", err)
+		}
+	}()
+
+	repos := models.NewPostgresRepositories()
+	plan, err := templatesync.MakePlan(context.Background(), repos, *dir)
+	if err != nil {
+		log.Printf("Error planning sync of %s: %v", *dir, err)
+		return 1
+	}
+	printPlan(os.Stdout, plan)
+
+	if *apply {
+		applied, err := templatesync.Apply(context.Background(), repos, plan, templatesync.Options{Force: *force, Prune: *prune})
+		if err != nil {
+			log.Printf("Error applying sync of %s: %v", *dir, err)
+			return 1
+		}
+		fmt.Printf("Applied %d of %d changes\n", len(applied), len(plan.Pending()))
+	}
+
+	if plan.HasDrift() {
+		return 2
+	}
+	return 0
+}
+
+func printPlan(out io.Writer, plan templatesync.Plan) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ACTION\tPATH\tTEMPLATE\tCHANGES")
+	for _, c := range plan.Changes {
+		action := c.Action
+		if c.Drift {
+			action += " (drift)"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", action, c.Path, c.TemplateID, strings.Join(c.Fields, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		log.Printf("Error writing plan: %v", err)
+	}
+}
+
+// syncTemplates reconciles TEMPLATE_SYNC_DIR at startup. In "plan" mode it
+// only logs the pending changes.
+func syncTemplates(dir, mode string) {
+	plan, err := templatesync.MakePlan(context.Background(), handlers.Repos, dir)
+	if err != nil {
+		log.Fatalf("Error planning sync of %s: %v", dir, err)
+	}
+
+	for _, c := range plan.Changes {
+		if c.Drift {
+			log.Printf("Template sync: %s (%s) was edited outside %s since the last sync: %s",
+				c.Path, c.TemplateID, dir, strings.Join(c.Fields, ", "))
+		}
+	}
+
+	if mode != "apply" {
+		log.Printf("Template sync: %d pending changes in %s", len(plan.Pending()), dir)
+		return
+	}
+
+	applied, err := templatesync.Apply(context.Background(), handlers.Repos, plan, templatesync.Options{})
+	if err != nil {
+		log.Fatalf("Error applying sync of %s: %v", dir, err)
+	}
+	log.Printf("Template sync: applied %d of %d changes from %s", len(applied), len(plan.Pending()), dir)
+}
@@ -0,0 +1,273 @@
+// Package templatesync keeps templates in sync with a directory tree, so
+// templates can be versioned in git next to the migrations.
+//
+// Every template is a folder holding a template.yaml manifest and a content
+// file:
+//
+//	welcome-email/
+//	  template.yaml
+//	  content.html
+//
+// The manifest names the template and describes its category, format,
+// variables and template_config entries:
+//
+//	id: 6f1c2a9e-5d0b-4a53-9c1e-0d7f6b2f8a41
+//	name: Welcome Email
+//	category: Email
+//	format: html
+//	variables:
+//	  - name: user_name
+//	    description: Name shown in the greeting
+//	    required: true
+//	config:
+//	  email.subject: "Welcome {{.user_name}}"
+package templatesync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestFile is the name of the manifest in every template folder.
+const ManifestFile = "template.yaml"
+
+// Manifest is the content of a template.yaml file.
+type Manifest struct {
+	ID       string `yaml:"id"`
+	Name     string `yaml:"name"`
+	Category string `yaml:"category"`
+	Format   string `yaml:"format"`
+	// Content is the content file relative to the folder. It defaults to
+	// the only file named content.*.
+	Content   string             `yaml:"content"`
+	Variables []ManifestVariable `yaml:"variables"`
+	Config    map[string]string  `yaml:"config"`
+}
+
+type ManifestVariable struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Default     string `yaml:"default"`
+	Required    bool   `yaml:"required"`
+	Type        string `yaml:"type"`
+}
+
+// Spec is a template as declared in the directory tree.
+type Spec struct {
+	// Path is the folder of the template relative to the synced directory.
+	Path     string
+	Template models.TemplateExport
+}
+
+// LoadDir reads every template folder below dir. Folders are found by their
+// template.yaml, so templates may be grouped in subdirectories.
+func LoadDir(dir string) ([]Spec, error) {
+	var specs []Spec
+	seen := make(map[string]string)
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != ManifestFile {
+			return nil
+		}
+
+		folder := filepath.Dir(p)
+		rel, err := filepath.Rel(dir, folder)
+		if err != nil {
+			return err
+		}
+		spec, err := loadSpec(folder, filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		if other, ok := seen[spec.Template.Template.ID]; ok {
+			return fmt.Errorf("%s: id %s is also used by %s", spec.Path, spec.Template.Template.ID, other)
+		}
+		seen[spec.Template.Template.ID] = spec.Path
+		specs = append(specs, spec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Path < specs[j].Path })
+	return specs, nil
+}
+
+func loadSpec(folder, rel string) (Spec, error) {
+	spec := Spec{Path: rel}
+
+	data, err := os.ReadFile(filepath.Join(folder, ManifestFile))
+	if err != nil {
+		return spec, err
+	}
+	var m Manifest
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&m); err != nil {
+		return spec, fmt.Errorf("%s/%s: %w", rel, ManifestFile, err)
+	}
+
+	if _, err := uuid.Parse(m.ID); err != nil {
+		return spec, fmt.Errorf("%s: id must be a UUID, got %q", rel, m.ID)
+	}
+	if m.Name == "" || m.Category == "" {
+		return spec, fmt.Errorf("%s: name and category are required", rel)
+	}
+
+	contentFile, err := findContentFile(folder, m.Content)
+	if err != nil {
+		return spec, fmt.Errorf("%s: %w", rel, err)
+	}
+	content, err := os.ReadFile(contentFile)
+	if err != nil {
+		return spec, err
+	}
+
+	format := m.Format
+	if format == "" {
+		format = "html"
+	}
+	spec.Template.Template = models.Template{
+		ID:           strings.ToLower(m.ID),
+		Name:         m.Name,
+		CategoryName: m.Category,
+		Content:      string(content),
+		Format:       format,
+		IsActive:     true,
+	}
+
+	names := make(map[string]bool)
+	for _, v := range m.Variables {
+		if v.Name == "" {
+			return spec, fmt.Errorf("%s: variable name is required", rel)
+		}
+		if names[v.Name] {
+			return spec, fmt.Errorf("%s: variable %q is listed more than once", rel, v.Name)
+		}
+		names[v.Name] = true
+		spec.Template.Variables = append(spec.Template.Variables, models.TemplateVariable{
+			VariableName: v.Name,
+			Description:  v.Description,
+			DefaultValue: v.Default,
+			IsRequired:   v.Required,
+			VariableType: v.Type,
+		})
+	}
+	for key, value := range m.Config {
+		spec.Template.Config = append(spec.Template.Config, models.TemplateConfig{ConfigKey: key, ConfigValue: value})
+	}
+	return spec, nil
+}
+
+func findContentFile(folder, name string) (string, error) {
+	if name != "" {
+		if filepath.IsAbs(name) || strings.HasPrefix(path.Clean(filepath.ToSlash(name)), "../") {
+			return "", fmt.Errorf("content %q must be inside the template folder", name)
+		}
+		return filepath.Join(folder, name), nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(folder, "content.*"))
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", errors.New("no content file, add content.<ext> or set content in the manifest")
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("several content files (%d), set content in the manifest", len(matches))
+	}
+}
+
+// hashedTemplate is the part of a template the sync manages, in a canonical
+// order so equal templates hash equally.
+type hashedTemplate struct {
+	Name      string
+	Category  string
+	Content   string
+	Format    string
+	Active    bool
+	Variables []string
+	Config    []string
+}
+
+func canonical(e models.TemplateExport) hashedTemplate {
+	h := hashedTemplate{
+		Name:      e.Template.Name,
+		Category:  e.Template.CategoryName,
+		Content:   e.Template.Content,
+		Format:    e.Template.Format,
+		Active:    e.Template.IsActive,
+		Variables: []string{},
+		Config:    []string{},
+	}
+	for _, v := range e.Variables {
+		variableType := v.VariableType
+		if variableType == "" {
+			variableType = "string"
+		}
+		h.Variables = append(h.Variables, fmt.Sprintf("%q %q %q %t %q",
+			v.VariableName, v.Description, v.DefaultValue, v.IsRequired, variableType))
+	}
+	for _, c := range e.Config {
+		h.Config = append(h.Config, fmt.Sprintf("%q %q", c.ConfigKey, c.ConfigValue))
+	}
+	sort.Strings(h.Variables)
+	sort.Strings(h.Config)
+	return h
+}
+
+// Hash returns the SHA-256 of the managed part of a template.
+func Hash(e models.TemplateExport) string {
+	data, _ := json.Marshal(canonical(e))
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// diffFields lists the parts in which two templates differ.
+func diffFields(a, b models.TemplateExport) []string {
+	ca, cb := canonical(a), canonical(b)
+	var fields []string
+	if ca.Name != cb.Name {
+		fields = append(fields, "name")
+	}
+	if ca.Category != cb.Category {
+		fields = append(fields, "category")
+	}
+	if ca.Content != cb.Content {
+		fields = append(fields, "content")
+	}
+	if ca.Format != cb.Format {
+		fields = append(fields, "format")
+	}
+	if ca.Active != cb.Active {
+		fields = append(fields, "active")
+	}
+	if strings.Join(ca.Variables, "\n") != strings.Join(cb.Variables, "\n") {
+		fields = append(fields, "variables")
+	}
+	if strings.Join(ca.Config, "\n") != strings.Join(cb.Config, "\n") {
+		fields = append(fields, "config")
+	}
+	return fields
+}
@@ -0,0 +1,210 @@
+package templatesync
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/elvismanchkin/migration_tools_poc_liquibase/models"
+)
+
+// SyncUser is recorded as the author of changes made by the sync.
+const SyncUser = "templates_sync"
+
+// Planned actions.
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionUnchanged = "unchanged"
+	// ActionOrphan is a managed template whose folder was removed.
+	ActionOrphan = "orphan"
+)
+
+// Change is the planned action for one template.
+type Change struct {
+	TemplateID string   `json:"template_id"`
+	Name       string   `json:"name"`
+	Path       string   `json:"path"`
+	Action     string   `json:"action"`
+	Fields     []string `json:"fields,omitempty"`
+	// Drift is set when the template was edited outside the sync, for
+	// example through the UI, since it was last synced. Apply leaves drifted
+	// templates alone unless forced.
+	Drift bool `json:"drift,omitempty"`
+
+	spec *Spec
+	hash string
+}
+
+type Plan struct {
+	Dir     string   `json:"dir"`
+	Changes []Change `json:"changes"`
+}
+
+// Options of Apply.
+type Options struct {
+	// Force overwrites templates that drifted since the last sync.
+	Force bool
+	// Prune moves orphaned templates to the trash.
+	Prune bool
+}
+
+// HasDrift reports whether any managed template was edited outside the sync.
+func (p Plan) HasDrift() bool {
+	for _, c := range p.Changes {
+		if c.Drift {
+			return true
+		}
+	}
+	return false
+}
+
+// Pending returns the changes Apply would make.
+func (p Plan) Pending() []Change {
+	var pending []Change
+	for _, c := range p.Changes {
+		if c.Action != ActionUnchanged {
+			pending = append(pending, c)
+		}
+	}
+	return pending
+}
+
+// MakePlan compares the templates declared in dir with the ones in repos.
+func MakePlan(ctx context.Context, repos models.Repositories, dir string) (Plan, error) {
+	plan := Plan{Dir: dir, Changes: []Change{}}
+
+	specs, err := LoadDir(dir)
+	if err != nil {
+		return plan, err
+	}
+	states, err := repos.SyncStates.List(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("loading sync state: %w", err)
+	}
+
+	declared := make(map[string]bool)
+	for i := range specs {
+		spec := &specs[i]
+		id := spec.Template.Template.ID
+		declared[id] = true
+
+		change := Change{
+			TemplateID: id,
+			Name:       spec.Template.Template.Name,
+			Path:       spec.Path,
+			spec:       spec,
+			hash:       Hash(spec.Template),
+		}
+
+		current, err := currentTemplate(ctx, repos, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			change.Action = ActionCreate
+			plan.Changes = append(plan.Changes, change)
+			continue
+		}
+		if err != nil {
+			return plan, fmt.Errorf("%s: %w", spec.Path, err)
+		}
+
+		currentHash := Hash(current)
+		state, managed := states[id]
+		change.Drift = managed && currentHash != state.ContentHash && currentHash != change.hash
+		if currentHash == change.hash {
+			change.Action = ActionUnchanged
+		} else {
+			change.Action = ActionUpdate
+			change.Fields = diffFields(current, spec.Template)
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	for id, state := range states {
+		if declared[id] {
+			continue
+		}
+		change := Change{TemplateID: id, Path: state.SourcePath, Action: ActionOrphan}
+		current, err := currentTemplate(ctx, repos, id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return plan, err
+		}
+		change.Name = current.Template.Name
+		if err == nil && !current.Template.IsActive {
+			// Already in the trash; only the sync state is left to remove.
+			change.Fields = []string{"active"}
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	sort.SliceStable(plan.Changes, func(i, j int) bool { return plan.Changes[i].Path < plan.Changes[j].Path })
+	return plan, nil
+}
+
+func currentTemplate(ctx context.Context, repos models.Repositories, id string) (models.TemplateExport, error) {
+	exports, err := repos.Bundles.Export(ctx, models.ExportFilter{IDs: []string{id}})
+	if err != nil {
+		return models.TemplateExport{}, err
+	}
+	return exports[0], nil
+}
+
+// Apply makes repos match the plan. Templates are written through
+// the bundle import with the new-version strategy, so the content they
+// replace is kept in the version history. It returns the changes that were
+// applied.
+func Apply(ctx context.Context, repos models.Repositories, plan Plan, opts Options) ([]Change, error) {
+	var applied []Change
+	var exports []models.TemplateExport
+	var written []Change
+
+	for _, c := range plan.Changes {
+		switch {
+		case c.Action == ActionUnchanged:
+			if err := repos.SyncStates.Set(ctx, c.TemplateID, c.Path, c.hash); err != nil {
+				return applied, err
+			}
+		case c.Action == ActionOrphan:
+			if !opts.Prune {
+				continue
+			}
+			// Templates already in the trash only lose their sync state.
+			err := repos.Templates.Delete(ctx, c.TemplateID, SyncUser)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return applied, fmt.Errorf("removing %s: %w", c.Path, err)
+			}
+			if err := repos.SyncStates.Delete(ctx, c.TemplateID); err != nil {
+				return applied, err
+			}
+			applied = append(applied, c)
+		case c.Drift && !opts.Force:
+			continue
+		default:
+			exports = append(exports, c.spec.Template)
+			written = append(written, c)
+		}
+	}
+
+	if len(exports) == 0 {
+		return applied, nil
+	}
+	if _, err := repos.Bundles.Import(ctx, nil, exports, models.ImportNewVersion, false, SyncUser); err != nil {
+		return applied, err
+	}
+
+	for _, c := range written {
+		if c.Action == ActionUpdate {
+			// Templates moved to the trash through the UI come back.
+			err := repos.Templates.Restore(ctx, c.TemplateID, SyncUser)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return applied, err
+			}
+		}
+		if err := repos.SyncStates.Set(ctx, c.TemplateID, c.Path, c.hash); err != nil {
+			return applied, err
+		}
+		applied = append(applied, c)
+	}
+	return applied, nil
+}